@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// DefaultTopUploadersLimit is the number of top uploaders we return when
+	// no limit is given.
+	DefaultTopUploadersLimit = 20
+	// MaxTopUploadersLimit is the maximum number of top uploaders we return.
+	MaxTopUploadersLimit = 1000
+)
+
+// analyticsGET returns portal-wide statistics for the given time period.
+func (api *API) analyticsGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	defaultPeriod, maxPeriod, _ := historyPeriodLimits(database.GranularityMonth)
+	from, to, err := parseTimePeriod(req, defaultPeriod, maxPeriod)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	stats, err := api.staticDB.PortalStats(req.Context(), from, to)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, stats)
+}
+
+// analyticsHistoryGET returns portal-wide statistics for the given time
+// period, grouped by day or month.
+func (api *API) analyticsHistoryGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	granularity := req.FormValue("granularity")
+	if granularity == "" {
+		granularity = database.GranularityDay
+	}
+	defaultPeriod, maxPeriod, err := historyPeriodLimits(granularity)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimePeriod(req, defaultPeriod, maxPeriod)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	history, err := api.staticDB.PortalStatsHistory(req.Context(), from, to, granularity)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, history)
+}
+
+// analyticsTopUploadersGET returns the users who uploaded the most data during
+// the given time period.
+func (api *API) analyticsTopUploadersGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	defaultPeriod, maxPeriod, _ := historyPeriodLimits(database.GranularityMonth)
+	from, to, err := parseTimePeriod(req, defaultPeriod, maxPeriod)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	limit := DefaultTopUploadersLimit
+	if limitStr := req.FormValue("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > MaxTopUploadersLimit {
+			api.WriteError(w, errors.New("invalid limit"), http.StatusBadRequest)
+			return
+		}
+	}
+	uploaders, err := api.staticDB.TopUploaders(req.Context(), from, to, limit)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, uploaders)
+}
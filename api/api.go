@@ -34,6 +34,12 @@ const (
 	PromoterPromoter = Promoter("promoter")
 )
 
+const (
+	// ErrCodeEmailNotConfirmed is the error code we return when the portal's
+	// policy requires a confirmed email address for the requested action.
+	ErrCodeEmailNotConfirmed = "email_not_confirmed"
+)
+
 type (
 	// API is the central struct which gives us access to all subsystems.
 	API struct {
@@ -54,6 +60,8 @@ type (
 	// errorWrap is a helper type for converting an `error` struct to JSON.
 	errorWrap struct {
 		Message string `json:"message"`
+		// Code allows clients to tell specific errors apart.
+		Code string `json:"code,omitempty"`
 	}
 )
 
@@ -196,10 +204,17 @@ func (api *API) WithDBSession(h httprouter.Handle) httprouter.Handle {
 
 // WriteError an error to the API caller.
 func (api *API) WriteError(w http.ResponseWriter, err error, code int) {
+	api.WriteErrorWithCode(w, err, code, "")
+}
+
+// WriteErrorWithCode writes an error to the API caller, together with an error
+// code which allows the caller to tell specific errors apart. See the
+// ErrCode constants for the supported codes.
+func (api *API) WriteErrorWithCode(w http.ResponseWriter, err error, code int, errCode string) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(code)
 	api.staticLogger.Errorln(code, err)
-	encodingErr := json.NewEncoder(w).Encode(errorWrap{Message: err.Error()})
+	encodingErr := json.NewEncoder(w).Encode(errorWrap{Message: err.Error(), Code: errCode})
 	if _, isJSONErr := encodingErr.(*json.SyntaxError); isJSONErr {
 		// Marshalling should only fail in the event of a developer error.
 		// Specifically, only non-marshallable types should cause an error here.
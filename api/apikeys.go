@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
 	"github.com/julienschmidt/httprouter"
 	"gitlab.com/NebulousLabs/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -117,6 +118,15 @@ func (api *API) userAPIKeyPOST(u *database.User, w http.ResponseWriter, req *htt
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
+	err = api.checkEmailConfirmed(req.Context(), u, database.ActionAPIKeys)
+	if errors.Contains(err, ErrEmailNotConfirmed) {
+		api.WriteErrorWithCode(w, err, http.StatusForbidden, ErrCodeEmailNotConfirmed)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
 	ak, err := api.staticDB.APIKeyCreate(req.Context(), *u, body.Name, body.Public, body.Skylinks)
 	if errors.Contains(err, database.ErrMaxNumAPIKeysExceeded) {
 		err = errors.AddContext(err, "the maximum number of API keys a user can create is "+strconv.Itoa(database.MaxNumAPIKeysPerUser))
@@ -127,6 +137,9 @@ func (api *API) userAPIKeyPOST(u *database.User, w http.ResponseWriter, req *htt
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	if !ak.Public {
+		api.notifySecurityEvent(req.Context(), u.Email, u.Locale, email.SecurityEventAPIKeyCreated)
+	}
 	api.WriteJSON(w, APIKeyResponseWithKeyFromAPIKey(*ak))
 }
 
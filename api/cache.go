@@ -25,7 +25,13 @@ type (
 		Sub           string
 		Tier          int
 		QuotaExceeded bool
-		ExpiresAt     time.Time
+		// EmailConfirmed is true when the user has confirmed their email
+		// address.
+		EmailConfirmed bool
+		// DownloadBandwidthUsed is the download bandwidth the user has used
+		// during the current billing period.
+		DownloadBandwidthUsed int64
+		ExpiresAt             time.Time
 	}
 )
 
@@ -51,14 +57,34 @@ func (utc *userTierCache) Get(sub string) (userTierCacheEntry, bool) {
 	return ce, true
 }
 
-// Set stores the user's tier in the cache under the given key.
+// Set stores the user's tier in the cache under the given key. If the user is
+// already cached under this key, their download bandwidth used is preserved.
 func (utc *userTierCache) Set(key string, u *database.User) {
 	utc.mu.Lock()
+	var bwUsed int64
+	if ce, exists := utc.cache[key]; exists && ce.Sub == u.Sub {
+		bwUsed = ce.DownloadBandwidthUsed
+	}
 	utc.cache[key] = userTierCacheEntry{
-		Sub:           u.Sub,
-		Tier:          u.Tier,
-		QuotaExceeded: u.QuotaExceeded,
-		ExpiresAt:     time.Now().UTC().Add(userTierCacheTTL).Truncate(time.Millisecond),
+		Sub:                   u.Sub,
+		Tier:                  u.Tier,
+		QuotaExceeded:         u.QuotaExceeded,
+		EmailConfirmed:        !u.EmailUnconfirmed,
+		DownloadBandwidthUsed: bwUsed,
+		ExpiresAt:             time.Now().UTC().Add(userTierCacheTTL).Truncate(time.Millisecond),
 	}
 	utc.mu.Unlock()
 }
+
+// SetDownloadBandwidthUsed updates the download bandwidth used by the user
+// cached under the given key. It doesn't create new cache entries.
+func (utc *userTierCache) SetDownloadBandwidthUsed(key string, used int64) {
+	utc.mu.Lock()
+	defer utc.mu.Unlock()
+	ce, exists := utc.cache[key]
+	if !exists {
+		return
+	}
+	ce.DownloadBandwidthUsed = used
+	utc.cache[key] = ce
+}
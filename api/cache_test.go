@@ -81,4 +81,21 @@ func TestUserTierCache(t *testing.T) {
 	if ce.Tier != u.Tier {
 		t.Fatalf("Expected tier %d, got %d", u.Tier, ce.Tier)
 	}
+
+	// Set the download bandwidth used and expect it to survive updates of the
+	// user's tier.
+	cache.SetDownloadBandwidthUsed(u.Sub, 123)
+	u.Tier = database.TierPremium20
+	cache.Set(u.Sub, u)
+	ce, ok = cache.Get(u.Sub)
+	if !ok || ce.DownloadBandwidthUsed != 123 {
+		t.Fatalf("Expected download bandwidth used of %d, got %d", 123, ce.DownloadBandwidthUsed)
+	}
+	// Setting the download bandwidth used for a missing entry should not
+	// create it.
+	cache.SetDownloadBandwidthUsed("missing", 123)
+	_, ok = cache.Get("missing")
+	if ok {
+		t.Fatal("Did not expect to get a cache entry!")
+	}
 }
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+type (
+	// EmailDuplicatesGET is the response of GET /users/duplicates and
+	// POST /users/duplicates/check
+	EmailDuplicatesGET struct {
+		Items []database.EmailDuplicate `json:"items"`
+	}
+)
+
+// emailDuplicatesGET returns the latest report of email addresses which belong
+// to more than one user. These accounts need to be merged before we can
+// enforce the uniqueness of email addresses.
+func (api *API) emailDuplicatesGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	dups, err := api.staticDB.EmailDuplicates(req.Context())
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, EmailDuplicatesGET{Items: dups})
+}
+
+// emailDuplicatesCheckPOST looks for duplicate email addresses again, e.g.
+// after some of the affected accounts have been merged, and returns the new
+// report. Once there are no duplicates left, it enforces the uniqueness of
+// email addresses.
+func (api *API) emailDuplicatesCheckPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	dups, err := api.staticDB.EmailDuplicatesCheck(req.Context())
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, EmailDuplicatesGET{Items: dups})
+}
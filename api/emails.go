@@ -0,0 +1,284 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// EmailEventsToken is the secret token email providers need to present in
+	// order to report bounces and complaints. When it's empty, we don't accept
+	// such reports. Its value is controlled by the ACCOUNTS_EMAIL_EVENTS_TOKEN
+	// environment variable.
+	EmailEventsToken = ""
+
+	// ErrEmailEventsDisabled is returned when an email provider reports a
+	// bounce or a complaint but we don't accept such reports.
+	ErrEmailEventsDisabled = errors.New("email events are not enabled on this portal")
+)
+
+type (
+	// EmailEventsPOST is the generic JSON format in which email providers can
+	// report bounces and complaints to POST /email/events
+	EmailEventsPOST struct {
+		Events []email.DeliveryEvent `json:"events"`
+	}
+	// EmailEventsResponse is the response of POST /email/events
+	EmailEventsResponse struct {
+		Suppressed int `json:"suppressed"`
+	}
+	// EmailGET is a single email message as returned by GET /emails.
+	EmailGET struct {
+		database.EmailMessage
+		Status string `json:"status"`
+	}
+	// EmailsGET is the response of GET /emails
+	EmailsGET struct {
+		Items    []EmailGET `json:"items"`
+		Offset   int        `json:"offset"`
+		PageSize int        `json:"pageSize"`
+		Count    int        `json:"count"`
+	}
+	// EmailsRequeuePOST is the request body of POST /emails/requeue
+	EmailsRequeuePOST struct {
+		// ID is the id of the message to requeue. When it's empty, we requeue
+		// all failed messages which match the rest of the fields.
+		ID       string      `json:"id"`
+		To       types.Email `json:"to"`
+		Template string      `json:"template"`
+	}
+	// EmailsRequeueResponse is the response of POST /emails/requeue
+	EmailsRequeueResponse struct {
+		Requeued int64 `json:"requeued"`
+	}
+	// UserConfirmStatusGET is the response of GET /user/confirm/status
+	UserConfirmStatusGET struct {
+		EmailConfirmed bool `json:"emailConfirmed"`
+		// Status is the delivery status of the latest confirmation email. It's
+		// empty if we haven't sent any.
+		Status         string    `json:"status,omitempty"`
+		CreatedAt      time.Time `json:"createdAt"`
+		SentAt         time.Time `json:"sentAt"`
+		LastAttemptAt  time.Time `json:"lastAttemptAt"`
+		FailedAttempts int       `json:"failedAttempts"`
+	}
+)
+
+// emailsGET lists the email messages which match the given filters. It allows
+// support staff to find messages we failed to deliver.
+// Supported filters: status ("pending", "sent", "failed"), to, template, and
+// from/until Unix timestamps of the message's creation.
+func (api *API) emailsGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	offset, err1 := fetchOffset(req.Form)
+	pageSize, err2 := fetchPageSize(req.Form, DefaultPageSizeSmall)
+	from, err3 := parseInt64Param(req, "from")
+	until, err4 := parseInt64Param(req, "until")
+	if err := errors.Compose(err1, err2, err3, err4); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	filter := database.EmailFilter{
+		Status:   req.Form.Get("status"),
+		To:       types.NewEmail(req.Form.Get("to")),
+		Template: req.Form.Get("template"),
+	}
+	if from > 0 {
+		filter.From = time.Unix(from, 0).UTC()
+	}
+	if until > 0 {
+		filter.Until = time.Unix(until, 0).UTC()
+	}
+	msgs, total, err := api.staticDB.EmailList(req.Context(), filter, offset, pageSize)
+	if errors.Contains(err, database.ErrInvalidEmailStatus) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	items := make([]EmailGET, 0, len(msgs))
+	for _, m := range msgs {
+		items = append(items, EmailGET{EmailMessage: m, Status: m.Status()})
+	}
+	response := EmailsGET{
+		Items:    items,
+		Offset:   offset,
+		PageSize: pageSize,
+		Count:    total,
+	}
+	api.WriteJSON(w, response)
+}
+
+// emailsRequeuePOST puts failed email messages back in the sending queue. It
+// requeues either the message with the given id or all failed messages which
+// match the given filters.
+func (api *API) emailsRequeuePOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var body EmailsRequeuePOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if body.ID == "" {
+		filter := database.EmailFilter{
+			To:       body.To,
+			Template: body.Template,
+		}
+		n, err := api.staticDB.EmailRequeueFailed(req.Context(), filter)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		api.WriteJSON(w, EmailsRequeueResponse{Requeued: n})
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(body.ID)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid id"), http.StatusBadRequest)
+		return
+	}
+	err = api.staticDB.EmailRequeue(req.Context(), id)
+	if errors.Contains(err, database.ErrEmailNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, EmailsRequeueResponse{Requeued: 1})
+}
+
+// userConfirmStatusGET tells the user whether we managed to deliver their
+// latest email address confirmation email.
+func (api *API) userConfirmStatusGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	resp := UserConfirmStatusGET{
+		EmailConfirmed: !u.EmailUnconfirmed,
+	}
+	if u.Email == "" {
+		api.WriteJSON(w, resp)
+		return
+	}
+	m, err := api.staticDB.EmailLatest(req.Context(), u.Email, email.TemplateConfirmEmail)
+	if errors.Contains(err, database.ErrEmailNotFound) {
+		api.WriteJSON(w, resp)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	resp.Status = m.Status()
+	resp.CreatedAt = m.CreatedAt
+	resp.SentAt = m.SentAt
+	resp.LastAttemptAt = m.LastAttemptAt
+	resp.FailedAttempts = m.FailedAttempts
+	api.WriteJSON(w, resp)
+}
+
+// emailEventsPOST accepts bounce and complaint reports from email providers
+// and adds the affected addresses to the suppression list. The reports are
+// either in our generic JSON format (see EmailEventsPOST) or SMTP delivery
+// status notifications. Transient bounces are ignored.
+func (api *API) emailEventsPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if EmailEventsToken == "" {
+		api.WriteError(w, ErrEmailEventsDisabled, http.StatusNotFound)
+		return
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = req.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(EmailEventsToken)) != 1 {
+		api.WriteError(w, database.ErrInvalidToken, http.StatusUnauthorized)
+		return
+	}
+	var events []email.DeliveryEvent
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var body EmailEventsPOST
+		err := parseRequestBodyJSON(req.Body, LimitBodySizeLarge, &body)
+		if err != nil {
+			api.WriteError(w, err, http.StatusBadRequest)
+			return
+		}
+		events = body.Events
+	} else {
+		var err error
+		events, err = email.ParseDSN(http.MaxBytesReader(w, req.Body, LimitBodySizeLarge), req.Header.Get("Content-Type"))
+		if err != nil {
+			api.WriteError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	// Validate the whole batch before applying any of it, so a rejected
+	// batch doesn't leave some of its events applied.
+	err := validateEmailEvents(events)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	var suppressed int
+	for _, ev := range events {
+		if skipEmailEvent(ev) {
+			continue
+		}
+		err = api.staticDB.EmailSuppressionAdd(req.Context(), ev.Email, ev.Type, ev.Detail)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		suppressed++
+	}
+	api.WriteJSON(w, EmailEventsResponse{Suppressed: suppressed})
+}
+
+// skipEmailEvent reports whether the given event doesn't require any action.
+func skipEmailEvent(ev email.DeliveryEvent) bool {
+	return ev.Email == "" || (ev.Transient && ev.Type == database.EmailSuppressionBounce)
+}
+
+// validateEmailEvents makes sure that we can apply all events of the batch.
+func validateEmailEvents(events []email.DeliveryEvent) error {
+	for i, ev := range events {
+		if skipEmailEvent(ev) {
+			continue
+		}
+		if ev.Type != database.EmailSuppressionBounce && ev.Type != database.EmailSuppressionComplaint {
+			return errors.AddContext(database.ErrInvalidEmailSuppressionReason, fmt.Sprintf("event %d", i))
+		}
+	}
+	return nil
+}
+
+// emailSuppressionDELETE removes an email address from the suppression list,
+// e.g. after the user has fixed their mailbox.
+func (api *API) emailSuppressionDELETE(_ *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	addr := types.NewEmail(ps.ByName("email"))
+	err := api.staticDB.EmailSuppressionRemove(req.Context(), addr)
+	if errors.Contains(err, database.ErrEmailSuppressionNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
 	"github.com/SkynetLabs/skynet-accounts/hash"
 	"github.com/SkynetLabs/skynet-accounts/jwt"
 	"github.com/SkynetLabs/skynet-accounts/lib"
@@ -24,7 +25,9 @@ import (
 	jwt2 "github.com/lestrrat-go/jwx/jwt"
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/SkynetLabs/skyd/build"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/text/language"
 )
 
 const (
@@ -47,6 +50,10 @@ var (
 	// flow fails. This error is sent instead of whatever internal error we had
 	// before in order to prevent an attacker from listing our users.
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrEmailNotConfirmed is returned when the user tries to perform an
+	// action which the portal only allows to users with a confirmed email
+	// address.
+	ErrEmailNotConfirmed = errors.New("please confirm your email address before performing this action")
 
 	// MyskyAllowlist contains skylinks we need to make available in order for
 	// users to be able to use MySky on all portals, including ones that require
@@ -56,6 +63,10 @@ var (
 		"AQBIMqRcHbGWXy4rlIwGW4Aa4v0w0xLb6JvUonnXazfxiw": struct{}{}, // skynet-mysky-dev
 		"AQASyOUdaov383UggiDN7izfcCH8k-3Z0FlPjtNyem1qMg": struct{}{}, // sandbridge
 	}
+
+	// QuotaWarningThresholds are the percentages of a user's quota at which
+	// we notify them by email. They must be in ascending order.
+	QuotaWarningThresholds = []int{80, 95, 100}
 )
 
 type (
@@ -64,6 +75,9 @@ type (
 	ChallengePublic struct {
 		// Challenge is a hex-encoded representation of the []byte challenge.
 		Challenge string `bson:"challenge" json:"challenge"`
+		// Type and ExpiresAt allow clients to build a version 2 response.
+		Type      string    `bson:"type" json:"type"`
+		ExpiresAt time.Time `bson:"expires_at" json:"expiresAt"`
 	}
 	// DownloadsGET is the response of GET /user/downloads
 	DownloadsGET struct {
@@ -131,6 +145,11 @@ type (
 		MaxNumberUploads  int    `json:"-"`
 		RegistryDelay     int    `json:"registry"` // ms delay
 		Storage           int64  `json:"-"`
+		// MonthlyDownloadBandwidth is the user's download bandwidth allowance
+		// for a billing period and DownloadBandwidthRemaining is the part of
+		// it they haven't used, yet. Both are in bytes.
+		MonthlyDownloadBandwidth   int64 `json:"monthlyDownloadBandwidth"`
+		DownloadBandwidthRemaining int64 `json:"downloadBandwidthRemaining"`
 	}
 
 	// accountRecoveryPOST defines the payload we expect when a user is trying
@@ -141,10 +160,25 @@ type (
 		ConfirmPassword string `json:"confirmPassword"`
 	}
 
+	// userConfirmPOST defines the payload we expect when a user confirms
+	// their email address.
+	userConfirmPOST struct {
+		Token string `json:"token"`
+	}
+
+	// userLockPOST defines the payload we expect when a user locks their
+	// account.
+	userLockPOST struct {
+		Token string `json:"token"`
+	}
+
 	// credentialsPOST defines the standard credentials package we expect.
 	credentialsPOST struct {
 		Email    types.Email `json:"email"`
 		Password string      `json:"password"`
+		// InviteCode is only used on registration. It's required when the
+		// portal is in invite-only mode.
+		InviteCode string `json:"inviteCode"`
 	}
 
 	// loginTTL defines the lifetime of the JWT issued on login.
@@ -158,6 +192,7 @@ type (
 		Email    types.Email `json:"email,omitempty"`
 		Password string      `json:"password,omitempty"`
 		StripeID string      `json:"stripeCustomerId,omitempty"`
+		Locale   string      `json:"locale,omitempty"`
 	}
 )
 
@@ -228,7 +263,7 @@ func (api *API) loginGET(_ *database.User, w http.ResponseWriter, req *http.Requ
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	api.WriteJSON(w, ChallengePublic{ch.Challenge})
+	api.WriteJSON(w, challengePublic(ch))
 }
 
 // loginPOST starts a user session by issuing a cookie
@@ -268,10 +303,19 @@ func (api *API) loginPOST(_ *database.User, w http.ResponseWriter, req *http.Req
 		return
 	}
 
+	// Check for a passkey assertion in the request's body.
+	var pkPayload passkeyLoginPOST
+	err = json.Unmarshal(body, &pkPayload)
+	if err == nil && pkPayload.Passkey != nil {
+		api.loginPOSTPasskey(w, req, *pkPayload.Passkey, jwtTTL.TTL)
+		return
+	}
+
 	// Check for a challenge response in the request's body.
 	var chr database.ChallengeResponse
 	err = chr.LoadFromBytes(body)
 	if err == nil {
+		chr.Origin = req.Header.Get("Origin")
 		api.loginPOSTChallengeResponse(w, req, chr, jwtTTL.TTL)
 		return
 	}
@@ -294,6 +338,10 @@ func (api *API) loginPOSTChallengeResponse(w http.ResponseWriter, req *http.Requ
 		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
+	err = api.staticDB.PubKeyUsed(ctx, u.ID, pk)
+	if err != nil {
+		api.staticLogger.Debugf("Failed to record the use of pubkey %s: %v", pk.String(), err)
+	}
 	api.loginUser(w, u, jwtTTL, false)
 }
 
@@ -348,6 +396,10 @@ func (api *API) loginPOSTToken(w http.ResponseWriter, req *http.Request) {
 // loginUser is a helper method that generates a JWT for the user and writes the
 // login cookie.
 func (api *API) loginUser(w http.ResponseWriter, u *database.User, jwtTTL int, returnUser bool) {
+	if !u.LockedAt.IsZero() {
+		api.WriteError(w, database.ErrAccountLocked, http.StatusForbidden)
+		return
+	}
 	// Generate a JWT.
 	tk, err := jwt.TokenForUser(u.Email, u.Sub, jwtTTL)
 	if err != nil {
@@ -394,12 +446,12 @@ func (api *API) logoutPOST(_ *database.User, w http.ResponseWriter, _ *http.Requ
 // registerGET generates a registration challenge for the caller.
 func (api *API) registerGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Check if the registrations are open.
-	val, err := api.staticDB.ReadConfigValue(req.Context(), database.ConfValRegistrationsDisabled)
-	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+	disabled, err := api.staticDB.SettingBool(req.Context(), database.ConfValRegistrationsDisabled)
+	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 		return
 	}
-	if val == database.ConfValTrue {
+	if disabled {
 		api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 		return
 	}
@@ -420,18 +472,18 @@ func (api *API) registerGET(_ *database.User, w http.ResponseWriter, req *http.R
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	api.WriteJSON(w, ChallengePublic{ch.Challenge})
+	api.WriteJSON(w, challengePublic(ch))
 }
 
 // registerPOST registers a new user based on a challenge-response.
 func (api *API) registerPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Check if the registrations are open.
-	val, err := api.staticDB.ReadConfigValue(req.Context(), database.ConfValRegistrationsDisabled)
-	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+	disabled, err := api.staticDB.SettingBool(req.Context(), database.ConfValRegistrationsDisabled)
+	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 		return
 	}
-	if val == database.ConfValTrue {
+	if disabled {
 		api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 		return
 	}
@@ -448,6 +500,7 @@ func (api *API) registerPOST(_ *database.User, w http.ResponseWriter, req *http.
 		api.WriteError(w, errors.AddContext(err, "missing or invalid challenge response"), http.StatusBadRequest)
 		return
 	}
+	chr.Origin = req.Header.Get("Origin")
 	// Parse the request's body.
 	var payload credentialsPOST
 	err = json.Unmarshal(body, &payload)
@@ -462,12 +515,22 @@ func (api *API) registerPOST(_ *database.User, w http.ResponseWriter, req *http.
 	}
 	// The password is optional and that's why we do not verify it.
 	ctx := req.Context()
+	if !api.emailDomainAllowed(ctx, w, payload.Email) {
+		return
+	}
 	pk, _, err := api.staticDB.ValidateChallengeResponse(ctx, chr, database.ChallengeTypeRegister)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to validate challenge response"), http.StatusBadRequest)
 		return
 	}
-	u, err := api.staticDB.UserCreatePK(ctx, payload.Email, payload.Password, "", pk, database.TierFree)
+	inv, ok := api.redeemInvite(ctx, w, payload.InviteCode)
+	if !ok {
+		return
+	}
+	u, err := api.staticDB.UserCreatePK(ctx, payload.Email, payload.Password, "", pk, inviteTier(inv))
+	if err != nil {
+		api.releaseInvite(ctx, inv)
+	}
 	if errors.Contains(err, database.ErrUserAlreadyExists) {
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
@@ -476,10 +539,9 @@ func (api *API) registerPOST(_ *database.User, w http.ResponseWriter, req *http.
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	err = api.staticMailer.SendAddressConfirmationEmail(ctx, u.Email, u.EmailConfirmationToken)
-	if err != nil {
-		api.staticLogger.Debugln(errors.AddContext(err, "failed to send address confirmation email"))
-	}
+	api.recordInvite(ctx, u, inv)
+	api.setLocaleFromRequest(ctx, u, req)
+	api.sendAddressConfirmation(ctx, u)
 	api.loginUser(w, u, 0, true)
 }
 
@@ -498,7 +560,7 @@ func (api *API) userLimitsGET(_ *database.User, w http.ResponseWriter, req *http
 	// to be presented in bytes per second. The default behaviour is to present
 	// them in bits per second.
 	inBytes := strings.EqualFold(req.FormValue("unit"), "byte")
-	respAnon := userLimitsGetFromTier("", database.TierAnonymous, false, inBytes)
+	respAnon := userLimitsGetFromTier("", database.TierAnonymous, false, 0, inBytes)
 	// First check for an API key.
 	ak, err := apiKeyFromRequest(req)
 	if err == nil {
@@ -506,7 +568,7 @@ func (api *API) userLimitsGET(_ *database.User, w http.ResponseWriter, req *http
 		ce, ok := api.staticUserTierCache.Get(ak.String())
 		if ok {
 			api.staticLogger.Traceln("Fetching user limits from cache by API key.")
-			api.WriteJSON(w, userLimitsGetFromTier(ce.Sub, ce.Tier, ce.QuotaExceeded, inBytes))
+			api.WriteJSON(w, api.userLimitsFromCacheEntry(req.Context(), ce, inBytes))
 			return
 		}
 		// Get the API key.
@@ -529,8 +591,8 @@ func (api *API) userLimitsGET(_ *database.User, w http.ResponseWriter, req *http
 			return
 		}
 		// Cache the user under the API key they used.
-		api.staticUserTierCache.Set(ak.String(), u)
-		api.WriteJSON(w, userLimitsGetFromTier(u.Sub, u.Tier, u.QuotaExceeded, inBytes))
+		ce = api.cacheUser(req.Context(), ak.String(), u)
+		api.WriteJSON(w, api.userLimitsFromCacheEntry(req.Context(), ce, inBytes))
 		return
 	}
 	// Next check for a token.
@@ -556,15 +618,9 @@ func (api *API) userLimitsGET(_ *database.User, w http.ResponseWriter, req *http
 			api.WriteJSON(w, respAnon)
 			return
 		}
-		api.staticUserTierCache.Set(u.Sub, u)
-		// Populate the tier and qe values, while simultaneously making sure
-		// that we can read the record from the cache.
-		ce, ok = api.staticUserTierCache.Get(u.Sub)
-		if !ok {
-			build.Critical("Failed to fetch user from UserTierCache right after setting it.")
-		}
+		ce = api.cacheUser(req.Context(), u.Sub, u)
 	}
-	api.WriteJSON(w, userLimitsGetFromTier(ce.Sub, ce.Tier, ce.QuotaExceeded, inBytes))
+	api.WriteJSON(w, api.userLimitsFromCacheEntry(req.Context(), ce, inBytes))
 }
 
 // userLimitsSkylinkGET returns the speed limits which apply to a GET call to
@@ -577,7 +633,7 @@ func (api *API) userLimitsSkylinkGET(_ *database.User, w http.ResponseWriter, re
 	// to be presented in bytes per second. The default behaviour is to present
 	// them in bits per second.
 	inBytes := strings.EqualFold(req.FormValue("unit"), "byte")
-	respAnon := userLimitsGetFromTier("", database.TierAnonymous, false, inBytes)
+	respAnon := userLimitsGetFromTier("", database.TierAnonymous, false, 0, inBytes)
 	// Validate the skylink.
 	skylink := ps.ByName("skylink")
 	if !database.ValidSkylink(skylink) {
@@ -589,7 +645,7 @@ func (api *API) userLimitsSkylinkGET(_ *database.User, w http.ResponseWriter, re
 	// anyone can access them, even on portals which require authentication or
 	// premium accounts.
 	if _, ok := MyskyAllowlist[skylink]; ok {
-		api.WriteJSON(w, userLimitsGetFromTier("", database.TierPremium5, false, inBytes))
+		api.WriteJSON(w, userLimitsGetFromTier("", database.TierPremium5, false, 0, inBytes))
 		return
 	}
 	// Try to fetch an API attached to the request.
@@ -609,7 +665,7 @@ func (api *API) userLimitsSkylinkGET(_ *database.User, w http.ResponseWriter, re
 	ce, ok := api.staticUserTierCache.Get(ak.String() + skylink)
 	if ok {
 		api.staticLogger.Traceln("Fetching user limits from cache by API key.")
-		api.WriteJSON(w, userLimitsGetFromTier(ce.Sub, ce.Tier, ce.QuotaExceeded, inBytes))
+		api.WriteJSON(w, api.userLimitsFromCacheEntry(req.Context(), ce, inBytes))
 		return
 	}
 	// Get the API key.
@@ -632,8 +688,8 @@ func (api *API) userLimitsSkylinkGET(_ *database.User, w http.ResponseWriter, re
 		return
 	}
 	// Store the user in the cache with a custom key.
-	api.staticUserTierCache.Set(ak.String()+skylink, user)
-	api.WriteJSON(w, userLimitsGetFromTier(user.Sub, user.Tier, user.QuotaExceeded, inBytes))
+	ce = api.cacheUser(req.Context(), ak.String()+skylink, user)
+	api.WriteJSON(w, api.userLimitsFromCacheEntry(req.Context(), ce, inBytes))
 }
 
 // userStatsGET returns statistics about an existing user.
@@ -646,6 +702,32 @@ func (api *API) userStatsGET(u *database.User, w http.ResponseWriter, req *http.
 	api.WriteJSON(w, us)
 }
 
+// userStatsHistoryGET returns the user's usage stats over the given time
+// period, grouped by day or month. The period is given as Unix timestamps via
+// the `from` and `to` parameters.
+func (api *API) userStatsHistoryGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	granularity := req.FormValue("granularity")
+	if granularity == "" {
+		granularity = database.GranularityDay
+	}
+	defaultPeriod, maxPeriod, err := historyPeriodLimits(granularity)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimePeriod(req, defaultPeriod, maxPeriod)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	history, err := api.staticDB.UserStatsHistory(req.Context(), *u, from, to, granularity)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, history)
+}
+
 // userDELETE deletes the user and all of their data.
 func (api *API) userDELETE(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	err := api.staticDB.UserDelete(req.Context(), u)
@@ -663,12 +745,12 @@ func (api *API) userDELETE(u *database.User, w http.ResponseWriter, req *http.Re
 // userPOST creates a new user.
 func (api *API) userPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Check if the registrations are open.
-	val, err := api.staticDB.ReadConfigValue(req.Context(), database.ConfValRegistrationsDisabled)
-	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+	disabled, err := api.staticDB.SettingBool(req.Context(), database.ConfValRegistrationsDisabled)
+	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 		return
 	}
-	if val == database.ConfValTrue {
+	if disabled {
 		api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 		return
 	}
@@ -692,6 +774,9 @@ func (api *API) userPOST(_ *database.User, w http.ResponseWriter, req *http.Requ
 		api.WriteError(w, errors.New("password is required"), http.StatusBadRequest)
 		return
 	}
+	if !api.emailDomainAllowed(req.Context(), w, payload.Email) {
+		return
+	}
 	// We are generating the sub here and not in UserCreate because there are
 	// many reasons to call UserCreate but this handler is the only place (so
 	// far) that should be allowed to call it without a sub. The reason for that
@@ -702,7 +787,14 @@ func (api *API) userPOST(_ *database.User, w http.ResponseWriter, req *http.Requ
 		api.WriteError(w, errors.AddContext(err, "failed to generate user sub"), http.StatusInternalServerError)
 		return
 	}
-	u, err := api.staticDB.UserCreate(req.Context(), payload.Email, payload.Password, sub, database.TierFree)
+	inv, ok := api.redeemInvite(req.Context(), w, payload.InviteCode)
+	if !ok {
+		return
+	}
+	u, err := api.staticDB.UserCreate(req.Context(), payload.Email, payload.Password, sub, inviteTier(inv))
+	if err != nil {
+		api.releaseInvite(req.Context(), inv)
+	}
 	if errors.Contains(err, database.ErrUserAlreadyExists) {
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
@@ -711,10 +803,9 @@ func (api *API) userPOST(_ *database.User, w http.ResponseWriter, req *http.Requ
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
-	err = api.staticMailer.SendAddressConfirmationEmail(req.Context(), u.Email, u.EmailConfirmationToken)
-	if err != nil {
-		api.staticLogger.Debugln(errors.AddContext(err, "failed to send address confirmation email"))
-	}
+	api.recordInvite(req.Context(), u, inv)
+	api.setLocaleFromRequest(req.Context(), u, req)
+	api.sendAddressConfirmation(req.Context(), u)
 	api.loginUser(w, u, 0, true)
 }
 
@@ -739,12 +830,12 @@ func (api *API) userPUT(u *database.User, w http.ResponseWriter, req *http.Reque
 	if payload.Password != "" {
 		// Check if the registrations are open. If they are not then changing
 		// passwords is also not allowed.
-		val, err := api.staticDB.ReadConfigValue(ctx, database.ConfValRegistrationsDisabled)
-		if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+		disabled, err := api.staticDB.SettingBool(ctx, database.ConfValRegistrationsDisabled)
+		if err != nil {
 			api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 			return
 		}
-		if val == database.ConfValTrue {
+		if disabled {
 			api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 			return
 		}
@@ -779,7 +870,15 @@ func (api *API) userPUT(u *database.User, w http.ResponseWriter, req *http.Reque
 		u.StripeID = payload.StripeID
 	}
 
-	var changedEmail bool
+	if payload.Locale != "" {
+		u.Locale, err = database.NormalizeLocale(payload.Locale)
+		if err != nil {
+			api.WriteError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var changedEmail, notifyOldEmail bool
 	if payload.Email != "" {
 		parsed, err := mail.ParseAddress(payload.Email.String())
 		if err != nil || payload.Email.String() != parsed.Address {
@@ -793,19 +892,38 @@ func (api *API) userPUT(u *database.User, w http.ResponseWriter, req *http.Reque
 			return
 		}
 		if err == nil && eu.Sub != u.Sub {
-			err = errors.New("this email is already in use")
-			api.WriteError(w, err, http.StatusBadRequest)
+			api.WriteError(w, database.ErrEmailInUse, http.StatusBadRequest)
 			return
 		}
-		// Set the new email and set it up for a confirmation.
-		u.Email = payload.Email
-		u.EmailConfirmationTokenExpiration = time.Now().UTC().Add(database.EmailConfirmationTokenTTL).Truncate(time.Millisecond)
-		u.EmailConfirmationToken, err = lib.GenerateUUID()
-		if err != nil {
-			api.WriteError(w, errors.AddContext(err, "failed to generate a token"), http.StatusInternalServerError)
-			return
+		if payload.Email == u.Email {
+			// Setting the current address discards any pending change and
+			// sends the user a new confirmation email.
+			u.PendingEmail = ""
+			u.PendingEmailExpiration = time.Time{}
+			u.EmailUnconfirmed = true
+			changedEmail = true
+		} else {
+			if !api.emailDomainAllowed(ctx, w, payload.Email) {
+				return
+			}
+			// We wouldn't be able to deliver the confirmation email.
+			suppressed, err := api.staticDB.EmailSuppressed(ctx, payload.Email)
+			if err != nil {
+				api.WriteError(w, err, http.StatusInternalServerError)
+				return
+			}
+			if suppressed {
+				api.WriteError(w, errors.New("we are unable to send emails to this address"), http.StatusBadRequest)
+				return
+			}
+			// We keep the current address until the user confirms the new
+			// one.
+			u.PendingEmail = payload.Email
+			u.PendingEmailExpiration = time.Now().UTC().Add(database.EmailConfirmationTokenTTL).Truncate(time.Millisecond)
+			changedEmail = true
+			// Allow the owner of the current address to revert the change.
+			notifyOldEmail = u.Email != ""
 		}
-		changedEmail = true
 	}
 
 	if api.staticDeps.Disrupt("DependencyUserPutMongoDelay") {
@@ -820,11 +938,22 @@ func (api *API) userPUT(u *database.User, w http.ResponseWriter, req *http.Reque
 	}
 	// Send a confirmation email if the user's email address was changed.
 	if changedEmail {
-		err = api.staticMailer.SendAddressConfirmationEmail(ctx, u.Email, u.EmailConfirmationToken)
+		api.sendAddressConfirmation(ctx, u)
+	}
+	if notifyOldEmail {
+		revertToken, err := api.staticDB.UserTokenCreate(ctx, u.ID, database.TokenPurposeEmailRevert, u.Email, database.EmailRevertTokenTTL)
 		if err != nil {
-			api.staticLogger.Debugln(errors.AddContext(err, "failed to send address confirmation email"))
+			api.staticLogger.Warningln(errors.AddContext(err, "failed to create an email revert token"))
+		} else {
+			err = api.staticMailer.SendEmailChangedEmail(ctx, u.Email, u.Locale, u.PendingEmail, revertToken)
+			if err != nil {
+				api.staticLogger.Warningln(errors.AddContext(err, "failed to send email changed email"))
+			}
 		}
 	}
+	if payload.Password != "" {
+		api.notifySecurityEvent(ctx, u.Email, u.Locale, email.SecurityEventPasswordChanged)
+	}
 	api.loginUser(w, u, 0, true)
 }
 
@@ -844,6 +973,20 @@ func (api *API) userPubKeyDELETE(u *database.User, w http.ResponseWriter, req *h
 		api.WriteError(w, errors.New("the given pubkey is not associated with this user"), http.StatusBadRequest)
 		return
 	}
+	err = api.staticDB.LoginMethodsLock(ctx, u.ID)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ok, err := api.staticDB.HasLoginMethodBesides(ctx, *u, pk, primitive.ObjectID{})
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		api.WriteError(w, database.ErrLastLoginMethod, http.StatusBadRequest)
+		return
+	}
 	err = api.staticDB.UserPubKeyRemove(ctx, *u, pk)
 	if errors.Contains(err, mongo.ErrNoDocuments) {
 		api.WriteError(w, err, http.StatusNotFound)
@@ -853,6 +996,7 @@ func (api *API) userPubKeyDELETE(u *database.User, w http.ResponseWriter, req *h
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	api.notifySecurityEvent(ctx, u.Email, u.Locale, email.SecurityEventPubKeyRemoved)
 	api.WriteSuccess(w)
 }
 
@@ -892,19 +1036,19 @@ func (api *API) userPubKeyRegisterGET(u *database.User, w http.ResponseWriter, r
 		api.WriteError(w, errors.AddContext(err, "failed to store unconfirmed user update"), http.StatusInternalServerError)
 		return
 	}
-	api.WriteJSON(w, ChallengePublic{ch.Challenge})
+	api.WriteJSON(w, challengePublic(ch))
 }
 
 // userPubKeyRegisterPOST updates the user's pubKey based on a challenge-response.
 func (api *API) userPubKeyRegisterPOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	ctx := req.Context()
 	// Get the challenge response.
-	var chr database.ChallengeResponse
-	err := chr.LoadFromReader(io.LimitReader(req.Body, LimitBodySizeSmall))
+	chr, label, err := readPubKeyRegisterBody(req.Body)
 	if err != nil {
-		api.WriteError(w, errors.AddContext(err, "missing or invalid challenge response"), http.StatusBadRequest)
+		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
+	chr.Origin = req.Header.Get("Origin")
 	pk, chID, err := api.staticDB.ValidateChallengeResponse(ctx, chr, database.ChallengeTypeUpdate)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to validate challenge response"), http.StatusBadRequest)
@@ -944,6 +1088,13 @@ func (api *API) userPubKeyRegisterPOST(u *database.User, w http.ResponseWriter,
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	if label != "" {
+		err = api.staticDB.PubKeyLabelSet(ctx, u.ID, pk, label)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
 	updatedUser, err := api.staticDB.UserByID(ctx, u.ID)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
@@ -954,6 +1105,7 @@ func (api *API) userPubKeyRegisterPOST(u *database.User, w http.ResponseWriter,
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	api.notifySecurityEvent(ctx, updatedUser.Email, updatedUser.Locale, email.SecurityEventPubKeyAdded)
 	api.loginUser(w, updatedUser, 0, true)
 }
 
@@ -1018,9 +1170,27 @@ func (api *API) userConfirmGET(_ *database.User, w http.ResponseWriter, req *htt
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	token := req.Form.Get("token")
+	api.confirmEmail(w, req, req.Form.Get("token"))
+}
+
+// userConfirmPOST is the same as userConfirmGET but it receives the token in
+// a JSON body.
+// The user doesn't need to be logged in.
+func (api *API) userConfirmPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var payload userConfirmPOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &payload)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse request body"), http.StatusBadRequest)
+		return
+	}
+	api.confirmEmail(w, req, payload.Token)
+}
+
+// confirmEmail confirms the email address to which the given token was sent
+// and logs its user in.
+func (api *API) confirmEmail(w http.ResponseWriter, req *http.Request, token string) {
 	u, err := api.staticDB.UserConfirmEmail(req.Context(), token)
-	if errors.Contains(err, database.ErrInvalidToken) || errors.Contains(err, database.ErrUserNotFound) {
+	if errors.Contains(err, database.ErrInvalidToken) || errors.Contains(err, database.ErrUserNotFound) || errors.Contains(err, database.ErrEmailInUse) {
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
@@ -1036,12 +1206,27 @@ func (api *API) userConfirmGET(_ *database.User, w http.ResponseWriter, req *htt
 // The user needs to be logged in.
 func (api *API) userReconfirmPOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	var err error
-	tk, err := api.staticDB.UserCreateEmailConfirmation(req.Context(), u.ID)
+	if u.PendingEmail != "" {
+		// Resend the confirmation of the address the user is switching to.
+		tk, err := api.staticDB.UserCreateEmailChange(req.Context(), u.ID, u.PendingEmail)
+		if err != nil {
+			api.WriteError(w, errors.AddContext(err, "failed to generate a new confirmation token"), http.StatusInternalServerError)
+			return
+		}
+		err = api.staticMailer.SendAddressConfirmationEmail(req.Context(), u.PendingEmail, u.Locale, tk)
+		if err != nil {
+			api.WriteError(w, errors.AddContext(err, "failed to send the new confirmation token"), http.StatusInternalServerError)
+			return
+		}
+		api.WriteSuccess(w)
+		return
+	}
+	tk, err := api.staticDB.UserCreateEmailConfirmation(req.Context(), u.ID, u.Email)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to generate a new confirmation token"), http.StatusInternalServerError)
 		return
 	}
-	err = api.staticMailer.SendAddressConfirmationEmail(req.Context(), u.Email, tk)
+	err = api.staticMailer.SendAddressConfirmationEmail(req.Context(), u.Email, u.Locale, tk)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to send the new confirmation token"), http.StatusInternalServerError)
 		return
@@ -1056,12 +1241,12 @@ func (api *API) userReconfirmPOST(u *database.User, w http.ResponseWriter, req *
 func (api *API) userRecoverRequestPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Check if the registrations are open. If they are not then account
 	// recovery is also disabled.
-	val, err := api.staticDB.ReadConfigValue(req.Context(), database.ConfValRegistrationsDisabled)
-	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+	disabled, err := api.staticDB.SettingBool(req.Context(), database.ConfValRegistrationsDisabled)
+	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 		return
 	}
-	if val == database.ConfValTrue {
+	if disabled {
 		api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 		return
 	}
@@ -1085,7 +1270,7 @@ func (api *API) userRecoverRequestPOST(_ *database.User, w http.ResponseWriter,
 		// Someone tried to recover an account with an email that's not in our
 		// database. It's possible that this is a user who forgot which email
 		// they used when they signed up. Email them, so they know.
-		errSend := api.staticMailer.SendAccountAccessAttemptedEmail(req.Context(), payload.Email)
+		errSend := api.staticMailer.SendAccountAccessAttemptedEmail(req.Context(), payload.Email, localeFromRequest(req))
 		if errSend != nil {
 			api.staticLogger.Warningln(errors.AddContext(err, "failed to send an email"))
 		}
@@ -1100,28 +1285,15 @@ func (api *API) userRecoverRequestPOST(_ *database.User, w http.ResponseWriter,
 		api.WriteError(w, errors.AddContext(err, "failed to fetch the user with this email"), http.StatusInternalServerError)
 		return
 	}
-	// Generate a new recovery token and add it to the user's account.
-	u.RecoveryToken, err = lib.GenerateUUID()
-	if err != nil {
-		api.WriteError(w, errors.AddContext(err, "failed to generate a token"), http.StatusInternalServerError)
-		return
-	}
-	err = api.staticDB.UserSave(req.Context(), u)
+	// Generate a new recovery token. This invalidates any previous ones.
+	tk, err := api.staticDB.UserTokenCreate(req.Context(), u.ID, database.TokenPurposeRecovery, u.Email, database.RecoveryTokenTTL)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to create a token"), http.StatusInternalServerError)
 		return
 	}
 	// Send the token to the user via an email.
-	err = api.staticMailer.SendRecoverAccountEmail(req.Context(), u.Email, u.RecoveryToken)
+	err = api.staticMailer.SendRecoverAccountEmail(req.Context(), u.Email, u.Locale, tk)
 	if err != nil {
-		// The token was successfully generated and added to the user's account,
-		// but we failed to send it to the user. We will try to remove it.
-		u.RecoveryToken = ""
-		if errRem := api.staticDB.UserSave(req.Context(), u); errRem != nil {
-			api.WriteError(w, errors.AddContext(err, "failed to send recovery email. no token has been added to the account. please try again"), http.StatusInternalServerError)
-			return
-		}
-		// We failed to remove the token we added. The user needs to be notified.
 		api.WriteError(w, errors.AddContext(err, "failed to send recovery email. please try again"), http.StatusInternalServerError)
 		return
 	}
@@ -1134,12 +1306,12 @@ func (api *API) userRecoverRequestPOST(_ *database.User, w http.ResponseWriter,
 func (api *API) userRecoverPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Check if the registrations are open. If they are not then account
 	// recovery is also disabled.
-	val, err := api.staticDB.ReadConfigValue(req.Context(), database.ConfValRegistrationsDisabled)
-	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+	disabled, err := api.staticDB.SettingBool(req.Context(), database.ConfValRegistrationsDisabled)
+	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
 		return
 	}
-	if val == database.ConfValTrue {
+	if disabled {
 		api.WriteError(w, errors.New("registrations are currently disabled"), http.StatusNotImplemented)
 		return
 	}
@@ -1159,18 +1331,27 @@ func (api *API) userRecoverPOST(_ *database.User, w http.ResponseWriter, req *ht
 		api.WriteError(w, errors.New("passwords don't match"), http.StatusBadRequest)
 		return
 	}
-	u, err := api.staticDB.UserByRecoveryToken(req.Context(), payload.Token)
+	passHash, err := hash.Generate(payload.Password)
 	if err != nil {
-		api.WriteError(w, errors.New("no such user"), http.StatusBadRequest)
+		api.WriteError(w, errors.AddContext(err, "failed to hash password"), http.StatusInternalServerError)
+		return
+	}
+	ut, err := api.staticDB.UserTokenConsume(req.Context(), payload.Token, database.TokenPurposeRecovery)
+	if errors.Contains(err, database.ErrInvalidToken) {
+		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	passHash, err := hash.Generate(payload.Password)
 	if err != nil {
-		api.WriteError(w, errors.AddContext(err, "failed to hash password"), http.StatusInternalServerError)
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	u, err := api.staticDB.UserByID(req.Context(), ut.UserID)
+	if err != nil {
+		api.WriteError(w, errors.New("no such user"), http.StatusBadRequest)
 		return
 	}
 	u.PasswordHash = string(passHash)
-	u.RecoveryToken = ""
+	u.LockedAt = time.Time{}
 	err = api.staticDB.UserSave(req.Context(), u)
 	if err != nil {
 		api.WriteError(w, errors.AddContext(err, "failed to save password"), http.StatusInternalServerError)
@@ -1179,6 +1360,42 @@ func (api *API) userRecoverPOST(_ *database.User, w http.ResponseWriter, req *ht
 	api.loginUser(w, u, 0, false)
 }
 
+// userLockPOST locks the account to which the given email revert token belongs
+// and sends account recovery instructions to the old email address of the
+// account. The user gets the token at their old address when someone changes
+// the address of their account. We only accept the token in a POST body, so
+// link prefetchers can't lock accounts.
+// The user doesn't need to be logged in.
+func (api *API) userLockPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	var payload userLockPOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &payload)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse request body"), http.StatusBadRequest)
+		return
+	}
+	u, oldEmail, err := api.staticDB.UserLock(ctx, payload.Token)
+	if errors.Contains(err, database.ErrInvalidToken) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	tk, err := api.staticDB.UserTokenCreate(ctx, u.ID, database.TokenPurposeRecovery, oldEmail, database.RecoveryTokenTTL)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "account locked but failed to create a recovery token. please request a new one"), http.StatusInternalServerError)
+		return
+	}
+	err = api.staticMailer.SendRecoverAccountEmail(ctx, oldEmail, u.Locale, tk)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "account locked but failed to send recovery email. please request a new one"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
 // trackUploadPOST registers a new upload in the system.
 func (api *API) trackUploadPOST(_ *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	sl := ps.ByName("skylink")
@@ -1279,6 +1496,10 @@ func (api *API) trackDownloadPOST(u *database.User, w http.ResponseWriter, req *
 		}()
 	}
 	api.WriteSuccess(w)
+	// Check whether the user has exhausted their download bandwidth
+	// allowance. Note that this call is not affected by the request's context,
+	// so we use a separate one.
+	go api.checkUserQuotas(context.Background(), u)
 }
 
 // trackRegistryReadPOST registers a new registry read in the system.
@@ -1322,25 +1543,238 @@ func (api *API) userUploadsDELETE(u *database.User, w http.ResponseWriter, req *
 	go api.checkUserQuotas(context.Background(), u)
 }
 
-// checkUserQuotas compares the resources consumed by the user to their quotas
-// and sets the QuotaExceeded flag on their account if they exceed any.
+// checkUserQuotas compares the storage consumed by the user to their quotas and
+// sets the QuotaExceeded flag on their account if they exceed any. It also
+// refreshes the download bandwidth they've used during the current billing
+// period, which limits their download speed on its own.
 func (api *API) checkUserQuotas(ctx context.Context, u *database.User) {
-	startOfTime := time.Time{}
-	upStats, err := api.staticDB.UserStatsUpload(ctx, u.ID, startOfTime)
+	period, total, err := api.staticDB.UserCounters(ctx, *u)
 	if err != nil {
-		api.staticLogger.Debugln("Failed to get user's upload bandwidth used:", err)
+		api.staticLogger.Debugln("Failed to get user's usage counters:", err)
 		return
 	}
+	api.staticUserTierCache.SetDownloadBandwidthUsed(u.Sub, period.BandwidthDownloads)
 	quota := database.UserLimits[u.Tier]
-	quotaExceeded := upStats.CountTotal > int64(quota.MaxNumberUploads) || upStats.SizeTotal > quota.Storage
+	quotaExceeded := total.NumUploads > int64(quota.MaxNumberUploads) || total.UploadsSize > quota.Storage
 	if quotaExceeded != u.QuotaExceeded {
-		u.QuotaExceeded = quotaExceeded
-		err = api.staticDB.UserSave(ctx, u)
+		err = api.staticDB.UserSetQuotaExceeded(ctx, u, quotaExceeded)
 		if err != nil {
-			api.staticLogger.Warnf("Failed to save user. User: %+v, err: %s", u, err.Error())
+			api.staticLogger.Warnf("Failed to set the quota exceeded flag of user %s: %s", u.ID.Hex(), err)
 		}
 		api.staticUserTierCache.Set(u.Sub, u)
 	}
+	if u.Email == "" {
+		return
+	}
+	sent, err := api.staticDB.QuotaNotifications(ctx, *u)
+	if err != nil {
+		api.staticLogger.Debugln("Failed to get user's quota notifications:", err)
+		return
+	}
+	api.notifyQuotaThreshold(ctx, u, sent, database.QuotaResourceStorage, total.UploadsSize, quota.Storage)
+	api.notifyQuotaThreshold(ctx, u, sent, database.QuotaResourceUploads, total.NumUploads, int64(quota.MaxNumberUploads))
+	api.notifyQuotaThreshold(ctx, u, sent, database.QuotaResourceDownloadBandwidth, period.BandwidthDownloads, quota.MonthlyDownloadBandwidth)
+}
+
+// notifyQuotaThreshold emails the user when their usage of the given resource
+// crosses one of the QuotaWarningThresholds. Each threshold of the monthly
+// download bandwidth is emailed at most once per billing period, while those
+// of storage and the number of uploads are emailed only once. When several
+// thresholds are crossed at once, we only email the highest one. The sent
+// notifications are the ones the user has already received.
+func (api *API) notifyQuotaThreshold(ctx context.Context, u *database.User, sent []database.QuotaNotification, resource string, used, limit int64) {
+	var newThreshold int
+	for _, threshold := range QuotaWarningThresholds {
+		if !quotaThresholdReached(used, limit, threshold) || quotaNotificationSent(sent, resource, threshold) {
+			continue
+		}
+		// Another check might have registered the notification since we
+		// loaded the sent ones.
+		isNew, err := api.staticDB.QuotaNotificationRegister(ctx, *u, resource, threshold)
+		if err != nil {
+			api.staticLogger.Debugln("Failed to register quota notification:", err)
+			return
+		}
+		if isNew {
+			newThreshold = threshold
+		}
+	}
+	if newThreshold == 0 {
+		return
+	}
+	err := api.staticMailer.SendQuotaWarningEmail(ctx, u.Email, u.Locale, resource, newThreshold, used, limit)
+	if err != nil {
+		api.staticLogger.Warnf("Failed to send quota warning email to user %s: %s", u.ID.Hex(), err)
+		// Allow the notification to be sent again on the next check.
+		err = api.staticDB.QuotaNotificationUnregister(ctx, *u, resource, newThreshold)
+		if err != nil {
+			api.staticLogger.Debugln("Failed to unregister quota notification:", err)
+		}
+	}
+}
+
+// quotaNotificationSent reports whether the given notifications include the
+// one about the given threshold of the given resource.
+func quotaNotificationSent(sent []database.QuotaNotification, resource string, threshold int) bool {
+	for _, qn := range sent {
+		if qn.Resource == resource && qn.Threshold == threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaThresholdReached reports whether the used amount has reached the given
+// percentage of the limit. Resources without a limit never reach a threshold.
+func quotaThresholdReached(used, limit int64, threshold int) bool {
+	if limit <= 0 {
+		return false
+	}
+	return used*100 >= limit*int64(threshold)
+}
+
+// notifySecurityEvent notifies the user at the given email address about a
+// sensitive change of their account. Users without an email address are not
+// notified.
+func (api *API) notifySecurityEvent(ctx context.Context, addr types.Email, locale, event string) {
+	if addr == "" {
+		return
+	}
+	err := api.staticMailer.SendSecurityNotificationEmail(ctx, addr, locale, event)
+	if err != nil {
+		api.staticLogger.Warningln(errors.AddContext(err, "failed to send security notification email"))
+	}
+}
+
+// sendAddressConfirmation issues a new email confirmation token for the user
+// and sends it to the address they need to confirm. That's the address they
+// are switching to, if they have a pending email change. Failures are only
+// logged because the user can request a new confirmation email.
+func (api *API) sendAddressConfirmation(ctx context.Context, u *database.User) {
+	var tk string
+	var err error
+	to := u.Email
+	if u.PendingEmail != "" {
+		to = u.PendingEmail
+		tk, err = api.staticDB.UserCreateEmailChange(ctx, u.ID, to)
+	} else {
+		tk, err = api.staticDB.UserCreateEmailConfirmation(ctx, u.ID, to)
+	}
+	if err != nil {
+		api.staticLogger.Debugln(errors.AddContext(err, "failed to create an email confirmation token"))
+		return
+	}
+	err = api.staticMailer.SendAddressConfirmationEmail(ctx, to, u.Locale, tk)
+	if err != nil {
+		api.staticLogger.Debugln(errors.AddContext(err, "failed to send address confirmation email"))
+	}
+}
+
+// cacheUser stores the user in the userTierCache under the given key, together
+// with the download bandwidth they've used during the current billing period.
+// It returns the new cache entry.
+func (api *API) cacheUser(ctx context.Context, key string, u *database.User) userTierCacheEntry {
+	api.staticUserTierCache.Set(key, u)
+	period, _, err := api.staticDB.UserCounters(ctx, *u)
+	if err != nil {
+		api.staticLogger.Debugln("Failed to get user's usage counters:", err)
+	} else {
+		api.staticUserTierCache.SetDownloadBandwidthUsed(key, period.BandwidthDownloads)
+	}
+	ce, ok := api.staticUserTierCache.Get(key)
+	if !ok {
+		build.Critical("Failed to fetch user from UserTierCache right after setting it.")
+	}
+	return ce
+}
+
+// checkEmailConfirmed returns ErrEmailNotConfirmed if the portal requires a
+// confirmed email address for the given action and the user hasn't confirmed
+// theirs.
+func (api *API) checkEmailConfirmed(ctx context.Context, u *database.User, action string) error {
+	if !u.EmailUnconfirmed {
+		return nil
+	}
+	required, err := api.staticDB.EmailConfirmationRequired(ctx, action)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrEmailNotConfirmed
+	}
+	return nil
+}
+
+// emailDomainAllowed checks the given email address against the portal's email
+// domain policy. If the policy doesn't allow the address, it writes the error
+// to the response and returns false.
+func (api *API) emailDomainAllowed(ctx context.Context, w http.ResponseWriter, addr types.Email) bool {
+	err := api.staticDB.EmailDomainCheck(ctx, addr)
+	if errors.Contains(err, database.ErrEmailDomainNotAllowed) || errors.Contains(err, database.ErrEmailDomainDenied) || errors.Contains(err, database.ErrEmailDisposable) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// userLimitsFromCacheEntry returns the limits of the cached user. Users who
+// haven't confirmed their email address get the anonymous limits if the
+// portal requires a confirmed address for getting the limits of their tier.
+func (api *API) userLimitsFromCacheEntry(ctx context.Context, ce userTierCacheEntry, inBytes bool) *UserLimitsGET {
+	if !ce.EmailConfirmed && ce.Tier != database.TierAnonymous {
+		required, err := api.staticDB.EmailConfirmationRequired(ctx, database.ActionLimits)
+		if err != nil {
+			api.staticLogger.Debugln("Failed to read the email confirmation policy:", err)
+		}
+		if required {
+			return userLimitsGetFromTier(ce.Sub, database.TierAnonymous, ce.QuotaExceeded, ce.DownloadBandwidthUsed, inBytes)
+		}
+	}
+	return userLimitsGetFromTier(ce.Sub, ce.Tier, ce.QuotaExceeded, ce.DownloadBandwidthUsed, inBytes)
+}
+
+// setLocaleFromRequest sets the locale of a newly registered user based on the
+// Accept-Language header of their registration request.
+func (api *API) setLocaleFromRequest(ctx context.Context, u *database.User, req *http.Request) {
+	locale := localeFromRequest(req)
+	if locale == "" {
+		return
+	}
+	u.Locale = locale
+	err := api.staticDB.UserSave(ctx, u)
+	if err != nil {
+		api.staticLogger.Debugln(errors.AddContext(err, "failed to set user's locale"))
+	}
+}
+
+// challengePublic returns the public part of the given challenge.
+func challengePublic(ch *database.Challenge) ChallengePublic {
+	return ChallengePublic{
+		Challenge: ch.Challenge,
+		Type:      ch.Type,
+		ExpiresAt: ch.ExpiresAt,
+	}
+}
+
+// localeFromRequest returns the most preferred locale in the request's
+// Accept-Language header. It returns an empty string if the header is missing,
+// invalid or only contains a wildcard.
+func localeFromRequest(req *http.Request) string {
+	tags, _, err := language.ParseAcceptLanguage(req.Header.Get("Accept-Language"))
+	if err != nil {
+		return ""
+	}
+	for _, tag := range tags {
+		// Skip the wildcard, which is parsed as "mul" (multiple languages).
+		if tag != language.Und && tag.String() != "mul" {
+			return tag.String()
+		}
+	}
+	return ""
 }
 
 // userFromRequest checks the requests for various forms of authentication (API
@@ -1380,7 +1814,7 @@ func UserGETFromUser(u *database.User) *UserGET {
 	}
 	return &UserGET{
 		User:           *u,
-		EmailConfirmed: u.EmailConfirmationToken == "",
+		EmailConfirmed: !u.EmailUnconfirmed,
 	}
 }
 
@@ -1414,8 +1848,9 @@ func parseRequestBodyJSON(body io.ReadCloser, maxBodySize int64, v interface{})
 
 // userLimitsGetFromTier is a helper that lets us succinctly translate
 // from the database DTO to the API DTO. The `inBytes` parameter determines
-// whether the returned speeds will be in Bps or bps.
-func userLimitsGetFromTier(sub string, tierID int, quotaExceeded, inBytes bool) *UserLimitsGET {
+// whether the returned speeds will be in Bps or bps. The monthly download
+// bandwidth allowance and the remaining part of it are always in bytes.
+func userLimitsGetFromTier(sub string, tierID int, quotaExceeded bool, downloadBandwidthUsed int64, inBytes bool) *UserLimitsGET {
 	t, ok := database.UserLimits[tierID]
 	if !ok {
 		build.Critical("userLimitsGetFromTier was called with non-existent tierID: " + strconv.Itoa(tierID))
@@ -1431,6 +1866,16 @@ func userLimitsGetFromTier(sub string, tierID int, quotaExceeded, inBytes bool)
 	if inBytes {
 		bpsMul = 1
 	}
+	bwRemaining := t.MonthlyDownloadBandwidth - downloadBandwidthUsed
+	if bwRemaining < 0 {
+		bwRemaining = 0
+	}
+	// Users who exhaust their download bandwidth allowance get anonymous
+	// download speeds until the end of the billing period.
+	downloadTier := limitsTier
+	if t.MonthlyDownloadBandwidth > 0 && bwRemaining == 0 {
+		downloadTier = database.UserLimits[database.TierAnonymous]
+	}
 	return &UserLimitsGET{
 		Sub:              sub,
 		TierID:           tierID,
@@ -1441,8 +1886,11 @@ func userLimitsGetFromTier(sub string, tierID int, quotaExceeded, inBytes bool)
 		// If the user exceeds their quota, their speed will be brought down to
 		// anonymous levels.
 		UploadBandwidth:   limitsTier.UploadBandwidth * bpsMul,
-		DownloadBandwidth: limitsTier.DownloadBandwidth * bpsMul,
+		DownloadBandwidth: downloadTier.DownloadBandwidth * bpsMul,
 		RegistryDelay:     limitsTier.RegistryDelay,
+
+		MonthlyDownloadBandwidth:   t.MonthlyDownloadBandwidth,
+		DownloadBandwidthRemaining: bwRemaining,
 	}
 }
 
@@ -3,10 +3,15 @@ package api
 import (
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"github.com/SkynetLabs/skynet-accounts/types"
 	"gitlab.com/NebulousLabs/errors"
 )
 
@@ -23,8 +28,8 @@ func TestUserGETFromUser(t *testing.T) {
 	}
 
 	u = &database.User{}
-	// Call with a user without a confirmation token.
-	u.EmailConfirmationToken = ""
+	// Call with a user with a confirmed email address.
+	u.EmailUnconfirmed = false
 	uGET = UserGETFromUser(u)
 	if uGET == nil {
 		t.Fatal("Unexpected nil.")
@@ -33,8 +38,8 @@ func TestUserGETFromUser(t *testing.T) {
 		t.Fatal("Expected EmailConfirmed to be true.")
 	}
 
-	// Call with a user with a confirmation token.
-	u.EmailConfirmationToken = "token"
+	// Call with a user with an unconfirmed email address.
+	u.EmailUnconfirmed = true
 	uGET = UserGETFromUser(u)
 	if uGET == nil {
 		t.Fatal("Unexpected nil.")
@@ -52,12 +57,14 @@ func TestUserLimitsGetFromTier(t *testing.T) {
 		sub                   string
 		tier                  int
 		quotaExceeded         bool
+		downloadBWUsed        int64
 		expectedSub           string
 		expectedTier          int
 		expectedStorage       int64
 		expectedUploadBW      int
 		expectedDownloadBW    int
 		expectedRegistryDelay int
+		expectedBWRemaining   int64
 	}{
 		{
 			name:                  "anon",
@@ -82,6 +89,7 @@ func TestUserLimitsGetFromTier(t *testing.T) {
 			expectedUploadBW:      database.UserLimits[database.TierPremium5].UploadBandwidth,
 			expectedDownloadBW:    database.UserLimits[database.TierPremium5].DownloadBandwidth,
 			expectedRegistryDelay: database.UserLimits[database.TierPremium5].RegistryDelay,
+			expectedBWRemaining:   database.UserLimits[database.TierPremium5].MonthlyDownloadBandwidth,
 		},
 		{
 			name:                  "plus, quota exceeded",
@@ -94,11 +102,40 @@ func TestUserLimitsGetFromTier(t *testing.T) {
 			expectedUploadBW:      database.UserLimits[database.TierAnonymous].UploadBandwidth,
 			expectedDownloadBW:    database.UserLimits[database.TierAnonymous].DownloadBandwidth,
 			expectedRegistryDelay: database.UserLimits[database.TierAnonymous].RegistryDelay,
+			expectedBWRemaining:   database.UserLimits[database.TierPremium5].MonthlyDownloadBandwidth,
+		},
+		{
+			name:                  "plus, some download bandwidth used",
+			sub:                   "this is a plus sub",
+			tier:                  database.TierPremium5,
+			quotaExceeded:         false,
+			downloadBWUsed:        skynet.GiB,
+			expectedSub:           "this is a plus sub",
+			expectedTier:          database.TierPremium5,
+			expectedStorage:       database.UserLimits[database.TierPremium5].Storage,
+			expectedUploadBW:      database.UserLimits[database.TierPremium5].UploadBandwidth,
+			expectedDownloadBW:    database.UserLimits[database.TierPremium5].DownloadBandwidth,
+			expectedRegistryDelay: database.UserLimits[database.TierPremium5].RegistryDelay,
+			expectedBWRemaining:   database.UserLimits[database.TierPremium5].MonthlyDownloadBandwidth - skynet.GiB,
+		},
+		{
+			name:                  "plus, download bandwidth exhausted",
+			sub:                   "this is a plus sub",
+			tier:                  database.TierPremium5,
+			quotaExceeded:         false,
+			downloadBWUsed:        database.UserLimits[database.TierPremium5].MonthlyDownloadBandwidth,
+			expectedSub:           "this is a plus sub",
+			expectedTier:          database.TierPremium5,
+			expectedStorage:       database.UserLimits[database.TierPremium5].Storage,
+			expectedUploadBW:      database.UserLimits[database.TierPremium5].UploadBandwidth,
+			expectedDownloadBW:    database.UserLimits[database.TierAnonymous].DownloadBandwidth,
+			expectedRegistryDelay: database.UserLimits[database.TierPremium5].RegistryDelay,
+			expectedBWRemaining:   0,
 		},
 	}
 
 	for _, tt := range tests {
-		ul := userLimitsGetFromTier(tt.sub, tt.tier, tt.quotaExceeded, true)
+		ul := userLimitsGetFromTier(tt.sub, tt.tier, tt.quotaExceeded, tt.downloadBWUsed, true)
 		if ul.Sub != tt.expectedSub {
 			t.Errorf("Test '%s': expected sub '%s', got '%s'", tt.name, tt.expectedSub, ul.Sub)
 		}
@@ -117,6 +154,9 @@ func TestUserLimitsGetFromTier(t *testing.T) {
 		if ul.RegistryDelay != tt.expectedRegistryDelay {
 			t.Errorf("Test '%s': expected registry delay %d, got %d", tt.name, tt.expectedRegistryDelay, ul.RegistryDelay)
 		}
+		if ul.DownloadBandwidthRemaining != tt.expectedBWRemaining {
+			t.Errorf("Test '%s': expected remaining download bandwidth %d, got %d", tt.name, tt.expectedBWRemaining, ul.DownloadBandwidthRemaining)
+		}
 	}
 
 	// Additionally, let us ensure that userLimitsGetFromTier logs a critical
@@ -132,10 +172,78 @@ func TestUserLimitsGetFromTier(t *testing.T) {
 			}
 		}()
 		// The call that we expect to log a critical.
-		_ = userLimitsGetFromTier("", math.MaxInt, false, true)
+		_ = userLimitsGetFromTier("", math.MaxInt, false, 0, true)
 		return
 	}()
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+// TestQuotaThresholdReached ensures that quotaThresholdReached correctly
+// detects the crossing of quota thresholds.
+func TestQuotaThresholdReached(t *testing.T) {
+	tests := []struct {
+		used      int64
+		limit     int64
+		threshold int
+		reached   bool
+	}{
+		{used: 79, limit: 100, threshold: 80, reached: false},
+		{used: 80, limit: 100, threshold: 80, reached: true},
+		{used: 96, limit: 100, threshold: 95, reached: true},
+		{used: 99, limit: 100, threshold: 100, reached: false},
+		{used: 101, limit: 100, threshold: 100, reached: true},
+		{used: 100, limit: 0, threshold: 80, reached: false},
+		{used: 80 * skynet.TiB, limit: 100 * skynet.TiB, threshold: 80, reached: true},
+	}
+	for _, tt := range tests {
+		if r := quotaThresholdReached(tt.used, tt.limit, tt.threshold); r != tt.reached {
+			t.Errorf("Expected %t for %d/%d at %d%%, got %t", tt.reached, tt.used, tt.limit, tt.threshold, r)
+		}
+	}
+}
+
+// TestLocaleFromRequest ensures that localeFromRequest picks the most
+// preferred locale from the Accept-Language header.
+func TestLocaleFromRequest(t *testing.T) {
+	tests := []struct {
+		header string
+		locale string
+	}{
+		{header: "", locale: ""},
+		{header: "es", locale: "es"},
+		{header: "de-at, en;q=0.8", locale: "de-AT"},
+		{header: "en;q=0.5, pt-br;q=0.9", locale: "pt-BR"},
+		{header: "*", locale: ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/user", nil)
+		if tt.header != "" {
+			req.Header.Set("Accept-Language", tt.header)
+		}
+		if l := localeFromRequest(req); l != tt.locale {
+			t.Errorf("Expected locale '%s' for header '%s', got '%s'", tt.locale, tt.header, l)
+		}
+	}
+}
+
+// TestValidateEmailEvents ensures that we reject a batch of email events if
+// any of them is invalid.
+func TestValidateEmailEvents(t *testing.T) {
+	valid := []email.DeliveryEvent{
+		{Type: database.EmailSuppressionBounce, Email: types.NewEmail("a@example.com")},
+		{Type: database.EmailSuppressionComplaint, Email: types.NewEmail("b@example.com")},
+		// Events without an address are skipped.
+		{Type: "delivery"},
+	}
+	err := validateEmailEvents(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := append(valid, email.DeliveryEvent{Type: "delivery", Email: types.NewEmail("c@example.com")})
+	err = validateEmailEvents(invalid)
+	if !errors.Contains(err, database.ErrInvalidEmailSuppressionReason) {
+		t.Fatalf("Expected '%v', got '%v'", database.ErrInvalidEmailSuppressionReason, err)
+	}
+}
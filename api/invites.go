@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// ErrCodeInviteRequired is the error code we return when the portal is in
+	// invite-only mode and the user didn't provide a valid invite code.
+	ErrCodeInviteRequired = "invite_required"
+)
+
+var (
+	// ErrInviteRequired is returned when the portal is in invite-only mode and
+	// the user tries to register without an invite code.
+	ErrInviteRequired = errors.New("registrations are invite-only, please provide an invite code")
+)
+
+type (
+	// InvitePOST is the request body of POST /invites
+	InvitePOST struct {
+		// Tier is the tier we assign to the users who register with the code.
+		// Zero means the default tier.
+		Tier int `json:"tier"`
+		// MaxUses is the number of times the code can be used. Defaults to 1.
+		MaxUses int `json:"maxUses"`
+		// ExpiresIn is the number of seconds after which the code expires.
+		// Zero means the code doesn't expire.
+		ExpiresIn int64 `json:"expiresIn"`
+	}
+	// InvitesGET is the response of GET /invites and GET /user/invites
+	InvitesGET struct {
+		Items    []database.Invite `json:"items"`
+		Offset   int               `json:"offset"`
+		PageSize int               `json:"pageSize"`
+		Count    int               `json:"count"`
+	}
+)
+
+// invitesGET lists all invite codes.
+func (api *API) invitesGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	offset, err1 := fetchOffset(req.Form)
+	pageSize, err2 := fetchPageSize(req.Form, DefaultPageSizeSmall)
+	if err := errors.Compose(err1, err2); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	invites, total, err := api.staticDB.Invites(req.Context(), offset, pageSize)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, InvitesGET{Items: invites, Offset: offset, PageSize: pageSize, Count: total})
+}
+
+// invitePOST creates a new invite code on behalf of the portal's admins.
+func (api *API) invitePOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var body InvitePOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if body.MaxUses == 0 {
+		body.MaxUses = 1
+	}
+	ttl := time.Duration(body.ExpiresIn) * time.Second
+	inv, err := api.staticDB.InviteCreate(req.Context(), primitive.ObjectID{}, body.Tier, body.MaxUses, ttl)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	api.WriteJSON(w, inv)
+}
+
+// inviteDELETE deletes an invite code, so it can no longer be used.
+func (api *API) inviteDELETE(_ *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	err := api.staticDB.InviteDelete(req.Context(), ps.ByName("code"))
+	if errors.Contains(err, database.ErrInviteNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// userInvitesGET lists the invite codes created by the user.
+func (api *API) userInvitesGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	offset, err1 := fetchOffset(req.Form)
+	pageSize, err2 := fetchPageSize(req.Form, DefaultPageSizeSmall)
+	if err := errors.Compose(err1, err2); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	invites, total, err := api.staticDB.InvitesByUser(req.Context(), u.ID, offset, pageSize)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, InvitesGET{Items: invites, Offset: offset, PageSize: pageSize, Count: total})
+}
+
+// userInvitePOST creates a single-use invite code on behalf of the user. The
+// number of codes a user can create depends on their tier.
+func (api *API) userInvitePOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	inv, err := api.staticDB.InviteCreateForUser(req.Context(), *u)
+	if errors.Contains(err, database.ErrInviteQuotaExceeded) {
+		err = errors.AddContext(err, "the number of invite codes you can create per month is "+strconv.Itoa(database.InviteQuota[u.Tier]))
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, inv)
+}
+
+// redeemInvite redeems the given invite code for a new registration. The code
+// is required when the portal is in invite-only mode and optional otherwise.
+// It returns a nil invite when the user didn't provide a code and doesn't need
+// one. On failure, it writes the error to the response and returns false.
+func (api *API) redeemInvite(ctx context.Context, w http.ResponseWriter, code string) (*database.Invite, bool) {
+	required, err := api.staticDB.SettingBool(ctx, database.ConfValInvitesRequired)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to read from configuration"), http.StatusInternalServerError)
+		return nil, false
+	}
+	if code == "" {
+		if required {
+			api.WriteErrorWithCode(w, ErrInviteRequired, http.StatusForbidden, ErrCodeInviteRequired)
+			return nil, false
+		}
+		return nil, true
+	}
+	inv, err := api.staticDB.InviteRedeem(ctx, code)
+	if errors.Contains(err, database.ErrInvalidInviteCode) {
+		api.WriteErrorWithCode(w, err, http.StatusForbidden, ErrCodeInviteRequired)
+		return nil, false
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return inv, true
+}
+
+// releaseInvite gives back the use of the given invite code after we failed to
+// register the user who redeemed it.
+func (api *API) releaseInvite(ctx context.Context, inv *database.Invite) {
+	if inv == nil {
+		return
+	}
+	if err := api.staticDB.InviteRelease(ctx, inv.ID); err != nil {
+		api.staticLogger.Warningln(errors.AddContext(err, "failed to release invite code"))
+	}
+}
+
+// recordInvite stores the invite code with which the user registered and the
+// tier change it caused, if any.
+func (api *API) recordInvite(ctx context.Context, u *database.User, inv *database.Invite) {
+	if inv == nil {
+		return
+	}
+	u.InviteCode = inv.Code
+	if err := api.staticDB.UserSave(ctx, u); err != nil {
+		api.staticLogger.Warningln(errors.AddContext(err, "failed to record the user's invite code"))
+	}
+	// Invites which don't assign a tier leave the user on the free tier.
+	if u.Tier == database.TierFree {
+		return
+	}
+	if err := api.staticDB.TierChangeCreate(ctx, u.ID, database.TierFree, u.Tier, database.TierChangeSourceInvite); err != nil {
+		api.staticLogger.Warningln(errors.AddContext(err, "failed to record tier change"))
+	}
+}
+
+// inviteTier returns the tier of new users who register with the given invite
+// code.
+func inviteTier(inv *database.Invite) int {
+	if inv == nil || inv.Tier == 0 {
+		return database.TierFree
+	}
+	return inv.Tier
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// loginEmailConfirmPOST defines the payload we expect when a user follows
+	// a login link.
+	loginEmailConfirmPOST struct {
+		Token string `json:"token"`
+	}
+)
+
+// loginEmailGET checks whether the given login link token is still valid,
+// without using it up. The dashboard page to which the login link points can
+// use it for telling the user that the link has expired before they try to
+// log in.
+// The user doesn't need to be logged in.
+func (api *API) loginEmailGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ok, err := api.staticDB.UserTokenValid(req.Context(), req.FormValue("token"), database.TokenPurposeLoginLink)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		api.WriteError(w, database.ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// loginEmailConfirmPOST logs in the user to whom we sent the given login link
+// token. We only accept the token in a POST body, so mail scanners and link
+// prefetchers can't use it up or log in on the user's behalf.
+// The user doesn't need to be logged in.
+func (api *API) loginEmailConfirmPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	var payload loginEmailConfirmPOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &payload)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse request body"), http.StatusBadRequest)
+		return
+	}
+	ut, err := api.staticDB.UserTokenConsume(ctx, payload.Token, database.TokenPurposeLoginLink)
+	if errors.Contains(err, database.ErrInvalidToken) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	u, err := api.staticDB.UserByID(ctx, ut.UserID)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, database.ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// The link only proves that the user owns the address to which we sent
+	// it, so it's no longer valid once the account's address changes.
+	if u.Email != ut.Email {
+		api.WriteError(w, database.ErrInvalidToken, http.StatusBadRequest)
+		return
+	}
+	api.loginUser(w, u, 0, false)
+}
+
+// loginEmailPOST sends the user a link which logs them in without a password.
+// This allows users who registered without a password to log in even when
+// they lost their MySky seed.
+// The user doesn't need to be logged in.
+func (api *API) loginEmailPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	// We don't expect a password but we want to use the same email parsing
+	// approach in all cases where we get an email address from the user.
+	var payload credentialsPOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &payload)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse request body"), http.StatusBadRequest)
+		return
+	}
+	if payload.Email == "" {
+		api.WriteError(w, errors.New("missing required parameter 'email'"), http.StatusBadRequest)
+		return
+	}
+	u, err := api.staticDB.UserByEmail(ctx, payload.Email)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		// Same as with account recovery, we let the owner of the address know
+		// that someone tried to use it but we don't tell the caller whether
+		// the address is in our database.
+		errSend := api.staticMailer.SendAccountAccessAttemptedEmail(ctx, payload.Email, localeFromRequest(req))
+		if errSend != nil {
+			api.staticLogger.Warningln(errors.AddContext(errSend, "failed to send an email"))
+		}
+		api.WriteSuccess(w)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to fetch the user with this email"), http.StatusInternalServerError)
+		return
+	}
+	// Generate a new login token. This invalidates any previous ones.
+	tk, err := api.staticDB.UserTokenCreate(ctx, u.ID, database.TokenPurposeLoginLink, u.Email, database.LoginLinkTokenTTL)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to create a token"), http.StatusInternalServerError)
+		return
+	}
+	err = api.staticMailer.SendLoginLinkEmail(ctx, u.Email, u.Locale, tk)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to send login email. please try again"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
@@ -0,0 +1,353 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
+	"github.com/SkynetLabs/skynet-accounts/webauthn"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// passkeyTimeout is the time in milliseconds we give the user for
+	// completing a passkey ceremony.
+	passkeyTimeout = 5 * 60 * 1000
+	// passkeyMaxNameLen is the maximum length of a passkey's name.
+	passkeyMaxNameLen = 64
+)
+
+type (
+	// PublicKeyCredential is the JSON form of a WebAuthn credential, as
+	// returned by the browser's navigator.credentials API. All binary values
+	// are base64url-encoded.
+	PublicKeyCredential struct {
+		ID       string                   `json:"id"`
+		Type     string                   `json:"type"`
+		Response AuthenticatorResponseRaw `json:"response"`
+	}
+	// AuthenticatorResponseRaw holds the fields of both the attestation and
+	// the assertion responses of an authenticator.
+	AuthenticatorResponseRaw struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"`
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	}
+	// PasskeyCreationOptions is the response of GET /user/passkeys/register.
+	// It's the JSON form of the options the client needs to pass to
+	// navigator.credentials.create().
+	PasskeyCreationOptions struct {
+		Challenge              string                        `json:"challenge"`
+		RP                     PasskeyEntity                 `json:"rp"`
+		User                   PasskeyEntity                 `json:"user"`
+		PubKeyCredParams       []PasskeyCredentialParameters `json:"pubKeyCredParams"`
+		Timeout                int                           `json:"timeout"`
+		Attestation            string                        `json:"attestation"`
+		ExcludeCredentials     []PasskeyCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection PasskeyAuthenticatorSelection `json:"authenticatorSelection"`
+	}
+	// PasskeyRequestOptions is the response of GET /login/passkey. It's the
+	// JSON form of the options the client needs to pass to
+	// navigator.credentials.get().
+	PasskeyRequestOptions struct {
+		Challenge        string `json:"challenge"`
+		RPID             string `json:"rpId"`
+		Timeout          int    `json:"timeout"`
+		UserVerification string `json:"userVerification"`
+	}
+	// PasskeyEntity describes the relying party or the user in the passkey
+	// creation options.
+	PasskeyEntity struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName,omitempty"`
+	}
+	// PasskeyCredentialParameters describes a type of credential we accept.
+	PasskeyCredentialParameters struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	}
+	// PasskeyCredentialDescriptor identifies a credential.
+	PasskeyCredentialDescriptor struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	// PasskeyAuthenticatorSelection describes the authenticators we accept.
+	PasskeyAuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	}
+	// PasskeyRegisterPOST is the request body of POST /user/passkeys/register
+	PasskeyRegisterPOST struct {
+		Name       string              `json:"name"`
+		Credential PublicKeyCredential `json:"credential"`
+	}
+	// PasskeysGET is the response of GET /user/passkeys
+	PasskeysGET struct {
+		Items []database.Passkey `json:"items"`
+	}
+	// passkeyLoginPOST is the request body of POST /login when logging in
+	// with a passkey.
+	passkeyLoginPOST struct {
+		Passkey *PublicKeyCredential `json:"passkey"`
+	}
+)
+
+// loginPasskeyGET generates a challenge for logging in with a passkey.
+func (api *API) loginPasskeyGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ch, err := api.staticDB.NewPasskeyChallenge(req.Context(), primitive.ObjectID{}, database.ChallengeTypePasskeyLogin)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	challenge, err := passkeyChallenge(ch)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             relyingParty().ID,
+		Timeout:          passkeyTimeout,
+		UserVerification: "preferred",
+	})
+}
+
+// loginPOSTPasskey is a helper that handles logins with a passkey.
+func (api *API) loginPOSTPasskey(w http.ResponseWriter, req *http.Request, cred PublicKeyCredential, jwtTTL int) {
+	ctx := req.Context()
+	clientData, err1 := webauthn.DecodeBase64URL(cred.Response.ClientDataJSON)
+	authData, err2 := webauthn.DecodeBase64URL(cred.Response.AuthenticatorData)
+	sig, err3 := webauthn.DecodeBase64URL(cred.Response.Signature)
+	credID, err4 := webauthn.DecodeBase64URL(cred.ID)
+	if err := errors.Compose(err1, err2, err3, err4); err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid passkey credential"), http.StatusBadRequest)
+		return
+	}
+	cd, err := webauthn.ParseClientData(clientData)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	challenge, err := webauthn.DecodeBase64URL(cd.Challenge)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid challenge"), http.StatusBadRequest)
+		return
+	}
+	_, err = api.staticDB.PasskeyChallengeConsume(ctx, challenge, database.ChallengeTypePasskeyLogin)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to validate challenge response"), http.StatusUnauthorized)
+		return
+	}
+	pk, err := api.staticDB.PasskeyByCredentialID(ctx, credID)
+	if errors.Contains(err, database.ErrPasskeyNotFound) {
+		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Authenticators which store the credential on the device return the id
+	// of the user we gave them during registration.
+	if cred.Response.UserHandle != "" && cred.Response.UserHandle != userHandle(pk.UserID) {
+		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	signCount, err := relyingParty().VerifyAssertion(challenge, pk.Credential(), clientData, authData, sig)
+	if err != nil {
+		api.staticLogger.Debugf("Failed to verify passkey %s: %v", pk.ID.Hex(), err)
+		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	err = api.staticDB.PasskeyUsed(ctx, pk, signCount)
+	if err != nil {
+		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	u, err := api.staticDB.UserByID(ctx, pk.UserID)
+	if err != nil {
+		api.WriteError(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	api.loginUser(w, u, jwtTTL, false)
+}
+
+// userPasskeysGET lists the user's passkeys.
+func (api *API) userPasskeysGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	pks, err := api.staticDB.PasskeysByUser(req.Context(), u.ID)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, PasskeysGET{Items: pks})
+}
+
+// userPasskeyDELETE deletes one of the user's passkeys.
+func (api *API) userPasskeyDELETE(u *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	ctx := req.Context()
+	id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid passkey id"), http.StatusBadRequest)
+		return
+	}
+	err = api.staticDB.LoginMethodsLock(ctx, u.ID)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ok, err := api.staticDB.HasLoginMethodBesides(ctx, *u, nil, id)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		api.WriteError(w, database.ErrLastLoginMethod, http.StatusBadRequest)
+		return
+	}
+	err = api.staticDB.PasskeyDelete(ctx, u.ID, id)
+	if errors.Contains(err, database.ErrPasskeyNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.notifySecurityEvent(ctx, u.Email, u.Locale, email.SecurityEventPasskeyRemoved)
+	api.WriteSuccess(w)
+}
+
+// userPasskeyRegisterGET generates the options for registering a new passkey.
+func (api *API) userPasskeyRegisterGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	pks, err := api.staticDB.PasskeysByUser(ctx, u.ID)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	ch, err := api.staticDB.NewPasskeyChallenge(ctx, u.ID, database.ChallengeTypePasskeyRegister)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	challenge, err := passkeyChallenge(ch)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	rp := relyingParty()
+	name := u.Email.String()
+	if name == "" {
+		name = u.Sub
+	}
+	opts := PasskeyCreationOptions{
+		Challenge:          challenge,
+		RP:                 PasskeyEntity{ID: rp.ID, Name: rp.Name},
+		User:               PasskeyEntity{ID: userHandle(u.ID), Name: name, DisplayName: name},
+		Timeout:            passkeyTimeout,
+		Attestation:        "none",
+		ExcludeCredentials: make([]PasskeyCredentialDescriptor, 0, len(pks)),
+		AuthenticatorSelection: PasskeyAuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+	}
+	for _, alg := range webauthn.SupportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, PasskeyCredentialParameters{Type: "public-key", Alg: alg})
+	}
+	for _, pk := range pks {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, PasskeyCredentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(pk.CredentialID),
+		})
+	}
+	api.WriteJSON(w, opts)
+}
+
+// userPasskeyRegisterPOST verifies the response of the user's authenticator to
+// the registration challenge and stores the new passkey.
+func (api *API) userPasskeyRegisterPOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	var body PasskeyRegisterPOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if len(body.Name) > passkeyMaxNameLen {
+		api.WriteError(w, errors.New("the passkey's name is too long"), http.StatusBadRequest)
+		return
+	}
+	clientData, err1 := webauthn.DecodeBase64URL(body.Credential.Response.ClientDataJSON)
+	attObj, err2 := webauthn.DecodeBase64URL(body.Credential.Response.AttestationObject)
+	if err := errors.Compose(err1, err2); err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid passkey credential"), http.StatusBadRequest)
+		return
+	}
+	cd, err := webauthn.ParseClientData(clientData)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	challenge, err := webauthn.DecodeBase64URL(cd.Challenge)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "invalid challenge"), http.StatusBadRequest)
+		return
+	}
+	ch, err := api.staticDB.PasskeyChallengeConsume(ctx, challenge, database.ChallengeTypePasskeyRegister)
+	if err != nil || ch.UserID != u.ID {
+		api.WriteError(w, errors.Compose(errors.New("failed to validate challenge response"), err), http.StatusBadRequest)
+		return
+	}
+	cred, err := relyingParty().VerifyRegistration(challenge, clientData, attObj)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to verify the passkey"), http.StatusBadRequest)
+		return
+	}
+	pk, err := api.staticDB.PasskeyCreate(ctx, u.ID, cred, body.Name)
+	if errors.Contains(err, database.ErrPasskeyExists) || errors.Contains(err, database.ErrMaxNumPasskeysExceeded) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.notifySecurityEvent(ctx, u.Email, u.Locale, email.SecurityEventPasskeyAdded)
+	api.WriteJSON(w, pk)
+}
+
+// passkeyChallenge returns the given challenge in the form the browser's
+// WebAuthn API expects it.
+func passkeyChallenge(ch *database.Challenge) (string, error) {
+	b, err := hex.DecodeString(ch.Challenge)
+	if err != nil {
+		return "", errors.AddContext(err, "invalid challenge")
+	}
+	return webauthn.ChallengeString(b), nil
+}
+
+// relyingParty returns the WebAuthn relying party of this portal. The
+// passkeys are valid for the portal's domain and all its subdomains.
+func relyingParty() webauthn.RelyingParty {
+	host := database.PortalName
+	if u, err := url.Parse(database.PortalName); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return webauthn.RelyingParty{ID: host, Name: host, Origins: database.TrustedOrigins}
+}
+
+// userHandle returns the WebAuthn user handle of the given user.
+func userHandle(uID primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString(uID[:])
+}
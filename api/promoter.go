@@ -36,10 +36,15 @@ func (api *API) promoterSetTierPOST(_ *database.User, w http.ResponseWriter, req
 		api.WriteError(w, err, http.StatusNotFound)
 		return
 	}
+	oldTier := u.Tier
 	err = api.staticDB.UserSetTier(ctx, u, body.Tier)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
+	err = api.staticDB.TierChangeCreate(ctx, u.ID, oldTier, u.Tier, database.TierChangeSourcePromoter)
+	if err != nil {
+		api.staticLogger.Warnf("Failed to record the tier change of user '%s': %s", u.ID.Hex(), err)
+	}
 	api.WriteSuccess(w)
 }
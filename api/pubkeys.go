@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// pubKeyMaxLabelLen is the maximum length of a pubkey's label, in
+	// characters.
+	pubKeyMaxLabelLen = 64
+)
+
+type (
+	// PubKeyGET describes one of the user's pubkeys.
+	PubKeyGET struct {
+		Key   string `json:"key"`
+		Type  string `json:"type"`
+		Label string `json:"label"`
+		// CreatedAt is the zero time for pubkeys added before we started
+		// tracking it. The same goes for LastLoginAt and pubkeys which
+		// haven't been used for logging in since then.
+		CreatedAt   time.Time `json:"createdAt"`
+		LastLoginAt time.Time `json:"lastLoginAt"`
+	}
+	// PubKeysGET is the response of GET /user/pubkeys
+	PubKeysGET struct {
+		Items []PubKeyGET `json:"items"`
+	}
+	// PubKeyPUT is the request body of PUT /user/pubkey/:pubKey
+	PubKeyPUT struct {
+		Label string `json:"label"`
+	}
+	// pubKeyRegisterPOST holds the fields of the request body of
+	// POST /user/pubkey/register which are not part of the challenge
+	// response.
+	pubKeyRegisterPOST struct {
+		Label string `json:"label"`
+	}
+)
+
+// userPubKeysGET lists the user's pubkeys.
+func (api *API) userPubKeysGET(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	recs, err := api.staticDB.PubKeyRecords(req.Context(), *u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	items := make([]PubKeyGET, 0, len(recs))
+	for _, r := range recs {
+		items = append(items, PubKeyGET{
+			Key:         r.Key.String(),
+			Type:        r.Key.Type(),
+			Label:       r.Label,
+			CreatedAt:   r.CreatedAt,
+			LastLoginAt: r.LastLoginAt,
+		})
+	}
+	api.WriteJSON(w, PubKeysGET{Items: items})
+}
+
+// userPubKeyPUT changes the label of one of the user's pubkeys.
+func (api *API) userPubKeyPUT(u *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var pk database.PubKey
+	err := pk.LoadString(ps.ByName("pubKey"))
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if !u.HasKey(pk) {
+		api.WriteError(w, errors.New("the given pubkey is not associated with this user"), http.StatusBadRequest)
+		return
+	}
+	var body PubKeyPUT
+	err = parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	label, err := pubKeyLabel(body.Label)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	err = api.staticDB.PubKeyLabelSet(req.Context(), u.ID, pk, label)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteSuccess(w)
+}
+
+// readPubKeyRegisterBody reads the body of POST /user/pubkey/register which
+// holds the challenge response and, optionally, the label of the new pubkey.
+func readPubKeyRegisterBody(body io.Reader) (database.ChallengeResponse, string, error) {
+	var chr database.ChallengeResponse
+	b, err := io.ReadAll(io.LimitReader(body, LimitBodySizeSmall))
+	if err != nil {
+		return chr, "", errors.AddContext(err, "failed to read request body")
+	}
+	err = chr.LoadFromBytes(b)
+	if err != nil {
+		return chr, "", errors.AddContext(err, "missing or invalid challenge response")
+	}
+	var payload pubKeyRegisterPOST
+	err = json.Unmarshal(b, &payload)
+	if err != nil {
+		return chr, "", errors.AddContext(err, "failed to parse request body")
+	}
+	label, err := pubKeyLabel(payload.Label)
+	if err != nil {
+		return chr, "", err
+	}
+	return chr, label, nil
+}
+
+// pubKeyLabel trims the given label and makes sure it's not too long.
+func pubKeyLabel(label string) (string, error) {
+	label = strings.TrimSpace(label)
+	if utf8.RuneCountInString(label) > pubKeyMaxLabelLen {
+		return "", errors.New("the pubkey's label is too long")
+	}
+	return label, nil
+}
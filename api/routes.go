@@ -39,10 +39,17 @@ func (api *API) buildHTTPRoutes() {
 
 	api.staticRouter.GET("/login", api.WithDBSession(api.noAuth(api.loginGET)))
 	api.staticRouter.POST("/login", api.WithDBSession(api.noAuth(api.loginPOST)))
+	api.staticRouter.GET("/login/email", api.noAuth(api.loginEmailGET))
+	api.staticRouter.POST("/login/email", api.WithDBSession(api.noAuth(api.loginEmailPOST)))
+	api.staticRouter.POST("/login/email/confirm", api.WithDBSession(api.noAuth(api.loginEmailConfirmPOST)))
+	api.staticRouter.GET("/login/passkey", api.WithDBSession(api.noAuth(api.loginPasskeyGET)))
 	api.staticRouter.POST("/logout", api.withAuth(api.logoutPOST, false))
 	api.staticRouter.GET("/register", api.noAuth(api.registerGET))
 	api.staticRouter.POST("/register", api.WithDBSession(api.noAuth(api.registerPOST)))
 
+	// Endpoint at which email providers report bounces and complaints.
+	api.staticRouter.POST("/email/events", api.noAuth(api.emailEventsPOST))
+
 	// Endpoints at which Nginx reports portal usage.
 	api.staticRouter.POST("/track/upload/:skylink", api.noAuth(api.trackUploadPOST))
 	api.staticRouter.POST("/track/download/:skylink", api.withAuth(api.trackDownloadPOST, true))
@@ -56,12 +63,17 @@ func (api *API) buildHTTPRoutes() {
 	api.staticRouter.GET("/user/limits", api.noAuth(api.userLimitsGET))
 	api.staticRouter.GET("/user/limits/:skylink", api.noAuth(api.userLimitsSkylinkGET))
 	api.staticRouter.GET("/user/stats", api.withAuth(api.userStatsGET, false))
+	api.staticRouter.GET("/user/stats/history", api.withAuth(api.userStatsHistoryGET, false))
+	api.staticRouter.GET("/user/pubkeys", api.withAuth(api.userPubKeysGET, false))
+	api.staticRouter.PUT("/user/pubkey/:pubKey", api.WithDBSession(api.withAuth(api.userPubKeyPUT, false)))
 	api.staticRouter.DELETE("/user/pubkey/:pubKey", api.WithDBSession(api.withAuth(api.userPubKeyDELETE, false)))
 	api.staticRouter.GET("/user/pubkey/register", api.WithDBSession(api.withAuth(api.userPubKeyRegisterGET, false)))
 	api.staticRouter.POST("/user/pubkey/register", api.WithDBSession(api.withAuth(api.userPubKeyRegisterPOST, false)))
 	api.staticRouter.GET("/user/uploads", api.withAuth(api.userUploadsGET, false))
 	api.staticRouter.DELETE("/user/uploads/:skylink", api.withAuth(api.userUploadsDELETE, false))
 	api.staticRouter.GET("/user/downloads", api.withAuth(api.userDownloadsGET, false))
+	api.staticRouter.POST("/user/merge", api.WithDBSession(api.withAuth(api.userMergePOST, false)))
+	api.staticRouter.POST("/user/merge/token", api.withAuth(api.userMergeTokenPOST, false))
 
 	// Endpoints for user API keys.
 	api.staticRouter.POST("/user/apikeys", api.WithDBSession(api.withAuth(api.userAPIKeyPOST, true)))
@@ -71,8 +83,21 @@ func (api *API) buildHTTPRoutes() {
 	api.staticRouter.PATCH("/user/apikeys/:id", api.WithDBSession(api.withAuth(api.userAPIKeyPATCH, true)))
 	api.staticRouter.DELETE("/user/apikeys/:id", api.withAuth(api.userAPIKeyDELETE, true))
 
+	// Endpoints for user passkeys.
+	api.staticRouter.GET("/user/passkeys", api.withAuth(api.userPasskeysGET, false))
+	api.staticRouter.DELETE("/user/passkeys/:id", api.WithDBSession(api.withAuth(api.userPasskeyDELETE, false)))
+	api.staticRouter.GET("/user/passkeys/register", api.WithDBSession(api.withAuth(api.userPasskeyRegisterGET, false)))
+	api.staticRouter.POST("/user/passkeys/register", api.WithDBSession(api.withAuth(api.userPasskeyRegisterPOST, false)))
+
+	// Endpoints for invite codes.
+	api.staticRouter.GET("/user/invites", api.withAuth(api.userInvitesGET, false))
+	api.staticRouter.POST("/user/invites", api.withAuth(api.userInvitePOST, false))
+
 	// Endpoints for email communication with the user.
-	api.staticRouter.GET("/user/confirm", api.WithDBSession(api.noAuth(api.userConfirmGET))) // TODO POST
+	api.staticRouter.GET("/user/confirm", api.WithDBSession(api.noAuth(api.userConfirmGET)))
+	api.staticRouter.POST("/user/confirm", api.WithDBSession(api.noAuth(api.userConfirmPOST)))
+	api.staticRouter.POST("/user/lock", api.noAuth(api.userLockPOST))
+	api.staticRouter.GET("/user/confirm/status", api.withAuth(api.userConfirmStatusGET, false))
 	api.staticRouter.POST("/user/reconfirm", api.WithDBSession(api.withAuth(api.userReconfirmPOST, false)))
 	api.staticRouter.POST("/user/recover/request", api.WithDBSession(api.noAuth(api.userRecoverRequestPOST)))
 	api.staticRouter.POST("/user/recover", api.WithDBSession(api.noAuth(api.userRecoverPOST)))
@@ -92,6 +117,22 @@ func (api *API) buildHTTPRoutes() {
 	// Internal endpoints. Never expose these!
 	api.staticRouter.GET("/uploadinfo/:skylink", api.noAuth(api.uploadInfoGET))
 	api.staticRouter.GET("/uploadedskylinks", api.noAuth(api.uploadedSkylinksGET))
+	api.staticRouter.POST("/usercounters/rebuild", api.noAuth(api.userCountersRebuildPOST))
+	api.staticRouter.GET("/analytics", api.noAuth(api.analyticsGET))
+	api.staticRouter.GET("/analytics/history", api.noAuth(api.analyticsHistoryGET))
+	api.staticRouter.GET("/analytics/topuploaders", api.noAuth(api.analyticsTopUploadersGET))
+	api.staticRouter.GET("/emails", api.noAuth(api.emailsGET))
+	api.staticRouter.POST("/emails/requeue", api.noAuth(api.emailsRequeuePOST))
+	api.staticRouter.DELETE("/emails/suppressions/:email", api.noAuth(api.emailSuppressionDELETE))
+	api.staticRouter.GET("/settings", api.noAuth(api.settingsGET))
+	api.staticRouter.GET("/settings/changes", api.noAuth(api.settingChangesGET))
+	api.staticRouter.PUT("/settings/:key", api.noAuth(api.settingPUT))
+	api.staticRouter.GET("/invites", api.noAuth(api.invitesGET))
+	api.staticRouter.POST("/invites", api.noAuth(api.invitePOST))
+	api.staticRouter.DELETE("/invites/:code", api.noAuth(api.inviteDELETE))
+	api.staticRouter.GET("/users/duplicates", api.noAuth(api.emailDuplicatesGET))
+	api.staticRouter.POST("/users/duplicates/check", api.noAuth(api.emailDuplicatesCheckPOST))
+	api.staticRouter.POST("/users/merge", api.WithDBSession(api.noAuth(api.usersMergePOST)))
 
 	if api.staticPromoter == PromoterPromoter {
 		api.staticRouter.POST("/promoter/settier/:sub", api.noAuth(api.promoterSetTierPOST))
@@ -120,6 +161,10 @@ func (api *API) withAuth(h HandlerWithUser, allowsAPIKey bool) httprouter.Handle
 			api.WriteError(w, err, http.StatusInternalServerError)
 			return
 		}
+		if !u.LockedAt.IsZero() {
+			api.WriteError(w, database.ErrAccountLocked, http.StatusForbidden)
+			return
+		}
 		// Embed the verified token in the context of the request.
 		ctx := jwt.ContextWithToken(req.Context(), token)
 		h(u, w, req.WithContext(ctx), ps)
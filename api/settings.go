@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// SettingsGET is the response of GET /settings
+	SettingsGET struct {
+		Items []database.Setting `json:"items"`
+	}
+	// SettingPUT is the request body of PUT /settings/:key
+	SettingPUT struct {
+		// Value is the new value of the setting. It's either in the setting's
+		// type, e.g. a bool for bool settings, or a string.
+		Value interface{} `json:"value"`
+		// ChangedBy identifies the admin who changes the setting. We record it
+		// in the settings audit log.
+		ChangedBy string `json:"changedBy"`
+	}
+	// SettingChangesGET is the response of GET /settings/changes
+	SettingChangesGET struct {
+		Items    []database.SettingChange `json:"items"`
+		Offset   int                      `json:"offset"`
+		PageSize int                      `json:"pageSize"`
+		Count    int                      `json:"count"`
+	}
+)
+
+// settingsGET returns the current values of all runtime settings.
+func (api *API) settingsGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	settings, err := api.staticDB.Settings(req.Context())
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, SettingsGET{Items: settings})
+}
+
+// settingPUT changes the value of a runtime setting. The change takes effect
+// on all servers within database.SettingsCacheTTL.
+func (api *API) settingPUT(_ *database.User, w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var body SettingPUT
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeLarge, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if body.ChangedBy == "" {
+		api.WriteError(w, errors.New("missing parameter 'changedBy'"), http.StatusBadRequest)
+		return
+	}
+	if body.Value == nil {
+		api.WriteError(w, errors.New("missing parameter 'value'"), http.StatusBadRequest)
+		return
+	}
+	s, err := api.staticDB.SettingSet(req.Context(), ps.ByName("key"), body.Value, body.ChangedBy)
+	if errors.Contains(err, database.ErrUnknownSetting) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if errors.Contains(err, database.ErrInvalidSettingValue) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, s)
+}
+
+// settingChangesGET returns the audit log of the changes of runtime settings,
+// newest first. It can be filtered by the setting's key.
+func (api *API) settingChangesGET(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	offset, err1 := fetchOffset(req.Form)
+	pageSize, err2 := fetchPageSize(req.Form, DefaultPageSizeSmall)
+	if err := errors.Compose(err1, err2); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	changes, total, err := api.staticDB.SettingChanges(req.Context(), req.Form.Get("key"), offset, pageSize)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	response := SettingChangesGET{
+		Items:    changes,
+		Offset:   offset,
+		PageSize: pageSize,
+		Count:    total,
+	}
+	api.WriteJSON(w, response)
+}
@@ -138,6 +138,7 @@ func (api *API) processStripeSub(ctx context.Context, s *stripe.Subscription) er
 		errMsg := fmt.Sprintf("failed to fetch user from DB for customer id %s", s.Customer.ID)
 		return errors.AddContext(err, errMsg)
 	}
+	oldTier := u.Tier
 	// Get all active subscriptions for this customer. There should be only one
 	// (or none) but we'd better check.
 	it := sub.List(&stripe.SubscriptionListParams{
@@ -195,6 +196,10 @@ func (api *API) processStripeSub(ctx context.Context, s *stripe.Subscription) er
 	err = api.staticDB.UserSave(ctx, u)
 	if err == nil {
 		api.staticLogger.Tracef("Subscribed user id '%s', tier %d, until %s.", u.ID, u.Tier, u.SubscribedUntil.String())
+		errTC := api.staticDB.TierChangeCreate(ctx, u.ID, oldTier, u.Tier, database.TierChangeSourceStripe)
+		if errTC != nil {
+			api.staticLogger.Warnf("Failed to record the tier change of user '%s': %s", u.ID.Hex(), errTC)
+		}
 	}
 	// Re-set the tier cache for this user, in case their tier changed.
 	api.staticUserTierCache.Set(u.Sub, u)
@@ -238,10 +243,19 @@ func (api *API) stripeCheckoutPOST(u *database.User, w http.ResponseWriter, req
 		api.WriteError(w, ErrStripeNotConfigured, http.StatusBadRequest)
 		return
 	}
+	err := api.checkEmailConfirmed(req.Context(), u, database.ActionCheckout)
+	if errors.Contains(err, ErrEmailNotConfirmed) {
+		api.WriteErrorWithCode(w, err, http.StatusForbidden, ErrCodeEmailNotConfirmed)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
 	body := struct {
 		Price string `json:"price"`
 	}{}
-	err := json.NewDecoder(io.LimitReader(req.Body, LimitBodySizeSmall)).Decode(&body)
+	err = json.NewDecoder(io.LimitReader(req.Body, LimitBodySizeSmall)).Decode(&body)
 	if err != nil {
 		api.WriteError(w, errors.New("missing parameter 'price'"), http.StatusBadRequest)
 		return
@@ -181,3 +181,47 @@ func parseInt64Param(req *http.Request, name string) (int64, error) {
 	}
 	return val, nil
 }
+
+// parseTimePeriod reads the `from` and `to` Unix timestamps from the request.
+// When `to` is missing, it defaults to now. When `from` is missing, it
+// defaults to `defaultPeriod` before `to`. Periods longer than `maxPeriod` are
+// rejected.
+func parseTimePeriod(req *http.Request, defaultPeriod, maxPeriod time.Duration) (from, to time.Time, err error) {
+	fromUnix, err := parseInt64Param(req, "from")
+	if err != nil {
+		return
+	}
+	toUnix, err := parseInt64Param(req, "to")
+	if err != nil {
+		return
+	}
+	to = time.Now().UTC()
+	if toUnix != 0 {
+		to = time.Unix(toUnix, 0).UTC()
+	}
+	from = to.Add(-defaultPeriod)
+	if fromUnix != 0 {
+		from = time.Unix(fromUnix, 0).UTC()
+	}
+	if !from.Before(to) {
+		return from, to, database.ErrInvalidTimePeriod
+	}
+	if to.Sub(from) > maxPeriod {
+		return from, to, ErrTimePeriodTooLong
+	}
+	return from, to, nil
+}
+
+// historyPeriodLimits returns the default and the maximum time periods we
+// allow when returning historical data with the given granularity.
+func historyPeriodLimits(granularity string) (defaultPeriod, maxPeriod time.Duration, err error) {
+	day := 24 * time.Hour
+	switch granularity {
+	case database.GranularityDay:
+		return 30 * day, 366 * day, nil
+	case database.GranularityMonth:
+		return 365 * day, 10 * 366 * day, nil
+	default:
+		return 0, 0, database.ErrInvalidGranularity
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// UserCountersRebuildResponse reports the outcome of rebuilding the users'
+	// usage counters.
+	UserCountersRebuildResponse struct {
+		Checked    int `json:"checked"`
+		Mismatched int `json:"mismatched"`
+	}
+)
+
+// userCountersRebuildPOST recomputes the usage counters from the raw uploads
+// and downloads data and reports how many of them were inaccurate. If a `sub`
+// is given, only that user's counters are rebuilt.
+func (api *API) userCountersRebuildPOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ctx := req.Context()
+	sub := req.FormValue("sub")
+	if sub == "" {
+		checked, mismatched, err := api.staticDB.UserCountersRebuildAll(ctx)
+		if err != nil {
+			api.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+		api.WriteJSON(w, UserCountersRebuildResponse{Checked: checked, Mismatched: mismatched})
+		return
+	}
+	u, err := api.staticDB.UserBySub(ctx, sub)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	mismatch, err := api.staticDB.UserCountersRebuild(ctx, *u)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	resp := UserCountersRebuildResponse{Checked: 1}
+	if mismatch {
+		resp.Mismatched = 1
+	}
+	api.WriteJSON(w, resp)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+type (
+	// UserMergeTokenPOST is the response of POST /user/merge/token
+	UserMergeTokenPOST struct {
+		Token string `json:"token"`
+	}
+	// UserMergePOST is the request body of POST /user/merge
+	UserMergePOST struct {
+		// Token is the merge token of the account we want to merge into the
+		// current one.
+		Token string `json:"token"`
+	}
+	// UsersMergePOST is the request body of POST /users/merge
+	UsersMergePOST struct {
+		// Target is the sub of the account which remains after the merge.
+		Target string `json:"target"`
+		// Source is the sub of the account we merge into the target and delete.
+		Source string `json:"source"`
+	}
+)
+
+// userMergeTokenPOST issues a short-lived token which allows the user to merge
+// the current account into another one. The user needs to log into the other
+// account and pass the token to POST /user/merge, thus proving that they own
+// both accounts.
+func (api *API) userMergeTokenPOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	tk, err := api.staticDB.UserTokenCreate(req.Context(), u.ID, database.TokenPurposeAccountMerge, u.Email, database.AccountMergeTokenTTL)
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, UserMergeTokenPOST{Token: tk})
+}
+
+// userMergePOST merges the account which issued the given merge token into the
+// current account. The other account is deleted.
+func (api *API) userMergePOST(u *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var body UserMergePOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	ut, err := api.staticDB.UserTokenConsume(ctx, body.Token, database.TokenPurposeAccountMerge)
+	if errors.Contains(err, database.ErrInvalidToken) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	source, err := api.staticDB.UserByID(ctx, ut.UserID)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, errors.AddContext(database.ErrInvalidToken, "the account no longer exists"), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.mergeUsers(w, req, u, source)
+}
+
+// usersMergePOST merges two accounts on behalf of the portal's admins.
+func (api *API) usersMergePOST(_ *database.User, w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var body UsersMergePOST
+	err := parseRequestBodyJSON(req.Body, LimitBodySizeSmall, &body)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if body.Target == "" || body.Source == "" {
+		api.WriteError(w, errors.New("both target and source are required"), http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	target, err := api.staticDB.UserBySub(ctx, body.Target)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, errors.AddContext(err, "target"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	source, err := api.staticDB.UserBySub(ctx, body.Source)
+	if errors.Contains(err, database.ErrUserNotFound) {
+		api.WriteError(w, errors.AddContext(err, "source"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.mergeUsers(w, req, target, source)
+}
+
+// mergeUsers merges the source account into the target account and responds
+// with the merged account.
+func (api *API) mergeUsers(w http.ResponseWriter, req *http.Request, target, source *database.User) {
+	u, err := api.staticDB.UserMerge(req.Context(), target, source)
+	if errors.Contains(err, database.ErrMergeSameUser) || errors.Contains(err, database.ErrMergeStripeConflict) {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	api.staticUserTierCache.Set(u.Sub, u)
+	api.WriteJSON(w, UserGETFromUser(u))
+}
@@ -0,0 +1,411 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type (
+	// PortalTraffic describes the number and the total size of a set of
+	// uploads or downloads.
+	PortalTraffic struct {
+		Count int64 `bson:"count" json:"count"`
+		Size  int64 `bson:"size" json:"size"`
+	}
+	// PortalStats holds portal-wide statistics for a given period.
+	//
+	// Uploads and downloads are grouped by the current tier of the user who
+	// made them. Anonymous uploads are reported under TierAnonymous.
+	PortalStats struct {
+		From            time.Time             `json:"from"`
+		To              time.Time             `json:"to"`
+		Registrations   int64                 `json:"registrations"`
+		ActiveUsers     int64                 `json:"activeUsers"`
+		Conversions     int64                 `json:"conversions"`
+		UploadsByTier   map[int]PortalTraffic `json:"uploadsByTier"`
+		DownloadsByTier map[int]PortalTraffic `json:"downloadsByTier"`
+		// AnonymousUploadsShare is the share of all uploaded bytes which were
+		// uploaded anonymously. It's a value between 0 and 1.
+		AnonymousUploadsShare float64 `json:"anonymousUploadsShare"`
+	}
+	// PortalStatsBucket holds portal-wide statistics for a single time bucket.
+	PortalStatsBucket struct {
+		Start           time.Time `json:"start"`
+		Registrations   int64     `json:"registrations"`
+		ActiveUsers     int64     `json:"activeUsers"`
+		Conversions     int64     `json:"conversions"`
+		NumUploads      int64     `json:"numUploads"`
+		NumAnonUploads  int64     `json:"numAnonUploads"`
+		NumDownloads    int64     `json:"numDownloads"`
+		UploadsSize     int64     `json:"uploadsSize"`
+		AnonUploadsSize int64     `json:"anonUploadsSize"`
+		DownloadsSize   int64     `json:"downloadsSize"`
+	}
+	// TopUploader describes a user and the size of their uploads over a given
+	// period.
+	TopUploader struct {
+		UserID      primitive.ObjectID `bson:"_id" json:"userId"`
+		Email       types.Email        `bson:"email" json:"email"`
+		Sub         string             `bson:"sub" json:"sub"`
+		Tier        int                `bson:"tier" json:"tier"`
+		NumUploads  int64              `bson:"num_uploads" json:"numUploads"`
+		UploadsSize int64              `bson:"uploads_size" json:"uploadsSize"`
+	}
+)
+
+// PortalStats returns portal-wide statistics for the given period.
+func (db *DB) PortalStats(ctx context.Context, from, to time.Time) (*PortalStats, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidTimePeriod
+	}
+	stats := PortalStats{
+		From:            from,
+		To:              to,
+		UploadsByTier:   make(map[int]PortalTraffic),
+		DownloadsByTier: make(map[int]PortalTraffic),
+	}
+	var err error
+	stats.Registrations, err = db.staticUsers.CountDocuments(ctx, bson.M{"created_at": periodFilter(from, to)})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count registrations")
+	}
+	stats.Conversions, err = db.staticTierChanges.CountDocuments(ctx, conversionsFilter(from, to))
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count conversions")
+	}
+	stats.ActiveUsers, err = db.portalActiveUsers(ctx, from, to)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count active users")
+	}
+	err = db.portalTrafficByTier(ctx, db.staticUploads, "timestamp", from, to, stats.UploadsByTier)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get uploads by tier")
+	}
+	err = db.portalTrafficByTier(ctx, db.staticDownloads, "created_at", from, to, stats.DownloadsByTier)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get downloads by tier")
+	}
+	var totalSize int64
+	for _, t := range stats.UploadsByTier {
+		totalSize += t.Size
+	}
+	if totalSize > 0 {
+		stats.AnonymousUploadsShare = float64(stats.UploadsByTier[TierAnonymous].Size) / float64(totalSize)
+	}
+	return &stats, nil
+}
+
+// PortalStatsHistory returns portal-wide statistics for the given period,
+// grouped in buckets of the given granularity. All buckets in the period are
+// returned, including the empty ones, in chronological order.
+func (db *DB) PortalStatsHistory(ctx context.Context, from, to time.Time, granularity string) ([]PortalStatsBucket, error) {
+	if granularity != GranularityDay && granularity != GranularityMonth {
+		return nil, ErrInvalidGranularity
+	}
+	if !from.Before(to) {
+		return nil, ErrInvalidTimePeriod
+	}
+	// Prepare all buckets in the period. We index them by the Unix timestamp
+	// of their start.
+	var buckets []PortalStatsBucket
+	index := make(map[int64]int)
+	for t := truncateToGranularity(from, granularity); t.Before(to); t = nextBucketStart(t, granularity) {
+		index[t.Unix()] = len(buckets)
+		buckets = append(buckets, PortalStatsBucket{Start: t})
+	}
+	bucketOf := func(t time.Time) *PortalStatsBucket {
+		ix, ok := index[t.Unix()]
+		if !ok {
+			return nil
+		}
+		return &buckets[ix]
+	}
+
+	// Registrations.
+	counts, err := db.countByBucket(ctx, db.staticUsers, bson.M{"created_at": periodFilter(from, to)}, "$created_at", granularity)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count registrations")
+	}
+	for t, n := range counts {
+		if b := bucketOf(t); b != nil {
+			b.Registrations = n
+		}
+	}
+	// Conversions.
+	counts, err = db.countByBucket(ctx, db.staticTierChanges, conversionsFilter(from, to), "$timestamp", granularity)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count conversions")
+	}
+	for t, n := range counts {
+		if b := bucketOf(t); b != nil {
+			b.Conversions = n
+		}
+	}
+	// Uploads and downloads.
+	type trafficResult struct {
+		Bucket time.Time `bson:"_id"`
+		Count  int64     `bson:"count"`
+		Size   int64     `bson:"size"`
+		Anon   int64     `bson:"anon"`
+		AnonSz int64     `bson:"anon_size"`
+	}
+	var uploads []trafficResult
+	err = db.aggregateAll(ctx, db.staticUploads, trafficByBucketPipeline("timestamp", from, to, granularity), &uploads)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get uploads")
+	}
+	for _, r := range uploads {
+		if b := bucketOf(r.Bucket); b != nil {
+			b.NumUploads = r.Count
+			b.UploadsSize = r.Size
+			b.NumAnonUploads = r.Anon
+			b.AnonUploadsSize = r.AnonSz
+		}
+	}
+	var downloads []trafficResult
+	err = db.aggregateAll(ctx, db.staticDownloads, trafficByBucketPipeline("created_at", from, to, granularity), &downloads)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get downloads")
+	}
+	for _, r := range downloads {
+		if b := bucketOf(r.Bucket); b != nil {
+			b.NumDownloads = r.Count
+			b.DownloadsSize = r.Size
+		}
+	}
+	// Active users, i.e. users who uploaded or downloaded anything.
+	activePipeline := mongo.Pipeline{
+		bson.D{{"$match", bson.D{
+			{"user_id", bson.D{{"$exists", true}}},
+			{"timestamp", periodFilter(from, to)},
+		}}},
+		bson.D{{"$project", bson.D{{"user_id", 1}, {"date", "$timestamp"}}}},
+		bson.D{{"$unionWith", bson.D{
+			{"coll", collDownloads},
+			{"pipeline", bson.A{
+				bson.D{{"$match", bson.D{{"created_at", periodFilter(from, to)}}}},
+				bson.D{{"$project", bson.D{{"user_id", 1}, {"date", "$created_at"}}}},
+			}},
+		}}},
+		bson.D{{"$group", bson.D{
+			{"_id", bson.D{
+				{"bucket", dateTruncStage("$date", granularity)},
+				{"user_id", "$user_id"},
+			}},
+		}}},
+		bson.D{{"$group", bson.D{
+			{"_id", "$_id.bucket"},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+	var active []struct {
+		Bucket time.Time `bson:"_id"`
+		Count  int64     `bson:"count"`
+	}
+	err = db.aggregateAll(ctx, db.staticUploads, activePipeline, &active)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count active users")
+	}
+	for _, r := range active {
+		if b := bucketOf(r.Bucket); b != nil {
+			b.ActiveUsers = r.Count
+		}
+	}
+	return buckets, nil
+}
+
+// TopUploaders returns the users who uploaded the most data during the given
+// period, sorted by the size of their uploads.
+func (db *DB) TopUploaders(ctx context.Context, from, to time.Time, limit int) ([]TopUploader, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidTimePeriod
+	}
+	if limit < 1 {
+		return nil, errors.New("the limit needs to be positive")
+	}
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", bson.D{
+			{"user_id", bson.D{{"$exists", true}}},
+			{"timestamp", periodFilter(from, to)},
+		}}},
+		skylinkSizeLookupStage(),
+		bson.D{{"$group", bson.D{
+			{"_id", "$user_id"},
+			{"num_uploads", bson.D{{"$sum", 1}}},
+			{"uploads_size", bson.D{{"$sum", skylinkSizeExpr()}}},
+		}}},
+		bson.D{{"$sort", bson.D{{"uploads_size", -1}}}},
+		bson.D{{"$limit", limit}},
+		bson.D{{"$lookup", bson.D{
+			{"from", collUsers},
+			{"localField", "_id"},
+			{"foreignField", "_id"},
+			{"as", "user"},
+		}}},
+		bson.D{{"$project", bson.D{
+			{"num_uploads", 1},
+			{"uploads_size", 1},
+			{"email", bson.D{{"$arrayElemAt", bson.A{"$user.email", 0}}}},
+			{"sub", bson.D{{"$arrayElemAt", bson.A{"$user.sub", 0}}}},
+			{"tier", bson.D{{"$arrayElemAt", bson.A{"$user.tier", 0}}}},
+		}}},
+	}
+	uploaders := make([]TopUploader, 0)
+	err := db.aggregateAll(ctx, db.staticUploads, pipeline, &uploaders)
+	if err != nil {
+		return nil, err
+	}
+	return uploaders, nil
+}
+
+// portalActiveUsers counts the distinct users who uploaded or downloaded
+// anything during the given period.
+func (db *DB) portalActiveUsers(ctx context.Context, from, to time.Time) (int64, error) {
+	uploaders, err := db.staticUploads.Distinct(ctx, "user_id", bson.M{
+		"user_id":   bson.M{"$exists": true},
+		"timestamp": periodFilter(from, to),
+	})
+	if err != nil {
+		return 0, err
+	}
+	downloaders, err := db.staticDownloads.Distinct(ctx, "user_id", bson.M{"created_at": periodFilter(from, to)})
+	if err != nil {
+		return 0, err
+	}
+	active := make(map[interface{}]struct{})
+	for _, id := range append(uploaders, downloaders...) {
+		active[id] = struct{}{}
+	}
+	return int64(len(active)), nil
+}
+
+// portalTrafficByTier groups the records in the given collection created
+// during the given period by the tier of the user who made them.
+func (db *DB) portalTrafficByTier(ctx context.Context, coll *mongo.Collection, dateField string, from, to time.Time, traffic map[int]PortalTraffic) error {
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", bson.D{{dateField, periodFilter(from, to)}}}},
+		skylinkSizeLookupStage(),
+		bson.D{{"$lookup", bson.D{
+			{"from", collUsers},
+			{"localField", "user_id"},
+			{"foreignField", "_id"},
+			{"as", "user"},
+		}}},
+		bson.D{{"$group", bson.D{
+			{"_id", bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$user.tier", 0}}}, TierAnonymous}}}},
+			{"count", bson.D{{"$sum", 1}}},
+			{"size", bson.D{{"$sum", skylinkSizeExpr()}}},
+		}}},
+	}
+	var results []struct {
+		Tier  int   `bson:"_id"`
+		Count int64 `bson:"count"`
+		Size  int64 `bson:"size"`
+	}
+	err := db.aggregateAll(ctx, coll, pipeline, &results)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		traffic[r.Tier] = PortalTraffic{Count: r.Count, Size: r.Size}
+	}
+	return nil
+}
+
+// countByBucket counts the documents in the given collection which match the
+// filter, grouped by the bucket of the given date field.
+func (db *DB) countByBucket(ctx context.Context, coll *mongo.Collection, filter bson.M, dateField, granularity string) (map[time.Time]int64, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{{"$match", filter}},
+		bson.D{{"$group", bson.D{
+			{"_id", dateTruncStage(dateField, granularity)},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+	var results []struct {
+		Bucket time.Time `bson:"_id"`
+		Count  int64     `bson:"count"`
+	}
+	err := db.aggregateAll(ctx, coll, pipeline, &results)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[time.Time]int64, len(results))
+	for _, r := range results {
+		counts[r.Bucket] = r.Count
+	}
+	return counts, nil
+}
+
+// aggregateAll runs the given pipeline and decodes all results into the given
+// slice.
+func (db *DB) aggregateAll(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, results interface{}) error {
+	c, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return c.All(ctx, results)
+}
+
+// trafficByBucketPipeline returns a pipeline which groups the uploads or
+// downloads created during the given period by time bucket. Records without
+// a user are counted as anonymous.
+func trafficByBucketPipeline(dateField string, from, to time.Time, granularity string) mongo.Pipeline {
+	isAnon := bson.D{{"$eq", bson.A{bson.D{{"$ifNull", bson.A{"$user_id", nil}}}, nil}}}
+	return mongo.Pipeline{
+		bson.D{{"$match", bson.D{{dateField, periodFilter(from, to)}}}},
+		skylinkSizeLookupStage(),
+		bson.D{{"$group", bson.D{
+			{"_id", dateTruncStage("$"+dateField, granularity)},
+			{"count", bson.D{{"$sum", 1}}},
+			{"size", bson.D{{"$sum", skylinkSizeExpr()}}},
+			{"anon", bson.D{{"$sum", bson.D{{"$cond", bson.A{isAnon, 1, 0}}}}}},
+			{"anon_size", bson.D{{"$sum", bson.D{{"$cond", bson.A{isAnon, skylinkSizeExpr(), 0}}}}}},
+		}}},
+	}
+}
+
+// skylinkSizeLookupStage returns a $lookup stage which adds the skylink's data
+// to uploads and downloads.
+func skylinkSizeLookupStage() bson.D {
+	return bson.D{{"$lookup", bson.D{
+		{"from", collSkylinks},
+		{"localField", "skylink_id"},
+		{"foreignField", "_id"},
+		{"as", "skylink_data"},
+	}}}
+}
+
+// skylinkSizeExpr returns an expression which evaluates to the size of an
+// upload or a download. Partial downloads are accounted by their `bytes` and
+// everything else by the size of the skylink.
+func skylinkSizeExpr() bson.D {
+	slSize := bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$skylink_data.size", 0}}}, 0}}}
+	return bson.D{{"$cond", bson.A{
+		bson.D{{"$gt", bson.A{"$bytes", 0}}},
+		"$bytes",
+		slSize,
+	}}}
+}
+
+// periodFilter returns a filter which matches dates in the [from, to) period.
+func periodFilter(from, to time.Time) bson.M {
+	return bson.M{"$gte": from, "$lt": to}
+}
+
+// conversionsFilter returns a filter which matches the tier changes from the
+// free tier to any paid tier during the given period.
+func conversionsFilter(from, to time.Time) bson.M {
+	return bson.M{
+		"timestamp": periodFilter(from, to),
+		"source":    TierChangeSourceStripe,
+		"from_tier": TierFree,
+		"to_tier":   bson.M{"$gt": TierFree},
+	}
+}
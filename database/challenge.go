@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/url"
 	"strings"
 	"time"
 
@@ -19,8 +18,11 @@ import (
 )
 
 const (
-	// ChallengeSignatureSize is the size of the expected signature.
+	// ChallengeSignatureSize is the size of the expected ed25519 signature.
 	ChallengeSignatureSize = ed25519.SignatureSize
+	// ChallengeSignatureSizeSecp256k1 is the size of the expected secp256k1
+	// signature. It's in the [R || S || V] format Ethereum wallets use.
+	ChallengeSignatureSizeSecp256k1 = 65
 	// ChallengeSize defines the number of bytes of entropy to send as a
 	// challenge
 	ChallengeSize = 32
@@ -32,8 +34,17 @@ const (
 	// we register a new pubkey for the user.
 	ChallengeTypeUpdate = "skynet-portal-update"
 
-	// PubKeySize defines the length of the public key in bytes.
+	// PubKeySize defines the length of an ed25519 public key in bytes.
 	PubKeySize = ed25519.PublicKeySize
+	// PubKeySizeSecp256k1 defines the length of an uncompressed secp256k1
+	// public key in bytes.
+	PubKeySizeSecp256k1 = 65
+
+	// PubKeyTypeEd25519 is the type of the ed25519 keys MySky uses.
+	PubKeyTypeEd25519 = "ed25519"
+	// PubKeyTypeSecp256k1 is the type of the secp256k1 keys Ethereum wallets
+	// use. They sign challenges as EIP-191 messages.
+	PubKeyTypeSecp256k1 = "secp256k1"
 
 	// challengeTTL defines how long we accept responses to this challenge.
 	challengeTTL = 10 * time.Minute
@@ -50,6 +61,13 @@ var (
 	// Its value is controlled by the PORTAL_DOMAIN environment variable.
 	// This name does not have a protocol prefix.
 	PortalName = "https://siasky.net"
+	// TrustedOrigins are the origins from which we accept passkey credentials
+	// and version 2 challenge responses. By default, that's only the portal's
+	// accounts dashboard. We can't trust the portal and its other subdomains
+	// because they serve skapps uploaded by anyone, e.g. at
+	// <skylink>.siasky.net and <name>.hns.siasky.net. The
+	// ACCOUNTS_TRUSTED_ORIGINS environment variable adds more origins.
+	TrustedOrigins = []string{"https://account.siasky.net"}
 )
 
 type (
@@ -58,10 +76,13 @@ type (
 	Challenge struct {
 		ID primitive.ObjectID `bson:"_id,omitempty" json:"-"`
 		// Challenge is a hex-encoded representation of the []byte challenge.
-		Challenge string    `bson:"challenge" json:"challenge"`
-		Type      string    `bson:"type" json:"-"`
-		PubKey    PubKey    `bson:"pub_key" json:"-"`
-		ExpiresAt time.Time `bson:"expires_at" json:"-"`
+		Challenge string `bson:"challenge" json:"challenge"`
+		Type      string `bson:"type" json:"-"`
+		PubKey    PubKey `bson:"pub_key" json:"-"`
+		// UserID is the user who requested the challenge. It's only set for
+		// challenges issued to logged in users.
+		UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"-"`
+		ExpiresAt time.Time          `bson:"expires_at" json:"-"`
 	}
 
 	// ChallengeResponse defines the format of a fully parsed and validated
@@ -69,11 +90,15 @@ type (
 	ChallengeResponse struct {
 		Response  []byte `json:"response"`
 		Signature []byte `json:"signature"`
+		// Origin is the origin of the HTTP request which delivered the
+		// response, if known. Version 2 responses need to match it.
+		Origin string `json:"-"`
 	}
 
 	// PubKey represents a public key. It's a helper type used to make function
-	// signatures more readable.
-	PubKey ed25519.PublicKey
+	// signatures more readable. We tell the supported key types apart by
+	// their length, see PubKey.Type.
+	PubKey []byte
 
 	// UnconfirmedUserUpdate contains a user update that should be applied once
 	// the respective challenge has been successfully responded to.
@@ -114,44 +139,34 @@ func (db *DB) NewChallenge(ctx context.Context, pubKey PubKey, cType string) (*C
 // database. It makes sure the challenge and type in the response match what's
 // in the database and that the signature is valid.
 //
-// Challenge format: challenge + type + recipient
+// We support two versions of the response format, see
+// parseChallengeResponseV1 and parseChallengeResponseV2.
 func (db *DB) ValidateChallengeResponse(ctx context.Context, chr ChallengeResponse, expType string) (PubKey, primitive.ObjectID, error) {
-	resp := chr.Response
-	// Get the challenge type which sits right after the challenge in the
-	// response.
+	var challenge []byte
 	var cType string
-	if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeLogin) {
-		cType = ChallengeTypeLogin
-	} else if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeRegister) {
-		cType = ChallengeTypeRegister
-	} else if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeUpdate) {
-		cType = ChallengeTypeUpdate
+	var err error
+	if bytes.HasPrefix(chr.Response, []byte(challengeResponseV2Header)) {
+		challenge, cType, err = parseChallengeResponseV2(chr.Response, chr.Origin, time.Now().UTC())
 	} else {
-		return nil, primitive.ObjectID{}, errors.New("invalid challenge type")
-	}
-	if cType != expType {
-		return nil, primitive.ObjectID{}, errors.New("unexpected challenge type")
+		var disabled bool
+		disabled, err = db.SettingBool(ctx, ConfValLegacyChallengesDisabled)
+		if err != nil {
+			return nil, primitive.ObjectID{}, errors.AddContext(err, "failed to read from configuration")
+		}
+		if disabled {
+			return nil, primitive.ObjectID{}, ErrLegacyChallengeResponse
+		}
+		challenge, cType, err = parseChallengeResponseV1(chr.Response)
 	}
-	// Now that we know the challenge type, we can get the recipient as well.
-	recipientOffset := ChallengeSize + len([]byte(cType))
-	// Extract recipient from response.
-	recipient := string(resp[recipientOffset:])
-	// Check if the recipient is the current portal or any of its subdomains.
-	recipientURL, err := url.Parse(recipient)
 	if err != nil {
-		return nil, primitive.ObjectID{}, errors.AddContext(err, "failed to parse recipient")
-	}
-	// The recipient should match the portal name.
-	if fmt.Sprintf("%s://%s", recipientURL.Scheme, recipientURL.Host) != PortalName {
-		return nil, primitive.ObjectID{}, fmt.Errorf("invalid recipient host %v != %v", recipientURL.Host, PortalName)
+		return nil, primitive.ObjectID{}, err
 	}
-	// Require HTTPS
-	if recipientURL.Scheme != "https" {
-		return nil, primitive.ObjectID{}, fmt.Errorf("invalid scheme %v, should be https", recipientURL.Scheme)
+	if cType != expType {
+		return nil, primitive.ObjectID{}, errors.New("unexpected challenge type")
 	}
 	// Fetch the challenge from the DB.
 	filter := bson.M{
-		"challenge": hex.EncodeToString(resp[:ChallengeSize]),
+		"challenge": hex.EncodeToString(challenge),
 		"type":      cType,
 	}
 	sr := db.staticChallenges.FindOne(ctx, filter)
@@ -163,7 +178,7 @@ func (db *DB) ValidateChallengeResponse(ctx context.Context, chr ChallengeRespon
 	if ch.ExpiresAt.Before(time.Now().UTC()) {
 		return nil, primitive.ObjectID{}, errors.New("challenge expired")
 	}
-	if !verifySignature(ch.PubKey, resp, chr.Signature) {
+	if !verifySignature(ch.PubKey, chr.Response, chr.Signature) {
 		return nil, primitive.ObjectID{}, errors.New("invalid signature")
 	}
 	// Now that the challenge has been used, we delete it from the DB. If this
@@ -237,7 +252,7 @@ func (cr *ChallengeResponse) LoadFromReader(r io.Reader) error {
 	if err != nil {
 		return errors.AddContext(err, "failed to parse the response signature")
 	}
-	if len(sig) != ChallengeSignatureSize {
+	if len(sig) != ChallengeSignatureSize && len(sig) != ChallengeSignatureSizeSecp256k1 {
 		return errors.New("invalid signature")
 	}
 	cr.Response = resp
@@ -245,13 +260,20 @@ func (cr *ChallengeResponse) LoadFromReader(r io.Reader) error {
 	return nil
 }
 
-// LoadString loads a PubKey from its hex-encoded string form.
+// LoadString loads a PubKey from its hex-encoded string form. It accepts
+// ed25519 keys and uncompressed secp256k1 keys.
 func (pk *PubKey) LoadString(s string) error {
-	b, err := hex.DecodeString(s)
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
 	if err != nil {
 		return errors.AddContext(err, ErrInvalidPublicKey.Error())
 	}
-	if len(b) != PubKeySize {
+	switch len(b) {
+	case PubKeySize:
+	case PubKeySizeSecp256k1:
+		if !validSecp256k1PubKey(b) {
+			return ErrInvalidPublicKey
+		}
+	default:
 		return ErrInvalidPublicKey
 	}
 	*pk = b[:]
@@ -263,7 +285,26 @@ func (pk PubKey) String() string {
 	return hex.EncodeToString(pk)
 }
 
-// verifySignature is a helper method.
+// Type returns the type of the key or an empty string if the key is not of
+// any of the supported types.
+func (pk PubKey) Type() string {
+	switch len(pk) {
+	case PubKeySize:
+		return PubKeyTypeEd25519
+	case PubKeySizeSecp256k1:
+		return PubKeyTypeSecp256k1
+	}
+	return ""
+}
+
+// verifySignature verifies the given signature of the given message with a
+// scheme that matches the type of the given key.
 func verifySignature(pk PubKey, message []byte, sig []byte) bool {
-	return ed25519.Verify(ed25519.PublicKey(pk[:]), message[:], sig[:])
+	switch pk.Type() {
+	case PubKeyTypeEd25519:
+		return len(sig) == ChallengeSignatureSize && ed25519.Verify(ed25519.PublicKey(pk[:]), message[:], sig[:])
+	case PubKeyTypeSecp256k1:
+		return verifySecp256k1(pk, message, sig)
+	}
+	return false
 }
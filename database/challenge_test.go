@@ -10,6 +10,8 @@ import (
 	"testing"
 
 	"github.com/SkynetLabs/skynet-accounts/jwt"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
 	"gitlab.com/NebulousLabs/fastrand"
 	"go.sia.tech/siad/crypto"
 	"golang.org/x/crypto/ed25519"
@@ -152,3 +154,74 @@ func TestPubKey_LoadString(t *testing.T) {
 		t.Fatalf("Expected '%s', got '%s'", hex.EncodeToString(pk2[:]), hex.EncodeToString(pk[:]))
 	}
 }
+
+// TestPubKey_Secp256k1 ensures that we accept secp256k1 keys and verify their
+// EIP-191 signatures.
+func TestPubKey_Secp256k1(t *testing.T) {
+	sk, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk PubKey
+	err = pk.LoadString("0x" + hex.EncodeToString(sk.PubKey().SerializeUncompressed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk.Type() != PubKeyTypeSecp256k1 {
+		t.Fatalf("Expected type '%s', got '%s'", PubKeyTypeSecp256k1, pk.Type())
+	}
+	// Compressed keys and invalid points are rejected.
+	err = pk.LoadString(hex.EncodeToString(sk.PubKey().SerializeCompressed()))
+	if err == nil {
+		t.Fatal("Expected compressed keys to be rejected.")
+	}
+	b := sk.PubKey().SerializeUncompressed()
+	b[PubKeySizeSecp256k1-1] ^= 0xff
+	err = pk.LoadString(hex.EncodeToString(b))
+	if err == nil {
+		t.Fatal("Expected an invalid point to be rejected.")
+	}
+
+	pk = sk.PubKey().SerializeUncompressed()
+	msg := append(fastrand.Bytes(ChallengeSize), []byte(ChallengeTypeLogin+PortalName)...)
+	sig := signEIP191(sk, msg)
+	if !verifySignature(pk, msg, sig) {
+		t.Fatal("Expected the signature to be valid.")
+	}
+	// Wallets which don't offset V by 27 produce valid signatures as well.
+	sig[64] -= 27
+	if !verifySignature(pk, msg, sig) {
+		t.Fatal("Expected the signature to be valid.")
+	}
+	// A signature of another message or by another key is invalid.
+	if verifySignature(pk, append(msg, 0), sig) {
+		t.Fatal("Expected the signature to be invalid.")
+	}
+	sk2, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifySignature(pk, msg, signEIP191(sk2, msg)) {
+		t.Fatal("Expected the signature to be invalid.")
+	}
+	// An ed25519 signature is invalid for a secp256k1 key.
+	if verifySignature(pk, msg, fastrand.Bytes(ChallengeSignatureSize)) {
+		t.Fatal("Expected the signature to be invalid.")
+	}
+}
+
+// TestEIP191Hash ensures that we hash messages the same way Ethereum wallets
+// do.
+func TestEIP191Hash(t *testing.T) {
+	h := hex.EncodeToString(EIP191Hash([]byte("Hello World")))
+	expected := "a1de988600a42c4b4ab089b619297c17d53cffae5d5120d82d8a92d0bb3b78f2"
+	if h != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, h)
+	}
+}
+
+// signEIP191 signs the given message in the same way Ethereum wallets do.
+func signEIP191(sk *secp256k1.PrivateKey, msg []byte) []byte {
+	compact := ecdsa.SignCompact(sk, EIP191Hash(msg), false)
+	return append(compact[1:], compact[0])
+}
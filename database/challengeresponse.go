@@ -0,0 +1,167 @@
+package database
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+/**
+Clients respond to a challenge by signing a message which contains it. We
+support two versions of that message.
+
+Version 1 is the challenge's bytes, followed by the challenge type and the
+recipient, i.e. the portal's address, with no separators. Since every portal
+issues challenges in the same format, a malicious portal could relay the
+response of a user to another portal. MySky still uses this version.
+
+Version 2 is a text message which also binds the response to the origin which
+requested it and to an expiry chosen by the client:
+
+	skynet-portal-challenge-v2
+	challenge: <hex-encoded challenge>
+	type: <challenge type>
+	origin: <origin, e.g. https://account.siasky.net>
+	expires: <RFC 3339 timestamp>
+
+The origin must be one of the TrustedOrigins. We don't accept the portal's other
+subdomains because they serve skapps uploaded by anyone, which could otherwise
+request responses for the portal's own login and registration. When the
+response arrives with an Origin header, e.g. from a browser, the header must
+match the signed origin as well.
+*/
+
+const (
+	// challengeResponseV2Header is the first line of a version 2 challenge
+	// response.
+	challengeResponseV2Header = "skynet-portal-challenge-v2\n"
+)
+
+var (
+	// ErrLegacyChallengeResponse is returned when the portal no longer
+	// accepts version 1 challenge responses.
+	ErrLegacyChallengeResponse = errors.New("version 1 challenge responses are no longer supported, please update your client")
+
+	// challengeResponseV2Fields are the fields of a version 2 challenge
+	// response, in the order in which they appear.
+	challengeResponseV2Fields = []string{"challenge", "type", "origin", "expires"}
+)
+
+// ChallengeResponseV2Message returns the message a client needs to sign in
+// order to respond to the given challenge with a version 2 response.
+func ChallengeResponseV2Message(challenge []byte, cType, origin string, expires time.Time) []byte {
+	return []byte(fmt.Sprintf("%schallenge: %s\ntype: %s\norigin: %s\nexpires: %s",
+		challengeResponseV2Header,
+		hex.EncodeToString(challenge),
+		cType,
+		origin,
+		expires.UTC().Format(time.RFC3339),
+	))
+}
+
+// parseChallengeResponseV1 extracts the challenge and its type from a version
+// 1 challenge response and makes sure it's meant for this portal.
+//
+// Challenge format: challenge + type + recipient
+func parseChallengeResponseV1(resp []byte) ([]byte, string, error) {
+	// Get the challenge type which sits right after the challenge in the
+	// response.
+	var cType string
+	if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeLogin) {
+		cType = ChallengeTypeLogin
+	} else if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeRegister) {
+		cType = ChallengeTypeRegister
+	} else if strings.HasPrefix(string(resp[ChallengeSize:]), ChallengeTypeUpdate) {
+		cType = ChallengeTypeUpdate
+	} else {
+		return nil, "", errors.New("invalid challenge type")
+	}
+	// Now that we know the challenge type, we can get the recipient as well.
+	recipientOffset := ChallengeSize + len([]byte(cType))
+	// Extract recipient from response.
+	recipient := string(resp[recipientOffset:])
+	// Check if the recipient is the current portal or any of its subdomains.
+	recipientURL, err := url.Parse(recipient)
+	if err != nil {
+		return nil, "", errors.AddContext(err, "failed to parse recipient")
+	}
+	// The recipient should match the portal name.
+	if fmt.Sprintf("%s://%s", recipientURL.Scheme, recipientURL.Host) != PortalName {
+		return nil, "", fmt.Errorf("invalid recipient host %v != %v", recipientURL.Host, PortalName)
+	}
+	// Require HTTPS
+	if recipientURL.Scheme != "https" {
+		return nil, "", fmt.Errorf("invalid scheme %v, should be https", recipientURL.Scheme)
+	}
+	return resp[:ChallengeSize], cType, nil
+}
+
+// parseChallengeResponseV2 extracts the challenge and its type from a version
+// 2 challenge response. It makes sure the response is meant for this portal,
+// that it matches the origin of the request which delivered it, if known, and
+// that it hasn't expired.
+func parseChallengeResponseV2(resp []byte, reqOrigin string, now time.Time) ([]byte, string, error) {
+	lines := strings.Split(strings.TrimPrefix(string(resp), challengeResponseV2Header), "\n")
+	if len(lines) != len(challengeResponseV2Fields) {
+		return nil, "", ErrInvalidChallengeResponse
+	}
+	values := make(map[string]string, len(lines))
+	for i, field := range challengeResponseV2Fields {
+		prefix := field + ": "
+		if !strings.HasPrefix(lines[i], prefix) {
+			return nil, "", errors.AddContext(ErrInvalidChallengeResponse, "missing field '"+field+"'")
+		}
+		values[field] = strings.TrimPrefix(lines[i], prefix)
+	}
+	challenge, err := hex.DecodeString(values["challenge"])
+	if err != nil || len(challenge) != ChallengeSize {
+		return nil, "", errors.AddContext(ErrInvalidChallengeResponse, "invalid challenge")
+	}
+	cType := values["type"]
+	if cType != ChallengeTypeLogin && cType != ChallengeTypeRegister && cType != ChallengeTypeUpdate {
+		return nil, "", errors.New("invalid challenge type")
+	}
+	err = validateOrigin(values["origin"], reqOrigin)
+	if err != nil {
+		return nil, "", err
+	}
+	expires, err := time.Parse(time.RFC3339, values["expires"])
+	if err != nil {
+		return nil, "", errors.AddContext(ErrInvalidChallengeResponse, "invalid expiry")
+	}
+	if !now.Before(expires) {
+		return nil, "", errors.New("challenge response expired")
+	}
+	return challenge, cType, nil
+}
+
+// validateOrigin makes sure the signed origin of a challenge response is one
+// of the TrustedOrigins and that it matches the origin of the request which
+// delivered the response, if known.
+func validateOrigin(origin, reqOrigin string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errors.AddContext(err, "failed to parse origin")
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %v, should be https", u.Scheme)
+	}
+	trusted := false
+	for _, o := range TrustedOrigins {
+		if origin == o {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("invalid origin %v, should be one of %v", origin, TrustedOrigins)
+	}
+	if reqOrigin != "" && reqOrigin != origin {
+		return fmt.Errorf("the signed origin %v doesn't match the request's origin %v", origin, reqOrigin)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestParseChallengeResponseV2 ensures that we only accept version 2
+// challenge responses which are bound to this portal and haven't expired.
+func TestParseChallengeResponseV2(t *testing.T) {
+	now := time.Now().UTC()
+	challenge := fastrand.Bytes(ChallengeSize)
+	origin := "https://account.siasky.net"
+	valid := ChallengeResponseV2Message(challenge, ChallengeTypeLogin, origin, now.Add(time.Minute))
+
+	ch, cType, err := parseChallengeResponseV2(valid, origin, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ch, challenge) || cType != ChallengeTypeLogin {
+		t.Fatalf("Unexpected challenge %x of type '%s'", ch, cType)
+	}
+	// Non-browser clients don't send an Origin header.
+	_, _, err = parseChallengeResponseV2(valid, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		resp      []byte
+		reqOrigin string
+	}{
+		{
+			name:      "origin mismatch",
+			resp:      valid,
+			reqOrigin: "https://siasky.net",
+		},
+		{
+			name: "other portal",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "https://account.skynetfree.net", now.Add(time.Minute)),
+		},
+		{
+			name: "lookalike portal",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "https://evilsiasky.net", now.Add(time.Minute)),
+		},
+		{
+			name: "portal",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "https://siasky.net", now.Add(time.Minute)),
+		},
+		{
+			name: "skapp subdomain",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "https://100bvbi8qhiv3s8tltrh7kj2f6dt5ib3sg4qrlrglmhrjk97b6i5cdg.siasky.net", now.Add(time.Minute)),
+		},
+		{
+			name: "hns subdomain",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "https://foo.hns.siasky.net", now.Add(time.Minute)),
+		},
+		{
+			name: "path",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, origin+"/login", now.Add(time.Minute)),
+		},
+		{
+			name: "plain http",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, "http://siasky.net", now.Add(time.Minute)),
+		},
+		{
+			name: "expired",
+			resp: ChallengeResponseV2Message(challenge, ChallengeTypeLogin, origin, now.Add(-time.Second)),
+		},
+		{
+			name: "invalid type",
+			resp: ChallengeResponseV2Message(challenge, "skynet-portal-other", origin, now.Add(time.Minute)),
+		},
+		{
+			name: "short challenge",
+			resp: ChallengeResponseV2Message(challenge[1:], ChallengeTypeLogin, origin, now.Add(time.Minute)),
+		},
+		{
+			name: "extra field",
+			resp: append(append([]byte{}, valid...), []byte("\nfoo: bar")...),
+		},
+		{
+			name: "unknown field",
+			resp: []byte(strings.Replace(string(valid), "challenge: ", "chal: ", 1)),
+		},
+	}
+	for _, tt := range tests {
+		_, _, err = parseChallengeResponseV2(tt.resp, tt.reqOrigin, now)
+		if err == nil {
+			t.Errorf("Expected an error for '%s'", tt.name)
+		}
+	}
+}
+
+// TestParseChallengeResponseV1 ensures that we keep accepting the responses
+// of old MySky clients.
+func TestParseChallengeResponseV1(t *testing.T) {
+	challenge := fastrand.Bytes(ChallengeSize)
+	resp := append(append(append([]byte{}, challenge...), ChallengeTypeRegister...), PortalName...)
+	ch, cType, err := parseChallengeResponseV1(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ch, challenge) || cType != ChallengeTypeRegister {
+		t.Fatalf("Unexpected challenge %x of type '%s'", ch, cType)
+	}
+	resp = append(append(append([]byte{}, challenge...), ChallengeTypeRegister...), "https://skynetfree.net"...)
+	_, _, err = parseChallengeResponseV1(resp)
+	if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Fatalf("Expected an invalid recipient error, got '%v'", err)
+	}
+	_, _, err = parseChallengeResponseV1(append(challenge, "skynet-portal-other"...))
+	if err == nil {
+		t.Fatal("Expected an invalid type error.")
+	}
+}
@@ -19,6 +19,31 @@ var (
 	// ConfValRegistrationsDisabled is the configuration value that disables
 	// new registration on the service.
 	ConfValRegistrationsDisabled = "registrations_disabled"
+	// ConfValEmailConfirmationRequired is the configuration value that lists
+	// the actions which users can only perform after confirming their email
+	// address. The actions are separated by commas, e.g. "api_keys,checkout".
+	ConfValEmailConfirmationRequired = "email_confirmation_required"
+	// ConfValInvitesRequired is the configuration value that puts the portal
+	// in invite-only mode, in which new users need an invite code in order to
+	// register.
+	ConfValInvitesRequired = "invites_required"
+	// ConfValEmailDomainsAllowed is the configuration value that lists the
+	// only email domains users can register with, e.g. on corporate portals.
+	// An empty list allows all domains.
+	ConfValEmailDomainsAllowed = "email_domains_allowed"
+	// ConfValEmailDomainsDenied is the configuration value that lists the
+	// email domains users can't register with.
+	ConfValEmailDomainsDenied = "email_domains_denied"
+	// ConfValEmailDisposableBlocked is the configuration value that prevents
+	// users from registering with disposable email addresses.
+	ConfValEmailDisposableBlocked = "email_disposable_blocked"
+	// ConfValEmailDisposableDomains is the configuration value that lists
+	// disposable email domains in addition to the ones we bundle.
+	ConfValEmailDisposableDomains = "email_disposable_domains"
+	// ConfValLegacyChallengesDisabled is the configuration value that makes
+	// the portal reject version 1 challenge responses, which aren't bound to
+	// an origin.
+	ConfValLegacyChallengesDisabled = "legacy_challenges_disabled"
 
 	// ConfValTrue represents the truthy value for flag-like configuration
 	// options.
@@ -28,6 +53,18 @@ var (
 	ConfValFalse = "false"
 )
 
+// The actions which can require a confirmed email address. See
+// ConfValEmailConfirmationRequired.
+const (
+	// ActionAPIKeys is creating API keys.
+	ActionAPIKeys = "api_keys"
+	// ActionCheckout is upgrading the account via Stripe checkout.
+	ActionCheckout = "checkout"
+	// ActionLimits is getting the limits of the user's tier instead of the
+	// anonymous limits.
+	ActionLimits = "limits"
+)
+
 type (
 	// ConfVal represents a single configuration value in the database.
 	ConfVal struct {
@@ -64,3 +101,18 @@ func (db *DB) WriteConfigValue(ctx context.Context, key, value string) error {
 	}
 	return nil
 }
+
+// EmailConfirmationRequired reports whether users need to confirm their email
+// address before they can perform the given action.
+func (db *DB) EmailConfirmationRequired(ctx context.Context, action string) (bool, error) {
+	actions, err := db.SettingStringList(ctx, ConfValEmailConfirmationRequired)
+	if err != nil {
+		return false, errors.AddContext(err, "failed to read from configuration")
+	}
+	for _, a := range actions {
+		if a == action {
+			return true, nil
+		}
+	}
+	return false, nil
+}
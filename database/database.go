@@ -53,6 +53,36 @@ var (
 	collConfiguration = "configuration"
 	// collAPIKeys defines the name of the db table with API keys for users.
 	collAPIKeys = "api_keys"
+	// collUserCounters defines the name of the db table with pre-aggregated
+	// usage counters for users.
+	collUserCounters = "user_counters"
+	// collTierChanges defines the name of the db table which records the
+	// changes of users' tiers.
+	collTierChanges = "tier_changes"
+	// collQuotaNotifications defines the name of the db table which records
+	// the quota warnings we've sent to users.
+	collQuotaNotifications = "quota_notifications"
+	// collEmailSuppressions defines the name of the db table which holds the
+	// email addresses we no longer send emails to.
+	collEmailSuppressions = "email_suppressions"
+	// collSettingChanges defines the name of the db table which records who
+	// changed which runtime setting.
+	collSettingChanges = "setting_changes"
+	// collUserTokens defines the name of the db table which holds the tokens
+	// we send to users via email, e.g. for confirming their email address.
+	collUserTokens = "user_tokens"
+	// collInvites defines the name of the db table which holds the invite
+	// codes for registering on the portal.
+	collInvites = "invites"
+	// collEmailDuplicates defines the name of the db table which holds the
+	// report of email addresses that belong to more than one user.
+	collEmailDuplicates = "email_duplicates"
+	// collPasskeys defines the name of the db table which holds the users'
+	// WebAuthn credentials.
+	collPasskeys = "passkeys"
+	// collPubKeys defines the name of the db table which holds the labels
+	// and usage of the users' pubkeys.
+	collPubKeys = "pubkeys"
 
 	// DefaultPageSize defines the default number of records to return.
 	DefaultPageSize = 10
@@ -100,6 +130,17 @@ type (
 		staticUnconfirmedUserUpdates *mongo.Collection
 		staticConfiguration          *mongo.Collection
 		staticAPIKeys                *mongo.Collection
+		staticUserCounters           *mongo.Collection
+		staticTierChanges            *mongo.Collection
+		staticQuotaNotifications     *mongo.Collection
+		staticEmailSuppressions      *mongo.Collection
+		staticSettingChanges         *mongo.Collection
+		staticUserTokens             *mongo.Collection
+		staticInvites                *mongo.Collection
+		staticEmailDuplicates        *mongo.Collection
+		staticPasskeys               *mongo.Collection
+		staticPubKeys                *mongo.Collection
+		staticSettings               *settingsCache
 		staticDeps                   lib.Dependencies
 		staticLogger                 *logrus.Logger
 	}
@@ -167,6 +208,17 @@ func NewCustomDB(ctx context.Context, dbName string, creds DBCredentials, logger
 		staticUnconfirmedUserUpdates: db.Collection(collUnconfirmedUserUpdates),
 		staticConfiguration:          db.Collection(collConfiguration),
 		staticAPIKeys:                db.Collection(collAPIKeys),
+		staticUserCounters:           db.Collection(collUserCounters),
+		staticTierChanges:            db.Collection(collTierChanges),
+		staticQuotaNotifications:     db.Collection(collQuotaNotifications),
+		staticEmailSuppressions:      db.Collection(collEmailSuppressions),
+		staticSettingChanges:         db.Collection(collSettingChanges),
+		staticUserTokens:             db.Collection(collUserTokens),
+		staticInvites:                db.Collection(collInvites),
+		staticEmailDuplicates:        db.Collection(collEmailDuplicates),
+		staticPasskeys:               db.Collection(collPasskeys),
+		staticPubKeys:                db.Collection(collPubKeys),
+		staticSettings:               &settingsCache{},
 		staticDeps:                   deps,
 		staticLogger:                 logger,
 	}, nil
@@ -261,6 +313,35 @@ func ensureDBSchema(ctx context.Context, db *mongo.Database, schema map[string][
 	if err != nil && !strings.Contains(err.Error(), "IndexNotFound") && !strings.Contains(err.Error(), "NamespaceNotFound") {
 		log.Debugf("Error while dropping index '%s': %v", "email_unique", err)
 	}
+	// Move the users' tokens into their own collection. The tokens we issued
+	// before that are no longer valid, so we only need to remember which
+	// users haven't confirmed their email address, yet.
+	filter := bson.M{"email_confirmation_token": bson.M{"$exists": true, "$ne": ""}}
+	update := bson.M{"$set": bson.M{"email_unconfirmed": true}}
+	_, err = db.Collection(collUsers).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to migrate email confirmations")
+	}
+	legacyFields := bson.M{
+		"email_confirmation_token":            "",
+		"email_confirmation_token_expiration": "",
+		"recovery_token":                      "",
+		"email_revert_token":                  "",
+		"email_revert_token_expiration":       "",
+		"email_revert_address":                "",
+		"pending_email_token":                 "",
+		"pending_email_token_expiration":      "",
+	}
+	var hasLegacyFields bson.A
+	for f := range legacyFields {
+		hasLegacyFields = append(hasLegacyFields, bson.M{f: bson.M{"$exists": true}})
+	}
+	filter = bson.M{"$or": hasLegacyFields}
+	update = bson.M{"$unset": legacyFields}
+	_, err = db.Collection(collUsers).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to remove legacy user tokens")
+	}
 	// Ensure current schema.
 	for collName, models := range schema {
 		coll, err := ensureCollection(ctx, db, collName)
@@ -275,7 +356,8 @@ func ensureDBSchema(ctx context.Context, db *mongo.Database, schema map[string][
 		}
 		log.Debugf("Ensured index exists: %v", names)
 	}
-	return nil
+	_, err = ensureUniqueEmails(ctx, db, log)
+	return err
 }
 
 // ensureCollection gets the given collection from the
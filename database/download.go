@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/SkynetLabs/skynet-accounts/skynet"
 	"gitlab.com/NebulousLabs/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -60,7 +61,7 @@ func (db *DB) DownloadCreate(ctx context.Context, user User, skylink Skylink, by
 	down, err := db.DownloadRecent(ctx, user.ID, skylink.ID)
 	if err == nil {
 		// We found a recent download of this skylink. Let's update it.
-		return nil, db.DownloadIncrement(ctx, down, bytes)
+		return nil, db.downloadIncrement(ctx, user, skylink, down, bytes)
 	}
 
 	// We couldn't find a recent download of this skylink, updated within
@@ -77,6 +78,16 @@ func (db *DB) DownloadCreate(ctx context.Context, user User, skylink Skylink, by
 		return nil, err
 	}
 	down.ID = ior.InsertedID.(primitive.ObjectID)
+	size := downloadEffectiveSize(bytes, skylink.Size)
+	inc := bson.M{
+		"num_downloads":  1,
+		"downloads_size": size,
+		"bw_downloads":   skynet.BandwidthDownloadCost(size),
+	}
+	err = db.userCountersInc(ctx, user, inc, true)
+	if err != nil {
+		db.staticLogger.Warnf("Failed to update the counters of user %s: %s", user.ID.Hex(), err)
+	}
 	return down, nil
 }
 
@@ -147,6 +158,20 @@ func (db *DB) DownloadRecent(ctx context.Context, uID primitive.ObjectID, skylin
 
 // DownloadIncrement increments the size of the download by additionalBytes.
 func (db *DB) DownloadIncrement(ctx context.Context, d *Download, additionalBytes int64) error {
+	user, err := db.UserByID(ctx, d.UserID)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch the downloader")
+	}
+	skylink, err := db.SkylinkByID(ctx, d.SkylinkID)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch the downloaded skylink")
+	}
+	return db.downloadIncrement(ctx, *user, *skylink, d, additionalBytes)
+}
+
+// downloadIncrement increments the size of the download by additionalBytes
+// and updates the downloader's counters.
+func (db *DB) downloadIncrement(ctx context.Context, user User, skylink Skylink, d *Download, additionalBytes int64) error {
 	filter := bson.M{"_id": d.ID}
 	update := bson.M{
 		"$inc": bson.M{"bytes": additionalBytes},
@@ -156,5 +181,10 @@ func (db *DB) DownloadIncrement(ctx context.Context, d *Download, additionalByte
 	if err != nil {
 		return errors.AddContext(err, "failed to update download record")
 	}
+	inc := downloadCountersDelta(d.Bytes, d.Bytes+additionalBytes, skylink.Size)
+	err = db.userCountersInc(ctx, user, inc, d.CreatedAt.After(monthStart(user.SubscribedUntil)))
+	if err != nil {
+		db.staticLogger.Warnf("Failed to update the counters of user %s: %s", user.ID.Hex(), err)
+	}
 	return nil
 }
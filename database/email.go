@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
+	"github.com/SkynetLabs/skynet-accounts/types"
 	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,12 +19,9 @@ const (
 	// token. After the token expires it can no longer be used and the user
 	// needs to request an email re-confirmation.
 	EmailConfirmationTokenTTL = 24 * time.Hour
-
-	// EmailMaxSendAttempts defines the maximum number of attempts we are going
-	// to make at sending a given email before giving up on it. This const is
-	// defined here and not in the email package because the database package
-	// cannot import the email package (loop).
-	EmailMaxSendAttempts = 3
+	// EmailRevertTokenTTL defines how long the user can revert a change of
+	// their email address via the link we send to their old address.
+	EmailRevertTokenTTL = 7 * 24 * time.Hour
 
 	// emailLockTTL defines how long an email can stay locked for sending. Once
 	// the lock expires the record will be unlocked and free for other servers
@@ -30,24 +29,92 @@ const (
 	emailLockTTL = 5 * time.Minute
 )
 
+const (
+	// EmailStatusPending marks messages which are waiting to be sent.
+	EmailStatusPending = "pending"
+	// EmailStatusSent marks messages which have been sent.
+	EmailStatusSent = "sent"
+	// EmailStatusFailed marks messages which we failed to send too many times
+	// or which were permanently rejected, and we gave up on.
+	EmailStatusFailed = "failed"
+)
+
+var (
+	// EmailMaxSendAttempts defines the maximum number of attempts we are going
+	// to make at sending a given email before giving up on it. This value is
+	// defined here and not in the email package because the database package
+	// cannot import the email package (loop). Its value is controlled by the
+	// ACCOUNTS_EMAIL_MAX_ATTEMPTS environment variable.
+	EmailMaxSendAttempts = 10
+	// EmailRetryBackoff is the time we wait before retrying a message which
+	// failed once. Each following failure doubles the wait. Its value is
+	// controlled by the ACCOUNTS_EMAIL_RETRY_BACKOFF environment variable.
+	EmailRetryBackoff = time.Minute
+	// EmailRetryBackoffMax caps the time we wait between two attempts at
+	// sending a message. Its value is controlled by the
+	// ACCOUNTS_EMAIL_RETRY_BACKOFF_MAX environment variable.
+	EmailRetryBackoffMax = 6 * time.Hour
+)
+
+var (
+	// ErrEmailNotFound is returned when the requested email message doesn't
+	// exist.
+	ErrEmailNotFound = errors.New("email message not found")
+	// ErrInvalidEmailStatus is returned when the given email status is not
+	// one of the supported values.
+	ErrInvalidEmailStatus = errors.New("invalid email status, supported values are 'pending', 'sent' and 'failed'")
+)
+
 type (
 	// EmailMessage represents an email message waiting to be sent
 	EmailMessage struct {
-		ID             primitive.ObjectID `bson:"_id,omitempty"`
-		From           string             `bson:"from"`
-		To             string             `bson:"to"`
-		Subject        string             `bson:"subject"`
-		Body           string             `bson:"body"`
-		BodyMime       string             `bson:"body_mime"`
-		LockedBy       string             `bson:"locked_by"`
-		LockedAt       time.Time          `bson:"locked_at,omitempty"`
-		SentAt         time.Time          `bson:"sent_at,omitempty"`
-		FailedAttempts int                `bson:"failed_attempts"`
+		ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		From           string             `bson:"from" json:"from"`
+		To             string             `bson:"to" json:"to"`
+		Subject        string             `bson:"subject" json:"subject"`
+		Body           string             `bson:"body" json:"-"`
+		BodyMime       string             `bson:"body_mime" json:"-"`
+		Template       string             `bson:"template,omitempty" json:"template"`
+		LockedBy       string             `bson:"locked_by" json:"lockedBy"`
+		LockedAt       time.Time          `bson:"locked_at,omitempty" json:"lockedAt"`
+		SentAt         time.Time          `bson:"sent_at,omitempty" json:"sentAt"`
+		FailedAttempts int                `bson:"failed_attempts" json:"failedAttempts"`
+		// FailedPermanently is set when the recipient's server rejected the
+		// message in a way that makes retrying pointless.
+		FailedPermanently bool      `bson:"failed_permanently,omitempty" json:"failedPermanently"`
+		LastError         string    `bson:"last_error,omitempty" json:"lastError"`
+		LastAttemptAt     time.Time `bson:"last_attempt_at,omitempty" json:"lastAttemptAt"`
+		NextAttemptAt     time.Time `bson:"next_attempt_at,omitempty" json:"nextAttemptAt"`
+		CreatedAt         time.Time `bson:"created_at,omitempty" json:"createdAt"`
+	}
+
+	// EmailFilter defines which email messages to select. Empty fields are
+	// ignored.
+	EmailFilter struct {
+		Status   string
+		To       types.Email
+		Template string
+		From     time.Time
+		Until    time.Time
 	}
 )
 
+// Status returns the delivery status of the message.
+func (m EmailMessage) Status() string {
+	if !m.SentAt.IsZero() {
+		return EmailStatusSent
+	}
+	if m.FailedPermanently || m.FailedAttempts >= EmailMaxSendAttempts {
+		return EmailStatusFailed
+	}
+	return EmailStatusPending
+}
+
 // EmailCreate creates an email message in the DB which is waiting to be sent.
 func (db *DB) EmailCreate(ctx context.Context, m EmailMessage) error {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
 	_, err := db.staticEmails.InsertOne(ctx, m)
 	if err != nil {
 		return errors.AddContext(err, "failed to Insert")
@@ -62,9 +129,10 @@ func (db *DB) EmailLockAndFetch(ctx context.Context, lockID string, batchSize in
 	// Find out how many entries are already locked by this id. Maybe we don't
 	// need to lock any additional ones.
 	filter := bson.M{
-		"locked_by":       lockID,
-		"failed_attempts": bson.M{"$lt": EmailMaxSendAttempts},
-		"sent_at":         nil,
+		"locked_by":          lockID,
+		"failed_attempts":    bson.M{"$lt": EmailMaxSendAttempts},
+		"failed_permanently": bson.M{"$ne": true},
+		"sent_at":            nil,
 	}
 	count, err := db.staticEmails.CountDocuments(ctx, filter)
 	if err != nil {
@@ -73,11 +141,15 @@ func (db *DB) EmailLockAndFetch(ctx context.Context, lockID string, batchSize in
 	// Lock some more entries in order to fill the batch.
 	// We select entries which:
 	//  - haven't failed more times than the limit
+	//  - haven't failed permanently
 	//  - aren't sent, yet
+	//  - are due for their next attempt
 	//  - are either unlocked or their lock has expired
 	filterLock := bson.M{
-		"failed_attempts": bson.M{"$lt": EmailMaxSendAttempts},
-		"sent_at":         nil,
+		"failed_attempts":    bson.M{"$lt": EmailMaxSendAttempts},
+		"failed_permanently": bson.M{"$ne": true},
+		"sent_at":            nil,
+		"next_attempt_at":    bson.M{"$not": bson.M{"$gt": time.Now().UTC()}},
 		"$or": bson.A{
 			bson.M{"locked_by": ""},
 			bson.M{"locked_at": bson.M{"$lt": time.Now().UTC().Add(-emailLockTTL)}},
@@ -137,11 +209,13 @@ func (db *DB) MarkAsSent(ctx context.Context, ids []primitive.ObjectID) error {
 		return nil
 	}
 	filter := bson.M{"_id": bson.M{"$in": ids}}
+	now := time.Now().UTC()
 	update := bson.M{
 		"$set": bson.M{
-			"locked_by": "",
-			"locked_at": time.Time{},
-			"sent_at":   time.Now().UTC(),
+			"locked_by":       "",
+			"locked_at":       time.Time{},
+			"sent_at":         now,
+			"last_attempt_at": now,
 		},
 	}
 	_, err := db.staticEmails.UpdateMany(ctx, filter, update)
@@ -152,26 +226,179 @@ func (db *DB) MarkAsSent(ctx context.Context, ids []primitive.ObjectID) error {
 }
 
 // MarkAsFailed increments the FailedAttempts counter on each message and
-// marks the message as Failed if that counter exceeds the maxAttemptsToSend.
-// It also unlocks all given messages.
+// schedules its next attempt with an exponential backoff. Messages which
+// failed permanently or reached EmailMaxSendAttempts are not retried. It also
+// stores each message's LastError and unlocks all given messages.
 func (db *DB) MarkAsFailed(ctx context.Context, msgs []*EmailMessage) error {
 	if len(msgs) == 0 {
 		return nil
 	}
-	var ids []primitive.ObjectID
+	now := time.Now().UTC()
+	var models []mongo.WriteModel
 	for _, m := range msgs {
-		ids = append(ids, m.ID)
+		set := bson.M{
+			"locked_by":       "",
+			"locked_at":       time.Time{},
+			"last_error":      m.LastError,
+			"last_attempt_at": now,
+			"next_attempt_at": now.Add(emailRetryBackoff(m.FailedAttempts + 1)),
+		}
+		if m.FailedPermanently {
+			set["failed_permanently"] = true
+		}
+		update := bson.M{
+			"$inc": bson.M{"failed_attempts": 1},
+			"$set": set,
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": m.ID}).SetUpdate(update))
 	}
-	filter := bson.M{"_id": bson.M{"$in": ids}}
-	update := bson.M{
-		"$inc": bson.M{"failed_attempts": 1},
+	_, err := db.staticEmails.BulkWrite(ctx, models)
+	return err
+}
+
+// EmailList returns the email messages which match the given filter, newest
+// first, together with the total number of matching messages.
+func (db *DB) EmailList(ctx context.Context, f EmailFilter, offset, pageSize int) ([]EmailMessage, int, error) {
+	filter, err := emailFilter(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := db.staticEmails.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to count email messages")
+	}
+	opts := options.Find()
+	opts.SetSort(bson.D{{"_id", -1}})
+	opts.SetSkip(int64(offset))
+	opts.SetLimit(int64(pageSize))
+	_, msgs, err := db.FindEmails(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return msgs, int(count), nil
+}
+
+// EmailLatest returns the latest email message sent to the given address from
+// the given template.
+func (db *DB) EmailLatest(ctx context.Context, to types.Email, template string) (EmailMessage, error) {
+	var m EmailMessage
+	opts := options.FindOne().SetSort(bson.D{{"_id", -1}})
+	sr := db.staticEmails.FindOne(ctx, bson.M{"to": to.String(), "template": template}, opts)
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return m, ErrEmailNotFound
+	}
+	if sr.Err() != nil {
+		return m, sr.Err()
+	}
+	err := sr.Decode(&m)
+	return m, err
+}
+
+// EmailRequeue resets the failed attempts of the failed email message with the
+// given id, so the message gets sent again.
+func (db *DB) EmailRequeue(ctx context.Context, id primitive.ObjectID) error {
+	filter, err := emailFilter(EmailFilter{Status: EmailStatusFailed})
+	if err != nil {
+		return err
+	}
+	filter["_id"] = id
+	ur, err := db.staticEmails.UpdateOne(ctx, filter, emailRequeueUpdate())
+	if err != nil {
+		return errors.AddContext(err, "failed to requeue email message")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrEmailNotFound
+	}
+	return nil
+}
+
+// EmailRequeueFailed resets the failed attempts of all failed email messages
+// which match the given filter. It returns the number of requeued messages.
+func (db *DB) EmailRequeueFailed(ctx context.Context, f EmailFilter) (int64, error) {
+	f.Status = EmailStatusFailed
+	filter, err := emailFilter(f)
+	if err != nil {
+		return 0, err
+	}
+	ur, err := db.staticEmails.UpdateMany(ctx, filter, emailRequeueUpdate())
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to requeue email messages")
+	}
+	return ur.ModifiedCount, nil
+}
+
+// emailRetryBackoff returns the time we wait before the next attempt at
+// sending a message which failed the given number of times. The wait doubles
+// with each failure, up to EmailRetryBackoffMax. We randomise the second half
+// of the wait, so messages which failed together don't get retried together.
+func emailRetryBackoff(failedAttempts int) time.Duration {
+	backoff := EmailRetryBackoff
+	for i := 1; i < failedAttempts && backoff < EmailRetryBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > EmailRetryBackoffMax {
+		backoff = EmailRetryBackoffMax
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	half := backoff / 2
+	return half + time.Duration(fastrand.Uint64n(uint64(backoff-half)))
+}
+
+// emailRequeueUpdate returns the update which puts failed email messages back
+// in the sending queue.
+func emailRequeueUpdate() bson.M {
+	return bson.M{
 		"$set": bson.M{
-			"locked_by": "",
-			"locked_at": time.Time{},
+			"failed_attempts": 0,
+			"locked_by":       "",
+			"locked_at":       time.Time{},
+		},
+		"$unset": bson.M{
+			"failed_permanently": "",
+			"next_attempt_at":    "",
 		},
 	}
-	_, err := db.staticEmails.UpdateMany(ctx, filter, update)
-	return err
+}
+
+// emailFilter converts the given EmailFilter to a DB filter.
+func emailFilter(f EmailFilter) (bson.M, error) {
+	filter := bson.M{}
+	switch f.Status {
+	case "":
+	case EmailStatusPending:
+		filter["sent_at"] = nil
+		filter["failed_attempts"] = bson.M{"$lt": EmailMaxSendAttempts}
+		filter["failed_permanently"] = bson.M{"$ne": true}
+	case EmailStatusSent:
+		filter["sent_at"] = bson.M{"$ne": nil}
+	case EmailStatusFailed:
+		filter["sent_at"] = nil
+		filter["$or"] = bson.A{
+			bson.M{"failed_attempts": bson.M{"$gte": EmailMaxSendAttempts}},
+			bson.M{"failed_permanently": true},
+		}
+	default:
+		return nil, ErrInvalidEmailStatus
+	}
+	if f.To != "" {
+		filter["to"] = f.To.String()
+	}
+	if f.Template != "" {
+		filter["template"] = f.Template
+	}
+	if !f.From.IsZero() || !f.Until.IsZero() {
+		createdAt := bson.M{}
+		if !f.From.IsZero() {
+			createdAt["$gte"] = f.From
+		}
+		if !f.Until.IsZero() {
+			createdAt["$lt"] = f.Until
+		}
+		filter["created_at"] = createdAt
+	}
+	return filter, nil
 }
 
 // PurgeEmailCollection is a helper method for testing purposes. It removes all
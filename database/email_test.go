@@ -0,0 +1,67 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEmailMessageStatus ensures that we correctly derive the delivery status
+// of email messages.
+func TestEmailMessageStatus(t *testing.T) {
+	tests := []struct {
+		msg    EmailMessage
+		status string
+	}{
+		{msg: EmailMessage{}, status: EmailStatusPending},
+		{msg: EmailMessage{FailedAttempts: EmailMaxSendAttempts - 1}, status: EmailStatusPending},
+		{msg: EmailMessage{FailedAttempts: EmailMaxSendAttempts}, status: EmailStatusFailed},
+		{msg: EmailMessage{FailedAttempts: 1, FailedPermanently: true}, status: EmailStatusFailed},
+		{msg: EmailMessage{SentAt: time.Now()}, status: EmailStatusSent},
+		{msg: EmailMessage{SentAt: time.Now(), FailedAttempts: 1}, status: EmailStatusSent},
+	}
+	for _, tt := range tests {
+		if s := tt.msg.Status(); s != tt.status {
+			t.Errorf("Expected status '%s', got '%s' for %+v", tt.status, s, tt.msg)
+		}
+	}
+	_, err := emailFilter(EmailFilter{Status: "bogus"})
+	if err != ErrInvalidEmailStatus {
+		t.Fatalf("Expected '%v', got '%v'", ErrInvalidEmailStatus, err)
+	}
+}
+
+// TestEmailRetryBackoff ensures that the time between attempts at sending an
+// email grows exponentially, stays within its limit and is randomised.
+func TestEmailRetryBackoff(t *testing.T) {
+	defer func(b, m time.Duration) {
+		EmailRetryBackoff = b
+		EmailRetryBackoffMax = m
+	}(EmailRetryBackoff, EmailRetryBackoffMax)
+	EmailRetryBackoff = time.Minute
+	EmailRetryBackoffMax = time.Hour
+
+	tests := []struct {
+		failedAttempts int
+		max            time.Duration
+	}{
+		{failedAttempts: 1, max: time.Minute},
+		{failedAttempts: 2, max: 2 * time.Minute},
+		{failedAttempts: 5, max: 16 * time.Minute},
+		{failedAttempts: 7, max: time.Hour},
+		{failedAttempts: 1000, max: time.Hour},
+	}
+	for _, tt := range tests {
+		b := emailRetryBackoff(tt.failedAttempts)
+		if b < tt.max/2 || b > tt.max {
+			t.Errorf("Expected a backoff between %v and %v after %d failures, got %v", tt.max/2, tt.max, tt.failedAttempts, b)
+		}
+	}
+	// Make sure we add jitter.
+	b1 := emailRetryBackoff(10)
+	for i := 0; i < 10; i++ {
+		if emailRetryBackoff(10) != b1 {
+			return
+		}
+	}
+	t.Fatal("Expected the backoff to be randomised.")
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	_ "embed" // Needed for embedding the disposable email domains.
+	"fmt"
+	"strings"
+
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrEmailDomainNotAllowed is returned when the portal only allows
+	// certain email domains and the given address is not on one of them.
+	ErrEmailDomainNotAllowed = errors.New("this portal doesn't accept email addresses from this domain")
+	// ErrEmailDomainDenied is returned when the given email address is on a
+	// domain which the portal doesn't accept.
+	ErrEmailDomainDenied = errors.New("email addresses from this domain are not accepted")
+	// ErrEmailDisposable is returned when the given email address is from a
+	// disposable email provider and the portal blocks those.
+	ErrEmailDisposable = errors.New("disposable email addresses are not accepted, please use a permanent address")
+
+	// disposableEmailDomainsList is the bundled list of disposable email
+	// providers.
+	//go:embed disposable_email_domains.txt
+	disposableEmailDomainsList string
+	// disposableEmailDomains is the parsed disposableEmailDomainsList.
+	disposableEmailDomains = parseDomainList(disposableEmailDomainsList)
+)
+
+// EmailDomainCheck ensures that the portal's email domain policy allows users
+// to use the given email address. The policy consists of the
+// ConfValEmailDomainsAllowed, ConfValEmailDomainsDenied,
+// ConfValEmailDisposableBlocked and ConfValEmailDisposableDomains settings.
+func (db *DB) EmailDomainCheck(ctx context.Context, email types.Email) error {
+	domain := emailDomain(email)
+	allowed, err := db.SettingStringList(ctx, ConfValEmailDomainsAllowed)
+	if err != nil {
+		return errors.AddContext(err, "failed to read the allowed email domains")
+	}
+	if len(allowed) > 0 && !domainInList(domain, toDomainSet(allowed)) {
+		return ErrEmailDomainNotAllowed
+	}
+	denied, err := db.SettingStringList(ctx, ConfValEmailDomainsDenied)
+	if err != nil {
+		return errors.AddContext(err, "failed to read the denied email domains")
+	}
+	if domainInList(domain, toDomainSet(denied)) {
+		return ErrEmailDomainDenied
+	}
+	blockDisposable, err := db.SettingBool(ctx, ConfValEmailDisposableBlocked)
+	if err != nil {
+		return errors.AddContext(err, "failed to read the disposable email policy")
+	}
+	if !blockDisposable {
+		return nil
+	}
+	extra, err := db.SettingStringList(ctx, ConfValEmailDisposableDomains)
+	if err != nil {
+		return errors.AddContext(err, "failed to read the disposable email domains")
+	}
+	if domainInList(domain, disposableEmailDomains) || domainInList(domain, toDomainSet(extra)) {
+		return ErrEmailDisposable
+	}
+	return nil
+}
+
+// IsDisposableEmailDomain reports whether the given domain or any of its
+// parent domains is on the bundled list of disposable email providers.
+func IsDisposableEmailDomain(domain string) bool {
+	return domainInList(strings.ToLower(domain), disposableEmailDomains)
+}
+
+// domainInList reports whether the given domain or any of its parent domains
+// is in the given set.
+func domainInList(domain string, set map[string]struct{}) bool {
+	for domain != "" {
+		if _, ok := set[domain]; ok {
+			return true
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return false
+}
+
+// emailDomain returns the lowercase domain part of the given email address.
+func emailDomain(email types.Email) string {
+	s := email.String()
+	return strings.ToLower(s[strings.LastIndex(s, "@")+1:])
+}
+
+// parseDomainList parses a list of domains with one domain per line. Empty
+// lines and lines starting with # are ignored.
+func parseDomainList(list string) map[string]struct{} {
+	var domains []string
+	s := bufio.NewScanner(strings.NewReader(list))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			domains = append(domains, line)
+		}
+	}
+	return toDomainSet(domains)
+}
+
+// toDomainSet converts the given list of domains into a lowercase set.
+func toDomainSet(domains []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(domains))
+	for _, d := range domains {
+		set[strings.ToLower(d)] = struct{}{}
+	}
+	return set
+}
+
+// validateDomains ensures the given comma-separated list only contains
+// domain names.
+func validateDomains(val string) error {
+	for _, d := range splitStringList(val) {
+		if strings.ContainsAny(d, "@ /") || !strings.Contains(d, ".") || strings.HasPrefix(d, ".") || strings.HasSuffix(d, ".") {
+			return fmt.Errorf("invalid domain '%s'", d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/types"
+)
+
+// TestDomainInList ensures that domains match themselves and their subdomains.
+func TestDomainInList(t *testing.T) {
+	set := toDomainSet([]string{"Example.com", "corp.net"})
+	tests := []struct {
+		domain string
+		found  bool
+	}{
+		{domain: "example.com", found: true},
+		{domain: "mail.example.com", found: true},
+		{domain: "a.b.corp.net", found: true},
+		{domain: "notexample.com", found: false},
+		{domain: "com", found: false},
+		{domain: "", found: false},
+	}
+	for _, tt := range tests {
+		if found := domainInList(tt.domain, set); found != tt.found {
+			t.Errorf("Expected %t for '%s', got %t", tt.found, tt.domain, found)
+		}
+	}
+}
+
+// TestDisposableEmailDomains ensures the bundled list of disposable email
+// providers is parsed correctly.
+func TestDisposableEmailDomains(t *testing.T) {
+	if len(disposableEmailDomains) == 0 {
+		t.Fatal("Expected the bundled list to be non-empty.")
+	}
+	for d := range disposableEmailDomains {
+		if err := validateDomains(d); err != nil {
+			t.Fatalf("Invalid bundled domain '%s': %v", d, err)
+		}
+	}
+	if !IsDisposableEmailDomain("Mailinator.com") || !IsDisposableEmailDomain("x.yopmail.com") {
+		t.Fatal("Expected domain to be disposable.")
+	}
+	if IsDisposableEmailDomain("siasky.net") {
+		t.Fatal("Expected domain not to be disposable.")
+	}
+	if d := emailDomain(types.NewEmail("user@Sub.Example.com")); d != "sub.example.com" {
+		t.Fatalf("Expected 'sub.example.com', got '%s'", d)
+	}
+}
+
+// TestValidateDomains ensures we only accept lists of domain names.
+func TestValidateDomains(t *testing.T) {
+	if err := validateDomains("example.com, corp.net"); err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{"user@example.com", "localhost", ".com", "example.com.", "exa mple.com"} {
+		if err := validateDomains(val); err == nil {
+			t.Errorf("Expected an error for '%s'", val)
+		}
+	}
+}
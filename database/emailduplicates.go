@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/**
+Email addresses are unique across users. We enforce that with a unique index on
+the users' email field. Users without an email address, e.g. users who
+registered with a pubkey, are not covered by the index.
+
+Before we normalized email addresses (see types.NewEmail), the same address
+could be stored in different forms by different users, so existing databases
+might contain duplicates which prevent us from creating the index. When we
+find such duplicates, we store a report in the collEmailDuplicates collection,
+so the portal's admins can merge the affected accounts, and we only create the
+index once no duplicates are left.
+*/
+
+const (
+	// emailUniqueIndex is the name of the unique index on the users' email
+	// field. We can't use "email_unique" because that's the name of a
+	// historical index which we drop on each start.
+	emailUniqueIndex = "email_normalized_unique"
+)
+
+type (
+	// EmailDuplicate describes an email address which belongs to more than
+	// one user.
+	EmailDuplicate struct {
+		Email      types.Email          `bson:"_id" json:"email"`
+		UserIDs    []primitive.ObjectID `bson:"user_ids" json:"userIds"`
+		DetectedAt time.Time            `bson:"detected_at" json:"detectedAt"`
+	}
+)
+
+// EmailDuplicates returns the latest report of email addresses which belong to
+// more than one user.
+func (db *DB) EmailDuplicates(ctx context.Context) ([]EmailDuplicate, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	c, err := db.staticEmailDuplicates.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to find email duplicates")
+	}
+	dups := make([]EmailDuplicate, 0)
+	err = c.All(ctx, &dups)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse email duplicates")
+	}
+	return dups, nil
+}
+
+// EmailDuplicatesCheck looks for email addresses which belong to more than one
+// user and replaces the stored report with the result. Once there are no
+// duplicates left, it ensures the unique index on the users' email field
+// exists. Admins can use it after merging the affected accounts, so they don't
+// need to wait for the next restart of the service.
+func (db *DB) EmailDuplicatesCheck(ctx context.Context) ([]EmailDuplicate, error) {
+	return ensureUniqueEmails(ctx, db.staticDB, db.staticLogger)
+}
+
+// ensureUniqueEmails normalizes the stored email addresses and creates the
+// unique index on the users' email field. If some addresses belong to more
+// than one user, it doesn't create the index. Instead, it stores a report of
+// the duplicates and returns them. Not being able to create the index doesn't
+// prevent the service from running.
+func ensureUniqueEmails(ctx context.Context, db *mongo.Database, log *logrus.Logger) ([]EmailDuplicate, error) {
+	users := db.Collection(collUsers)
+	specs, err := users.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list the users' indexes")
+	}
+	for _, s := range specs {
+		// All addresses we store are normalized and unique once the index
+		// exists, so there is nothing left to do.
+		if s.Name == emailUniqueIndex {
+			return []EmailDuplicate{}, nil
+		}
+	}
+	err = normalizeStoredEmails(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	dups, err := findEmailDuplicates(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	report := db.Collection(collEmailDuplicates)
+	_, err = report.DeleteMany(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to clear the email duplicates report")
+	}
+	if len(dups) > 0 {
+		docs := make([]interface{}, 0, len(dups))
+		for _, d := range dups {
+			docs = append(docs, d)
+		}
+		_, err = report.InsertMany(ctx, docs)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to store the email duplicates report")
+		}
+		log.Warnf("Found %d email addresses which belong to more than one user. The unique index on users' emails will be created once these accounts are merged. See the '%s' collection for details.", len(dups), collEmailDuplicates)
+		return dups, nil
+	}
+	model := mongo.IndexModel{
+		Keys: bson.M{"email": 1},
+		Options: options.Index().
+			SetName(emailUniqueIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+	}
+	_, err = users.Indexes().CreateOne(ctx, model)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create the unique index on users' emails")
+	}
+	log.Debugf("Ensured index exists: %v", emailUniqueIndex)
+	return dups, nil
+}
+
+// findEmailDuplicates returns all email addresses which belong to more than
+// one user.
+func findEmailDuplicates(ctx context.Context, users *mongo.Collection) ([]EmailDuplicate, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"email": bson.M{"$gt": ""}}}},
+		{{"$group", bson.M{
+			"_id":      "$email",
+			"user_ids": bson.M{"$push": "$_id"},
+			"count":    bson.M{"$sum": 1},
+		}}},
+		{{"$match", bson.M{"count": bson.M{"$gt": 1}}}},
+		{{"$sort", bson.M{"_id": 1}}},
+	}
+	c, err := users.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to find email duplicates")
+	}
+	dups := make([]EmailDuplicate, 0)
+	err = c.All(ctx, &dups)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse email duplicates")
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := range dups {
+		dups[i].DetectedAt = now
+	}
+	return dups, nil
+}
+
+// normalizeStoredEmails normalizes the email addresses and pending email
+// addresses of all users which have them.
+func normalizeStoredEmails(ctx context.Context, users *mongo.Collection) error {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"email": bson.M{"$gt": ""}},
+		bson.M{"pending_email": bson.M{"$gt": ""}},
+	}}
+	opts := options.Find().SetProjection(bson.M{"email": 1, "pending_email": 1})
+	c, err := users.Find(ctx, filter, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to find users' emails")
+	}
+	defer func() { _ = c.Close(ctx) }()
+	for c.Next(ctx) {
+		var u struct {
+			ID           primitive.ObjectID `bson:"_id"`
+			Email        string             `bson:"email"`
+			PendingEmail string             `bson:"pending_email"`
+		}
+		if err = c.Decode(&u); err != nil {
+			return errors.AddContext(err, "failed to parse user's emails")
+		}
+		set := bson.M{}
+		if e := types.NewEmail(u.Email); string(e) != u.Email {
+			set["email"] = e
+		}
+		if e := types.NewEmail(u.PendingEmail); string(e) != u.PendingEmail {
+			set["pending_email"] = e
+		}
+		if len(set) == 0 {
+			continue
+		}
+		_, err = users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$set": set})
+		if err != nil {
+			return errors.AddContext(err, "failed to normalize user's emails")
+		}
+	}
+	return c.Err()
+}
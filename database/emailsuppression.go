@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// EmailSuppressionBounce marks addresses to which our emails bounced
+	// permanently.
+	EmailSuppressionBounce = "bounce"
+	// EmailSuppressionComplaint marks addresses whose owners reported our
+	// emails as spam.
+	EmailSuppressionComplaint = "complaint"
+)
+
+var (
+	// ErrEmailSuppressionNotFound is returned when the given email address is
+	// not on the suppression list.
+	ErrEmailSuppressionNotFound = errors.New("email address is not suppressed")
+	// ErrInvalidEmailSuppressionReason is returned when the given reason for
+	// suppressing an address is not supported.
+	ErrInvalidEmailSuppressionReason = errors.New("invalid suppression reason, supported values are 'bounce' and 'complaint'")
+)
+
+type (
+	// EmailSuppression is an email address to which we no longer send emails
+	// because they bounced or because the recipient complained about them.
+	EmailSuppression struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		Email     types.Email        `bson:"email" json:"email"`
+		Reason    string             `bson:"reason" json:"reason"`
+		Detail    string             `bson:"detail,omitempty" json:"detail"`
+		CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+	}
+)
+
+// EmailSuppressionAdd adds the given email address to the suppression list and
+// flags all users with that address, so they can be prompted to change it.
+// Suppressing an address which is already suppressed updates its reason.
+func (db *DB) EmailSuppressionAdd(ctx context.Context, email types.Email, reason, detail string) error {
+	if reason != EmailSuppressionBounce && reason != EmailSuppressionComplaint {
+		return ErrInvalidEmailSuppressionReason
+	}
+	filter := bson.M{"email": email.String()}
+	update := bson.M{
+		"$set": bson.M{
+			"reason": reason,
+			"detail": detail,
+		},
+		"$setOnInsert": bson.M{
+			"created_at": time.Now().UTC().Truncate(time.Millisecond),
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err := db.staticEmailSuppressions.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to suppress email address")
+	}
+	update = bson.M{"$set": bson.M{"email_delivery_issue": reason}}
+	_, err = db.staticUsers.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to flag users")
+	}
+	return nil
+}
+
+// EmailSuppressionRemove removes the given email address from the suppression
+// list and clears the flag of the users with that address.
+func (db *DB) EmailSuppressionRemove(ctx context.Context, email types.Email) error {
+	filter := bson.M{"email": email.String()}
+	dr, err := db.staticEmailSuppressions.DeleteOne(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to remove email suppression")
+	}
+	if dr.DeletedCount == 0 {
+		return ErrEmailSuppressionNotFound
+	}
+	update := bson.M{"$unset": bson.M{"email_delivery_issue": ""}}
+	_, err = db.staticUsers.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to clear user flags")
+	}
+	return nil
+}
+
+// EmailSuppressionByEmail returns the suppression of the given email address.
+func (db *DB) EmailSuppressionByEmail(ctx context.Context, email types.Email) (EmailSuppression, error) {
+	var es EmailSuppression
+	sr := db.staticEmailSuppressions.FindOne(ctx, bson.M{"email": email.String()})
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return es, ErrEmailSuppressionNotFound
+	}
+	if sr.Err() != nil {
+		return es, sr.Err()
+	}
+	err := sr.Decode(&es)
+	return es, err
+}
+
+// EmailSuppressed reports whether the given email address is on the
+// suppression list.
+func (db *DB) EmailSuppressed(ctx context.Context, email types.Email) (bool, error) {
+	n, err := db.staticEmailSuppressions.CountDocuments(ctx, bson.M{"email": email.String()})
+	if err != nil {
+		return false, errors.AddContext(err, "failed to check email suppression")
+	}
+	return n > 0, nil
+}
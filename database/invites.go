@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/**
+Invite codes allow portals to run in invite-only mode, in which new users can
+only register with a valid code. The mode is controlled by the
+ConfValInvitesRequired runtime setting.
+
+Admins can create codes which can be used multiple times and which assign a
+given tier to the users who register with them. Users can create single-use
+codes, up to the quota of their tier.
+*/
+
+const (
+	// inviteCodeSize is the number of random bytes in an invite code.
+	inviteCodeSize = 10
+)
+
+var (
+	// InviteQuota defines how many invite codes a user of each tier can create
+	// during an InviteQuotaPeriod.
+	InviteQuota = map[int]int{
+		TierAnonymous: 0,
+		TierFree:      1,
+		TierPremium5:  3,
+		TierPremium20: 10,
+		TierPremium80: 25,
+	}
+	// InviteQuotaPeriod is the period over which we count the invite codes a
+	// user has created.
+	InviteQuotaPeriod = 30 * 24 * time.Hour
+	// UserInviteTTL defines how long the invite codes created by users are
+	// valid.
+	UserInviteTTL = 30 * 24 * time.Hour
+
+	// ErrInvalidInviteCode is returned when the given invite code doesn't
+	// exist, has expired or has been used up.
+	ErrInvalidInviteCode = errors.New("invalid, expired or used up invite code")
+	// ErrInviteNotFound is returned when the given invite code doesn't exist.
+	ErrInviteNotFound = errors.New("invite code not found")
+	// ErrInviteQuotaExceeded is returned when the user has created as many
+	// invite codes as their tier allows.
+	ErrInviteQuotaExceeded = errors.New("invite code quota exceeded")
+)
+
+type (
+	// Invite is an invite code which allows registering on the portal while
+	// it's in invite-only mode.
+	Invite struct {
+		ID   primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		Code string             `bson:"code" json:"code"`
+		// CreatedBy is the user who created the code. It's empty for codes
+		// created by admins.
+		CreatedBy primitive.ObjectID `bson:"created_by,omitempty" json:"-"`
+		// Tier is the tier assigned to the users who register with this code.
+		// Zero means the default tier.
+		Tier      int       `bson:"tier,omitempty" json:"tier,omitempty"`
+		MaxUses   int       `bson:"max_uses" json:"maxUses"`
+		Uses      int       `bson:"uses" json:"uses"`
+		CreatedAt time.Time `bson:"created_at" json:"createdAt"`
+		// ExpiresAt is the zero time for codes which don't expire.
+		ExpiresAt time.Time `bson:"expires_at,omitempty" json:"expiresAt"`
+	}
+)
+
+// InviteCreate creates a new invite code. The code can be used maxUses times
+// and expires after the given TTL. A zero TTL means the code doesn't expire.
+// The createdBy user id is empty for codes created by admins.
+func (db *DB) InviteCreate(ctx context.Context, createdBy primitive.ObjectID, tier, maxUses int, ttl time.Duration) (*Invite, error) {
+	if maxUses < 1 {
+		return nil, errors.New("an invite code needs to allow at least one use")
+	}
+	if tier != 0 && (tier <= TierAnonymous || tier >= TierMaxReserved) {
+		return nil, errors.New("invalid tier")
+	}
+	if ttl < 0 {
+		return nil, errors.New("invalid ttl")
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	inv := &Invite{
+		Code:      base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(fastrand.Bytes(inviteCodeSize)),
+		CreatedBy: createdBy,
+		Tier:      tier,
+		MaxUses:   maxUses,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		inv.ExpiresAt = now.Add(ttl)
+	}
+	ir, err := db.staticInvites.InsertOne(ctx, inv)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to insert invite code")
+	}
+	inv.ID = ir.InsertedID.(primitive.ObjectID)
+	return inv, nil
+}
+
+// InviteCreateForUser creates a single-use invite code on behalf of the given
+// user. It fails with ErrInviteQuotaExceeded if the user has already created
+// as many codes as their tier allows during the current quota period.
+//
+// The quota period starts with the first code the user creates after the
+// previous period ended. We count the codes on the user's document with a
+// conditional update, so concurrent requests can't exceed the quota.
+func (db *DB) InviteCreateForUser(ctx context.Context, u User) (*Invite, error) {
+	quota := InviteQuota[u.Tier]
+	if quota <= 0 {
+		return nil, ErrInviteQuotaExceeded
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	periodStart := now.Add(-InviteQuotaPeriod)
+	filter := bson.M{
+		"_id": u.ID,
+		"$or": bson.A{
+			bson.M{"invites_period_start": bson.M{"$not": bson.M{"$gt": periodStart}}},
+			bson.M{"invites_created": bson.M{"$lt": quota}},
+		},
+	}
+	// A missing period start sorts before any date, so it counts as expired.
+	expired := bson.M{"$not": bson.A{bson.M{"$gt": bson.A{"$invites_period_start", periodStart}}}}
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"invites_created": bson.M{"$cond": bson.A{
+				expired,
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$invites_created", 0}}, 1}},
+			}},
+			"invites_period_start": bson.M{"$cond": bson.A{expired, now, "$invites_period_start"}},
+		}},
+	}
+	ur, err := db.staticUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count the user's invite codes")
+	}
+	if ur.MatchedCount == 0 {
+		return nil, ErrInviteQuotaExceeded
+	}
+	inv, err := db.InviteCreate(ctx, u.ID, 0, 1, UserInviteTTL)
+	if err != nil {
+		// Give the user their quota back.
+		_, errDec := db.staticUsers.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$inc": bson.M{"invites_created": -1}})
+		return nil, errors.Compose(err, errDec)
+	}
+	return inv, nil
+}
+
+// InviteDelete deletes the given invite code, so it can no longer be used.
+func (db *DB) InviteDelete(ctx context.Context, code string) error {
+	dr, err := db.staticInvites.DeleteOne(ctx, bson.M{"code": normalizeInviteCode(code)})
+	if err != nil {
+		return errors.AddContext(err, "failed to delete invite code")
+	}
+	if dr.DeletedCount == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// InviteRedeem uses up one of the uses of the given invite code and returns
+// the code. It fails with ErrInvalidInviteCode if the code doesn't exist, has
+// expired, or has no uses left.
+func (db *DB) InviteRedeem(ctx context.Context, code string) (*Invite, error) {
+	if code == "" {
+		return nil, ErrInvalidInviteCode
+	}
+	filter := bson.M{
+		"code":  normalizeInviteCode(code),
+		"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": time.Now().UTC()}},
+		},
+	}
+	update := bson.M{"$inc": bson.M{"uses": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	sr := db.staticInvites.FindOneAndUpdate(ctx, filter, update, opts)
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return nil, ErrInvalidInviteCode
+	}
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to redeem invite code")
+	}
+	var inv Invite
+	err := sr.Decode(&inv)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse invite code")
+	}
+	return &inv, nil
+}
+
+// InviteRelease gives back a use of the given invite code. We use it when we
+// fail to register a user after redeeming their code.
+func (db *DB) InviteRelease(ctx context.Context, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id, "uses": bson.M{"$gt": 0}}
+	update := bson.M{"$inc": bson.M{"uses": -1}}
+	_, err := db.staticInvites.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to release invite code")
+	}
+	return nil
+}
+
+// Invites returns a page of all invite codes, newest first, and their total
+// count.
+func (db *DB) Invites(ctx context.Context, offset, pageSize int) ([]Invite, int, error) {
+	return db.managedInvites(ctx, bson.M{}, offset, pageSize)
+}
+
+// InvitesByUser returns a page of the invite codes created by the given user,
+// newest first, and their total count.
+func (db *DB) InvitesByUser(ctx context.Context, uID primitive.ObjectID, offset, pageSize int) ([]Invite, int, error) {
+	return db.managedInvites(ctx, bson.M{"created_by": uID}, offset, pageSize)
+}
+
+// managedInvites returns a page of the invite codes which match the given
+// filter and their total count.
+func (db *DB) managedInvites(ctx context.Context, filter bson.M, offset, pageSize int) ([]Invite, int, error) {
+	count, err := db.staticInvites.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to count invite codes")
+	}
+	opts := options.Find()
+	opts.SetSort(bson.D{{"_id", -1}})
+	opts.SetSkip(int64(offset))
+	opts.SetLimit(int64(pageSize))
+	c, err := db.staticInvites.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to find invite codes")
+	}
+	invites := make([]Invite, 0)
+	err = c.All(ctx, &invites)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to parse invite codes")
+	}
+	return invites, int(count), nil
+}
+
+// normalizeInviteCode allows users to enter invite codes in any case and with
+// surrounding whitespace.
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,22 @@
+package database
+
+import (
+	"gitlab.com/NebulousLabs/errors"
+	"golang.org/x/text/language"
+)
+
+var (
+	// ErrInvalidLocale is returned when the given locale is not a valid BCP 47
+	// language tag.
+	ErrInvalidLocale = errors.New("invalid locale")
+)
+
+// NormalizeLocale validates the given BCP 47 language tag, e.g. "pt-BR", and
+// returns its canonical form.
+func NormalizeLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil || tag == language.Und {
+		return "", ErrInvalidLocale
+	}
+	return tag.String(), nil
+}
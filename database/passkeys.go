@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/webauthn"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// ChallengeTypePasskeyRegister is the type of the challenge we use for
+	// registering a new passkey.
+	ChallengeTypePasskeyRegister = "skynet-portal-passkey-register"
+	// ChallengeTypePasskeyLogin is the type of the challenge we use for
+	// logging in with a passkey.
+	ChallengeTypePasskeyLogin = "skynet-portal-passkey-login"
+
+	// MaxPasskeysPerUser is the maximum number of passkeys a user can have.
+	MaxPasskeysPerUser = 20
+)
+
+var (
+	// ErrPasskeyExists is returned when the user tries to register a passkey
+	// which is already registered.
+	ErrPasskeyExists = errors.New("passkey already registered")
+	// ErrPasskeyNotFound is returned when the passkey doesn't exist or doesn't
+	// belong to the user.
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	// ErrMaxNumPasskeysExceeded is returned when the user tries to register
+	// more passkeys than allowed.
+	ErrMaxNumPasskeysExceeded = errors.New("maximum number of passkeys exceeded")
+)
+
+type (
+	// Passkey is a WebAuthn credential which the user can use for logging in.
+	Passkey struct {
+		ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		UserID primitive.ObjectID `bson:"user_id" json:"-"`
+		// CredentialID is the id the authenticator assigned to the credential.
+		CredentialID []byte `bson:"credential_id" json:"-"`
+		// PublicKey is the COSE-encoded public key of the credential.
+		PublicKey []byte `bson:"public_key" json:"-"`
+		// SignCount is the last value of the authenticator's signature counter
+		// we've seen. We use it to detect cloned authenticators.
+		SignCount uint32    `bson:"sign_count" json:"-"`
+		Name      string    `bson:"name" json:"name"`
+		CreatedAt time.Time `bson:"created_at" json:"createdAt"`
+		// LastUsedAt is the zero time for passkeys which were never used.
+		LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"lastUsedAt"`
+	}
+)
+
+// Credential returns the passkey in the form the webauthn package works with.
+func (pk Passkey) Credential() webauthn.Credential {
+	return webauthn.Credential{
+		ID:        pk.CredentialID,
+		PublicKey: pk.PublicKey,
+		SignCount: pk.SignCount,
+	}
+}
+
+// NewPasskeyChallenge creates a new challenge for the given passkey ceremony.
+// The user is only known when registering a new passkey.
+func (db *DB) NewPasskeyChallenge(ctx context.Context, uID primitive.ObjectID, cType string) (*Challenge, error) {
+	if cType != ChallengeTypePasskeyRegister && cType != ChallengeTypePasskeyLogin {
+		return nil, errors.New("invalid challenge type '" + cType + "'")
+	}
+	ch := &Challenge{
+		Challenge: hex.EncodeToString(fastrand.Bytes(ChallengeSize)),
+		Type:      cType,
+		UserID:    uID,
+		ExpiresAt: time.Now().UTC().Add(challengeTTL).Truncate(time.Millisecond),
+	}
+	ior, err := db.staticChallenges.InsertOne(ctx, ch)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to create challenge DB record")
+	}
+	ch.ID = ior.InsertedID.(primitive.ObjectID)
+	return ch, nil
+}
+
+// PasskeyChallengeConsume fetches and deletes the given passkey challenge, so
+// it can only be used once. It fails if the challenge doesn't exist, has
+// expired, or is of a different type.
+func (db *DB) PasskeyChallengeConsume(ctx context.Context, challenge []byte, cType string) (*Challenge, error) {
+	filter := bson.M{
+		"challenge":  hex.EncodeToString(challenge),
+		"type":       cType,
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}
+	sr := db.staticChallenges.FindOneAndDelete(ctx, filter)
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return nil, errors.New("challenge not found or expired")
+	}
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to fetch challenge")
+	}
+	var ch Challenge
+	err := sr.Decode(&ch)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse challenge")
+	}
+	return &ch, nil
+}
+
+// PasskeyCreate stores a new passkey for the given user.
+func (db *DB) PasskeyCreate(ctx context.Context, uID primitive.ObjectID, cred webauthn.Credential, name string) (*Passkey, error) {
+	n, err := db.staticPasskeys.CountDocuments(ctx, bson.M{"user_id": uID})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to count the user's passkeys")
+	}
+	if n >= MaxPasskeysPerUser {
+		return nil, ErrMaxNumPasskeysExceeded
+	}
+	pk := &Passkey{
+		UserID:       uID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		Name:         name,
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	ior, err := db.staticPasskeys.InsertOne(ctx, pk)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrPasskeyExists
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to insert passkey")
+	}
+	pk.ID = ior.InsertedID.(primitive.ObjectID)
+	return pk, nil
+}
+
+// PasskeyByCredentialID returns the passkey with the given credential id.
+func (db *DB) PasskeyByCredentialID(ctx context.Context, credID []byte) (*Passkey, error) {
+	sr := db.staticPasskeys.FindOne(ctx, bson.M{"credential_id": credID})
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return nil, ErrPasskeyNotFound
+	}
+	if sr.Err() != nil {
+		return nil, errors.AddContext(sr.Err(), "failed to fetch passkey")
+	}
+	var pk Passkey
+	err := sr.Decode(&pk)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse passkey")
+	}
+	return &pk, nil
+}
+
+// PasskeysByUser returns all passkeys of the given user, oldest first.
+func (db *DB) PasskeysByUser(ctx context.Context, uID primitive.ObjectID) ([]Passkey, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	c, err := db.staticPasskeys.Find(ctx, bson.M{"user_id": uID}, opts)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to find passkeys")
+	}
+	pks := make([]Passkey, 0)
+	err = c.All(ctx, &pks)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse passkeys")
+	}
+	return pks, nil
+}
+
+// PasskeyDelete deletes the given passkey of the given user.
+func (db *DB) PasskeyDelete(ctx context.Context, uID, id primitive.ObjectID) error {
+	dr, err := db.staticPasskeys.DeleteOne(ctx, bson.M{"_id": id, "user_id": uID})
+	if err != nil {
+		return errors.AddContext(err, "failed to delete passkey")
+	}
+	if dr.DeletedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// PasskeyUsed records that the passkey was used for logging in and stores the
+// new value of its signature counter. It fails if the passkey was used
+// concurrently since we loaded it.
+func (db *DB) PasskeyUsed(ctx context.Context, pk *Passkey, signCount uint32) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	filter := bson.M{"_id": pk.ID, "sign_count": pk.SignCount}
+	update := bson.M{"$set": bson.M{"sign_count": signCount, "last_used_at": now}}
+	ur, err := db.staticPasskeys.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update passkey")
+	}
+	if ur.MatchedCount == 0 {
+		return webauthn.ErrSignCountMismatch
+	}
+	pk.SignCount = signCount
+	pk.LastUsedAt = now
+	return nil
+}
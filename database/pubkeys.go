@@ -0,0 +1,146 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrLastLoginMethod is returned when a user without a password or a
+	// confirmed email tries to remove the last pubkey or passkey they can log
+	// in with.
+	ErrLastLoginMethod = errors.New("cannot remove the last login method of an account")
+)
+
+type (
+	// PubKeyRecord holds the label and the usage of one of the user's pubkeys.
+	// The user's PubKeys field remains the source of truth on which pubkeys
+	// they can log in with. Pubkeys added before we started keeping records
+	// don't have one until they are used.
+	PubKeyRecord struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		UserID      primitive.ObjectID `bson:"user_id"`
+		Key         PubKey             `bson:"key"`
+		Label       string             `bson:"label"`
+		CreatedAt   time.Time          `bson:"created_at,omitempty"`
+		LastLoginAt time.Time          `bson:"last_login_at,omitempty"`
+	}
+)
+
+// HasLoginMethodBesides reports whether the user can still log in after
+// removing the given pubkey or passkey. Either of them can be empty. Users can
+// always log in with a password, if they have one, or with an email link, if
+// they have confirmed their email address.
+func (db *DB) HasLoginMethodBesides(ctx context.Context, u User, pk PubKey, passkeyID primitive.ObjectID) (bool, error) {
+	if u.PasswordHash != "" {
+		return true, nil
+	}
+	if u.Email != "" && !u.EmailUnconfirmed {
+		return true, nil
+	}
+	for _, upk := range u.PubKeys {
+		if !bytes.Equal(upk, pk) {
+			return true, nil
+		}
+	}
+	filter := bson.M{"user_id": u.ID}
+	if !passkeyID.IsZero() {
+		filter["_id"] = bson.M{"$ne": passkeyID}
+	}
+	n, err := db.staticPasskeys.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, errors.AddContext(err, "failed to count the user's passkeys")
+	}
+	return n > 0, nil
+}
+
+// LoginMethodsLock marks the start of a change to the user's login methods.
+// It must be called within the same transaction as the change, before
+// checking HasLoginMethodBesides. Since it writes to the user's document,
+// concurrent transactions which remove the user's login methods fail with a
+// WriteConflict and get retried, instead of each of them removing one of the
+// user's last two login methods.
+func (db *DB) LoginMethodsLock(ctx context.Context, uID primitive.ObjectID) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	update := bson.M{"$set": bson.M{"login_methods_changed_at": now}}
+	ur, err := db.staticUsers.UpdateOne(ctx, bson.M{"_id": uID}, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to lock the user's login methods")
+	}
+	if ur.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// PubKeyRecords returns the records of all pubkeys of the given user, in the
+// order in which they appear in the user's PubKeys.
+func (db *DB) PubKeyRecords(ctx context.Context, u User) ([]PubKeyRecord, error) {
+	c, err := db.staticPubKeys.Find(ctx, bson.M{"user_id": u.ID})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to find pubkey records")
+	}
+	var recs []PubKeyRecord
+	err = c.All(ctx, &recs)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse pubkey records")
+	}
+	byKey := make(map[string]PubKeyRecord, len(recs))
+	for _, r := range recs {
+		byKey[r.Key.String()] = r
+	}
+	items := make([]PubKeyRecord, 0, len(u.PubKeys))
+	for _, pk := range u.PubKeys {
+		r, exists := byKey[pk.String()]
+		if !exists {
+			r = PubKeyRecord{UserID: u.ID, Key: pk}
+		}
+		items = append(items, r)
+	}
+	return items, nil
+}
+
+// PubKeyLabelSet sets the label of the given pubkey of the given user.
+func (db *DB) PubKeyLabelSet(ctx context.Context, uID primitive.ObjectID, pk PubKey, label string) error {
+	return db.managedPubKeyRecordUpdate(ctx, uID, pk, bson.M{"label": label})
+}
+
+// PubKeyUsed records that the user logged in with the given pubkey.
+func (db *DB) PubKeyUsed(ctx context.Context, uID primitive.ObjectID, pk PubKey) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return db.managedPubKeyRecordUpdate(ctx, uID, pk, bson.M{"last_login_at": now})
+}
+
+// managedPubKeyRecordCreate creates the record of a pubkey which was just
+// added to the given user. It replaces any leftover record of the same key.
+func (db *DB) managedPubKeyRecordCreate(ctx context.Context, uID primitive.ObjectID, pk PubKey) error {
+	r := PubKeyRecord{
+		UserID:    uID,
+		Key:       pk,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.staticPubKeys.ReplaceOne(ctx, bson.M{"key": pk}, r, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to save pubkey record")
+	}
+	return nil
+}
+
+// managedPubKeyRecordUpdate sets the given fields of the record of the given
+// pubkey, creating the record if it doesn't exist yet.
+func (db *DB) managedPubKeyRecordUpdate(ctx context.Context, uID primitive.ObjectID, pk PubKey, set bson.M) error {
+	set["user_id"] = uID
+	opts := options.Update().SetUpsert(true)
+	_, err := db.staticPubKeys.UpdateOne(ctx, bson.M{"key": pk}, bson.M{"$set": set}, opts)
+	if err != nil {
+		return errors.AddContext(err, "failed to update pubkey record")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// QuotaResourceStorage identifies the storage quota of a user.
+	QuotaResourceStorage = "storage"
+	// QuotaResourceUploads identifies the quota on the number of uploads of a
+	// user.
+	QuotaResourceUploads = "uploads"
+	// QuotaResourceDownloadBandwidth identifies the monthly download bandwidth
+	// quota of a user.
+	QuotaResourceDownloadBandwidth = "download_bandwidth"
+)
+
+type (
+	// QuotaNotification records that we've notified a user that their usage
+	// of a given resource has reached a given threshold (in percent) during
+	// the billing period which starts at PeriodStart. We use these records in
+	// order to notify the user only once per threshold per billing period.
+	// Storage and the number of uploads are not limited per billing period,
+	// so their records have a zero PeriodStart and we notify the user about
+	// each of their thresholds only once.
+	QuotaNotification struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		UserID      primitive.ObjectID `bson:"user_id" json:"userId"`
+		PeriodStart time.Time          `bson:"period_start" json:"periodStart"`
+		Resource    string             `bson:"resource" json:"resource"`
+		Threshold   int                `bson:"threshold" json:"threshold"`
+		CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+	}
+)
+
+// QuotaNotifications returns the records of the quota notifications the user
+// has been sent during their current billing period, together with those of
+// resources which are not limited per billing period.
+func (db *DB) QuotaNotifications(ctx context.Context, user User) ([]QuotaNotification, error) {
+	filter := bson.M{
+		"user_id":      user.ID,
+		"period_start": bson.M{"$in": bson.A{time.Time{}, monthStart(user.SubscribedUntil)}},
+	}
+	c, err := db.staticQuotaNotifications.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch quota notifications")
+	}
+	var qns []QuotaNotification
+	err = c.All(ctx, &qns)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse quota notifications")
+	}
+	return qns, nil
+}
+
+// QuotaNotificationRegister records that the user has been notified about
+// reaching the given threshold of the given resource during their current
+// billing period. It reports whether the record is new, i.e. whether the user
+// hasn't already been notified about this threshold in this period.
+func (db *DB) QuotaNotificationRegister(ctx context.Context, user User, resource string, threshold int) (bool, error) {
+	if user.ID.IsZero() {
+		return false, errors.New("invalid user")
+	}
+	qn := QuotaNotification{
+		UserID:      user.ID,
+		PeriodStart: quotaNotificationPeriodStart(user, resource),
+		Resource:    resource,
+		Threshold:   threshold,
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+	}
+	_, err := db.staticQuotaNotifications.InsertOne(ctx, qn)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.AddContext(err, "failed to record quota notification")
+	}
+	return true, nil
+}
+
+// QuotaNotificationUnregister removes the record of the user being notified
+// about reaching the given threshold of the given resource during their
+// current billing period. We use it when we fail to notify the user, so we
+// can try again later.
+func (db *DB) QuotaNotificationUnregister(ctx context.Context, user User, resource string, threshold int) error {
+	filter := bson.M{
+		"user_id":      user.ID,
+		"period_start": quotaNotificationPeriodStart(user, resource),
+		"resource":     resource,
+		"threshold":    threshold,
+	}
+	_, err := db.staticQuotaNotifications.DeleteOne(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete quota notification")
+	}
+	return nil
+}
+
+// quotaNotificationPeriodStart returns the PeriodStart of the quota
+// notifications about the given resource. Only the download bandwidth is
+// limited per billing period.
+func quotaNotificationPeriodStart(user User, resource string) time.Time {
+	if resource == QuotaResourceDownloadBandwidth {
+		return monthStart(user.SubscribedUntil)
+	}
+	return time.Time{}
+}
@@ -100,5 +100,84 @@ var (
 				Options: options.Index().SetName("user_id"),
 			},
 		},
+		collUserCounters: {
+			{
+				Keys:    bson.D{{"user_id", 1}, {"period_start", 1}},
+				Options: options.Index().SetName("user_id_period_start_unique").SetUnique(true),
+			},
+		},
+		collTierChanges: {
+			{
+				Keys:    bson.M{"user_id": 1},
+				Options: options.Index().SetName("user_id"),
+			},
+			{
+				Keys:    bson.M{"timestamp": 1},
+				Options: options.Index().SetName("timestamp"),
+			},
+		},
+		collQuotaNotifications: {
+			{
+				Keys:    bson.D{{"user_id", 1}, {"period_start", 1}, {"resource", 1}, {"threshold", 1}},
+				Options: options.Index().SetName("user_id_period_start_resource_threshold_unique").SetUnique(true),
+			},
+		},
+		collEmailSuppressions: {
+			{
+				Keys:    bson.M{"email": 1},
+				Options: options.Index().SetName("email_unique").SetUnique(true),
+			},
+		},
+		collSettingChanges: {
+			{
+				Keys:    bson.M{"key": 1},
+				Options: options.Index().SetName("key"),
+			},
+		},
+		collUserTokens: {
+			{
+				Keys:    bson.M{"token_hash": 1},
+				Options: options.Index().SetName("token_hash_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{"user_id", 1}, {"purpose", 1}},
+				Options: options.Index().SetName("user_id_purpose"),
+			},
+			// MongoDB removes the tokens once they expire.
+			{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+			},
+		},
+		collInvites: {
+			{
+				Keys:    bson.M{"code": 1},
+				Options: options.Index().SetName("code_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.M{"created_by": 1},
+				Options: options.Index().SetName("created_by"),
+			},
+		},
+		collPasskeys: {
+			{
+				Keys:    bson.M{"credential_id": 1},
+				Options: options.Index().SetName("credential_id_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.M{"user_id": 1},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
+		collPubKeys: {
+			{
+				Keys:    bson.M{"key": 1},
+				Options: options.Index().SetName("key_unique").SetUnique(true),
+			},
+			{
+				Keys:    bson.M{"user_id": 1},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
 	}
 )
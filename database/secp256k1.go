@@ -0,0 +1,65 @@
+package database
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+/**
+Ethereum wallets don't sign arbitrary data. Instead, they sign EIP-191 messages
+(`personal_sign`), i.e. they prefix the data with "\x19Ethereum Signed
+Message:\n" and its length and sign its Keccak-256 hash. The resulting
+signature is in the [R || S || V] format, where V is the recovery id of the
+public key, optionally offset by 27.
+*/
+
+// eip191Prefix is the prefix of the messages Ethereum wallets sign.
+const eip191Prefix = "\x19Ethereum Signed Message:\n"
+
+// EIP191Hash returns the hash an Ethereum wallet signs when signing the given
+// message.
+func EIP191Hash(message []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	_, _ = h.Write([]byte(eip191Prefix + strconv.Itoa(len(message))))
+	_, _ = h.Write(message)
+	return h.Sum(nil)
+}
+
+// validSecp256k1PubKey checks whether the given bytes are an uncompressed
+// secp256k1 public key.
+func validSecp256k1PubKey(b []byte) bool {
+	if len(b) != PubKeySizeSecp256k1 || b[0] != 0x04 {
+		return false
+	}
+	_, err := secp256k1.ParsePubKey(b)
+	return err == nil
+}
+
+// verifySecp256k1 verifies the given EIP-191 signature of the given message by
+// recovering the public key from it and comparing it to the given key.
+func verifySecp256k1(pk PubKey, message []byte, sig []byte) bool {
+	if len(sig) != ChallengeSignatureSizeSecp256k1 {
+		return false
+	}
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return false
+	}
+	// The decred library expects the recovery code in front of the signature
+	// and offset by 27, plus 4 for compressed keys.
+	compact := make([]byte, 0, ChallengeSignatureSizeSecp256k1)
+	compact = append(compact, 27+v)
+	compact = append(compact, sig[:64]...)
+	recovered, _, err := ecdsa.RecoverCompact(compact, EIP191Hash(message))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(recovered.SerializeUncompressed(), pk)
+}
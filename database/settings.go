@@ -0,0 +1,441 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The types of runtime settings.
+const (
+	// SettingTypeBool is a setting which is either "true" or "false".
+	SettingTypeBool = "bool"
+	// SettingTypeInt is a setting which holds an integer.
+	SettingTypeInt = "int"
+	// SettingTypeString is a setting which holds an arbitrary string.
+	SettingTypeString = "string"
+	// SettingTypeStringList is a setting which holds a list of strings. The
+	// list is stored as a comma-separated string.
+	SettingTypeStringList = "string_list"
+)
+
+var (
+	// SettingsCacheTTL defines how long we use the cached settings before
+	// reloading them from the database. Since all servers reload them, a
+	// change made on one server reaches the others within this time.
+	SettingsCacheTTL = 30 * time.Second
+
+	// ErrUnknownSetting is returned when the given setting is not registered.
+	ErrUnknownSetting = errors.New("unknown setting")
+	// ErrInvalidSettingValue is returned when the given value doesn't match the
+	// type of the setting or fails its validation.
+	ErrInvalidSettingValue = errors.New("invalid setting value")
+
+	// settingDefs holds all registered runtime settings, keyed by their key
+	// in the configuration collection.
+	settingDefs = map[string]SettingDef{
+		ConfValRegistrationsDisabled: {
+			Key:         ConfValRegistrationsDisabled,
+			Type:        SettingTypeBool,
+			Default:     ConfValFalse,
+			Description: "Disables new registrations, password changes and account recovery.",
+		},
+		ConfValEmailConfirmationRequired: {
+			Key:         ConfValEmailConfirmationRequired,
+			Type:        SettingTypeStringList,
+			Default:     "",
+			Description: "The actions which users can only perform after confirming their email address.",
+			Validate:    validateActions,
+		},
+		ConfValInvitesRequired: {
+			Key:         ConfValInvitesRequired,
+			Type:        SettingTypeBool,
+			Default:     ConfValFalse,
+			Description: "Requires new users to register with an invite code.",
+		},
+		ConfValEmailDomainsAllowed: {
+			Key:         ConfValEmailDomainsAllowed,
+			Type:        SettingTypeStringList,
+			Default:     "",
+			Description: "The only email domains users can register with. An empty list allows all domains.",
+			Validate:    validateDomains,
+		},
+		ConfValEmailDomainsDenied: {
+			Key:         ConfValEmailDomainsDenied,
+			Type:        SettingTypeStringList,
+			Default:     "",
+			Description: "The email domains users can't register with.",
+			Validate:    validateDomains,
+		},
+		ConfValEmailDisposableBlocked: {
+			Key:         ConfValEmailDisposableBlocked,
+			Type:        SettingTypeBool,
+			Default:     ConfValFalse,
+			Description: "Prevents users from registering with disposable email addresses.",
+		},
+		ConfValEmailDisposableDomains: {
+			Key:         ConfValEmailDisposableDomains,
+			Type:        SettingTypeStringList,
+			Default:     "",
+			Description: "Disposable email domains in addition to the bundled list.",
+			Validate:    validateDomains,
+		},
+		ConfValLegacyChallengesDisabled: {
+			Key:         ConfValLegacyChallengesDisabled,
+			Type:        SettingTypeBool,
+			Default:     ConfValFalse,
+			Description: "Rejects version 1 challenge responses, which aren't bound to an origin. Old MySky clients only send those.",
+		},
+	}
+)
+
+type (
+	// SettingDef describes a runtime setting which admins can change without
+	// restarting the service.
+	SettingDef struct {
+		Key         string
+		Type        string
+		Default     string
+		Description string
+		// Validate performs additional checks on the normalized value. It's
+		// optional.
+		Validate func(string) error
+	}
+	// Setting is the current state of a runtime setting.
+	Setting struct {
+		Key         string      `json:"key"`
+		Type        string      `json:"type"`
+		Value       interface{} `json:"value"`
+		Default     interface{} `json:"default"`
+		Description string      `json:"description"`
+	}
+	// SettingChange is an audit record of a change of a runtime setting.
+	SettingChange struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		Key       string             `bson:"key" json:"key"`
+		OldValue  string             `bson:"old_value" json:"oldValue"`
+		NewValue  string             `bson:"new_value" json:"newValue"`
+		ChangedBy string             `bson:"changed_by" json:"changedBy"`
+		ChangedAt time.Time          `bson:"changed_at" json:"changedAt"`
+	}
+
+	// settingsCache holds the values of all runtime settings which are set in
+	// the database.
+	settingsCache struct {
+		values    map[string]string
+		expiresAt time.Time
+		mu        sync.Mutex
+	}
+)
+
+// Settings returns the current state of all registered runtime settings,
+// sorted by key.
+func (db *DB) Settings(ctx context.Context) ([]Setting, error) {
+	values, err := db.managedSettingValues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]Setting, 0, len(settingDefs))
+	for key, def := range settingDefs {
+		val, ok := values[key]
+		if !ok {
+			val = def.Default
+		}
+		settings = append(settings, def.setting(val))
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+	return settings, nil
+}
+
+// SettingBool returns the value of the given bool setting.
+func (db *DB) SettingBool(ctx context.Context, key string) (bool, error) {
+	val, err := db.managedSetting(ctx, key, SettingTypeBool)
+	if err != nil {
+		return false, err
+	}
+	return val == ConfValTrue, nil
+}
+
+// SettingInt returns the value of the given int setting.
+func (db *DB) SettingInt(ctx context.Context, key string) (int64, error) {
+	val, err := db.managedSetting(ctx, key, SettingTypeInt)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// SettingString returns the value of the given string setting.
+func (db *DB) SettingString(ctx context.Context, key string) (string, error) {
+	return db.managedSetting(ctx, key, SettingTypeString)
+}
+
+// SettingStringList returns the value of the given string list setting.
+func (db *DB) SettingStringList(ctx context.Context, key string) ([]string, error) {
+	val, err := db.managedSetting(ctx, key, SettingTypeStringList)
+	if err != nil {
+		return nil, err
+	}
+	return splitStringList(val), nil
+}
+
+// SettingSet changes the value of the given runtime setting and records who
+// changed it. The value can be given either in its JSON type, e.g. a bool for
+// bool settings, or as a string.
+func (db *DB) SettingSet(ctx context.Context, key string, value interface{}, changedBy string) (Setting, error) {
+	def, ok := settingDefs[key]
+	if !ok {
+		return Setting{}, ErrUnknownSetting
+	}
+	val, err := def.normalize(value)
+	if err != nil {
+		return Setting{}, err
+	}
+	values, err := db.managedSettingValues(ctx)
+	if err != nil {
+		return Setting{}, err
+	}
+	oldVal, ok := values[key]
+	if !ok {
+		oldVal = def.Default
+	}
+	if val == oldVal {
+		return def.setting(val), nil
+	}
+	err = db.WriteConfigValue(ctx, key, val)
+	if err != nil {
+		return Setting{}, errors.AddContext(err, "failed to save setting")
+	}
+	db.staticSettings.set(key, val)
+	change := SettingChange{
+		Key:       key,
+		OldValue:  oldVal,
+		NewValue:  val,
+		ChangedBy: changedBy,
+		ChangedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	_, err = db.staticSettingChanges.InsertOne(ctx, change)
+	if err != nil {
+		db.staticLogger.Warningln(errors.AddContext(err, fmt.Sprintf("failed to record the change of setting '%s'", key)))
+	}
+	return def.setting(val), nil
+}
+
+// SettingChanges returns the recorded changes of runtime settings, newest
+// first. If key is not empty, it only returns the changes of that setting.
+func (db *DB) SettingChanges(ctx context.Context, key string, offset, pageSize int) ([]SettingChange, int, error) {
+	filter := bson.M{}
+	if key != "" {
+		filter["key"] = key
+	}
+	count, err := db.staticSettingChanges.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to count setting changes")
+	}
+	opts := options.Find()
+	opts.SetSort(bson.D{{"_id", -1}})
+	opts.SetSkip(int64(offset))
+	opts.SetLimit(int64(pageSize))
+	c, err := db.staticSettingChanges.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to find setting changes")
+	}
+	changes := make([]SettingChange, 0)
+	err = c.All(ctx, &changes)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "failed to parse setting changes")
+	}
+	return changes, int(count), nil
+}
+
+// managedSetting returns the value of the given setting as it's stored in the
+// database or its default value, if it's not set.
+func (db *DB) managedSetting(ctx context.Context, key, settingType string) (string, error) {
+	def, ok := settingDefs[key]
+	if !ok {
+		return "", ErrUnknownSetting
+	}
+	if def.Type != settingType {
+		return "", fmt.Errorf("setting '%s' is of type %s, not %s", key, def.Type, settingType)
+	}
+	values, err := db.managedSettingValues(ctx)
+	if err != nil {
+		return "", err
+	}
+	val, ok := values[key]
+	if !ok {
+		return def.Default, nil
+	}
+	return val, nil
+}
+
+// managedSettingValues returns the values of all settings which are set in the
+// database. It reloads them if the cache has expired. If the reload fails, we
+// keep using the values we have and try again on the next call.
+func (db *DB) managedSettingValues(ctx context.Context) (map[string]string, error) {
+	sc := db.staticSettings
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.values != nil && time.Now().Before(sc.expiresAt) {
+		return sc.values, nil
+	}
+	c, err := db.staticConfiguration.Find(ctx, bson.M{})
+	if err != nil {
+		if sc.values != nil {
+			db.staticLogger.Warningln(errors.AddContext(err, "failed to reload settings"))
+			return sc.values, nil
+		}
+		return nil, errors.AddContext(err, "failed to load settings")
+	}
+	var confVals []ConfVal
+	err = c.All(ctx, &confVals)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse settings")
+	}
+	values := make(map[string]string)
+	for _, cv := range confVals {
+		if _, ok := settingDefs[cv.Key]; ok {
+			values[cv.Key] = cv.Value
+		}
+	}
+	sc.values = values
+	sc.expiresAt = time.Now().Add(SettingsCacheTTL)
+	return sc.values, nil
+}
+
+// set updates the cached value of the given setting.
+func (sc *settingsCache) set(key, val string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.values == nil {
+		// We'll load all values on the next read.
+		return
+	}
+	// Copy the map, so we don't modify maps we've already returned.
+	values := make(map[string]string, len(sc.values)+1)
+	for k, v := range sc.values {
+		values[k] = v
+	}
+	values[key] = val
+	sc.values = values
+}
+
+// normalize converts the given value to the string we store in the database
+// and validates it.
+func (def SettingDef) normalize(value interface{}) (string, error) {
+	var val string
+	switch v := value.(type) {
+	case string:
+		val = strings.TrimSpace(v)
+	case bool:
+		val = strconv.FormatBool(v)
+	case float64:
+		val = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		val = strconv.Itoa(v)
+	case int64:
+		val = strconv.FormatInt(v, 10)
+	case []string:
+		if def.Type != SettingTypeStringList {
+			return "", errors.AddContext(ErrInvalidSettingValue, "unexpected list")
+		}
+		val = strings.Join(v, ",")
+	case []interface{}:
+		if def.Type != SettingTypeStringList {
+			return "", errors.AddContext(ErrInvalidSettingValue, "unexpected list")
+		}
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.AddContext(ErrInvalidSettingValue, "list items must be strings")
+			}
+			items = append(items, s)
+		}
+		val = strings.Join(items, ",")
+	default:
+		return "", errors.AddContext(ErrInvalidSettingValue, fmt.Sprintf("unsupported value type %T", value))
+	}
+	switch def.Type {
+	case SettingTypeBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return "", errors.AddContext(ErrInvalidSettingValue, "expected a bool")
+		}
+		val = strconv.FormatBool(b)
+	case SettingTypeInt:
+		i, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return "", errors.AddContext(ErrInvalidSettingValue, "expected an integer")
+		}
+		val = strconv.FormatInt(i, 10)
+	case SettingTypeStringList:
+		val = strings.Join(splitStringList(val), ",")
+	}
+	if def.Validate != nil {
+		if err := def.Validate(val); err != nil {
+			return "", errors.Compose(err, ErrInvalidSettingValue)
+		}
+	}
+	return val, nil
+}
+
+// setting returns the setting with the given value.
+func (def SettingDef) setting(val string) Setting {
+	return Setting{
+		Key:         def.Key,
+		Type:        def.Type,
+		Value:       def.typedValue(val),
+		Default:     def.typedValue(def.Default),
+		Description: def.Description,
+	}
+}
+
+// typedValue converts the stored value of the setting to its JSON type.
+func (def SettingDef) typedValue(val string) interface{} {
+	switch def.Type {
+	case SettingTypeBool:
+		return val == ConfValTrue
+	case SettingTypeInt:
+		i, _ := strconv.ParseInt(val, 10, 64)
+		return i
+	case SettingTypeStringList:
+		return splitStringList(val)
+	default:
+		return val
+	}
+}
+
+// splitStringList splits a comma-separated list, trimming the items and
+// dropping the empty ones.
+func splitStringList(val string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateActions ensures the given comma-separated list only contains
+// actions which can require a confirmed email address.
+func validateActions(val string) error {
+	for _, a := range splitStringList(val) {
+		if a != ActionAPIKeys && a != ActionCheckout && a != ActionLimits {
+			return fmt.Errorf("unknown action '%s'", a)
+		}
+	}
+	return nil
+}
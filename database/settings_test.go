@@ -0,0 +1,67 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSettingDefNormalize ensures that we convert setting values to their
+// stored form and reject values which don't match the setting's type.
+func TestSettingDefNormalize(t *testing.T) {
+	boolDef := SettingDef{Type: SettingTypeBool}
+	intDef := SettingDef{Type: SettingTypeInt}
+	listDef := SettingDef{Type: SettingTypeStringList, Validate: validateActions}
+	tests := []struct {
+		def      SettingDef
+		value    interface{}
+		expected string
+		valid    bool
+	}{
+		{def: boolDef, value: true, expected: "true", valid: true},
+		{def: boolDef, value: "1", expected: "true", valid: true},
+		{def: boolDef, value: " false ", expected: "false", valid: true},
+		{def: boolDef, value: "maybe", valid: false},
+		{def: boolDef, value: 1.5, valid: false},
+		{def: intDef, value: float64(42), expected: "42", valid: true},
+		{def: intDef, value: "-7", expected: "-7", valid: true},
+		{def: intDef, value: 1.5, valid: false},
+		{def: intDef, value: []interface{}{"1"}, valid: false},
+		{def: listDef, value: []interface{}{"api_keys", " limits "}, expected: "api_keys,limits", valid: true},
+		{def: listDef, value: "checkout,,", expected: "checkout", valid: true},
+		{def: listDef, value: "", expected: "", valid: true},
+		{def: listDef, value: []interface{}{"api_keys", 1}, valid: false},
+		{def: listDef, value: "api_keys,unknown", valid: false},
+		{def: listDef, value: map[string]interface{}{}, valid: false},
+	}
+	for _, tt := range tests {
+		val, err := tt.def.normalize(tt.value)
+		if tt.valid && (err != nil || val != tt.expected) {
+			t.Errorf("Expected '%s' for %v, got '%s' and '%v'", tt.expected, tt.value, val, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected an error for %v, got '%s'", tt.value, val)
+		}
+	}
+}
+
+// TestSettingDefTypedValue ensures that we present setting values in their
+// JSON types.
+func TestSettingDefTypedValue(t *testing.T) {
+	if v := (SettingDef{Type: SettingTypeBool}).typedValue("true"); v != true {
+		t.Fatalf("Expected true, got %v", v)
+	}
+	if v := (SettingDef{Type: SettingTypeInt}).typedValue("42"); v != int64(42) {
+		t.Fatalf("Expected 42, got %v", v)
+	}
+	if v := (SettingDef{Type: SettingTypeString}).typedValue("value"); v != "value" {
+		t.Fatalf("Expected 'value', got %v", v)
+	}
+	v := (SettingDef{Type: SettingTypeStringList}).typedValue("a, b")
+	if !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatalf("Expected [a b], got %v", v)
+	}
+	v = (SettingDef{Type: SettingTypeStringList}).typedValue("")
+	if !reflect.DeepEqual(v, []string{}) {
+		t.Fatalf("Expected an empty list, got %v", v)
+	}
+}
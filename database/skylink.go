@@ -8,6 +8,7 @@ import (
 	"gitlab.com/SkynetLabs/skyd/skymodules"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -81,10 +82,24 @@ func (db *DB) SkylinkUpdate(ctx context.Context, id primitive.ObjectID, name str
 	if size > 0 {
 		updates["size"] = size
 	}
-	_, err := db.staticSkylinks.UpdateOne(ctx, filter, bson.M{"$set": updates})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	sr := db.staticSkylinks.FindOneAndUpdate(ctx, filter, bson.M{"$set": updates}, opts)
+	var old Skylink
+	err := sr.Decode(&old)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return nil
+	}
 	if err != nil {
 		return errors.AddContext(err, "failed to update")
 	}
+	// The usage counters of everyone who uploaded or downloaded this skylink
+	// depend on its size.
+	if size > 0 && size != old.Size {
+		err = db.userCountersMarkStaleBySkylink(ctx, id)
+		if err != nil {
+			return errors.AddContext(err, "failed to invalidate user counters")
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// TierChangeSourceStripe marks tier changes caused by Stripe subscription
+	// events.
+	TierChangeSourceStripe = "stripe"
+	// TierChangeSourcePromoter marks tier changes requested by the promoter.
+	TierChangeSourcePromoter = "promoter"
+	// TierChangeSourceMerge marks tier changes caused by merging two users'
+	// accounts.
+	TierChangeSourceMerge = "merge"
+	// TierChangeSourceInvite marks the tiers assigned to new users by the
+	// invite codes with which they registered.
+	TierChangeSourceInvite = "invite"
+)
+
+type (
+	// TierChange records a change of a user's tier. We use these records for
+	// tracking the conversions between tiers.
+	TierChange struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+		UserID    primitive.ObjectID `bson:"user_id" json:"userId"`
+		FromTier  int                `bson:"from_tier" json:"fromTier"`
+		ToTier    int                `bson:"to_tier" json:"toTier"`
+		Source    string             `bson:"source" json:"source"`
+		Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+	}
+)
+
+// TierChangeCreate records a change of the given user's tier.
+func (db *DB) TierChangeCreate(ctx context.Context, userID primitive.ObjectID, fromTier, toTier int, source string) error {
+	if userID.IsZero() {
+		return errors.New("invalid user")
+	}
+	if fromTier == toTier {
+		return nil
+	}
+	tc := TierChange{
+		UserID:    userID,
+		FromTier:  fromTier,
+		ToTier:    toTier,
+		Source:    source,
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	_, err := db.staticTierChanges.InsertOne(ctx, tc)
+	if err != nil {
+		return errors.AddContext(err, "failed to record tier change")
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"gitlab.com/NebulousLabs/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -54,6 +55,21 @@ func (db *DB) UploadCreate(ctx context.Context, user User, ip string, skylink Sk
 	if skylink.ID.IsZero() {
 		return nil, errors.New("skylink doesn't exist")
 	}
+	// Only the first pinned upload of a skylink counts towards the user's used
+	// storage.
+	var alreadyPinned bool
+	if !user.ID.IsZero() {
+		filter := bson.M{
+			"skylink_id": skylink.ID,
+			"user_id":    user.ID,
+			"unpinned":   false,
+		}
+		n, err := db.staticUploads.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to check for previous uploads")
+		}
+		alreadyPinned = n > 0
+	}
 	up := Upload{
 		UserID:     user.ID,
 		UploaderIP: ip,
@@ -65,6 +81,18 @@ func (db *DB) UploadCreate(ctx context.Context, user User, ip string, skylink Sk
 		return nil, err
 	}
 	up.ID = ior.InsertedID.(primitive.ObjectID)
+	inc := bson.M{
+		"num_uploads": 1,
+		"bw_uploads":  skynet.BandwidthUploadCost(skylink.Size),
+	}
+	if !alreadyPinned {
+		inc["uploads_size"] = skylink.Size
+		inc["raw_storage_used"] = skynet.RawStorageUsed(skylink.Size)
+	}
+	err = db.userCountersInc(ctx, user, inc, true)
+	if err != nil {
+		db.staticLogger.Warnf("Failed to update the counters of user %s: %s", user.ID.Hex(), err)
+	}
 	return &up, nil
 }
 
@@ -115,14 +143,85 @@ func (db *DB) UnpinUploads(ctx context.Context, skylink Skylink, user User) (int
 		"user_id":    user.ID,
 		"unpinned":   false,
 	}
+	// Fetch the uploads we're about to unpin, so we can update the user's
+	// counters accordingly.
+	opts := options.Find().SetSort(bson.M{"timestamp": 1})
+	c, err := db.staticUploads.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var pinned []Upload
+	err = c.All(ctx, &pinned)
+	if err != nil {
+		return 0, err
+	}
 	update := bson.M{"$set": bson.M{"unpinned": true}}
 	ur, err := db.staticUploads.UpdateMany(ctx, filter, update)
 	if err != nil {
 		return 0, err
 	}
+	if len(pinned) > 0 {
+		err = db.unpinUpdateCounters(ctx, user, skylink, pinned)
+		if err != nil {
+			db.staticLogger.Warnf("Failed to update the counters of user %s: %s", user.ID.Hex(), err)
+		}
+	}
 	return ur.ModifiedCount, nil
 }
 
+// unpinUpdateCounters removes the given pinned uploads from the user's
+// counters. The uploads are expected to be sorted by timestamp. Unpinning
+// doesn't affect the bandwidth used.
+func (db *DB) unpinUpdateCounters(ctx context.Context, user User, skylink Skylink, pinned []Upload) error {
+	// Make sure we use the latest skylink size.
+	sl, err := db.SkylinkByID(ctx, skylink.ID)
+	if err != nil {
+		return err
+	}
+	storageDec := bson.M{
+		"uploads_size":     -sl.Size,
+		"raw_storage_used": -skynet.RawStorageUsed(sl.Size),
+	}
+	// Update the totals.
+	totalDec := bson.M{"num_uploads": -int64(len(pinned))}
+	for k, v := range storageDec {
+		totalDec[k] = v
+	}
+	err = db.userCountersInc(ctx, user, totalDec, false)
+	if err != nil {
+		return err
+	}
+	// Update the current period. Only uploads made during this period count
+	// towards it and the storage only counts if the first one was made during
+	// this period.
+	periodStart := monthStart(user.SubscribedUntil)
+	var inPeriod int64
+	for _, up := range pinned {
+		if up.Timestamp.After(periodStart) {
+			inPeriod++
+		}
+	}
+	if inPeriod == 0 {
+		return nil
+	}
+	periodDec := bson.M{"num_uploads": -inPeriod, "version": 1}
+	if pinned[0].Timestamp.After(periodStart) {
+		for k, v := range storageDec {
+			periodDec[k] = v
+		}
+	}
+	filter := bson.M{"user_id": user.ID, "period_start": periodStart}
+	update := bson.M{
+		"$inc": periodDec,
+		"$set": bson.M{"updated_at": time.Now().UTC().Truncate(time.Millisecond)},
+	}
+	_, err = db.staticUserCounters.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update user counters")
+	}
+	return nil
+}
+
 // UpdateUpload modifies the given upload according to the given update.
 func (db *DB) UpdateUpload(ctx context.Context, id primitive.ObjectID, update bson.M) (int64, error) {
 	ur, err := db.staticUploads.UpdateByID(ctx, id, update)
@@ -51,57 +51,71 @@ var (
 	AnonUser = User{}
 	// UserLimits defines the speed limits for each tier.
 	// RegistryDelay delay is in ms.
+	// MonthlyDownloadBandwidth is the download bandwidth, in bytes, the user
+	// can use during a single billing period before being hit with a speed
+	// limit. It doesn't apply to anonymous users because we can't track them.
 	UserLimits = map[int]TierLimits{
 		TierAnonymous: {
-			TierName:          "anonymous",
-			UploadBandwidth:   5 * mbpsToBytesPerSecond,
-			DownloadBandwidth: 5 * mbpsToBytesPerSecond,
-			MaxUploadSize:     1 * skynet.GiB,
-			MaxNumberUploads:  0,
-			RegistryDelay:     250,
-			Storage:           0,
+			TierName:                 "anonymous",
+			UploadBandwidth:          5 * mbpsToBytesPerSecond,
+			DownloadBandwidth:        5 * mbpsToBytesPerSecond,
+			MaxUploadSize:            1 * skynet.GiB,
+			MaxNumberUploads:         0,
+			RegistryDelay:            250,
+			Storage:                  0,
+			MonthlyDownloadBandwidth: 0,
 		},
 		TierFree: {
-			TierName:          "free",
-			UploadBandwidth:   10 * mbpsToBytesPerSecond,
-			DownloadBandwidth: 40 * mbpsToBytesPerSecond,
-			MaxUploadSize:     100 * skynet.GiB,
-			MaxNumberUploads:  0.1 * filesAllowedPerTiB,
-			RegistryDelay:     125,
-			Storage:           100 * skynet.GiB,
+			TierName:                 "free",
+			UploadBandwidth:          10 * mbpsToBytesPerSecond,
+			DownloadBandwidth:        40 * mbpsToBytesPerSecond,
+			MaxUploadSize:            100 * skynet.GiB,
+			MaxNumberUploads:         0.1 * filesAllowedPerTiB,
+			RegistryDelay:            125,
+			Storage:                  100 * skynet.GiB,
+			MonthlyDownloadBandwidth: 200 * skynet.GiB,
 		},
 		TierPremium5: {
-			TierName:          "plus",
-			UploadBandwidth:   20 * mbpsToBytesPerSecond,
-			DownloadBandwidth: 80 * mbpsToBytesPerSecond,
-			MaxUploadSize:     1 * skynet.TiB,
-			MaxNumberUploads:  1 * filesAllowedPerTiB,
-			RegistryDelay:     0,
-			Storage:           1 * skynet.TiB,
+			TierName:                 "plus",
+			UploadBandwidth:          20 * mbpsToBytesPerSecond,
+			DownloadBandwidth:        80 * mbpsToBytesPerSecond,
+			MaxUploadSize:            1 * skynet.TiB,
+			MaxNumberUploads:         1 * filesAllowedPerTiB,
+			RegistryDelay:            0,
+			Storage:                  1 * skynet.TiB,
+			MonthlyDownloadBandwidth: 2 * skynet.TiB,
 		},
 		TierPremium20: {
-			TierName:          "pro",
-			UploadBandwidth:   40 * mbpsToBytesPerSecond,
-			DownloadBandwidth: 160 * mbpsToBytesPerSecond,
-			MaxUploadSize:     4 * skynet.TiB,
-			MaxNumberUploads:  4 * filesAllowedPerTiB,
-			RegistryDelay:     0,
-			Storage:           4 * skynet.TiB,
+			TierName:                 "pro",
+			UploadBandwidth:          40 * mbpsToBytesPerSecond,
+			DownloadBandwidth:        160 * mbpsToBytesPerSecond,
+			MaxUploadSize:            4 * skynet.TiB,
+			MaxNumberUploads:         4 * filesAllowedPerTiB,
+			RegistryDelay:            0,
+			Storage:                  4 * skynet.TiB,
+			MonthlyDownloadBandwidth: 8 * skynet.TiB,
 		},
 		TierPremium80: {
-			TierName:          "extreme",
-			UploadBandwidth:   80 * mbpsToBytesPerSecond,
-			DownloadBandwidth: 320 * mbpsToBytesPerSecond,
-			MaxUploadSize:     10 * skynet.TiB,
-			MaxNumberUploads:  20 * filesAllowedPerTiB,
-			RegistryDelay:     0,
-			Storage:           20 * skynet.TiB,
+			TierName:                 "extreme",
+			UploadBandwidth:          80 * mbpsToBytesPerSecond,
+			DownloadBandwidth:        320 * mbpsToBytesPerSecond,
+			MaxUploadSize:            10 * skynet.TiB,
+			MaxNumberUploads:         20 * filesAllowedPerTiB,
+			RegistryDelay:            0,
+			Storage:                  20 * skynet.TiB,
+			MonthlyDownloadBandwidth: 40 * skynet.TiB,
 		},
 	}
 
 	// ErrInvalidToken is returned when the token is found to be invalid for any
 	// reason, including expiration.
 	ErrInvalidToken = errors.New("invalid token")
+	// ErrAccountLocked is returned when the user locked their account because
+	// of suspicious activity. The account stays locked until it's recovered.
+	ErrAccountLocked = errors.New("this account is locked, please recover it via the link we sent to your email address")
+	// ErrEmailInUse is returned when the user tries to switch to an email
+	// address which another user already has.
+	ErrEmailInUse = errors.New("this email is already in use")
 )
 
 type (
@@ -109,34 +123,51 @@ type (
 	User struct {
 		// ID is auto-generated by Mongo on insert. We will usually use it in
 		// its ID.Hex() form.
-		ID                               primitive.ObjectID `bson:"_id,omitempty" json:"-"`
-		Email                            types.Email        `bson:"email" json:"email"`
-		EmailConfirmationToken           string             `bson:"email_confirmation_token,omitempty" json:"-"`
-		EmailConfirmationTokenExpiration time.Time          `bson:"email_confirmation_token_expiration,omitempty" json:"-"`
-		PasswordHash                     string             `bson:"password_hash" json:"-"`
-		RecoveryToken                    string             `bson:"recovery_token,omitempty" json:"-"`
-		Sub                              string             `bson:"sub" json:"sub"`
-		Tier                             int                `bson:"tier" json:"tier"`
-		CreatedAt                        time.Time          `bson:"created_at" json:"createdAt"`
-		MigratedAt                       time.Time          `bson:"migrated_at" json:"migratedAt"`
-		SubscribedUntil                  time.Time          `bson:"subscribed_until" json:"subscribedUntil"`
-		SubscriptionStatus               string             `bson:"subscription_status" json:"subscriptionStatus"`
-		SubscriptionCancelAt             time.Time          `bson:"subscription_cancel_at" json:"subscriptionCancelAt"`
-		SubscriptionCancelAtPeriodEnd    bool               `bson:"subscription_cancel_at_period_end" json:"subscriptionCancelAtPeriodEnd"`
-		StripeID                         string             `bson:"stripe_id" json:"stripeCustomerId"`
-		QuotaExceeded                    bool               `bson:"quota_exceeded" json:"quotaExceeded"`
-		PubKeys                          []PubKey           `bson:"pub_keys" json:"-"`
+		ID                            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		Email                         types.Email        `bson:"email" json:"email"`
+		EmailUnconfirmed              bool               `bson:"email_unconfirmed,omitempty" json:"-"`
+		PasswordHash                  string             `bson:"password_hash" json:"-"`
+		Sub                           string             `bson:"sub" json:"sub"`
+		Tier                          int                `bson:"tier" json:"tier"`
+		CreatedAt                     time.Time          `bson:"created_at" json:"createdAt"`
+		MigratedAt                    time.Time          `bson:"migrated_at" json:"migratedAt"`
+		SubscribedUntil               time.Time          `bson:"subscribed_until" json:"subscribedUntil"`
+		SubscriptionStatus            string             `bson:"subscription_status" json:"subscriptionStatus"`
+		SubscriptionCancelAt          time.Time          `bson:"subscription_cancel_at" json:"subscriptionCancelAt"`
+		SubscriptionCancelAtPeriodEnd bool               `bson:"subscription_cancel_at_period_end" json:"subscriptionCancelAtPeriodEnd"`
+		StripeID                      string             `bson:"stripe_id" json:"stripeCustomerId"`
+		QuotaExceeded                 bool               `bson:"quota_exceeded" json:"quotaExceeded"`
+		Locale                        string             `bson:"locale,omitempty" json:"locale"`
+		EmailDeliveryIssue            string             `bson:"email_delivery_issue,omitempty" json:"emailDeliveryIssue,omitempty"`
+		LockedAt                      time.Time          `bson:"locked_at,omitempty" json:"-"`
+		// InviteCode is the invite code with which the user registered.
+		InviteCode string `bson:"invite_code,omitempty" json:"-"`
+		// InvitesCreated is the number of invite codes the user created
+		// since InvitesPeriodStart. We use them for enforcing the user's
+		// invite quota.
+		InvitesCreated     int       `bson:"invites_created,omitempty" json:"-"`
+		InvitesPeriodStart time.Time `bson:"invites_period_start,omitempty" json:"-"`
+		// LoginMethodsChangedAt is the last time the user removed a pubkey or
+		// a passkey. We write it within the removal's transaction, so
+		// concurrent removals conflict with each other.
+		LoginMethodsChangedAt time.Time `bson:"login_methods_changed_at,omitempty" json:"-"`
+		// PendingEmail is the address the user wants to switch to. We keep
+		// using their current address until they confirm the new one.
+		PendingEmail           types.Email `bson:"pending_email,omitempty" json:"pendingEmail,omitempty"`
+		PendingEmailExpiration time.Time   `bson:"pending_email_expiration,omitempty" json:"-"`
+		PubKeys                []PubKey    `bson:"pub_keys" json:"-"`
 	}
 	// TierLimits defines the speed limits imposed on the user based on their
 	// tier.
 	TierLimits struct {
-		TierName          string `json:"tierName"`
-		UploadBandwidth   int    `json:"upload"`        // bytes per second
-		DownloadBandwidth int    `json:"download"`      // bytes per second
-		MaxUploadSize     int64  `json:"maxUploadSize"` // the max size of a single upload in bytes
-		MaxNumberUploads  int    `json:"-"`
-		RegistryDelay     int    `json:"registry"` // ms delay
-		Storage           int64  `json:"-"`
+		TierName                 string `json:"tierName"`
+		UploadBandwidth          int    `json:"upload"`        // bytes per second
+		DownloadBandwidth        int    `json:"download"`      // bytes per second
+		MaxUploadSize            int64  `json:"maxUploadSize"` // the max size of a single upload in bytes
+		MaxNumberUploads         int    `json:"-"`
+		RegistryDelay            int    `json:"registry"` // ms delay
+		Storage                  int64  `json:"-"`
+		MonthlyDownloadBandwidth int64  `json:"-"` // bytes per billing period
 	}
 )
 
@@ -146,6 +177,11 @@ func (db *DB) UserByEmail(ctx context.Context, email types.Email) (*User, error)
 	if err != nil {
 		return nil, err
 	}
+	// This can only happen until the duplicates are merged and we manage to
+	// create the unique index on the email field.
+	if len(users) > 1 {
+		db.staticLogger.Warnf("Found %d users with email '%s'.", len(users), email)
+	}
 	return users[0], nil
 }
 
@@ -173,6 +209,7 @@ func (db *DB) UserByID(ctx context.Context, id primitive.ObjectID) (*User, error
 	if err != nil {
 		return nil, errors.AddContext(err, "failed to parse value from DB")
 	}
+	u.dropExpiredEmailChange()
 	return &u, nil
 }
 
@@ -184,18 +221,10 @@ func (db *DB) UserByPubKey(ctx context.Context, pk PubKey) (*User, error) {
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
+	u.dropExpiredEmailChange()
 	return &u, nil
 }
 
-// UserByRecoveryToken returns the user with the given recovery token.
-func (db *DB) UserByRecoveryToken(ctx context.Context, token string) (*User, error) {
-	users, err := db.managedUsersByField(ctx, "recovery_token", token)
-	if err != nil {
-		return nil, err
-	}
-	return users[0], nil
-}
-
 // UserByStripeID finds a user by their Stripe customer id.
 func (db *DB) UserByStripeID(ctx context.Context, id string) (*User, error) {
 	c, err := db.staticUsers.Find(ctx, bson.M{"stripe_id": id})
@@ -220,6 +249,7 @@ func (db *DB) UserByStripeID(ctx context.Context, id string) (*User, error) {
 	if err != nil {
 		return nil, errors.AddContext(err, "failed to parse value from DB")
 	}
+	u.dropExpiredEmailChange()
 	return &u, nil
 }
 
@@ -229,28 +259,25 @@ func (db *DB) UserBySub(ctx context.Context, sub string) (*User, error) {
 }
 
 // UserConfirmEmail confirms that the email to which the passed confirmation
-// token belongs actually belongs to its user.
+// token belongs actually belongs to its user. If the token confirms a pending
+// email change, the user switches to the new address.
 func (db *DB) UserConfirmEmail(ctx context.Context, token string) (*User, error) {
-	if token == "" {
-		return nil, errors.AddContext(ErrInvalidToken, "token cannot be empty")
-	}
-	users, err := db.managedUsersByField(ctx, "email_confirmation_token", token)
+	ut, err := db.UserTokenConsume(ctx, token, TokenPurposeEmailConfirmation, TokenPurposeEmailChange)
 	if err != nil {
-		return nil, errors.AddContext(err, "failed to read users from DB")
+		return nil, err
 	}
-	if len(users) == 0 {
-		return nil, errors.AddContext(ErrInvalidToken, "no user has this token")
+	u, err := db.UserByID(ctx, ut.UserID)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to fetch the token's user")
 	}
-	if len(users) > 1 {
-		build.Critical("multiple users found for the same confirmation token", token)
-		return nil, errors.AddContext(ErrInvalidToken, "please request a new token")
+	if ut.Purpose == TokenPurposeEmailChange {
+		return db.managedUserConfirmEmailChange(ctx, u, ut)
 	}
-	u := users[0]
-	// Check if the token has expired.
-	if u.EmailConfirmationTokenExpiration.Before(time.Now().UTC()) {
-		return nil, errors.AddContext(ErrInvalidToken, "token expired")
+	// The user might have changed their address since we sent the token.
+	if u.Email != ut.Email {
+		return nil, errors.AddContext(ErrInvalidToken, "the email address has changed since the token was issued")
 	}
-	u.EmailConfirmationToken = ""
+	u.EmailUnconfirmed = false
 	err = db.UserSave(ctx, u)
 	if err != nil {
 		return nil, errors.AddContext(err, "failed to update user")
@@ -258,6 +285,42 @@ func (db *DB) UserConfirmEmail(ctx context.Context, token string) (*User, error)
 	return u, nil
 }
 
+// UserLock locks the account to which the given email revert token belongs.
+// The user gets this token at their old email address when someone changes the
+// address of their account. Locking the account restores the old address,
+// unless another account uses it by now, and discards any pending email
+// change. It returns the locked user and the address to which the recovery
+// instructions should be sent.
+func (db *DB) UserLock(ctx context.Context, revertToken string) (*User, types.Email, error) {
+	ut, err := db.UserTokenConsume(ctx, revertToken, TokenPurposeEmailRevert)
+	if err != nil {
+		return nil, "", err
+	}
+	u, err := db.UserByID(ctx, ut.UserID)
+	if err != nil {
+		return nil, "", errors.AddContext(err, "failed to fetch the token's user")
+	}
+	oldEmail := ut.Email
+	eu, err := db.UserByEmail(ctx, oldEmail)
+	if err != nil && !errors.Contains(err, ErrUserNotFound) {
+		return nil, "", errors.AddContext(err, "failed to check the old email address")
+	}
+	if errors.Contains(err, ErrUserNotFound) || eu.ID == u.ID {
+		// The owner of the old address proved it by using the token we sent
+		// there, so there's no need to confirm it again.
+		u.Email = oldEmail
+		u.EmailUnconfirmed = false
+	}
+	u.PendingEmail = ""
+	u.PendingEmailExpiration = time.Time{}
+	u.LockedAt = time.Now().UTC().Truncate(time.Millisecond)
+	err = db.UserSave(ctx, u)
+	if err != nil {
+		return nil, "", errors.AddContext(err, "failed to lock user")
+	}
+	return u, oldEmail, nil
+}
+
 // UserCreate creates a new user in the DB.
 //
 // The `sub` field is optional.
@@ -302,36 +365,33 @@ func (db *DB) UserCreate(ctx context.Context, emailAddr types.Email, pass, sub s
 			return nil, errors.AddContext(ErrGeneralInternalFailure, "failed to hash password")
 		}
 	}
-	emailConfToken, err := lib.GenerateUUID()
-	if err != nil {
-		return nil, errors.AddContext(err, "failed to generate an email confirmation token")
-	}
 	u := &User{
-		ID:                               primitive.ObjectID{},
-		Email:                            emailAddr,
-		EmailConfirmationToken:           emailConfToken,
-		EmailConfirmationTokenExpiration: time.Now().UTC().Add(EmailConfirmationTokenTTL).Truncate(time.Millisecond),
-		PasswordHash:                     string(passHash),
-		RecoveryToken:                    "",
-		Sub:                              sub,
-		Tier:                             tier,
-		CreatedAt:                        time.Now().UTC().Truncate(time.Millisecond),
-		MigratedAt:                       time.Time{},
-		SubscribedUntil:                  time.Time{},
-		SubscriptionStatus:               "",
-		SubscriptionCancelAt:             time.Time{},
-		SubscriptionCancelAtPeriodEnd:    false,
-		StripeID:                         "",
-		QuotaExceeded:                    false,
-		PubKeys:                          make([]PubKey, 0),
-	}
-	// TODO This part can race and create multiple accounts with the same email, unless we add DB-level uniqueness restriction.
-	// Insert the user.
+		ID:                            primitive.ObjectID{},
+		Email:                         emailAddr,
+		EmailUnconfirmed:              true,
+		PasswordHash:                  string(passHash),
+		Sub:                           sub,
+		Tier:                          tier,
+		CreatedAt:                     time.Now().UTC().Truncate(time.Millisecond),
+		MigratedAt:                    time.Time{},
+		SubscribedUntil:               time.Time{},
+		SubscriptionStatus:            "",
+		SubscriptionCancelAt:          time.Time{},
+		SubscriptionCancelAtPeriodEnd: false,
+		StripeID:                      "",
+		QuotaExceeded:                 false,
+		PubKeys:                       make([]PubKey, 0),
+	}
+	// Insert the user. The unique indexes on the email and sub fields prevent
+	// concurrent requests from creating multiple accounts for them.
 	fields, err := bson.Marshal(u)
 	if err != nil {
 		return nil, err
 	}
 	ir, err := db.staticUsers.InsertOne(ctx, fields)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUserAlreadyExists
+	}
 	if err != nil {
 		return nil, errors.AddContext(err, "failed to Insert")
 	}
@@ -339,26 +399,50 @@ func (db *DB) UserCreate(ctx context.Context, emailAddr types.Email, pass, sub s
 	return u, nil
 }
 
-// UserCreateEmailConfirmation creates a new email confirmation record for this
-// user.
-func (db *DB) UserCreateEmailConfirmation(ctx context.Context, uID primitive.ObjectID) (string, error) {
-	exp := time.Now().UTC().Add(EmailConfirmationTokenTTL).Truncate(time.Millisecond)
-	tk, err := lib.GenerateUUID()
+// UserCreateEmailConfirmation marks the user's email address as unconfirmed
+// and returns a new token which confirms it. The token is sent to the given
+// address.
+func (db *DB) UserCreateEmailConfirmation(ctx context.Context, uID primitive.ObjectID, email types.Email) (string, error) {
+	filter := bson.M{"_id": uID}
+	update := bson.M{"$set": bson.M{"email_unconfirmed": true}}
+	_, err := db.staticUsers.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return "", err
 	}
+	return db.UserTokenCreate(ctx, uID, TokenPurposeEmailConfirmation, email, EmailConfirmationTokenTTL)
+}
+
+// UserCreateEmailChange records that the user wants to switch to the given
+// email address and returns the token which confirms the change. The user
+// keeps their current address until they confirm the new one. A new change
+// replaces any pending one.
+func (db *DB) UserCreateEmailChange(ctx context.Context, uID primitive.ObjectID, newEmail types.Email) (string, error) {
+	exp := time.Now().UTC().Add(EmailConfirmationTokenTTL).Truncate(time.Millisecond)
 	filter := bson.M{"_id": uID}
 	update := bson.M{
 		"$set": bson.M{
-			"email_confirmation_token":            tk,
-			"email_confirmation_token_expiration": exp,
+			"pending_email":            newEmail.String(),
+			"pending_email_expiration": exp,
 		},
 	}
-	_, err = db.staticUsers.UpdateOne(ctx, filter, update)
+	_, err := db.staticUsers.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return "", err
 	}
-	return tk, nil
+	return db.UserTokenCreate(ctx, uID, TokenPurposeEmailChange, newEmail, EmailConfirmationTokenTTL)
+}
+
+// UserCancelEmailChange discards the pending email change of the given user.
+func (db *DB) UserCancelEmailChange(ctx context.Context, uID primitive.ObjectID) error {
+	filter := bson.M{"_id": uID}
+	update := bson.M{
+		"$unset": bson.M{
+			"pending_email":            "",
+			"pending_email_expiration": "",
+		},
+	}
+	_, err := db.staticUsers.UpdateOne(ctx, filter, update)
+	return err
 }
 
 // UserCreatePK creates a new user with a pubkey in the DB.
@@ -404,28 +488,22 @@ func (db *DB) UserCreatePK(ctx context.Context, emailAddr types.Email, pass, sub
 			return nil, errors.AddContext(ErrGeneralInternalFailure, "failed to hash password")
 		}
 	}
-	emailConfToken, err := lib.GenerateUUID()
-	if err != nil {
-		return nil, errors.AddContext(err, "failed to generate an email confirmation token")
-	}
 	u := &User{
-		ID:                               primitive.ObjectID{},
-		Email:                            emailAddr,
-		EmailConfirmationToken:           emailConfToken,
-		EmailConfirmationTokenExpiration: time.Now().UTC().Add(EmailConfirmationTokenTTL).Truncate(time.Millisecond),
-		PasswordHash:                     string(passHash),
-		RecoveryToken:                    "",
-		Sub:                              sub,
-		Tier:                             tier,
-		CreatedAt:                        time.Now().UTC().Truncate(time.Millisecond),
-		MigratedAt:                       time.Time{},
-		SubscribedUntil:                  time.Time{},
-		SubscriptionStatus:               "",
-		SubscriptionCancelAt:             time.Time{},
-		SubscriptionCancelAtPeriodEnd:    false,
-		StripeID:                         "",
-		QuotaExceeded:                    false,
-		PubKeys:                          []PubKey{pk},
+		ID:                            primitive.ObjectID{},
+		Email:                         emailAddr,
+		EmailUnconfirmed:              true,
+		PasswordHash:                  string(passHash),
+		Sub:                           sub,
+		Tier:                          tier,
+		CreatedAt:                     time.Now().UTC().Truncate(time.Millisecond),
+		MigratedAt:                    time.Time{},
+		SubscribedUntil:               time.Time{},
+		SubscriptionStatus:            "",
+		SubscriptionCancelAt:          time.Time{},
+		SubscriptionCancelAtPeriodEnd: false,
+		StripeID:                      "",
+		QuotaExceeded:                 false,
+		PubKeys:                       []PubKey{pk},
 	}
 	// Insert the user.
 	fields, err := bson.Marshal(u)
@@ -433,10 +511,17 @@ func (db *DB) UserCreatePK(ctx context.Context, emailAddr types.Email, pass, sub
 		return nil, err
 	}
 	ir, err := db.staticUsers.InsertOne(ctx, fields)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUserAlreadyExists
+	}
 	if err != nil {
 		return nil, errors.AddContext(err, "failed to Insert")
 	}
 	u.ID = ir.InsertedID.(primitive.ObjectID)
+	err = db.managedPubKeyRecordCreate(ctx, u.ID, pk)
+	if err != nil {
+		return nil, err
+	}
 	return u, nil
 }
 
@@ -471,6 +556,30 @@ func (db *DB) UserDelete(ctx context.Context, u *User) error {
 	if err != nil {
 		return errors.AddContext(err, "failed to delete user unconfirmed updates")
 	}
+	_, err = db.staticUserCounters.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user counters")
+	}
+	_, err = db.staticTierChanges.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user tier changes")
+	}
+	_, err = db.staticQuotaNotifications.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user quota notifications")
+	}
+	_, err = db.staticUserTokens.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user tokens")
+	}
+	_, err = db.staticPasskeys.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user passkeys")
+	}
+	_, err = db.staticPubKeys.DeleteMany(ctx, filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to delete user pubkeys")
+	}
 	// Delete the actual user.
 	filter = bson.M{"_id": u.ID}
 	dr, err := db.staticUsers.DeleteOne(ctx, filter)
@@ -491,6 +600,9 @@ func (db *DB) UserSave(ctx context.Context, u *User) error {
 	filter := bson.M{"_id": u.ID}
 	opts := options.Replace().SetUpsert(true)
 	_, err := db.staticUsers.ReplaceOne(ctx, filter, u, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailInUse
+	}
 	if err != nil {
 		return errors.AddContext(err, "failed to update")
 	}
@@ -518,7 +630,10 @@ func (db *DB) UserPubKeyAdd(ctx context.Context, u User, pk PubKey) (err error)
 		},
 	}
 	_, err = db.staticUsers.UpdateOne(ctx, filter, update)
-	return err
+	if err != nil {
+		return err
+	}
+	return db.managedPubKeyRecordCreate(ctx, u.ID, pk)
 }
 
 // UserPubKeyRemove removes a PubKey from the given user's set.
@@ -534,7 +649,14 @@ func (db *DB) UserPubKeyRemove(ctx context.Context, u User, pk PubKey) error {
 	if err == nil && ur.ModifiedCount == 0 {
 		err = mongo.ErrNoDocuments
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	_, err = db.staticPubKeys.DeleteOne(ctx, bson.M{"key": pk, "user_id": u.ID})
+	if err != nil {
+		return errors.AddContext(err, "failed to delete pubkey record")
+	}
+	return nil
 }
 
 // UserSetStripeID changes the user's stripe id in the DB.
@@ -549,6 +671,21 @@ func (db *DB) UserSetStripeID(ctx context.Context, u *User, stripeID string) err
 	return nil
 }
 
+// UserSetQuotaExceeded sets the user's QuotaExceeded flag to the given value.
+func (db *DB) UserSetQuotaExceeded(ctx context.Context, u *User, exceeded bool) error {
+	filter := bson.M{"_id": u.ID}
+	update := bson.M{"$set": bson.M{"quota_exceeded": exceeded}}
+	ur, err := db.staticUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update")
+	}
+	if ur.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	u.QuotaExceeded = exceeded
+	return nil
+}
+
 // UserSetTier sets the user's tier to the given value.
 func (db *DB) UserSetTier(ctx context.Context, u *User, t int) error {
 	if t <= TierAnonymous || t >= TierMaxReserved {
@@ -567,6 +704,35 @@ func (db *DB) UserSetTier(ctx context.Context, u *User, t int) error {
 	return nil
 }
 
+// managedUserConfirmEmailChange switches the user to the new email address
+// confirmed by the given email change token. Since the user proved they own
+// the new address by using the token, it's confirmed right away.
+func (db *DB) managedUserConfirmEmailChange(ctx context.Context, u *User, ut UserToken) (*User, error) {
+	// Expired changes are dropped when we load the user. The user might have
+	// also requested a change to another address since we sent the token.
+	if u.PendingEmail == "" || u.PendingEmail != ut.Email {
+		return nil, errors.AddContext(ErrInvalidToken, "this email change is no longer pending")
+	}
+	// Make sure nobody claimed the address since the change was requested.
+	eu, err := db.UserByEmail(ctx, u.PendingEmail)
+	if err != nil && !errors.Contains(err, ErrUserNotFound) {
+		return nil, errors.AddContext(err, "failed to check the new email address")
+	}
+	if err == nil && eu.ID != u.ID {
+		return nil, ErrEmailInUse
+	}
+	u.Email = u.PendingEmail
+	u.EmailUnconfirmed = false
+	u.EmailDeliveryIssue = ""
+	u.PendingEmail = ""
+	u.PendingEmailExpiration = time.Time{}
+	err = db.UserSave(ctx, u)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to update user")
+	}
+	return u, nil
+}
+
 // managedUsersByField finds all users that have a given field value.
 // The calling method is responsible for the validation of the value.
 func (db *DB) managedUsersByField(ctx context.Context, fieldName, fieldValue string) ([]*User, error) {
@@ -586,6 +752,7 @@ func (db *DB) managedUsersByField(ctx context.Context, fieldName, fieldValue str
 		if err = c.Decode(&u); err != nil {
 			return nil, errors.AddContext(err, "failed to parse value from DB")
 		}
+		u.dropExpiredEmailChange()
 		users = append(users, &u)
 	}
 	if len(users) == 0 {
@@ -609,9 +776,19 @@ func (db *DB) managedUserBySub(ctx context.Context, sub string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	u.dropExpiredEmailChange()
 	return &u, nil
 }
 
+// dropExpiredEmailChange discards the user's pending email change if it has
+// expired. The change is removed from the DB the next time we save the user.
+func (u *User) dropExpiredEmailChange() {
+	if u.PendingEmail != "" && u.PendingEmailExpiration.Before(time.Now().UTC()) {
+		u.PendingEmail = ""
+		u.PendingEmailExpiration = time.Time{}
+	}
+}
+
 // HasKey checks if the given pubkey is among the pubkeys registered for the
 // user.
 func (u User) HasKey(pk PubKey) bool {
@@ -61,3 +61,21 @@ func TestMonthStart(t *testing.T) {
 		}
 	}
 }
+
+// TestDropExpiredEmailChange ensures that we discard pending email changes once
+// they expire.
+func TestDropExpiredEmailChange(t *testing.T) {
+	u := User{
+		PendingEmail:           "new@siasky.net",
+		PendingEmailExpiration: time.Now().UTC().Add(time.Hour),
+	}
+	u.dropExpiredEmailChange()
+	if u.PendingEmail == "" {
+		t.Fatal("Expected the pending change to be kept.")
+	}
+	u.PendingEmailExpiration = time.Now().UTC().Add(-time.Hour)
+	u.dropExpiredEmailChange()
+	if u.PendingEmail != "" || !u.PendingEmailExpiration.IsZero() {
+		t.Fatalf("Expected the pending change to be dropped, got %+v", u)
+	}
+}
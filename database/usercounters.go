@@ -0,0 +1,322 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// userCountersRebuildAttempts is the number of times we try to store the
+	// rebuilt counters before giving up because they keep changing under us.
+	userCountersRebuildAttempts = 5
+)
+
+var (
+	// ErrUserCountersChanged is returned when the user's counters keep
+	// changing while we rebuild them.
+	ErrUserCountersChanged = errors.New("user counters changed during rebuild")
+)
+
+type (
+	// UserCounters holds pre-aggregated upload and download statistics for a
+	// user. Each user has one such document per billing period, plus one
+	// document holding their all-time totals. The totals document is the one
+	// with a zero PeriodStart.
+	//
+	// The counters are updated incrementally on every upload, download and
+	// unpin. When the data they are based on changes in a way we can't track
+	// incrementally (e.g. we learn the actual size of a skylink) they are
+	// marked as stale and get rebuilt from the raw data on the next read.
+	//
+	// Every write increments Version, which lets a rebuild detect that the
+	// counters changed while it was computing them.
+	UserCounters struct {
+		ID                 primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+		UserID             primitive.ObjectID `bson:"user_id" json:"userId"`
+		PeriodStart        time.Time          `bson:"period_start" json:"periodStart"`
+		NumUploads         int64              `bson:"num_uploads" json:"numUploads"`
+		UploadsSize        int64              `bson:"uploads_size" json:"uploadsSize"`
+		RawStorageUsed     int64              `bson:"raw_storage_used" json:"rawStorageUsed"`
+		BandwidthUploads   int64              `bson:"bw_uploads" json:"bwUploads"`
+		NumDownloads       int64              `bson:"num_downloads" json:"numDownloads"`
+		DownloadsSize      int64              `bson:"downloads_size" json:"downloadsSize"`
+		BandwidthDownloads int64              `bson:"bw_downloads" json:"bwDownloads"`
+		Stale              bool               `bson:"stale" json:"-"`
+		UpdatedAt          time.Time          `bson:"updated_at" json:"updatedAt"`
+		Version            int64              `bson:"version" json:"-"`
+	}
+)
+
+// UserCounters returns the user's counters for the current billing period and
+// their all-time totals. If the counters are missing or stale, they are rebuilt
+// from the raw uploads and downloads data.
+func (db *DB) UserCounters(ctx context.Context, user User) (period UserCounters, total UserCounters, err error) {
+	if user.ID.IsZero() {
+		return period, total, errors.New("invalid user")
+	}
+	periodStart := monthStart(user.SubscribedUntil)
+	period, errPeriod := db.userCountersFetch(ctx, user.ID, periodStart)
+	total, errTotal := db.userCountersFetch(ctx, user.ID, time.Time{})
+	if errPeriod == nil && errTotal == nil && !period.Stale && !total.Stale {
+		return period, total, nil
+	}
+	if errPeriod != nil && !errors.Contains(errPeriod, mongo.ErrNoDocuments) {
+		return period, total, errPeriod
+	}
+	if errTotal != nil && !errors.Contains(errTotal, mongo.ErrNoDocuments) {
+		return period, total, errTotal
+	}
+	period, total, _, err = db.userCountersRebuild(ctx, user)
+	return period, total, err
+}
+
+// UserCountersRebuild recomputes the user's counters for the current billing
+// period and their all-time totals from the raw data and stores them.
+// It reports whether the stored counters (if any) differed from the recomputed
+// ones.
+func (db *DB) UserCountersRebuild(ctx context.Context, user User) (mismatch bool, err error) {
+	_, _, mismatch, err = db.userCountersRebuild(ctx, user)
+	return mismatch, err
+}
+
+// UserCountersRebuildAll rebuilds the counters of all users. It reports the
+// number of users it checked and the number of users whose stored counters
+// didn't match the recomputed ones.
+func (db *DB) UserCountersRebuildAll(ctx context.Context) (checked, mismatched int, err error) {
+	c, err := db.staticUsers.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, 0, errors.AddContext(err, "failed to fetch users")
+	}
+	defer func() {
+		if errDef := c.Close(ctx); errDef != nil {
+			db.staticLogger.Traceln("Error on closing DB cursor.", errDef)
+		}
+	}()
+	var errs []error
+	for c.Next(ctx) {
+		var u User
+		if err = c.Decode(&u); err != nil {
+			return checked, mismatched, errors.AddContext(err, "failed to decode user")
+		}
+		mismatch, err := db.UserCountersRebuild(ctx, u)
+		if err != nil {
+			errs = append(errs, errors.AddContext(err, "failed to rebuild counters for user "+u.ID.Hex()))
+			continue
+		}
+		checked++
+		if mismatch {
+			db.staticLogger.Infof("User %s had inaccurate counters.", u.ID.Hex())
+			mismatched++
+		}
+	}
+	return checked, mismatched, errors.Compose(errs...)
+}
+
+// userCountersRebuild recomputes the user's counters from the raw data, stores
+// them and returns them. It also reports whether the stored counters differed
+// from the recomputed ones. Stale counters are not considered a mismatch.
+//
+// Uploads and downloads which happen while we compute the counters would be
+// lost if we overwrote the counters unconditionally. That's why we only
+// replace counters whose version hasn't changed since we started and retry
+// otherwise.
+func (db *DB) userCountersRebuild(ctx context.Context, user User) (period UserCounters, total UserCounters, mismatch bool, err error) {
+	for i := 0; i < userCountersRebuildAttempts; i++ {
+		period, total, mismatch, err = db.managedUserCountersRebuild(ctx, user)
+		if !errors.Contains(err, ErrUserCountersChanged) {
+			return
+		}
+	}
+	return period, total, mismatch, err
+}
+
+// managedUserCountersRebuild makes a single attempt at rebuilding the user's
+// counters. It returns ErrUserCountersChanged if any of the counters changed
+// since it read them.
+func (db *DB) managedUserCountersRebuild(ctx context.Context, user User) (period UserCounters, total UserCounters, mismatch bool, err error) {
+	periodStart := monthStart(user.SubscribedUntil)
+	// Read the stored counters before the raw data, so any change to the
+	// raw data after this point also changes their version.
+	var olds [2]UserCounters
+	var exists [2]bool
+	for i, ps := range []time.Time{periodStart, {}} {
+		old, errFetch := db.userCountersFetch(ctx, user.ID, ps)
+		if errFetch != nil && !errors.Contains(errFetch, mongo.ErrNoDocuments) {
+			return period, total, false, errors.AddContext(errFetch, "failed to fetch user counters")
+		}
+		olds[i], exists[i] = old, errFetch == nil
+	}
+	upStats, err := db.UserStatsUpload(ctx, user.ID, periodStart)
+	if err != nil {
+		return period, total, false, errors.AddContext(err, "failed to get user's upload stats")
+	}
+	downStats, err := db.userDownloadStats(ctx, user.ID, periodStart)
+	if err != nil {
+		return period, total, false, errors.AddContext(err, "failed to get user's download stats")
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	period = UserCounters{
+		UserID:             user.ID,
+		PeriodStart:        periodStart,
+		NumUploads:         upStats.Count,
+		UploadsSize:        upStats.Size,
+		RawStorageUsed:     upStats.RawStorageUsed,
+		BandwidthUploads:   upStats.Bandwidth,
+		NumDownloads:       downStats.Count,
+		DownloadsSize:      downStats.Size,
+		BandwidthDownloads: downStats.Bandwidth,
+		UpdatedAt:          now,
+	}
+	total = UserCounters{
+		UserID:             user.ID,
+		NumUploads:         upStats.CountTotal,
+		UploadsSize:        upStats.SizeTotal,
+		RawStorageUsed:     upStats.RawStorageUsedTotal,
+		BandwidthUploads:   upStats.BandwidthTotal,
+		NumDownloads:       downStats.CountTotal,
+		DownloadsSize:      downStats.SizeTotal,
+		BandwidthDownloads: downStats.BandwidthTotal,
+		UpdatedAt:          now,
+	}
+	for i, uc := range []*UserCounters{&period, &total} {
+		old := olds[i]
+		if exists[i] && !old.Stale && !old.equalValues(*uc) {
+			mismatch = true
+		}
+		if !exists[i] {
+			// Nothing updates missing counters, so we only need to make sure
+			// nobody else created them in the meantime.
+			_, err = db.staticUserCounters.InsertOne(ctx, uc)
+			if mongo.IsDuplicateKeyError(err) {
+				return period, total, mismatch, ErrUserCountersChanged
+			}
+			if err != nil {
+				return period, total, mismatch, errors.AddContext(err, "failed to store user counters")
+			}
+			continue
+		}
+		uc.Version = old.Version + 1
+		filter := bson.M{
+			"user_id":      user.ID,
+			"period_start": uc.PeriodStart,
+			"version":      old.Version,
+		}
+		ur, errReplace := db.staticUserCounters.ReplaceOne(ctx, filter, uc)
+		if errReplace != nil {
+			return period, total, mismatch, errors.AddContext(errReplace, "failed to store user counters")
+		}
+		if ur.MatchedCount == 0 {
+			return period, total, mismatch, ErrUserCountersChanged
+		}
+	}
+	return period, total, mismatch, nil
+}
+
+// userCountersFetch fetches the user's counters document for the period
+// starting at the given time.
+func (db *DB) userCountersFetch(ctx context.Context, userID primitive.ObjectID, periodStart time.Time) (UserCounters, error) {
+	var uc UserCounters
+	filter := bson.M{"user_id": userID, "period_start": periodStart}
+	sr := db.staticUserCounters.FindOne(ctx, filter)
+	if sr.Err() != nil {
+		return uc, sr.Err()
+	}
+	err := sr.Decode(&uc)
+	return uc, err
+}
+
+// userCountersInc increments the user's total counters by the given amounts.
+// If inPeriod is true, it also increments their counters for the current
+// billing period. The counters are only updated if they already exist. Missing
+// counters are going to be rebuilt from the raw data when they are first read.
+func (db *DB) userCountersInc(ctx context.Context, user User, inc bson.M, inPeriod bool) error {
+	if user.ID.IsZero() || len(inc) == 0 {
+		return nil
+	}
+	periodStarts := bson.A{time.Time{}}
+	if inPeriod {
+		periodStarts = append(periodStarts, monthStart(user.SubscribedUntil))
+	}
+	filter := bson.M{
+		"user_id":      user.ID,
+		"period_start": bson.M{"$in": periodStarts},
+	}
+	incVersion := bson.M{"version": 1}
+	for k, v := range inc {
+		incVersion[k] = v
+	}
+	update := bson.M{
+		"$inc": incVersion,
+		"$set": bson.M{"updated_at": time.Now().UTC().Truncate(time.Millisecond)},
+	}
+	_, err := db.staticUserCounters.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to update user counters")
+	}
+	return nil
+}
+
+// userCountersMarkStaleBySkylink marks as stale the counters of all users who
+// have uploaded or downloaded the given skylink.
+func (db *DB) userCountersMarkStaleBySkylink(ctx context.Context, skylinkID primitive.ObjectID) error {
+	filter := bson.M{"skylink_id": skylinkID}
+	uploaders, err := db.staticUploads.Distinct(ctx, "user_id", filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch uploaders")
+	}
+	downloaders, err := db.staticDownloads.Distinct(ctx, "user_id", filter)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch downloaders")
+	}
+	userIDs := append(uploaders, downloaders...)
+	if len(userIDs) == 0 {
+		return nil
+	}
+	update := bson.M{
+		"$set": bson.M{"stale": true},
+		"$inc": bson.M{"version": 1},
+	}
+	_, err = db.staticUserCounters.UpdateMany(ctx, bson.M{"user_id": bson.M{"$in": userIDs}}, update)
+	if err != nil {
+		return errors.AddContext(err, "failed to mark user counters as stale")
+	}
+	return nil
+}
+
+// equalValues reports whether the two counters hold the same values.
+func (uc UserCounters) equalValues(other UserCounters) bool {
+	return uc.NumUploads == other.NumUploads &&
+		uc.UploadsSize == other.UploadsSize &&
+		uc.RawStorageUsed == other.RawStorageUsed &&
+		uc.BandwidthUploads == other.BandwidthUploads &&
+		uc.NumDownloads == other.NumDownloads &&
+		uc.DownloadsSize == other.DownloadsSize &&
+		uc.BandwidthDownloads == other.BandwidthDownloads
+}
+
+// downloadEffectiveSize returns the size we account for a download with the
+// given number of bytes. Downloads with zero bytes are full downloads, so we
+// account the full size of the skylink.
+func downloadEffectiveSize(bytes, skylinkSize int64) int64 {
+	if bytes > 0 {
+		return bytes
+	}
+	return skylinkSize
+}
+
+// downloadCountersDelta returns the changes to the download counters caused by
+// a download record growing from oldBytes to newBytes.
+func downloadCountersDelta(oldBytes, newBytes, skylinkSize int64) bson.M {
+	oldSize := downloadEffectiveSize(oldBytes, skylinkSize)
+	newSize := downloadEffectiveSize(newBytes, skylinkSize)
+	return bson.M{
+		"downloads_size": newSize - oldSize,
+		"bw_downloads":   skynet.BandwidthDownloadCost(newSize) - skynet.BandwidthDownloadCost(oldSize),
+	}
+}
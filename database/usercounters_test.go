@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+)
+
+// TestDownloadCountersDelta ensures we correctly calculate the changes to the
+// download counters when a download record grows.
+func TestDownloadCountersDelta(t *testing.T) {
+	slSize := int64(10 * skynet.MiB)
+	tests := []struct {
+		oldBytes   int64
+		newBytes   int64
+		expectSize int64
+		expectBW   int64
+	}{
+		{
+			oldBytes:   100,
+			newBytes:   300,
+			expectSize: 200,
+			expectBW:   skynet.BandwidthDownloadCost(300) - skynet.BandwidthDownloadCost(100),
+		},
+		{
+			// A full download growing into a partial one.
+			oldBytes:   0,
+			newBytes:   300,
+			expectSize: 300 - slSize,
+			expectBW:   skynet.BandwidthDownloadCost(300) - skynet.BandwidthDownloadCost(slSize),
+		},
+		{
+			oldBytes:   0,
+			newBytes:   0,
+			expectSize: 0,
+			expectBW:   0,
+		},
+	}
+	for _, tt := range tests {
+		delta := downloadCountersDelta(tt.oldBytes, tt.newBytes, slSize)
+		if delta["downloads_size"] != tt.expectSize {
+			t.Errorf("Expected size delta %d, got %v.", tt.expectSize, delta["downloads_size"])
+		}
+		if delta["bw_downloads"] != tt.expectBW {
+			t.Errorf("Expected bandwidth delta %d, got %v.", tt.expectBW, delta["bw_downloads"])
+		}
+	}
+}
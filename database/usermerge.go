@@ -0,0 +1,163 @@
+package database
+
+import (
+	"bytes"
+	"context"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/**
+Users who registered more than once, e.g. once with an email and a password and
+once with a MySky pubkey, end up with their uploads and stats split between two
+accounts. Merging moves everything that belongs to the source account over to
+the target account and deletes the source account.
+*/
+
+var (
+	// ErrMergeSameUser is returned when we try to merge an account into
+	// itself.
+	ErrMergeSameUser = errors.New("cannot merge an account into itself")
+	// ErrMergeStripeConflict is returned when both accounts have a paid
+	// subscription with different Stripe customers. One of the subscriptions
+	// needs to be cancelled before the accounts can be merged.
+	ErrMergeStripeConflict = errors.New("both accounts have a paid subscription, please cancel one of them before merging the accounts")
+)
+
+// UserMerge moves the uploads, downloads, API keys, pubkeys and passkeys of
+// the source user to the target user, reconciles their tiers and Stripe
+// customers, and deletes the source user. The target user keeps their identity (sub) and
+// their email address, unless they don't have one.
+//
+// The merge consists of multiple operations, so the caller needs to run it in
+// a transaction in order to make it atomic.
+func (db *DB) UserMerge(ctx context.Context, target, source *User) (*User, error) {
+	if target.ID.IsZero() || source.ID.IsZero() {
+		return nil, errors.AddContext(ErrUserNotFound, "user struct not fully initialised")
+	}
+	if target.ID == source.ID {
+		return nil, ErrMergeSameUser
+	}
+	merged := *target
+	err := mergeSubscription(&merged, *source)
+	if err != nil {
+		return nil, err
+	}
+	if merged.Email == "" && source.Email != "" {
+		merged.Email = source.Email
+		merged.EmailUnconfirmed = source.EmailUnconfirmed
+		merged.EmailDeliveryIssue = source.EmailDeliveryIssue
+	}
+	if merged.PasswordHash == "" {
+		merged.PasswordHash = source.PasswordHash
+	}
+	merged.PubKeys = mergePubKeys(target.PubKeys, source.PubKeys)
+
+	// Move the source user's data.
+	filter := bson.M{"user_id": source.ID}
+	update := bson.M{"$set": bson.M{"user_id": target.ID}}
+	_, err = db.staticUploads.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move uploads")
+	}
+	_, err = db.staticDownloads.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move downloads")
+	}
+	_, err = db.staticAPIKeys.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move API keys")
+	}
+	_, err = db.staticPasskeys.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move passkeys")
+	}
+	_, err = db.staticPubKeys.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move pubkeys")
+	}
+	_, err = db.staticInvites.UpdateMany(ctx, bson.M{"created_by": source.ID}, bson.M{"$set": bson.M{"created_by": target.ID}})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to move invite codes")
+	}
+	// Delete the source user and whatever data we didn't move. We need to do
+	// that before we save the target user because the source user might hold
+	// the email address we're moving to the target user.
+	err = db.UserDelete(ctx, source)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to delete the source user")
+	}
+	err = db.UserSave(ctx, &merged)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to save the target user")
+	}
+	err = db.TierChangeCreate(ctx, merged.ID, target.Tier, merged.Tier, TierChangeSourceMerge)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.UserCountersRebuild(ctx, merged)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to rebuild the target user's counters")
+	}
+	// The source user no longer shares an email address with anyone.
+	_, err = db.staticEmailDuplicates.UpdateMany(ctx, bson.M{"user_ids": source.ID}, bson.M{"$pull": bson.M{"user_ids": source.ID}})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to update the email duplicates report")
+	}
+	_, err = db.staticEmailDuplicates.DeleteMany(ctx, bson.M{"user_ids.1": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to update the email duplicates report")
+	}
+	return &merged, nil
+}
+
+// mergePubKeys returns the union of the given sets of pubkeys.
+func mergePubKeys(pks, other []PubKey) []PubKey {
+	merged := append(make([]PubKey, 0, len(pks)+len(other)), pks...)
+	for _, pk := range other {
+		found := false
+		for _, m := range merged {
+			if bytes.Equal(m, pk) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, pk)
+		}
+	}
+	return merged
+}
+
+// mergeSubscription reconciles the tier and the Stripe customer of the target
+// user with those of the source user. The merged account gets the higher of
+// the two tiers. If only one of the users has a Stripe customer, the merged
+// account gets it, together with its subscription. If both users have
+// different Stripe customers, the merged account gets the one with a paid
+// tier. We refuse to merge two paid subscriptions because one of them would
+// keep charging the user.
+func mergeSubscription(target *User, source User) error {
+	if source.StripeID != "" && source.StripeID != target.StripeID {
+		takeSource := target.StripeID == ""
+		if !takeSource {
+			targetPaid := target.Tier > TierFree
+			sourcePaid := source.Tier > TierFree
+			if targetPaid && sourcePaid {
+				return ErrMergeStripeConflict
+			}
+			takeSource = sourcePaid
+		}
+		if takeSource {
+			target.StripeID = source.StripeID
+			target.SubscribedUntil = source.SubscribedUntil
+			target.SubscriptionStatus = source.SubscriptionStatus
+			target.SubscriptionCancelAt = source.SubscriptionCancelAt
+			target.SubscriptionCancelAtPeriodEnd = source.SubscriptionCancelAtPeriodEnd
+		}
+	}
+	if source.Tier > target.Tier {
+		target.Tier = source.Tier
+	}
+	return nil
+}
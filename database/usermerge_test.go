@@ -0,0 +1,96 @@
+package database
+
+import (
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestMergeSubscription ensures that merging two accounts reconciles their
+// tiers and Stripe customers correctly.
+func TestMergeSubscription(t *testing.T) {
+	tests := []struct {
+		name             string
+		target           User
+		source           User
+		expectedTier     int
+		expectedStripeID string
+		expectedErr      error
+	}{
+		{
+			name:             "no Stripe customers",
+			target:           User{Tier: TierFree},
+			source:           User{Tier: TierPremium5},
+			expectedTier:     TierPremium5,
+			expectedStripeID: "",
+		},
+		{
+			name:             "only source has a Stripe customer",
+			target:           User{Tier: TierFree},
+			source:           User{Tier: TierPremium20, StripeID: "cus_source"},
+			expectedTier:     TierPremium20,
+			expectedStripeID: "cus_source",
+		},
+		{
+			name:             "only target has a Stripe customer",
+			target:           User{Tier: TierPremium5, StripeID: "cus_target"},
+			source:           User{Tier: TierFree},
+			expectedTier:     TierPremium5,
+			expectedStripeID: "cus_target",
+		},
+		{
+			name:             "only source is paying",
+			target:           User{Tier: TierFree, StripeID: "cus_target"},
+			source:           User{Tier: TierPremium5, StripeID: "cus_source"},
+			expectedTier:     TierPremium5,
+			expectedStripeID: "cus_source",
+		},
+		{
+			name:             "only target is paying",
+			target:           User{Tier: TierPremium5, StripeID: "cus_target"},
+			source:           User{Tier: TierFree, StripeID: "cus_source"},
+			expectedTier:     TierPremium5,
+			expectedStripeID: "cus_target",
+		},
+		{
+			name:        "both are paying",
+			target:      User{Tier: TierPremium5, StripeID: "cus_target"},
+			source:      User{Tier: TierPremium20, StripeID: "cus_source"},
+			expectedErr: ErrMergeStripeConflict,
+		},
+	}
+	for _, tt := range tests {
+		u := tt.target
+		err := mergeSubscription(&u, tt.source)
+		if tt.expectedErr != nil {
+			if !errors.Contains(err, tt.expectedErr) {
+				t.Errorf("%s: expected error '%s', got '%v'", tt.name, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if u.Tier != tt.expectedTier || u.StripeID != tt.expectedStripeID {
+			t.Errorf("%s: expected tier %d and Stripe ID '%s', got %d and '%s'", tt.name, tt.expectedTier, tt.expectedStripeID, u.Tier, u.StripeID)
+		}
+	}
+}
+
+// TestMergePubKeys ensures that merging two sets of pubkeys results in their
+// union.
+func TestMergePubKeys(t *testing.T) {
+	pk1 := PubKey(fastrand.Bytes(PubKeySize))
+	pk2 := PubKey(fastrand.Bytes(PubKeySize))
+	pk3 := PubKey(fastrand.Bytes(PubKeySize))
+	merged := mergePubKeys([]PubKey{pk1, pk2}, []PubKey{pk2, pk3})
+	if len(merged) != 3 {
+		t.Fatalf("Expected 3 pubkeys, got %d", len(merged))
+	}
+	merged = mergePubKeys(nil, []PubKey{pk1})
+	if len(merged) != 1 {
+		t.Fatalf("Expected 1 pubkey, got %d", len(merged))
+	}
+}
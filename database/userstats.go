@@ -107,38 +107,28 @@ func (db *DB) userStats(ctx context.Context, user User) (*UserStats, error) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		upStats, err := db.UserStatsUpload(ctx, user.ID, startOfMonth)
+		period, total, err := db.UserCounters(ctx, user)
 		if err != nil {
-			regErr("Failed to get user's upload stats:", err)
+			regErr("Failed to get user's usage counters:", err)
 			return
 		}
-		stats.NumUploads = upStats.Count
-		stats.NumUploadsTotal = upStats.CountTotal
-		stats.UploadsSize = upStats.Size
-		stats.UploadsSizeTotal = upStats.SizeTotal
-		stats.TotalUploadsSize = upStats.SizeTotal
-		stats.BandwidthUploads = upStats.Bandwidth
-		stats.BandwidthUploadsTotal = upStats.BandwidthTotal
-		stats.RawStorageUsed = upStats.RawStorageUsed
-		stats.RawStorageUsedTotal = upStats.RawStorageUsedTotal
-		db.staticLogger.Tracef("User %s upload stats: %v", user.ID.Hex(), upStats)
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		downStats, err := db.userDownloadStats(ctx, user.ID, startOfMonth)
-		if err != nil {
-			regErr("Failed to get user's download stats:", err)
-			return
-		}
-		stats.NumDownloads = downStats.Count
-		stats.NumDownloadsTotal = downStats.CountTotal
-		stats.DownloadsSize = downStats.Size
-		stats.DownloadsSizeTotal = downStats.SizeTotal
-		stats.TotalDownloadsSize = downStats.SizeTotal
-		stats.BandwidthDownloads = downStats.Bandwidth
-		stats.BandwidthDownloadsTotal = downStats.BandwidthTotal
-		db.staticLogger.Tracef("User %s download stats: %v", user.ID.Hex(), downStats)
+		stats.NumUploads = period.NumUploads
+		stats.NumUploadsTotal = total.NumUploads
+		stats.UploadsSize = period.UploadsSize
+		stats.UploadsSizeTotal = total.UploadsSize
+		stats.TotalUploadsSize = total.UploadsSize
+		stats.BandwidthUploads = period.BandwidthUploads
+		stats.BandwidthUploadsTotal = total.BandwidthUploads
+		stats.RawStorageUsed = period.RawStorageUsed
+		stats.RawStorageUsedTotal = total.RawStorageUsed
+		stats.NumDownloads = period.NumDownloads
+		stats.NumDownloadsTotal = total.NumDownloads
+		stats.DownloadsSize = period.DownloadsSize
+		stats.DownloadsSizeTotal = total.DownloadsSize
+		stats.TotalDownloadsSize = total.DownloadsSize
+		stats.BandwidthDownloads = period.BandwidthDownloads
+		stats.BandwidthDownloadsTotal = total.BandwidthDownloads
+		db.staticLogger.Tracef("User %s usage counters: %v, %v", user.ID.Hex(), period, total)
 	}()
 	wg.Add(1)
 	go func() {
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// GranularityDay groups the historical stats by day.
+	GranularityDay = "day"
+	// GranularityMonth groups the historical stats by month.
+	GranularityMonth = "month"
+)
+
+var (
+	// ErrInvalidGranularity is returned when the requested granularity of
+	// the historical stats is not supported.
+	ErrInvalidGranularity = errors.New("invalid granularity, supported values are 'day' and 'month'")
+)
+
+type (
+	// UserStatsHistoryBucket holds the user's usage stats for a single time
+	// bucket, e.g. a day or a month. Just like with the current period stats,
+	// all uploads count towards the bandwidth but only pinned ones count
+	// towards the number of uploads, their size and the raw storage used.
+	UserStatsHistoryBucket struct {
+		Start              time.Time `json:"start"`
+		NumUploads         int64     `json:"numUploads"`
+		UploadsSize        int64     `json:"uploadsSize"`
+		RawStorageUsed     int64     `json:"rawStorageUsed"`
+		BandwidthUploads   int64     `json:"bwUploads"`
+		NumDownloads       int64     `json:"numDownloads"`
+		DownloadsSize      int64     `json:"downloadsSize"`
+		BandwidthDownloads int64     `json:"bwDownloads"`
+	}
+)
+
+// UserStatsHistory returns the user's usage stats between `from` and `to`,
+// grouped in buckets of the given granularity. All buckets in the period are
+// returned, including the empty ones, in chronological order.
+func (db *DB) UserStatsHistory(ctx context.Context, user User, from, to time.Time, granularity string) ([]UserStatsHistoryBucket, error) {
+	if user.ID.IsZero() {
+		return nil, errors.New("invalid user")
+	}
+	if granularity != GranularityDay && granularity != GranularityMonth {
+		return nil, ErrInvalidGranularity
+	}
+	if !from.Before(to) {
+		return nil, ErrInvalidTimePeriod
+	}
+	// Prepare all buckets in the period. We index them by the Unix timestamp
+	// of their start.
+	var buckets []UserStatsHistoryBucket
+	index := make(map[int64]int)
+	for t := truncateToGranularity(from, granularity); t.Before(to); t = nextBucketStart(t, granularity) {
+		index[t.Unix()] = len(buckets)
+		buckets = append(buckets, UserStatsHistoryBucket{Start: t})
+	}
+	err := db.userStatsHistoryUploads(ctx, user.ID, from, to, granularity, buckets, index)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get upload history")
+	}
+	err = db.userStatsHistoryDownloads(ctx, user.ID, from, to, granularity, buckets, index)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to get download history")
+	}
+	return buckets, nil
+}
+
+// userStatsHistoryUploads adds the user's uploads to the given buckets.
+//
+// A skylink's size only counts towards its first pinned upload, so we need to
+// go over all uploads before `to` and not just the ones in the period.
+func (db *DB) userStatsHistoryUploads(ctx context.Context, userID primitive.ObjectID, from, to time.Time, granularity string, buckets []UserStatsHistoryBucket, index map[int64]int) error {
+	matchStage := bson.D{{"$match", bson.D{
+		{"user_id", userID},
+		{"timestamp", bson.D{{"$lt", to}}},
+	}}}
+	sortStage := bson.D{{"$sort", bson.D{{"timestamp", 1}}}}
+	lookupStage := bson.D{
+		{"$lookup", bson.D{
+			{"from", "skylinks"},
+			{"localField", "skylink_id"},
+			{"foreignField", "_id"},
+			{"as", "skylink_data"},
+		}},
+	}
+	projectStage := bson.D{{"$project", bson.D{
+		{"_id", 0},
+		{"skylink_id", 1},
+		{"unpinned", 1},
+		{"timestamp", 1},
+		{"size", bson.D{{"$arrayElemAt", bson.A{"$skylink_data.size", 0}}}},
+		{"bucket", dateTruncStage("$timestamp", granularity)},
+	}}}
+	pipeline := mongo.Pipeline{matchStage, sortStage, lookupStage, projectStage}
+	c, err := db.staticUploads.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errDef := c.Close(ctx); errDef != nil {
+			db.staticLogger.Traceln("Error on closing DB cursor.", errDef)
+		}
+	}()
+
+	// We need this struct, so we can safely decode both int32 and int64.
+	result := struct {
+		SkylinkID primitive.ObjectID `bson:"skylink_id"`
+		Size      int64              `bson:"size"`
+		Unpinned  bool               `bson:"unpinned"`
+		Timestamp time.Time          `bson:"timestamp"`
+		Bucket    time.Time          `bson:"bucket"`
+	}{}
+	processedSkylinks := make(map[primitive.ObjectID]bool)
+	for c.Next(ctx) {
+		if err = c.Decode(&result); err != nil {
+			return errors.AddContext(err, "failed to decode DB data")
+		}
+		firstPinned := !result.Unpinned && !processedSkylinks[result.SkylinkID]
+		if !result.Unpinned {
+			processedSkylinks[result.SkylinkID] = true
+		}
+		if result.Timestamp.Before(from) {
+			continue
+		}
+		ix, ok := index[result.Bucket.Unix()]
+		if !ok {
+			continue
+		}
+		buckets[ix].BandwidthUploads += skynet.BandwidthUploadCost(result.Size)
+		if result.Unpinned {
+			continue
+		}
+		buckets[ix].NumUploads++
+		if firstPinned {
+			buckets[ix].UploadsSize += result.Size
+			buckets[ix].RawStorageUsed += skynet.RawStorageUsed(result.Size)
+		}
+	}
+	return nil
+}
+
+// userStatsHistoryDownloads adds the user's downloads to the given buckets.
+func (db *DB) userStatsHistoryDownloads(ctx context.Context, userID primitive.ObjectID, from, to time.Time, granularity string, buckets []UserStatsHistoryBucket, index map[int64]int) error {
+	matchStage := bson.D{{"$match", bson.D{
+		{"user_id", userID},
+		{"created_at", bson.D{{"$gte", from}, {"$lt", to}}},
+	}}}
+	lookupStage := bson.D{
+		{"$lookup", bson.D{
+			{"from", "skylinks"},
+			{"localField", "skylink_id"},
+			{"foreignField", "_id"},
+			{"as", "skylink_data"},
+		}},
+	}
+	// Partial downloads are accounted by their `bytes` and full downloads by
+	// the size of the skylink.
+	projectStage := bson.D{{"$project", bson.D{
+		{"_id", 0},
+		{"size", bson.D{
+			{"$cond", bson.A{
+				bson.D{{"$gt", bson.A{"$bytes", 0}}}, // if
+				"$bytes",                             // then
+				bson.D{{"$arrayElemAt", bson.A{"$skylink_data.size", 0}}}, // else
+			}},
+		}},
+		{"bucket", dateTruncStage("$created_at", granularity)},
+	}}}
+	pipeline := mongo.Pipeline{matchStage, lookupStage, projectStage}
+	c, err := db.staticDownloads.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errDef := c.Close(ctx); errDef != nil {
+			db.staticLogger.Traceln("Error on closing DB cursor.", errDef)
+		}
+	}()
+
+	// We need this struct, so we can safely decode both int32 and int64.
+	result := struct {
+		Size   int64     `bson:"size"`
+		Bucket time.Time `bson:"bucket"`
+	}{}
+	for c.Next(ctx) {
+		if err = c.Decode(&result); err != nil {
+			return errors.AddContext(err, "failed to decode DB data")
+		}
+		ix, ok := index[result.Bucket.Unix()]
+		if !ok {
+			continue
+		}
+		buckets[ix].NumDownloads++
+		buckets[ix].DownloadsSize += result.Size
+		buckets[ix].BandwidthDownloads += skynet.BandwidthDownloadCost(result.Size)
+	}
+	return nil
+}
+
+// dateTruncStage returns a $dateTrunc expression which truncates the given
+// date field to the start of its bucket in UTC.
+func dateTruncStage(field, granularity string) bson.D {
+	return bson.D{{"$dateTrunc", bson.D{
+		{"date", field},
+		{"unit", granularity},
+		{"timezone", "UTC"},
+	}}}
+}
+
+// truncateToGranularity returns the start of the bucket the given time falls
+// in. It matches the behaviour of MongoDB's $dateTrunc in UTC.
+func truncateToGranularity(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == GranularityMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// nextBucketStart returns the start of the bucket that follows the one which
+// starts at t.
+func nextBucketStart(t time.Time, granularity string) time.Time {
+	if granularity == GranularityMonth {
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
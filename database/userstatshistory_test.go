@@ -0,0 +1,34 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHistoryBuckets ensures we correctly calculate the start of the time
+// buckets used by the historical stats.
+func TestHistoryBuckets(t *testing.T) {
+	ts := time.Date(2022, 1, 31, 13, 14, 15, 16, time.UTC)
+
+	day := truncateToGranularity(ts, GranularityDay)
+	if !day.Equal(time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected day bucket start %s", day)
+	}
+	if next := nextBucketStart(day, GranularityDay); !next.Equal(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected next day bucket start %s", next)
+	}
+	month := truncateToGranularity(ts, GranularityMonth)
+	if !month.Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected month bucket start %s", month)
+	}
+	// Make sure we don't skip February when starting from a long month.
+	if next := nextBucketStart(month, GranularityMonth); !next.Equal(time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected next month bucket start %s", next)
+	}
+	// Make sure we handle non-UTC times.
+	loc := time.FixedZone("UTC+3", 3*3600)
+	local := time.Date(2022, 2, 1, 1, 0, 0, 0, loc)
+	if day = truncateToGranularity(local, GranularityDay); !day.Equal(time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected day bucket start %s", day)
+	}
+}
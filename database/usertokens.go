@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/lib"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The purposes for which we issue user tokens.
+const (
+	// TokenPurposeEmailConfirmation tokens confirm the user's email address.
+	TokenPurposeEmailConfirmation = "email_confirmation"
+	// TokenPurposeEmailChange tokens confirm the address the user wants to
+	// switch to.
+	TokenPurposeEmailChange = "email_change"
+	// TokenPurposeEmailRevert tokens allow the owner of the previous address
+	// of an account to revert a change of the address and lock the account.
+	TokenPurposeEmailRevert = "email_revert"
+	// TokenPurposeRecovery tokens allow the user to set a new password without
+	// logging in.
+	TokenPurposeRecovery = "recovery"
+	// TokenPurposeAccountMerge tokens prove that the user is logged into the
+	// account which they want to merge into another one.
+	TokenPurposeAccountMerge = "account_merge"
+	// TokenPurposeLoginLink tokens allow the user to log in without a
+	// password by following a link we sent to their email address.
+	TokenPurposeLoginLink = "login_link"
+)
+
+const (
+	// RecoveryTokenTTL defines the lifetime of an account recovery token.
+	RecoveryTokenTTL = 24 * time.Hour
+	// AccountMergeTokenTTL defines the lifetime of an account merge token.
+	AccountMergeTokenTTL = 15 * time.Minute
+	// LoginLinkTokenTTL defines the lifetime of a login link token.
+	LoginLinkTokenTTL = 15 * time.Minute
+)
+
+type (
+	// UserToken is a single-use token we send to a user via email, so they
+	// can prove they own the address. We only store the hash of the token.
+	// Expired tokens, consumed or not, are removed by a TTL index.
+	UserToken struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty"`
+		UserID    primitive.ObjectID `bson:"user_id"`
+		Purpose   string             `bson:"purpose"`
+		TokenHash string             `bson:"token_hash"`
+		// Email is the address to which we sent the token.
+		Email      types.Email `bson:"email"`
+		CreatedAt  time.Time   `bson:"created_at"`
+		ExpiresAt  time.Time   `bson:"expires_at"`
+		ConsumedAt time.Time   `bson:"consumed_at,omitempty"`
+	}
+)
+
+// UserTokenCreate issues a new token for the given purpose and returns it. The
+// token is sent to the given email address and expires after the given TTL.
+// Issuing a new token invalidates all tokens the user has for the same purpose.
+func (db *DB) UserTokenCreate(ctx context.Context, uID primitive.ObjectID, purpose string, email types.Email, ttl time.Duration) (string, error) {
+	tk, err := lib.GenerateUUID()
+	if err != nil {
+		return "", errors.AddContext(err, "failed to generate a token")
+	}
+	_, err = db.staticUserTokens.DeleteMany(ctx, bson.M{"user_id": uID, "purpose": purpose, "consumed_at": bson.M{"$exists": false}})
+	if err != nil {
+		return "", errors.AddContext(err, "failed to invalidate old tokens")
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ut := UserToken{
+		UserID:    uID,
+		Purpose:   purpose,
+		TokenHash: hashToken(tk),
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	_, err = db.staticUserTokens.InsertOne(ctx, ut)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to save token")
+	}
+	return tk, nil
+}
+
+// UserTokenConsume marks the given token as used and returns it. It fails with
+// ErrInvalidToken if the token doesn't exist, has already been used, has
+// expired, or doesn't serve any of the given purposes.
+func (db *DB) UserTokenConsume(ctx context.Context, token string, purposes ...string) (UserToken, error) {
+	var ut UserToken
+	if token == "" {
+		return ut, errors.AddContext(ErrInvalidToken, "token cannot be empty")
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	filter := validTokenFilter(token, now, purposes)
+	update := bson.M{"$set": bson.M{"consumed_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	sr := db.staticUserTokens.FindOneAndUpdate(ctx, filter, update, opts)
+	if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+		return ut, errors.AddContext(ErrInvalidToken, "no such token or the token has expired")
+	}
+	if sr.Err() != nil {
+		return ut, errors.AddContext(sr.Err(), "failed to consume token")
+	}
+	err := sr.Decode(&ut)
+	return ut, err
+}
+
+// UserTokenValid reports whether the given token can be used for any of the
+// given purposes. Unlike UserTokenConsume, it doesn't use the token up.
+func (db *DB) UserTokenValid(ctx context.Context, token string, purposes ...string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	n, err := db.staticUserTokens.CountDocuments(ctx, validTokenFilter(token, now, purposes))
+	if err != nil {
+		return false, errors.AddContext(err, "failed to look up token")
+	}
+	return n > 0, nil
+}
+
+// validTokenFilter returns a filter which matches the given token if it can
+// still be used for any of the given purposes.
+func validTokenFilter(token string, now time.Time, purposes []string) bson.M {
+	return bson.M{
+		"token_hash":  hashToken(token),
+		"purpose":     bson.M{"$in": purposes},
+		"consumed_at": bson.M{"$exists": false},
+		"expires_at":  bson.M{"$gt": now},
+	}
+}
+
+// hashToken returns the hash under which we store the given token.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,144 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+var (
+	// ErrInvalidDSN is returned when we can't find any delivery status
+	// information in a delivery status notification.
+	ErrInvalidDSN = errors.New("invalid delivery status notification")
+)
+
+type (
+	// DeliveryEvent is a notification from an email provider that an email
+	// to the given address bounced or that its recipient complained about
+	// it, i.e. marked it as spam.
+	DeliveryEvent struct {
+		// Type is either "bounce" or "complaint".
+		Type  string      `json:"type"`
+		Email types.Email `json:"email"`
+		// Transient marks bounces which might succeed if retried later, e.g.
+		// because the recipient's mailbox is full.
+		Transient bool   `json:"transient"`
+		Detail    string `json:"detail"`
+	}
+)
+
+// ParseDSN extracts the delivery events from an SMTP delivery status
+// notification (RFC 3464). The notification can be given either as a complete
+// email message (content type "message/rfc822") or as its
+// "multipart/report" body.
+func ParseDSN(r io.Reader, contentType string) ([]DeliveryEvent, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errors.Compose(err, ErrInvalidDSN)
+	}
+	if mediaType == "message/rfc822" {
+		msg, err := mail.ReadMessage(r)
+		if err != nil {
+			return nil, errors.Compose(err, ErrInvalidDSN)
+		}
+		return ParseDSN(msg.Body, msg.Header.Get("Content-Type"))
+	}
+	if mediaType == "message/delivery-status" {
+		return parseDeliveryStatus(r)
+	}
+	if mediaType != "multipart/report" {
+		return nil, ErrInvalidDSN
+	}
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, ErrInvalidDSN
+		}
+		if err != nil {
+			return nil, errors.Compose(err, ErrInvalidDSN)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if partType == "message/delivery-status" {
+			return parseDeliveryStatus(p)
+		}
+	}
+}
+
+// parseDeliveryStatus parses the body of a "message/delivery-status" part. It
+// consists of a group of per-message fields, followed by a group of fields for
+// each recipient. The groups are separated by empty lines.
+func parseDeliveryStatus(r io.Reader) ([]DeliveryEvent, error) {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	var events []DeliveryEvent
+	var groups int
+	for {
+		h, err := tr.ReadMIMEHeader()
+		if len(h) > 0 {
+			groups++
+			// The first group holds the per-message fields.
+			if groups > 1 {
+				if ev, ok := recipientDeliveryEvent(h); ok {
+					events = append(events, ev)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Compose(err, ErrInvalidDSN)
+		}
+	}
+	if groups < 2 {
+		return nil, ErrInvalidDSN
+	}
+	return events, nil
+}
+
+// recipientDeliveryEvent converts the per-recipient fields of a delivery
+// status notification into a delivery event. It reports false for recipients
+// to which the message was delivered.
+func recipientDeliveryEvent(h textproto.MIMEHeader) (DeliveryEvent, bool) {
+	recipient := h.Get("Final-Recipient")
+	if recipient == "" {
+		recipient = h.Get("Original-Recipient")
+	}
+	// The recipient is formatted as "address-type; address".
+	if i := strings.Index(recipient, ";"); i >= 0 {
+		recipient = recipient[i+1:]
+	}
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return DeliveryEvent{}, false
+	}
+	status := strings.TrimSpace(h.Get("Status"))
+	var transient bool
+	switch strings.ToLower(strings.TrimSpace(h.Get("Action"))) {
+	case "failed":
+		// Status codes starting with 5 are permanent failures.
+		transient = !strings.HasPrefix(status, "5")
+	case "delayed":
+		transient = true
+	default:
+		return DeliveryEvent{}, false
+	}
+	detail := strings.TrimSpace(h.Get("Diagnostic-Code"))
+	if detail == "" {
+		detail = status
+	}
+	return DeliveryEvent{
+		Type:      database.EmailSuppressionBounce,
+		Email:     types.NewEmail(recipient),
+		Transient: transient,
+		Detail:    detail,
+	}, true
+}
@@ -0,0 +1,82 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// dsnBody is the body of a delivery status notification which reports one
+// permanent failure, one delayed delivery and one successful delivery.
+const dsnBody = "--BOUNDARY\r\n" +
+	"Content-Type: text/plain; charset=us-ascii\r\n" +
+	"\r\n" +
+	"This is the mail system. Your message could not be delivered.\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"Arrival-Date: Tue, 1 Mar 2022 10:00:00 +0000\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; Gone@Example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 user unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; full@example.com\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.2.2\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; fine@example.com\r\n" +
+	"Action: delivered\r\n" +
+	"Status: 2.0.0\r\n" +
+	"--BOUNDARY--\r\n"
+
+// TestParseDSN ensures that we correctly extract the delivery events from
+// delivery status notifications.
+func TestParseDSN(t *testing.T) {
+	contentType := `multipart/report; report-type=delivery-status; boundary="BOUNDARY"`
+	events, err := ParseDSN(strings.NewReader(dsnBody), contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %+v", len(events), events)
+	}
+	if events[0].Type != database.EmailSuppressionBounce || events[0].Email != "gone@example.com" || events[0].Transient {
+		t.Fatalf("Unexpected event %+v", events[0])
+	}
+	if events[0].Detail != "smtp; 550 5.1.1 user unknown" {
+		t.Fatalf("Unexpected detail '%s'", events[0].Detail)
+	}
+	if events[1].Email != "full@example.com" || !events[1].Transient {
+		t.Fatalf("Unexpected event %+v", events[1])
+	}
+
+	// The same notification as a complete email message.
+	msg := "From: MAILER-DAEMON@example.com\r\n" +
+		"To: noreply@siasky.net\r\n" +
+		"Subject: Undelivered Mail Returned to Sender\r\n" +
+		"Content-Type: " + contentType + "\r\n" +
+		"\r\n" + dsnBody
+	events, err = ParseDSN(strings.NewReader(msg), "message/rfc822")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+
+	// Messages which are not delivery status notifications.
+	_, err = ParseDSN(strings.NewReader("hello"), "text/plain")
+	if !errors.Contains(err, ErrInvalidDSN) {
+		t.Fatalf("Expected '%v', got '%v'", ErrInvalidDSN, err)
+	}
+	noStatus := "--B\r\nContent-Type: text/plain\r\n\r\nhello\r\n--B--\r\n"
+	_, err = ParseDSN(strings.NewReader(noStatus), `multipart/report; boundary="B"`)
+	if !errors.Contains(err, ErrInvalidDSN) {
+		t.Fatalf("Expected '%v', got '%v'", ErrInvalidDSN, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
 )
 
 /**
@@ -29,22 +30,46 @@ func NewMailer(db *database.DB) *Mailer {
 }
 
 // Send queues an email message for sending. The message will be sent by Sender
-// with the next batch of emails.
+// with the next batch of emails. Messages to suppressed addresses, i.e.
+// addresses which bounced or complained, are silently dropped.
 func (em Mailer) Send(ctx context.Context, m database.EmailMessage) error {
+	suppressed, err := em.staticDB.EmailSuppressed(ctx, types.NewEmail(m.To))
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return nil
+	}
 	return em.staticDB.EmailCreate(ctx, m)
 }
 
 // SendAddressConfirmationEmail sends a new email to the given email address
 // with a link to confirm the ownership of the address.
-func (em Mailer) SendAddressConfirmationEmail(ctx context.Context, email types.Email, token string) error {
-	m := confirmEmailEmail(email.String(), token)
+func (em Mailer) SendAddressConfirmationEmail(ctx context.Context, email types.Email, locale, token string) error {
+	m, err := em.confirmEmailEmail(ctx, email.String(), locale, token)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
 	return em.Send(ctx, *m)
 }
 
 // SendRecoverAccountEmail sends a new email to the given email address
 // with a link to recover the account.
-func (em Mailer) SendRecoverAccountEmail(ctx context.Context, email types.Email, token string) error {
-	m := recoverAccountEmail(email.String(), token)
+func (em Mailer) SendRecoverAccountEmail(ctx context.Context, email types.Email, locale, token string) error {
+	m, err := em.recoverAccountEmail(ctx, email.String(), locale, token)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
+	return em.Send(ctx, *m)
+}
+
+// SendLoginLinkEmail sends a new email to the given email address with a link
+// which logs the user in without a password.
+func (em Mailer) SendLoginLinkEmail(ctx context.Context, email types.Email, locale, token string) error {
+	m, err := em.loginLinkEmail(ctx, email.String(), locale, token)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
 	return em.Send(ctx, *m)
 }
 
@@ -53,7 +78,43 @@ func (em Mailer) SendRecoverAccountEmail(ctx context.Context, email types.Email,
 // recover a Skynet account but their email is not in our system. The main
 // reason to do that is because the user might have forgotten which email they
 // used for signing up.
-func (em Mailer) SendAccountAccessAttemptedEmail(ctx context.Context, email types.Email) error {
-	m := accountAccessAttemptedEmail(email.String())
+func (em Mailer) SendAccountAccessAttemptedEmail(ctx context.Context, email types.Email, locale string) error {
+	m, err := em.accountAccessAttemptedEmail(ctx, email.String(), locale)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
+	return em.Send(ctx, *m)
+}
+
+// SendQuotaWarningEmail sends a new email to the given email address that
+// notifies the user that they have used the given percentage of their quota
+// on the given resource.
+func (em Mailer) SendQuotaWarningEmail(ctx context.Context, email types.Email, locale, resource string, percent int, used, limit int64) error {
+	m, err := em.quotaWarningEmail(ctx, email.String(), locale, resource, percent, used, limit)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
+	return em.Send(ctx, *m)
+}
+
+// SendEmailChangedEmail sends a new email to the current email address of a
+// user that notifies them that someone requested to change it to newEmail. The email
+// contains a link with the given token which allows the user to lock their
+// account and revert the change.
+func (em Mailer) SendEmailChangedEmail(ctx context.Context, email types.Email, locale string, newEmail types.Email, token string) error {
+	m, err := em.emailChangedEmail(ctx, email.String(), locale, newEmail.String(), token)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
+	return em.Send(ctx, *m)
+}
+
+// SendSecurityNotificationEmail sends a new email to the given email address
+// that notifies the user about the given security event on their account.
+func (em Mailer) SendSecurityNotificationEmail(ctx context.Context, email types.Email, locale, event string) error {
+	m, err := em.securityNotificationEmail(ctx, email.String(), locale, event)
+	if err != nil {
+		return errors.AddContext(err, "failed to build email")
+	}
 	return em.Send(ctx, *m)
 }
@@ -2,7 +2,6 @@ package email
 
 import (
 	"context"
-	"crypto/tls"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -16,7 +15,6 @@ import (
 	"gitlab.com/SkynetLabs/skyd/build"
 	"gitlab.com/SkynetLabs/skyd/skymodules"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"gopkg.in/mail.v2"
 )
 
 const (
@@ -56,9 +54,9 @@ var (
 		},
 	).(string)
 
-	// matchPattern extracts all relevant configuration values from an email
+	// matchPattern extracts all relevant configuration values from an SMTP
 	// connection URI
-	matchPattern = regexp.MustCompile("smtps://(?P<user>.*):(?P<password>.*)@(?P<server>.*):(?P<port>\\d*)(/\\??skip_ssl_verify=(?P<skip_ssl_verify>\\w*))?")
+	matchPattern = regexp.MustCompile("(?P<scheme>smtps?)://(?P<user>.*):(?P<password>.*)@(?P<server>.*):(?P<port>\\d*)(/\\??skip_ssl_verify=(?P<skip_ssl_verify>\\w*))?")
 
 	// sleepBetweenScans defines how long the sender should sleep between its
 	// sweeps of the DB.
@@ -75,27 +73,28 @@ type (
 	// Sender is a daemon that periodically checks the DB for emails waiting to
 	// be sent and sends them.
 	Sender struct {
-		staticConfig emailConfig
-		staticCtx    context.Context
-		staticDB     *database.DB
-		staticDeps   skymodules.SkydDependencies
-		staticLogger *logrus.Logger
+		staticCtx       context.Context
+		staticDB        *database.DB
+		staticDeps      skymodules.SkydDependencies
+		staticLogger    *logrus.Logger
+		staticTransport Transport
 	}
 
 	// emailConfig contains all configuration options we need in order to send
-	// an email
+	// an email via SMTP
 	emailConfig struct {
 		User               string
 		Pass               string
 		Server             string
 		Port               int
 		InsecureSkipVerify bool
+		StartTLS           bool
 	}
 )
 
 // NewSender returns a new Sender instance.
 func NewSender(ctx context.Context, db *database.DB, logger *logrus.Logger, deps skymodules.SkydDependencies, emailConnURI string) (Sender, error) {
-	c, err := config(emailConnURI)
+	t, err := NewTransport(emailConnURI)
 	if err != nil {
 		return Sender{}, errors.AddContext(err, "failed to parse email config")
 	}
@@ -104,11 +103,11 @@ func NewSender(ctx context.Context, db *database.DB, logger *logrus.Logger, deps
 		return Sender{}, err
 	}
 	return Sender{
-		staticConfig: c,
-		staticCtx:    mongo.NewSessionContext(ctx, sess),
-		staticDB:     db,
-		staticDeps:   deps,
-		staticLogger: logger,
+		staticCtx:       mongo.NewSessionContext(ctx, sess),
+		staticDB:        db,
+		staticDeps:      deps,
+		staticLogger:    logger,
+		staticTransport: t,
 	}, nil
 }
 
@@ -146,10 +145,15 @@ func (s Sender) ScanAndSend(lockID string) (int, int) {
 	var failed []*database.EmailMessage
 	var errs []error
 	for i, m := range msgs {
-		err = s.send(m.From, m.To, m.Subject, m.Body, m.BodyMime)
+		err = s.send(m)
 		if err != nil {
 			errs = append(errs, err)
+			msgs[i].LastError = err.Error()
+			msgs[i].FailedPermanently = isPermanentError(err)
 			failed = append(failed, &msgs[i])
+			if msgs[i].FailedPermanently || m.FailedAttempts+1 >= database.EmailMaxSendAttempts {
+				s.staticLogger.Warningf("Giving up on sending email %s to %s: %s", m.ID.Hex(), m.To, err)
+			}
 			continue
 		}
 		sent = append(sent, m.ID)
@@ -177,38 +181,16 @@ func (s Sender) ScanAndSend(lockID string) (int, int) {
 // send an email message.
 //
 // This function will not be called by Mailer but rather by Sender.
-//
-// bodyMime should be either "text/plain" or "text/html"
-func (s Sender) send(from, to, subject, body, bodyMime string) error {
-	m := mail.NewMessage()
-	m.SetHeader("From", from)
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", subject)
-	m.SetBody(bodyMime, body)
-
-	return s.sendMultiple(m)
-}
-
-// sendMultiple one or more email messages.
-//
-// This function will not be called by Mailer but rather by Sender.
-func (s Sender) sendMultiple(m ...*mail.Message) error {
-	d := mail.NewDialer(s.staticConfig.Server, s.staticConfig.Port, s.staticConfig.User, s.staticConfig.Pass)
-	// This is only needed when SSL/TLS certificate is not valid on server.
-	// In production this should be set to false.
-	/* #nosec */
-	d.TLSConfig = &tls.Config{
-		InsecureSkipVerify: s.staticConfig.InsecureSkipVerify,
-		ServerName:         s.staticConfig.Server,
-	}
+func (s Sender) send(m database.EmailMessage) error {
 	if s.staticDeps.Disrupt("SkipSendingEmails") {
 		return nil
 	}
-	return d.DialAndSend(m...)
+	return s.staticTransport.Send(s.staticCtx, m)
 }
 
-// config parses the DefaultConnectionURI variable and extracts the configuration
-// values from it.
+// config parses the given SMTP connection URI and extracts the configuration
+// values from it. The `smtp` scheme means that the connection should be
+// upgraded via STARTTLS, while `smtps` uses TLS from the start.
 func config(connURI string) (emailConfig, error) {
 	match := matchPattern.FindStringSubmatch(connURI)
 	if len(match) == 0 {
@@ -244,5 +226,6 @@ func config(connURI string) (emailConfig, error) {
 		Server:             server,
 		Port:               port,
 		InsecureSkipVerify: skip == "true",
+		StartTLS:           result["scheme"] == "smtp",
 	}, nil
 }
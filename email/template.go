@@ -1,136 +1,423 @@
 package email
 
 import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	texttemplate "text/template"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/text/language"
 )
 
-const (
-	confirmEmailSubject = "Please verify your email address"
-	confirmEmailMime    = "multipart/alternative; boundary=e31b4aa4706e10c57d31a44da59281c216fb10992b0e5b512edea805408a"
-	confirmEmailTempl   = `
---e31b4aa4706e10c57d31a44da59281c216fb10992b0e5b512edea805408a
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/plain; charset=UTF-8
-
-Hi, please verify your account by clicking the following link:
-
-<a href="{{.ConfirmEndpoint}}?token={{.Token}}">{{.ConfirmEndpoint}}?token={{.Token}}</a>
-
---e31b4aa4706e10c57d31a44da59281c216fb10992b0e5b512edea805408a
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/html; charset=UTF-8
-
-Hi, please verify your account by clicking the following link:
-
-<a href="{{.ConfirmEndpoint}}?token={{.Token}}">{{.ConfirmEndpoint}}?token={{.Token}}</a>
+/**
+Each email consists of three templates which share the same name and differ
+only by their extension:
+ - `.subject` is a text/template for the subject of the email
+ - `.txt` is a text/template for the plain text part of the email
+ - `.html` is an html/template for the HTML part of the email. It can use the
+   "header" and "footer" templates defined in `layout.html`.
+
+All templates have access to the operator's branding via `.Branding`.
+
+Templates can be localized by adding the BCP 47 language tag before the
+extension, e.g. `confirm_email.es.txt` or `confirm_email.pt-BR.txt`. We look
+for the user's exact locale first, then for its base language and finally fall
+back to the English template without a language tag.
+
+The default templates are embedded in the binary. Operators can override any of
+them, as well as the branding, without recompiling the service:
+ - by placing a file with the same name in TemplatesDir. The branding can be
+   overridden by a `branding.json` file in the same directory.
+ - by storing the template in the configuration collection in the DB under the
+   key `email_template:<file name>`, e.g. `email_template:confirm_email.html`.
+   The branding can be overridden by a JSON object stored under the
+   `email_branding` key.
+The DB takes precedence over TemplatesDir. Branding overrides only need to
+contain the fields they change.
+*/
 
---e31b4aa4706e10c57d31a44da59281c216fb10992b0e5b512edea805408a--
-`
-
-	recoverAccountSubject = "Recover access to your account"
-	recoverAccountMime    = "multipart/alternative; boundary=9f0f6cc6978acbf34b218925c8b6be77292fcc0ec91a086b04045aafa8ca"
-	recoverAccountTempl   = `
---9f0f6cc6978acbf34b218925c8b6be77292fcc0ec91a086b04045aafa8ca
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/plain; charset=UTF-8
+const (
+	// confKeyTemplatePrefix is the prefix of the configuration keys which
+	// hold template overrides.
+	confKeyTemplatePrefix = "email_template:"
+	// confKeyBranding is the configuration key which holds the branding
+	// overrides.
+	confKeyBranding = "email_branding"
+	// brandingFile is the name of the file in TemplatesDir which holds the
+	// branding overrides.
+	brandingFile = "branding.json"
+	// layoutTemplate is the name of the template which defines the shared
+	// parts of all HTML templates.
+	layoutTemplate = "layout"
+)
 
-Hi,
+// The names of the templates of the emails we send. The template name is
+// stored on each email message, so we can tell them apart.
+const (
+	TemplateConfirmEmail           = "confirm_email"
+	TemplateRecoverAccount         = "recover_account"
+	TemplateLoginLink              = "login_link"
+	TemplateAccountAccessAttempted = "account_access_attempted"
+	TemplateQuotaWarning           = "quota_warning"
+	TemplateEmailChanged           = "email_changed"
+	TemplateSecurityNotification   = "security_notification"
+)
 
-please recover access to your account by clicking the following link:
+// The security events about which we notify users.
+const (
+	SecurityEventPasswordChanged = "password_changed"
+	SecurityEventPubKeyAdded     = "pubkey_added"
+	SecurityEventPubKeyRemoved   = "pubkey_removed"
+	SecurityEventAPIKeyCreated   = "api_key_created"
+	SecurityEventPasskeyAdded    = "passkey_added"
+	SecurityEventPasskeyRemoved  = "passkey_removed"
+)
 
-<a href="{{.RecoverEndpoint}}?token={{.Token}}">{{.RecoverEndpoint}}?token={{.Token}}</a>
+var (
+	// TemplatesDir is the directory from which operators can override the
+	// default email templates and branding. Its value is controlled by the
+	// ACCOUNTS_EMAIL_TEMPLATES_DIR environment variable. Optional.
+	TemplatesDir = ""
+
+	// DefaultBranding is the branding we use unless the operator overrides
+	// it.
+	DefaultBranding = Branding{
+		PortalName:      "Skynet",
+		LogoURL:         "",
+		PrimaryColor:    "#00c65e",
+		BackgroundColor: "#f5f5f7",
+		TextColor:       "#333333",
+	}
 
---9f0f6cc6978acbf34b218925c8b6be77292fcc0ec91a086b04045aafa8ca
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/html; charset=UTF-8
+	//go:embed templates
+	defaultTemplates embed.FS
 
-Hi,
+	// errTemplateNotFound is returned when a template file doesn't exist in
+	// any of the template sources.
+	errTemplateNotFound = errors.New("template not found")
+)
 
-please recover access to your account by clicking the following link:
+type (
+	// Branding defines the visual identity of the portal in the emails we
+	// send.
+	Branding struct {
+		PortalName      string `json:"portalName"`
+		LogoURL         string `json:"logoUrl"`
+		PrimaryColor    string `json:"primaryColor"`
+		BackgroundColor string `json:"backgroundColor"`
+		TextColor       string `json:"textColor"`
+	}
+)
 
-<a href="{{.RecoverEndpoint}}?token={{.Token}}">{{.RecoverEndpoint}}?token={{.Token}}</a>
+// confirmEmailEmail generates an email for confirming that the user owns the
+// given email address.
+func (em Mailer) confirmEmailEmail(ctx context.Context, to, locale, token string) (*database.EmailMessage, error) {
+	data := map[string]interface{}{
+		"Link": PortalAddressAccounts + "/user/confirm?token=" + token,
+	}
+	return em.render(ctx, TemplateConfirmEmail, to, locale, data)
+}
 
---9f0f6cc6978acbf34b218925c8b6be77292fcc0ec91a086b04045aafa8ca--
-`
+// recoverAccountEmail generates an email for recovering an account.
+func (em Mailer) recoverAccountEmail(ctx context.Context, to, locale, token string) (*database.EmailMessage, error) {
+	data := map[string]interface{}{
+		"Link": PortalAddressAccounts + "/user/recover?token=" + token,
+	}
+	return em.render(ctx, TemplateRecoverAccount, to, locale, data)
+}
 
-	accountAccessAttemptedSubject = "Account access attempted"
-	accountAccessAttemptedMime    = "multipart/alternative; boundary=f096ee1beed49f6757a41b4bf22d1ddc10cc9480a4df9376ebac4fe4f405"
-	accountAccessAttemptedTempl   = `
---f096ee1beed49f6757a41b4bf22d1ddc10cc9480a4df9376ebac4fe4f405
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/plain; charset=UTF-8
+// loginLinkEmail generates an email with a link which logs the user in.
+func (em Mailer) loginLinkEmail(ctx context.Context, to, locale, token string) (*database.EmailMessage, error) {
+	data := map[string]interface{}{
+		"Link": PortalAddressAccounts + "/login/email/confirm?token=" + token,
+	}
+	return em.render(ctx, TemplateLoginLink, to, locale, data)
+}
 
-Hi,
+// accountAccessAttemptedEmail generates an email for notifying a user that
+// someone tried to use their email for recovering a Skynet account but their
+// email is not in our system. The main reason to do that is because the user
+// might have forgotten which email they used for signing up.
+func (em Mailer) accountAccessAttemptedEmail(ctx context.Context, to, locale string) (*database.EmailMessage, error) {
+	return em.render(ctx, TemplateAccountAccessAttempted, to, locale, map[string]interface{}{})
+}
 
-you (or someone else) entered this email address when trying to recover acc=
-ess to an account.
+// quotaWarningEmail generates an email for notifying a user that they have
+// used the given percentage of their quota on the given resource.
+func (em Mailer) quotaWarningEmail(ctx context.Context, to, locale, resource string, percent int, used, limit int64) (*database.EmailMessage, error) {
+	var usedStr, limitStr string
+	if resource == database.QuotaResourceUploads {
+		usedStr = strconv.FormatInt(used, 10)
+		limitStr = strconv.FormatInt(limit, 10)
+	} else {
+		usedStr = formatBytes(used)
+		limitStr = formatBytes(limit)
+	}
+	data := map[string]interface{}{
+		"Resource": quotaResourceName(resource),
+		"Percent":  percent,
+		"Used":     usedStr,
+		"Limit":    limitStr,
+		"Link":     PortalAddressAccounts + "/payments",
+	}
+	return em.render(ctx, TemplateQuotaWarning, to, locale, data)
+}
 
-However, this email address is not on our database of registered users and =
-therefore the attempt has failed.
+// emailChangedEmail generates an email for notifying a user at their current
+// email address that someone requested to change the address of their account.
+// It contains a link to the dashboard page which allows them to revert the
+// change if they didn't make it. The page needs to POST the token to
+// `/user/lock`, so merely opening the link doesn't lock the account.
+func (em Mailer) emailChangedEmail(ctx context.Context, to, locale, newEmail, token string) (*database.EmailMessage, error) {
+	data := map[string]interface{}{
+		"NewEmail": newEmail,
+		"Link":     PortalAddressAccounts + "/user/lock?token=" + token,
+	}
+	return em.render(ctx, TemplateEmailChanged, to, locale, data)
+}
 
-If this was you, check if you signed up using a different address.
+// securityNotificationEmail generates an email for notifying a user about a
+// sensitive change of their account, e.g. a password change.
+func (em Mailer) securityNotificationEmail(ctx context.Context, to, locale, event string) (*database.EmailMessage, error) {
+	data := map[string]interface{}{
+		"Event": event,
+		"Link":  PortalAddressAccounts,
+	}
+	return em.render(ctx, TemplateSecurityNotification, to, locale, data)
+}
 
-If this was not you, please ignore this email.
+// render executes the templates with the given name, localized for the given
+// locale, and returns an email message with a multipart/alternative body
+// containing both a plain text and an HTML part.
+func (em Mailer) render(ctx context.Context, name, to, locale string, data map[string]interface{}) (*database.EmailMessage, error) {
+	branding, err := em.branding(ctx)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to load branding")
+	}
+	data["Branding"] = branding
 
---f096ee1beed49f6757a41b4bf22d1ddc10cc9480a4df9376ebac4fe4f405
-Content-Transfer-Encoding: quoted-printable
-Content-Type: text/html; charset=UTF-8
+	subjectSrc, err := em.localizedTemplateSource(ctx, name, "subject", locale)
+	if err != nil {
+		return nil, err
+	}
+	textSrc, err := em.localizedTemplateSource(ctx, name, "txt", locale)
+	if err != nil {
+		return nil, err
+	}
+	layoutSrc, err := em.localizedTemplateSource(ctx, layoutTemplate, "html", locale)
+	if err != nil {
+		return nil, err
+	}
+	htmlSrc, err := em.localizedTemplateSource(ctx, name, "html", locale)
+	if err != nil {
+		return nil, err
+	}
 
-Hi,
+	var subject, text, html bytes.Buffer
+	subjectTmpl, err := texttemplate.New(name + ".subject").Parse(subjectSrc)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse subject template")
+	}
+	if err = subjectTmpl.Execute(&subject, data); err != nil {
+		return nil, errors.AddContext(err, "failed to execute subject template")
+	}
+	textTmpl, err := texttemplate.New(name + ".txt").Parse(textSrc)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse text template")
+	}
+	if err = textTmpl.Execute(&text, data); err != nil {
+		return nil, errors.AddContext(err, "failed to execute text template")
+	}
+	htmlTmpl, err := htmltemplate.New(name + ".html").Parse(htmlSrc)
+	if err == nil {
+		_, err = htmlTmpl.New(layoutTemplate + ".html").Parse(layoutSrc)
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to parse HTML template")
+	}
+	if err = htmlTmpl.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, errors.AddContext(err, "failed to execute HTML template")
+	}
 
-you (or someone else) entered this email address when trying to recover acc=
-ess to an account.
+	body, mimeType, err := multipartAlternative(text.String(), html.String())
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to build email body")
+	}
+	return &database.EmailMessage{
+		From:     From,
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		Body:     body,
+		BodyMime: mimeType,
+		Template: name,
+	}, nil
+}
 
-However, this email address is not on our database of registered users and =
-therefore the attempt has failed.
+// localizedTemplateSource returns the source of the template with the given
+// name and extension which best matches the given locale. It falls back to the
+// English template.
+func (em Mailer) localizedTemplateSource(ctx context.Context, name, ext, locale string) (string, error) {
+	for _, file := range localizedFileNames(name, ext, locale) {
+		src, err := em.templateSource(ctx, file)
+		if errors.Contains(err, errTemplateNotFound) {
+			continue
+		}
+		return src, err
+	}
+	return "", errors.AddContext(errTemplateNotFound, name+"."+ext)
+}
 
-If this was you, check if you signed up using a different address.
+// localizedFileNames returns the names of the files which might hold the
+// template with the given name and extension, in order of preference for the
+// given locale.
+func localizedFileNames(name, ext, locale string) []string {
+	var files []string
+	if tag, err := language.Parse(locale); err == nil && tag != language.Und {
+		files = append(files, name+"."+tag.String()+"."+ext)
+		if base, conf := tag.Base(); conf != language.No && base.String() != tag.String() {
+			files = append(files, name+"."+base.String()+"."+ext)
+		}
+	}
+	return append(files, name+"."+ext)
+}
 
-If this was not you, please ignore this email.
+// templateSource returns the source of the template in the given file. It
+// checks the DB first, TemplatesDir second and falls back to the default
+// templates.
+func (em Mailer) templateSource(ctx context.Context, file string) (string, error) {
+	if em.staticDB != nil {
+		src, err := em.staticDB.ReadConfigValue(ctx, confKeyTemplatePrefix+file)
+		if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+			return "", errors.AddContext(err, "failed to read template "+file+" from the DB")
+		}
+		if src != "" {
+			return src, nil
+		}
+	}
+	if TemplatesDir != "" {
+		src, err := os.ReadFile(filepath.Join(TemplatesDir, file))
+		if err != nil && !os.IsNotExist(err) {
+			return "", errors.AddContext(err, "failed to read template "+file)
+		}
+		if err == nil {
+			return string(src), nil
+		}
+	}
+	src, err := defaultTemplates.ReadFile("templates/" + file)
+	if err != nil {
+		return "", errTemplateNotFound
+	}
+	return string(src), nil
+}
 
---f096ee1beed49f6757a41b4bf22d1ddc10cc9480a4df9376ebac4fe4f405--
-`
-)
+// branding returns the DefaultBranding with all overrides applied.
+func (em Mailer) branding(ctx context.Context) (Branding, error) {
+	b := DefaultBranding
+	if TemplatesDir != "" {
+		bj, err := os.ReadFile(filepath.Join(TemplatesDir, brandingFile))
+		if err != nil && !os.IsNotExist(err) {
+			return Branding{}, err
+		}
+		if err == nil {
+			if err = json.Unmarshal(bj, &b); err != nil {
+				return Branding{}, errors.AddContext(err, "invalid "+brandingFile)
+			}
+		}
+	}
+	if em.staticDB != nil {
+		bj, err := em.staticDB.ReadConfigValue(ctx, confKeyBranding)
+		if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+			return Branding{}, err
+		}
+		if bj != "" {
+			if err = json.Unmarshal([]byte(bj), &b); err != nil {
+				return Branding{}, errors.AddContext(err, "invalid branding in the DB")
+			}
+		}
+	}
+	return b, nil
+}
 
-// confirmEmailEmail generates an email for confirming that the user owns the
-// given email address.
-func confirmEmailEmail(to string, token string) *database.EmailMessage {
-	body := strings.ReplaceAll(confirmEmailTempl, "{{.ConfirmEndpoint}}", PortalAddressAccounts+"/user/confirm")
-	body = strings.ReplaceAll(body, "{{.Token}}", token)
-	return &database.EmailMessage{
-		From:     From,
-		To:       to,
-		Subject:  confirmEmailSubject,
-		Body:     body,
-		BodyMime: confirmEmailMime,
+// multipartAlternative returns a multipart/alternative body which contains
+// the given plain text and HTML parts, as well as its MIME type. Both parts
+// are quoted-printable encoded.
+func multipartAlternative(text, html string) (string, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", "", err
+		}
+		qpw := quotedprintable.NewWriter(pw)
+		if _, err = qpw.Write([]byte(p.content)); err != nil {
+			return "", "", err
+		}
+		if err = qpw.Close(); err != nil {
+			return "", "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
 	}
+	return body.String(), "multipart/alternative; boundary=" + w.Boundary(), nil
 }
 
-// recoverAccountEmail generates an email for recovering an account.
-func recoverAccountEmail(to string, token string) *database.EmailMessage {
-	body := strings.ReplaceAll(recoverAccountTempl, "{{.RecoverEndpoint}}", PortalAddressAccounts+"/user/recover")
-	body = strings.ReplaceAll(body, "{{.Token}}", token)
-	return &database.EmailMessage{
-		From:     From,
-		To:       to,
-		Subject:  recoverAccountSubject,
-		Body:     body,
-		BodyMime: recoverAccountMime,
+// quotaResourceName returns the human-readable name of the given quota
+// resource.
+func quotaResourceName(resource string) string {
+	switch resource {
+	case database.QuotaResourceStorage:
+		return "storage"
+	case database.QuotaResourceUploads:
+		return "allowed number of uploads"
+	case database.QuotaResourceDownloadBandwidth:
+		return "monthly download bandwidth"
+	default:
+		return resource
 	}
 }
 
-// accountAccessAttemptedEmail generates an email for notifying a user that
-// someone tried to use their email for recovering a Skynet account but their
-// email is not in our system. The main reason to do that is because the user
-// might have forgotten which email they used for signing up.
-func accountAccessAttemptedEmail(to string) *database.EmailMessage {
-	return &database.EmailMessage{
-		From:     From,
-		To:       to,
-		Subject:  accountAccessAttemptedSubject,
-		Body:     accountAccessAttemptedTempl,
-		BodyMime: accountAccessAttemptedMime,
+// formatBytes returns a human-readable representation of the given number of
+// bytes, e.g. "1.50 GiB".
+func formatBytes(b int64) string {
+	units := []struct {
+		size int64
+		name string
+	}{
+		{skynet.TiB, "TiB"},
+		{skynet.GiB, "GiB"},
+		{skynet.MiB, "MiB"},
+		{skynet.KiB, "KiB"},
+	}
+	for _, u := range units {
+		if b >= u.size {
+			return fmt.Sprintf("%.2f %s", float64(b)/float64(u.size), u.name)
+		}
 	}
+	return fmt.Sprintf("%d B", b)
 }
@@ -1,10 +1,18 @@
 package email
 
 import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/lib"
+	"github.com/SkynetLabs/skynet-accounts/skynet"
 )
 
 // TestConfirmEmailEmail ensures that the email we send to the user contains
@@ -15,14 +23,19 @@ func TestConfirmEmailEmail(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	em := confirmEmailEmail(to, token)
+	em, err := Mailer{}.confirmEmailEmail(context.Background(), to, "", token)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if em.To != to {
 		t.Fatalf("Expected the email to go to %s, got %s", to, em.To)
 	}
 	if em.From != From {
 		t.Fatalf("Expected the email to go from %s, got %s", From, em.From)
 	}
-	if !strings.Contains(em.Body, "https://account.siasky.net/user/confirm?token="+token) {
+	text, html := emailParts(t, em)
+	link := "https://account.siasky.net/user/confirm?token=" + token
+	if !strings.Contains(text, link) || !strings.Contains(html, `href="`+link+`"`) {
 		t.Fatal("Invalid confirmation link.")
 	}
 }
@@ -35,27 +48,262 @@ func TestRecoverAccountEmail(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	em := recoverAccountEmail(to, token)
+	em, err := Mailer{}.recoverAccountEmail(context.Background(), to, "", token)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if em.To != to {
 		t.Fatalf("Expected the email to go to %s, got %s", to, em.To)
 	}
 	if em.From != From {
 		t.Fatalf("Expected the email to go from %s, got %s", From, em.From)
 	}
-	if !strings.Contains(em.Body, "https://account.siasky.net/user/recover?token="+token) {
+	text, html := emailParts(t, em)
+	link := "https://account.siasky.net/user/recover?token=" + token
+	if !strings.Contains(text, link) || !strings.Contains(html, `href="`+link+`"`) {
 		t.Fatal("Invalid confirmation link.")
 	}
 }
 
+// TestLoginLinkEmail ensures that the email we send to the user contains the
+// correct login link.
+func TestLoginLinkEmail(t *testing.T) {
+	token, err := lib.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	em, err := Mailer{}.loginLinkEmail(context.Background(), "user@siasky.net", "", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em.Subject != "Log in to your account" {
+		t.Fatalf("Unexpected subject '%s'", em.Subject)
+	}
+	text, html := emailParts(t, em)
+	link := "https://account.siasky.net/login/email/confirm?token=" + token
+	if !strings.Contains(text, link) || !strings.Contains(html, `href="`+link+`"`) {
+		t.Fatal("Invalid login link.")
+	}
+}
+
 // TestAccountAccessAttemptedEmail ensures that the email we send to the user
 // is going to the correct email.
 func TestAccountAccessAttemptedEmail(t *testing.T) {
 	to := "user@siasky.net"
-	em := accountAccessAttemptedEmail(to)
+	em, err := Mailer{}.accountAccessAttemptedEmail(context.Background(), to, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em.To != to {
+		t.Fatalf("Expected the email to go to %s, got %s", to, em.To)
+	}
+	if em.From != From {
+		t.Fatalf("Expected the email to go from %s, got %s", From, em.From)
+	}
+}
+
+// TestQuotaWarningEmail ensures that the quota warning email contains the
+// correct usage information.
+func TestQuotaWarningEmail(t *testing.T) {
+	to := "user@siasky.net"
+	em, err := Mailer{}.quotaWarningEmail(context.Background(), to, "", database.QuotaResourceStorage, 80, 80*skynet.GiB, 100*skynet.GiB)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if em.To != to {
 		t.Fatalf("Expected the email to go to %s, got %s", to, em.To)
 	}
 	if em.From != From {
 		t.Fatalf("Expected the email to go from %s, got %s", From, em.From)
 	}
+	if em.Subject != "You have used 80% of your storage" {
+		t.Fatalf("Unexpected subject '%s'", em.Subject)
+	}
+	text, _ := emailParts(t, em)
+	if !strings.Contains(text, "80.00 GiB out of 100.00 GiB") {
+		t.Fatal("Invalid usage information.")
+	}
+	em, err = Mailer{}.quotaWarningEmail(context.Background(), to, "", database.QuotaResourceUploads, 100, 10000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em.Subject != "You have used all of your allowed number of uploads" {
+		t.Fatalf("Unexpected subject '%s'", em.Subject)
+	}
+	text, _ = emailParts(t, em)
+	if !strings.Contains(text, "10000 out of 10000") {
+		t.Fatal("Invalid usage information.")
+	}
+}
+
+// TestEmailChangedEmail ensures that the email we send to the old address of
+// a user contains the new address and the correct lock link.
+func TestEmailChangedEmail(t *testing.T) {
+	em, err := Mailer{}.emailChangedEmail(context.Background(), "old@siasky.net", "", "new@siasky.net", "revert-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em.To != "old@siasky.net" {
+		t.Fatalf("Expected the email to go to the old address, got %s", em.To)
+	}
+	text, html := emailParts(t, em)
+	link := "https://account.siasky.net/user/lock?token=revert-token"
+	if !strings.Contains(text, link) || !strings.Contains(html, `href="`+link+`"`) {
+		t.Fatal("Invalid lock link.")
+	}
+	if !strings.Contains(text, "new@siasky.net") {
+		t.Fatal("Expected the new address in the email.")
+	}
+}
+
+// TestSecurityNotificationEmail ensures that we describe each security event
+// correctly.
+func TestSecurityNotificationEmail(t *testing.T) {
+	tests := []struct {
+		event   string
+		subject string
+	}{
+		{event: SecurityEventPasswordChanged, subject: "Your password was changed"},
+		{event: SecurityEventPubKeyAdded, subject: "A new login key was added to your account"},
+		{event: SecurityEventPubKeyRemoved, subject: "A login key was removed from your account"},
+		{event: SecurityEventAPIKeyCreated, subject: "A new API key was created for your account"},
+		{event: SecurityEventPasskeyAdded, subject: "A new passkey was added to your account"},
+		{event: SecurityEventPasskeyRemoved, subject: "A passkey was removed from your account"},
+	}
+	for _, tt := range tests {
+		em, err := Mailer{}.securityNotificationEmail(context.Background(), "user@siasky.net", "", tt.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if em.Subject != tt.subject {
+			t.Errorf("Expected subject '%s' for event '%s', got '%s'", tt.subject, tt.event, em.Subject)
+		}
+		if em.Template != TemplateSecurityNotification {
+			t.Errorf("Expected template '%s', got '%s'", TemplateSecurityNotification, em.Template)
+		}
+	}
+}
+
+// TestLocalizedTemplates ensures that we pick the localized templates which
+// best match the user's locale and fall back to English.
+func TestLocalizedTemplates(t *testing.T) {
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{locale: "", subject: "Please verify your email address"},
+		{locale: "es", subject: "Por favor, verifica tu dirección de correo electrónico"},
+		{locale: "es-MX", subject: "Por favor, verifica tu dirección de correo electrónico"},
+		{locale: "de-AT", subject: "Bitte bestätigen Sie Ihre E-Mail-Adresse"},
+		{locale: "fr", subject: "Please verify your email address"},
+		{locale: "invalid locale", subject: "Please verify your email address"},
+	}
+	for _, tt := range tests {
+		em, err := Mailer{}.confirmEmailEmail(context.Background(), "user@siasky.net", tt.locale, "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if em.Subject != tt.subject {
+			t.Errorf("Expected subject '%s' for locale '%s', got '%s'", tt.subject, tt.locale, em.Subject)
+		}
+	}
+	// Make sure the localized layout is used as well.
+	em, err := Mailer{}.recoverAccountEmail(context.Background(), "user@siasky.net", "de", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, html := emailParts(t, em)
+	if !strings.Contains(html, "Ihr Skynet-Team") {
+		t.Fatal("Expected the German footer in the HTML part.")
+	}
+}
+
+// TestTemplateOverrides ensures that operators can override the templates and
+// the branding from TemplatesDir.
+func TestTemplateOverrides(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d string) { TemplatesDir = d }(TemplatesDir)
+	TemplatesDir = dir
+
+	err := os.WriteFile(filepath.Join(dir, TemplateConfirmEmail+".subject"), []byte("Welcome to {{.Branding.PortalName}}"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branding := `{"portalName": "My Portal", "logoUrl": "https://example.com/logo.png"}`
+	err = os.WriteFile(filepath.Join(dir, brandingFile), []byte(branding), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	em, err := Mailer{}.confirmEmailEmail(context.Background(), "user@siasky.net", "", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if em.Subject != "Welcome to My Portal" {
+		t.Fatalf("Unexpected subject '%s'", em.Subject)
+	}
+	_, html := emailParts(t, em)
+	if !strings.Contains(html, `src="https://example.com/logo.png"`) {
+		t.Fatal("Expected the custom logo in the HTML part.")
+	}
+	// Fields which are not overridden keep their default values.
+	if !strings.Contains(html, DefaultBranding.PrimaryColor) {
+		t.Fatal("Expected the default primary color in the HTML part.")
+	}
+}
+
+// TestMultipartAlternative ensures that multipartAlternative generates a new
+// boundary for each message and properly encodes long lines.
+func TestMultipartAlternative(t *testing.T) {
+	longLine := strings.Repeat("a=b ", 50)
+	body1, mime1, err := multipartAlternative(longLine, "<p>"+longLine+"</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, mime2, err := multipartAlternative(longLine, longLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mime1 == mime2 {
+		t.Fatal("Expected different boundaries.")
+	}
+	for _, line := range strings.Split(body1, "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("Line too long (%d): %s", len(line), line)
+		}
+	}
+	text, _ := emailParts(t, &database.EmailMessage{Body: body1, BodyMime: mime1})
+	if text != longLine {
+		t.Fatalf("Expected '%s', got '%s'", longLine, text)
+	}
+}
+
+// emailParts decodes the plain text and HTML parts of the given email.
+func emailParts(t *testing.T, em *database.EmailMessage) (text string, html string) {
+	mediaType, params, err := mime.ParseMediaType(em.BodyMime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected media type '%s'", mediaType)
+	}
+	r := multipart.NewReader(strings.NewReader(em.Body), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return text, html
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart decodes quoted-printable parts transparently.
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(p.Header.Get("Content-Type"), "text/html") {
+			html = string(b)
+		} else {
+			text = string(b)
+		}
+	}
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SkynetLabs/skynet-accounts/api"
 	"github.com/SkynetLabs/skynet-accounts/build"
@@ -40,6 +41,21 @@ const (
 	// envEmailFrom holds the name of the environment variable that allows us to
 	// override the "from" address of our emails to users.
 	envEmailFrom = "ACCOUNTS_EMAIL_FROM"
+	// envEmailEventsToken holds the name of the environment variable which
+	// holds the token email providers use to report bounces and complaints.
+	envEmailEventsToken = "ACCOUNTS_EMAIL_EVENTS_TOKEN" // #nosec
+	// envEmailMaxAttempts holds the name of the environment variable which
+	// defines how many times we try to send an email before giving up on it.
+	envEmailMaxAttempts = "ACCOUNTS_EMAIL_MAX_ATTEMPTS"
+	// envEmailRetryBackoff holds the name of the environment variable which
+	// defines how long we wait before retrying to send a failed email.
+	envEmailRetryBackoff = "ACCOUNTS_EMAIL_RETRY_BACKOFF"
+	// envEmailRetryBackoffMax holds the name of the environment variable
+	// which caps the time we wait between two attempts at sending an email.
+	envEmailRetryBackoffMax = "ACCOUNTS_EMAIL_RETRY_BACKOFF_MAX"
+	// envEmailTemplatesDir holds the name of the environment variable which
+	// points to a directory with overrides of the default email templates.
+	envEmailTemplatesDir = "ACCOUNTS_EMAIL_TEMPLATES_DIR"
 	// envEmailURI holds the name of the environment variable for email URI.
 	envEmailURI = "ACCOUNTS_EMAIL_URI"
 	// envLogLevel holds the name of the environment variable which defines the
@@ -56,6 +72,10 @@ const (
 	// envServerDomain holds the name of the environment variable for the
 	// identity of this server. Example: eu-ger-1.siasky.net
 	envServerDomain = "SERVER_DOMAIN"
+	// envTrustedOrigins holds the name of the environment variable which
+	// lists additional origins from which we accept passkey credentials and
+	// challenge responses, separated by commas. Example: https://dashboard.siasky.net
+	envTrustedOrigins = "ACCOUNTS_TRUSTED_ORIGINS"
 	// envStripeAPIKey hold the name of the environment variable for Stripe's
 	// API key. It's only required when integrating with Stripe.
 	envStripeAPIKey = "STRIPE_API_KEY" // #nosec
@@ -73,6 +93,7 @@ type (
 		DBCreds               database.DBCredentials
 		PortalName            string
 		PortalAddressAccounts string
+		TrustedOrigins        []string
 		Promoter              string
 		ServerLockID          string
 		StripeKey             string
@@ -80,6 +101,11 @@ type (
 		JWTTTL                int
 		EmailURI              string
 		EmailFrom             string
+		EmailTemplatesDir     string
+		EmailEventsToken      string
+		EmailMaxAttempts      int
+		EmailRetryBackoff     time.Duration
+		EmailRetryBackoffMax  time.Duration
 		MaxAPIKeys            int
 	}
 )
@@ -139,6 +165,17 @@ func parseConfiguration(logger *logrus.Logger) (ServiceConfig, error) {
 	}
 	config.PortalName = "https://" + portal
 	config.PortalAddressAccounts = "https://account." + portal
+	config.TrustedOrigins = []string{config.PortalAddressAccounts}
+	if str := os.Getenv(envTrustedOrigins); str != "" {
+		for _, o := range strings.Split(str, ",") {
+			o = strings.TrimSpace(o)
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme != "https" || u.Host == "" || u.Path != "" {
+				return ServiceConfig{}, fmt.Errorf("invalid origin '%s' in env var %s", o, envTrustedOrigins)
+			}
+			config.TrustedOrigins = append(config.TrustedOrigins, o)
+		}
+	}
 
 	config.Promoter = api.PromoterStripe
 	if val, ok := os.LookupEnv(envPromoter); ok {
@@ -192,13 +229,14 @@ func parseConfiguration(logger *logrus.Logger) (ServiceConfig, error) {
 			return ServiceConfig{}, email.ErrInvalidEmailConfiguration
 		}
 		// Validate the given URI.
-		uri, err := url.Parse(config.EmailURI)
-		if err != nil || uri.Host == "" || uri.User == nil {
-			return ServiceConfig{}, email.ErrInvalidEmailConfiguration
+		if _, err := email.NewTransport(config.EmailURI); err != nil {
+			return ServiceConfig{}, err
 		}
-		// Set the FROM address to outgoing emails. This can be overridden by
-		// the ACCOUNTS_EMAIL_FROM optional environment variable.
-		if uri.User != nil {
+		// Set the FROM address to outgoing emails. For SMTP it defaults to
+		// the user we log in as. This can be overridden by the
+		// ACCOUNTS_EMAIL_FROM optional environment variable.
+		uri, err := url.Parse(config.EmailURI)
+		if err == nil && strings.HasPrefix(uri.Scheme, "smtp") && uri.User != nil {
 			config.EmailFrom = uri.User.Username()
 		}
 		if emailFrom := os.Getenv(envEmailFrom); emailFrom != "" {
@@ -208,6 +246,36 @@ func parseConfiguration(logger *logrus.Logger) (ServiceConfig, error) {
 		if config.EmailFrom == "" {
 			config.EmailFrom = email.From
 		}
+		config.EmailTemplatesDir = os.Getenv(envEmailTemplatesDir)
+		config.EmailEventsToken = os.Getenv(envEmailEventsToken)
+	}
+	// Fetch the configuration for retrying failed emails.
+	config.EmailMaxAttempts = database.EmailMaxSendAttempts
+	if str := os.Getenv(envEmailMaxAttempts); str != "" {
+		maxAttempts, err := strconv.Atoi(str)
+		if err != nil || maxAttempts <= 0 {
+			return ServiceConfig{}, fmt.Errorf("invalid value for env var %s: '%s', must be a positive integer", envEmailMaxAttempts, str)
+		}
+		config.EmailMaxAttempts = maxAttempts
+	}
+	config.EmailRetryBackoff = database.EmailRetryBackoff
+	if str := os.Getenv(envEmailRetryBackoff); str != "" {
+		backoff, err := time.ParseDuration(str)
+		if err != nil || backoff <= 0 {
+			return ServiceConfig{}, fmt.Errorf("invalid value for env var %s: '%s', must be a positive duration, e.g. '1m'", envEmailRetryBackoff, str)
+		}
+		config.EmailRetryBackoff = backoff
+	}
+	config.EmailRetryBackoffMax = database.EmailRetryBackoffMax
+	if str := os.Getenv(envEmailRetryBackoffMax); str != "" {
+		backoff, err := time.ParseDuration(str)
+		if err != nil || backoff <= 0 {
+			return ServiceConfig{}, fmt.Errorf("invalid value for env var %s: '%s', must be a positive duration, e.g. '6h'", envEmailRetryBackoffMax, str)
+		}
+		config.EmailRetryBackoffMax = backoff
+	}
+	if config.EmailRetryBackoffMax < config.EmailRetryBackoff {
+		return ServiceConfig{}, fmt.Errorf("%s must not be shorter than %s", envEmailRetryBackoffMax, envEmailRetryBackoff)
 	}
 	// Fetch the configuration for maximum number of API keys allowed per user.
 	if maxAPIKeysStr, exists := os.LookupEnv(envMaxNumAPIKeysPerUser); exists {
@@ -244,6 +312,7 @@ func main() {
 		log.Fatal(err)
 	}
 	database.PortalName = config.PortalName
+	database.TrustedOrigins = config.TrustedOrigins
 	jwt.PortalName = config.PortalName
 	email.PortalAddressAccounts = config.PortalAddressAccounts
 	api.DashboardURL = config.PortalAddressAccounts
@@ -252,6 +321,11 @@ func main() {
 	jwt.AccountsJWKSFile = config.JWKSFile
 	jwt.TTL = config.JWTTTL
 	email.From = config.EmailFrom
+	email.TemplatesDir = config.EmailTemplatesDir
+	api.EmailEventsToken = config.EmailEventsToken
+	database.EmailMaxSendAttempts = config.EmailMaxAttempts
+	database.EmailRetryBackoff = config.EmailRetryBackoff
+	database.EmailRetryBackoffMax = config.EmailRetryBackoffMax
 	database.MaxNumAPIKeysPerUser = config.MaxAPIKeys
 
 	// Set up key components:
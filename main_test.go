@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/email"
@@ -30,7 +31,11 @@ func TestParseConfiguration(t *testing.T) {
 			envJWTTTL,
 			envEmailURI,
 			envEmailFrom,
+			envEmailMaxAttempts,
+			envEmailRetryBackoff,
+			envEmailRetryBackoffMax,
 			envMaxNumAPIKeysPerUser,
+			envTrustedOrigins,
 		}
 		values := make(map[string]string)
 		for _, k := range keys {
@@ -163,6 +168,59 @@ func TestParseConfiguration(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// Invalid ACCOUNTS_EMAIL_MAX_ATTEMPTS
+	err = os.Setenv(envEmailMaxAttempts, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseConfiguration(logger)
+	if err == nil || !strings.Contains(err.Error(), "invalid value for env var "+envEmailMaxAttempts) {
+		t.Fatal("Failed to error out on invalid", envEmailMaxAttempts)
+	}
+	err = os.Unsetenv(envEmailMaxAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Invalid ACCOUNTS_EMAIL_RETRY_BACKOFF
+	err = os.Setenv(envEmailRetryBackoff, "one minute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseConfiguration(logger)
+	if err == nil || !strings.Contains(err.Error(), "invalid value for env var "+envEmailRetryBackoff) {
+		t.Fatal("Failed to error out on invalid", envEmailRetryBackoff)
+	}
+	// ACCOUNTS_EMAIL_RETRY_BACKOFF_MAX shorter than ACCOUNTS_EMAIL_RETRY_BACKOFF
+	err = os.Setenv(envEmailRetryBackoff, "2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Setenv(envEmailRetryBackoffMax, "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseConfiguration(logger)
+	if err == nil || !strings.Contains(err.Error(), "must not be shorter than") {
+		t.Fatal("Failed to error out on backoff max shorter than backoff")
+	}
+	err = errors.Compose(os.Unsetenv(envEmailRetryBackoff), os.Unsetenv(envEmailRetryBackoffMax))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Trusted origins need to be HTTPS origins without a path.
+	err = os.Setenv(envTrustedOrigins, "https://dashboard.example.com, http://insecure.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseConfiguration(logger)
+	if err == nil || !strings.Contains(err.Error(), envTrustedOrigins) {
+		t.Fatal("Failed to error out on an invalid trusted origin")
+	}
+	err = os.Setenv(envTrustedOrigins, "https://dashboard.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
 	sk := "sk_live_THIS_IS_A_LIVE_KEY"
 	err = os.Setenv(envStripeAPIKey, sk)
 	if err != nil {
@@ -187,6 +245,9 @@ func TestParseConfiguration(t *testing.T) {
 	if config.PortalAddressAccounts != "https://account."+portal {
 		t.Fatalf("Expected %s, got %s", "https://accounts."+portal, config.PortalAddressAccounts)
 	}
+	if len(config.TrustedOrigins) != 2 || config.TrustedOrigins[0] != config.PortalAddressAccounts || config.TrustedOrigins[1] != "https://dashboard.example.com" {
+		t.Fatalf("Unexpected trusted origins %v", config.TrustedOrigins)
+	}
 	if config.StripeKey != sk {
 		t.Fatalf("Expected %s, got %s", sk, config.StripeKey)
 	}
@@ -202,6 +263,12 @@ func TestParseConfiguration(t *testing.T) {
 	if config.MaxAPIKeys != database.MaxNumAPIKeysPerUser {
 		t.Fatalf("Expected %d, got %d", database.MaxNumAPIKeysPerUser, config.MaxAPIKeys)
 	}
+	if config.EmailMaxAttempts != database.EmailMaxSendAttempts {
+		t.Fatalf("Expected %d, got %d", database.EmailMaxSendAttempts, config.EmailMaxAttempts)
+	}
+	if config.EmailRetryBackoff != database.EmailRetryBackoff || config.EmailRetryBackoffMax != database.EmailRetryBackoffMax {
+		t.Fatalf("Expected default backoff, got %v and %v", config.EmailRetryBackoff, config.EmailRetryBackoffMax)
+	}
 
 	// Set alternative config values and test their outcomes.
 
@@ -227,6 +294,23 @@ func TestParseConfiguration(t *testing.T) {
 	if config.MaxAPIKeys != maxKeys {
 		t.Fatalf("Expected %d, got %d", maxKeys, config.MaxAPIKeys)
 	}
+
+	// Custom email retry configuration.
+	err = errors.Compose(
+		os.Setenv(envEmailMaxAttempts, "5"),
+		os.Setenv(envEmailRetryBackoff, "30s"),
+		os.Setenv(envEmailRetryBackoffMax, "1h"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = parseConfiguration(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.EmailMaxAttempts != 5 || config.EmailRetryBackoff != 30*time.Second || config.EmailRetryBackoffMax != time.Hour {
+		t.Fatalf("Unexpected email retry configuration %d, %v, %v", config.EmailMaxAttempts, config.EmailRetryBackoff, config.EmailRetryBackoffMax)
+	}
 }
 
 // TestLoadDBCredentials ensures that we validate that all required environment
@@ -346,6 +346,8 @@ func testAPIKeysAcceptance(t *testing.T, at *test.AccountsTester) {
 		{verb: http.MethodPut, endpoint: "/user"},
 		{verb: http.MethodDelete, endpoint: "/user"},
 		{verb: http.MethodGet, endpoint: "/user/stats"},
+		{verb: http.MethodGet, endpoint: "/user/pubkeys"},
+		{verb: http.MethodPut, endpoint: "/user/pubkey/somePubKey"},
 		{verb: http.MethodDelete, endpoint: "/user/pubkey/somePubKey"},
 		{verb: http.MethodGet, endpoint: "/user/pubkey/register"},
 		{verb: http.MethodPost, endpoint: "/user/pubkey/register"},
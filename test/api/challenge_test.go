@@ -10,6 +10,7 @@ import (
 	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/test"
 	"github.com/SkynetLabs/skynet-accounts/types"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/NebulousLabs/fastrand"
 	"go.sia.tech/siad/crypto"
@@ -136,6 +137,175 @@ func testLogin(t *testing.T, at *test.AccountsTester) {
 	}
 }
 
+// testLoginSecp256k1 validates that wallet users can register and log in with
+// secp256k1 keys and EIP-191 signatures.
+func testLoginSecp256k1(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	sk, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := database.PubKey(sk.PubKey().SerializeUncompressed())
+
+	// Register a user with the wallet's key.
+	ch, _, err := at.RegisterGET(pk)
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err := hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response := append(chBytes, append([]byte(database.ChallengeTypeRegister), []byte(database.PortalName)...)...)
+	emailStr := types.NewEmail(name + "@siasky.net")
+	_, status, err := at.RegisterPOST(response, test.SignEIP191(sk, response), emailStr.String())
+	if err != nil {
+		t.Fatalf("Failed to register. Status %d, error '%s'", status, err)
+	}
+	u, err := at.DB.UserByPubKey(at.Ctx, pk)
+	if err != nil {
+		t.Fatal("Failed to fetch user from DB:", err)
+	}
+	defer func() {
+		if err = at.DB.UserDelete(at.Ctx, u); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+
+	// A signature by another key is rejected.
+	ch, _, err = at.LoginPubKeyGET(pk)
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err = hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response = append(chBytes, append([]byte(database.ChallengeTypeLogin), []byte(database.PortalName)...)...)
+	sk2, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := at.LoginPubKeyPOST(response, test.SignEIP191(sk2, response), emailStr.String())
+	if err == nil || r.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+	// Log in with the wallet's signature.
+	r, b, err := at.LoginPubKeyPOST(response, test.SignEIP191(sk, response), emailStr.String())
+	if err != nil {
+		t.Fatalf("Failed to login. Status %d, body '%s', error '%s'", r.StatusCode, string(b), err)
+	}
+	at.SetCookie(test.ExtractCookie(r))
+	defer at.ClearCredentials()
+	ug, _, err := at.UserGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ug.Email != emailStr {
+		t.Fatalf("Expected user with email %s, got %s", emailStr, ug.Email)
+	}
+}
+
+// testLoginOriginBound validates the login flow with version 2 challenge
+// responses, which are bound to the origin which requested them, and that the
+// portal can stop accepting version 1 responses.
+func testLoginOriginBound(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	sk, pkk := crypto.GenerateKeyPair()
+	pk := database.PubKey(pkk[:])
+	origin := database.TrustedOrigins[0]
+
+	// Register a user with a version 2 response.
+	ch, _, err := at.RegisterGET(pk)
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	if ch.Type != database.ChallengeTypeRegister || ch.ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected challenge %+v", ch)
+	}
+	chBytes, err := hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response := database.ChallengeResponseV2Message(chBytes, ch.Type, origin, ch.ExpiresAt)
+	emailStr := types.NewEmail(name + "@siasky.net")
+	_, status, err := at.RegisterPOST(response, ed25519.Sign(sk[:], response), emailStr.String())
+	if err != nil {
+		t.Fatalf("Failed to register. Status %d, error '%s'", status, err)
+	}
+	u, err := at.DB.UserByPubKey(at.Ctx, pk)
+	if err != nil {
+		t.Fatal("Failed to fetch user from DB:", err)
+	}
+	defer func() {
+		if err = at.DB.UserDelete(at.Ctx, u); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+
+	// A response relayed by another origin is rejected.
+	ch, _, err = at.LoginPubKeyGET(pk)
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err = hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response = database.ChallengeResponseV2Message(chBytes, ch.Type, origin, ch.ExpiresAt)
+	sig := ed25519.Sign(sk[:], response)
+	r, err := at.LoginPubKeyPOSTWithOrigin(response, sig, "https://evil.example.com")
+	if err == nil || r.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+	// So is a response requested by a skapp hosted on the portal.
+	skapp := "https://foo.hns.siasky.net"
+	relayed := database.ChallengeResponseV2Message(chBytes, ch.Type, skapp, ch.ExpiresAt)
+	r, err = at.LoginPubKeyPOSTWithOrigin(relayed, ed25519.Sign(sk[:], relayed), skapp)
+	if err == nil || r.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+	// So is a response signed for another portal.
+	other := database.ChallengeResponseV2Message(chBytes, ch.Type, "https://account.skynetfree.net", ch.ExpiresAt)
+	r, err = at.LoginPubKeyPOSTWithOrigin(other, ed25519.Sign(sk[:], other), "https://account.skynetfree.net")
+	if err == nil || r.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+	r, err = at.LoginPubKeyPOSTWithOrigin(response, sig, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.ExtractCookie(r) == nil {
+		t.Fatal("Expected a login cookie.")
+	}
+
+	// Once the portal stops accepting version 1 responses, old clients can't
+	// log in anymore.
+	_, err = at.DB.SettingSet(at.Ctx, database.ConfValLegacyChallengesDisabled, true, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_, err = at.DB.SettingSet(at.Ctx, database.ConfValLegacyChallengesDisabled, false, t.Name())
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	ch, _, err = at.LoginPubKeyGET(pk)
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err = hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response = append(chBytes, append([]byte(database.ChallengeTypeLogin), []byte(database.PortalName)...)...)
+	r, _, err = at.LoginPubKeyPOST(response, ed25519.Sign(sk[:], response), emailStr.String())
+	if err == nil || r.StatusCode != http.StatusUnauthorized || !strings.Contains(err.Error(), database.ErrLegacyChallengeResponse.Error()) {
+		t.Fatalf("Expected %d and '%v', got %d and '%v'", http.StatusUnauthorized, database.ErrLegacyChallengeResponse, r.StatusCode, err)
+	}
+}
+
 // testUserAddPubKey tests the ability of update user's pubKey.
 func testUserAddPubKey(t *testing.T, at *test.AccountsTester) {
 	name := test.DBNameForTest(t.Name())
@@ -322,3 +492,156 @@ func testUserDeletePubKey(t *testing.T, at *test.AccountsTester) {
 		t.Fatalf("Expected to fail with 400. Status %d, error '%s'", status, err)
 	}
 }
+
+// testUserPubKeys ensures that users can list and label their pubkeys and that
+// users without a password or a confirmed email can't remove their last pubkey.
+func testUserPubKeys(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	sk, pk := crypto.GenerateKeyPair()
+
+	// Register a user without a password.
+	ch, _, err := at.RegisterGET(pk[:])
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err := hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response := append(chBytes, append([]byte(database.ChallengeTypeRegister), []byte(database.PortalName)...)...)
+	emailStr := types.NewEmail(name + "@siasky.net")
+	_, status, err := at.RegisterPOST(response, ed25519.Sign(sk[:], response), emailStr.String())
+	if err != nil {
+		t.Fatalf("Failed to register. Status %d, error '%s'", status, err)
+	}
+	u, err := at.DB.UserByPubKey(at.Ctx, pk[:])
+	if err != nil {
+		t.Fatal("Failed to fetch user from DB:", err)
+	}
+	defer func() {
+		if err = at.DB.UserDelete(at.Ctx, u); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+
+	// Log in with the pubkey.
+	ch, _, err = at.LoginPubKeyGET(pk[:])
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err = hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response = append(chBytes, append([]byte(database.ChallengeTypeLogin), []byte(database.PortalName)...)...)
+	r, b, err := at.LoginPubKeyPOST(response, ed25519.Sign(sk[:], response), emailStr.String())
+	if err != nil {
+		t.Fatalf("Failed to login. Status %d, body '%s', error '%s'", r.StatusCode, string(b), err)
+	}
+	at.SetCookie(test.ExtractCookie(r))
+	defer at.ClearCredentials()
+
+	// The pubkey is listed with its creation and last login times.
+	pks, _, err := at.UserPubkeysGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pks.Items) != 1 {
+		t.Fatalf("Expected one pubkey, got %d", len(pks.Items))
+	}
+	pkg := pks.Items[0]
+	if pkg.Key != hex.EncodeToString(pk[:]) || pkg.Type != database.PubKeyTypeEd25519 {
+		t.Fatalf("Unexpected pubkey %+v", pkg)
+	}
+	if pkg.CreatedAt.IsZero() || pkg.LastLoginAt.IsZero() {
+		t.Fatalf("Expected creation and last login times, got %+v", pkg)
+	}
+
+	// The user can't remove their only login method.
+	status, err = at.UserPubkeyDELETE(pk[:])
+	if err == nil || status != http.StatusBadRequest || !strings.Contains(err.Error(), database.ErrLastLoginMethod.Error()) {
+		t.Fatalf("Expected %d '%s', got %d '%v'", http.StatusBadRequest, database.ErrLastLoginMethod, status, err)
+	}
+
+	// Label the pubkey.
+	status, err = at.UserPubkeyPUT(pk[:], strings.Repeat("a", 65))
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusBadRequest, status, err)
+	}
+	// The limit is in characters, not bytes.
+	status, err = at.UserPubkeyPUT(pk[:], strings.Repeat("ü", 64))
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusNoContent, status, err)
+	}
+	status, err = at.UserPubkeyPUT(pk[:], " laptop ")
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusNoContent, status, err)
+	}
+	_, pk2 := crypto.GenerateKeyPair()
+	status, err = at.UserPubkeyPUT(pk2[:], "other")
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusBadRequest, status, err)
+	}
+
+	// Add a second pubkey with a label.
+	sk3, pk3 := crypto.GenerateKeyPair()
+	ch, _, err = at.UserPubkeyRegisterGET(hex.EncodeToString(pk3[:]))
+	if err != nil {
+		t.Fatal("Failed to get a challenge:", err)
+	}
+	chBytes, err = hex.DecodeString(ch.Challenge)
+	if err != nil {
+		t.Fatal("Invalid challenge:", err)
+	}
+	response = append(chBytes, append([]byte(database.ChallengeTypeUpdate), []byte(database.PortalName)...)...)
+	_, status, err = at.UserPubkeyRegisterPOSTWithLabel(response, ed25519.Sign(sk3[:], response), "phone")
+	if err != nil {
+		t.Fatalf("Failed to add a pubkey. Status %d, error '%s'", status, err)
+	}
+	pks, _, err = at.UserPubkeysGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pks.Items) != 2 {
+		t.Fatalf("Expected two pubkeys, got %d", len(pks.Items))
+	}
+	if pks.Items[0].Label != "laptop" || pks.Items[1].Label != "phone" {
+		t.Fatalf("Unexpected labels '%s' and '%s'", pks.Items[0].Label, pks.Items[1].Label)
+	}
+	if pks.Items[1].CreatedAt.IsZero() || !pks.Items[1].LastLoginAt.IsZero() {
+		t.Fatalf("Unexpected times %+v", pks.Items[1])
+	}
+
+	// Now the user can remove the first pubkey but not the second.
+	status, err = at.UserPubkeyDELETE(pk[:])
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusNoContent, status, err)
+	}
+	status, err = at.UserPubkeyDELETE(pk3[:])
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusBadRequest, status, err)
+	}
+	pks, _, err = at.UserPubkeysGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pks.Items) != 1 || pks.Items[0].Key != hex.EncodeToString(pk3[:]) {
+		t.Fatalf("Unexpected pubkeys %+v", pks.Items)
+	}
+
+	// Once the user confirms their email they can log in with an email link,
+	// so they can remove their last pubkey.
+	u, err = at.DB.UserByID(at.Ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.EmailUnconfirmed = false
+	err = at.DB.UserSave(at.Ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = at.UserPubkeyDELETE(pk3[:])
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d '%v'", http.StatusNoContent, status, err)
+	}
+}
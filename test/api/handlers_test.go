@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -65,15 +66,26 @@ func TestHandlers(t *testing.T) {
 		{name: "UserEdit", test: testUserPUT},
 		{name: "UserAddPubKey", test: testUserAddPubKey},
 		{name: "DeletePubKey", test: testUserDeletePubKey},
+		{name: "UserPubKeys", test: testUserPubKeys},
 		{name: "UserDelete", test: testUserDELETE},
 		{name: "UserLimits", test: testUserLimits},
 		{name: "UserDeleteUploads", test: testUserUploadsDELETE},
 		{name: "UserConfirmReconfirmEmail", test: testUserConfirmReconfirmEmailGET},
 		{name: "UserAccountRecovery", test: testUserAccountRecovery},
+		{name: "UserSecurityNotifications", test: testUserSecurityNotifications},
+		{name: "EmailConfirmationPolicy", test: testEmailConfirmationPolicy},
+		{name: "InviteOnlyRegistration", test: testInviteOnlyRegistration},
+		{name: "EmailDomainPolicy", test: testEmailDomainPolicy},
+		{name: "UserMerge", test: testUserMerge},
+		{name: "Passkeys", test: testPasskeys},
+		{name: "LoginEmail", test: testLoginEmail},
 		{name: "StandardTrackingFlow", test: testTrackingAndStats},
+		{name: "UserStatsHistory", test: testUserStatsHistory},
 		{name: "StandardUserFlow", test: testUserFlow},
 		{name: "Challenge-Response/Registration", test: testRegistration},
 		{name: "Challenge-Response/Login", test: testLogin},
+		{name: "Challenge-Response/LoginSecp256k1", test: testLoginSecp256k1},
+		{name: "Challenge-Response/LoginOriginBound", test: testLoginOriginBound},
 		{name: "PrivateAPIKeysFlow", test: testPrivateAPIKeysFlow},
 		{name: "PrivateAPIKeysUsage", test: testPrivateAPIKeysUsage},
 		{name: "PublicAPIKeysFlow", test: testPublicAPIKeysFlow},
@@ -331,18 +343,18 @@ func testUserPUT(t *testing.T, at *test.AccountsTester) {
 	if err != nil || status != http.StatusOK {
 		t.Fatal(status, err)
 	}
-	// Fetch the user from the DB because we want to be sure that their email
-	// is marked as unconfirmed which is not reflected in the JSON
-	// representation of the object.
-	u3, err := at.DB.UserByEmail(at.Ctx, emailAddr)
+	// Fetch the user from the DB because we want to be sure that the change
+	// is pending which is not fully reflected in the JSON representation of
+	// the object.
+	u3, err := at.DB.UserByID(at.Ctx, u.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u3.Email != emailAddr {
-		t.Fatalf("Expected the user to have email %s, got %s", emailAddr, u3.Email)
+	if u3.Email != u.Email {
+		t.Fatalf("Expected the user to keep email %s until the new one is confirmed, got %s", u.Email, u3.Email)
 	}
-	if u3.EmailConfirmationToken == "" {
-		t.Fatalf("Expected the user to have a non-empty confirmation token, got '%s'", u3.EmailConfirmationToken)
+	if u3.PendingEmail != emailAddr {
+		t.Fatalf("Expected the user to have pending email %s, got %s", emailAddr, u3.PendingEmail)
 	}
 	// Expect to find a confirmation email queued for sending.
 	filer := bson.M{"to": emailAddr.String()}
@@ -353,6 +365,22 @@ func testUserPUT(t *testing.T, at *test.AccountsTester) {
 	if len(msgs) != 1 || msgs[0].Subject != "Please verify your email address" {
 		t.Fatal("Expected to find a single confirmation email but didn't.")
 	}
+	// Confirm the new address and expect the user to switch to it.
+	tk, err := test.EmailToken(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmGET(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u3, err = at.DB.UserByEmail(at.Ctx, emailAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u3.ID != u.ID || u3.PendingEmail != "" || u3.EmailUnconfirmed {
+		t.Fatalf("Expected the user to switch to confirmed email %s, got %+v", emailAddr, u3)
+	}
 	// Update the user's email to a mixed-case string, expect it to be persisted
 	// as lowercase only.
 	emailStr := name + "_ThIsIsMiXeDcAsE@siasky.net"
@@ -360,9 +388,7 @@ func testUserPUT(t *testing.T, at *test.AccountsTester) {
 	if err != nil || status != http.StatusOK {
 		t.Fatal(status, err)
 	}
-	// Fetch the user by the mixed-case email. Expect this to succeed because we
-	// cast the email to lowercase in the UserPUT handler.
-	u4, err := at.DB.UserByEmail(at.Ctx, types.NewEmail(emailStr))
+	u4, err := at.DB.UserByID(at.Ctx, u.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -370,8 +396,56 @@ func testUserPUT(t *testing.T, at *test.AccountsTester) {
 	// because that will cast it to lowercase even if it's not.
 	// We disable gocritic here, so it doesn't suggest to use strings.EqualFold().
 	//nolint:gocritic
-	if string(u4.Email) != strings.ToLower(emailStr) {
-		t.Fatalf("Expected the email to be '%s', got '%s", strings.ToLower(emailStr), u4.Email)
+	if string(u4.PendingEmail) != strings.ToLower(emailStr) {
+		t.Fatalf("Expected the pending email to be '%s', got '%s", strings.ToLower(emailStr), u4.PendingEmail)
+	}
+	// Setting the current email again discards the pending change.
+	_, status, err = at.UserPUT(emailAddr.String(), "", "")
+	if err != nil || status != http.StatusOK {
+		t.Fatal(status, err)
+	}
+	u4, err = at.DB.UserByID(at.Ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u4.PendingEmail != "" || u4.Email != emailAddr {
+		t.Fatalf("Expected the pending change to be discarded, got email %s and pending email %s", u4.Email, u4.PendingEmail)
+	}
+
+	// Set an invalid locale.
+	b, err := json.Marshal(map[string]string{"locale": "not a locale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := at.Request(http.MethodPut, "/user", nil, b, nil, nil)
+	if err == nil || r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d and error '%v'", r.StatusCode, err)
+	}
+	// Set the user's locale. Expect it to be normalized.
+	b, err = json.Marshal(map[string]string{"locale": "es-es"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u5 api.UserGET
+	_, err = at.Request(http.MethodPut, "/user", nil, b, nil, &u5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u5.Locale != "es-ES" {
+		t.Fatalf("Expected locale 'es-ES', got '%s'", u5.Locale)
+	}
+	// Expect the confirmation email for the next email change to be localized.
+	emailAddr = types.NewEmail(name + "_es@siasky.net")
+	_, status, err = at.UserPUT(emailAddr.String(), "", "")
+	if err != nil || status != http.StatusOK {
+		t.Fatal(status, err)
+	}
+	_, msgs, err = at.DB.FindEmails(at.Ctx, bson.M{"to": emailAddr.String()}, &options.FindOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Subject != "Por favor, verifica tu dirección de correo electrónico" {
+		t.Fatal("Expected to find a single localized confirmation email but didn't.")
 	}
 }
 
@@ -675,7 +749,16 @@ func testUserConfirmReconfirmEmailGET(t *testing.T, at *test.AccountsTester) {
 	defer at.ClearCredentials()
 
 	// Confirm the user
-	_, err = at.UserConfirmGET(u.EmailConfirmationToken)
+	filter := bson.M{"to": u.Email.String()}
+	_, msgs, err := at.DB.FindEmails(at.Ctx, filter, &options.FindOptions{})
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Expected to find a single confirmation email, got %d and '%v'", len(msgs), err)
+	}
+	tk, err := test.EmailToken(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmGET(tk)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -684,9 +767,14 @@ func testUserConfirmReconfirmEmailGET(t *testing.T, at *test.AccountsTester) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u2.EmailConfirmationToken != "" {
+	if u2.EmailUnconfirmed {
 		t.Fatal("User's email is not confirmed.")
 	}
+	// The token can only be used once.
+	_, err = at.UserConfirmGET(tk)
+	if err == nil || !strings.Contains(err.Error(), badRequest) {
+		t.Fatalf("Expected '%s', got '%s'", badRequest, err)
+	}
 
 	// Make sure `POST /user/reconfirm` requires a cookie.
 	at.ClearCredentials()
@@ -706,9 +794,30 @@ func testUserConfirmReconfirmEmailGET(t *testing.T, at *test.AccountsTester) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u3.EmailConfirmationToken == "" {
+	if !u3.EmailUnconfirmed {
 		t.Fatal("User is still confirmed.")
 	}
+	// Confirm the user via POST, using the token from the new email.
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	_, msgs, err = at.DB.FindEmails(at.Ctx, filter, opts)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("Expected to find two confirmation emails, got %d and '%v'", len(msgs), err)
+	}
+	tk, err = test.EmailToken(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmPOST(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u3, err = at.DB.UserByEmail(at.Ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u3.EmailUnconfirmed {
+		t.Fatal("User's email is not confirmed.")
+	}
 
 	// Call the endpoint without a token.
 	_, err = at.UserConfirmGET("")
@@ -721,12 +830,11 @@ func testUserConfirmReconfirmEmailGET(t *testing.T, at *test.AccountsTester) {
 		t.Fatalf("Expected '%s', got '%s'", badRequest, err)
 	}
 	// Call the endpoint with an expired token.
-	u.EmailConfirmationTokenExpiration = time.Now().Add(-time.Hour).UTC()
-	err = at.DB.UserSave(at.Ctx, u.User)
+	tk, err = at.DB.UserTokenCreate(at.Ctx, u.ID, database.TokenPurposeEmailConfirmation, u.Email, -time.Hour)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = at.UserConfirmGET(u.EmailConfirmationToken)
+	_, err = at.UserConfirmGET(tk)
 	if err == nil || !strings.Contains(err.Error(), badRequest) {
 		t.Fatalf("Expected '%s', got '%s'", badRequest, err)
 	}
@@ -795,8 +903,12 @@ func testUserAccountRecovery(t *testing.T, at *test.AccountsTester) {
 		t.Fatalf("Expected to find a single email with subject '%s', got %v", "Recover access to your account", len(msgs))
 	}
 	// Scan the message body for the recovery link.
-	linkPattern := regexp.MustCompile("<a\\shref=\"(?P<recEndpoint>.*?)\\?token=(?P<token>.*?)\">")
-	match := linkPattern.FindStringSubmatch(msgs[0].Body)
+	text, err := test.EmailTextPart(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkPattern := regexp.MustCompile(`(?P<recEndpoint>https?://\S*?)\?token=(?P<token>\S+)`)
+	match := linkPattern.FindStringSubmatch(text)
 	if len(match) != 3 {
 		t.Fatalf("Expected to get %d matches, got %d", 3, len(match))
 	}
@@ -855,14 +967,6 @@ func testUserAccountRecovery(t *testing.T, at *test.AccountsTester) {
 	if err != nil {
 		t.Fatal(err, string(b))
 	}
-	// Make sure the reset token is removed from the user.
-	u2, err := at.DB.UserByEmail(at.Ctx, u.Email)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if u2.RecoveryToken != "" {
-		t.Fatalf("Expected recovery token to be empty, got '%s'", u2.RecoveryToken)
-	}
 	// Make extra sure we cannot sue the token again. This is only to make sure
 	// we didn't cache it anywhere or allow it to somehow linger somewhere.
 	_, err = at.UserRecoverPOST(token, newPassword, newPassword)
@@ -1022,6 +1126,19 @@ func testUserFlow(t *testing.T, at *test.AccountsTester) {
 	if err != nil {
 		t.Fatalf("Failed to update user. Error: %s", err.Error())
 	}
+	// Confirm the new email, so the change takes effect.
+	pu, err := at.DB.UserByID(at.Ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctk, err := at.DB.UserCreateEmailChange(at.Ctx, u.ID, pu.PendingEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmGET(ctk)
+	if err != nil {
+		t.Fatal(err)
+	}
 	// Grab the new cookie. It has changed because of the user edit.
 	at.ClearCredentials()
 	r, _, err = at.LoginCredentialsPOST(newEmail.String(), password)
@@ -1052,3 +1169,233 @@ func testUserFlow(t *testing.T, at *test.AccountsTester) {
 		t.Fatalf("Expected to get %s, got %s.", unauthorized, err)
 	}
 }
+
+// testUserSecurityNotifications ensures that we notify users about sensitive
+// changes of their accounts and that they can lock their accounts when their
+// email address is changed without their consent.
+func testUserSecurityNotifications(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	u, c, err := test.CreateUserAndLogin(at, name)
+	if err != nil {
+		t.Fatal("Failed to create a user and log in:", err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	defer at.ClearCredentials()
+	at.SetCookie(c)
+	oldEmail := u.Email
+
+	// Change the password and expect a notification.
+	_, _, err = at.UserPUT("", hex.EncodeToString(fastrand.Bytes(16)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.DB.EmailLatest(at.Ctx, oldEmail, email.TemplateSecurityNotification)
+	if err != nil {
+		t.Fatal("Expected a security notification, got", err)
+	}
+
+	// Change the email address. Expect a notification with a revert link at
+	// the old address. Confirm the change, so we can test reverting it.
+	newEmail := types.NewEmail(name + "_new@siasky.net")
+	_, _, err = at.UserPUT(newEmail.String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := at.DB.EmailLatest(at.Ctx, oldEmail, email.TemplateEmailChanged)
+	if err != nil {
+		t.Fatal("Expected an email changed notification, got", err)
+	}
+	text, err := test.EmailTextPart(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := regexp.MustCompile(`/user/lock\?token=(\S+)`).FindStringSubmatch(text)
+	if len(match) != 2 {
+		t.Fatalf("Expected to find a lock link in '%s'", text)
+	}
+	pu, err := at.DB.UserByID(at.Ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := at.DB.UserCreateEmailChange(at.Ctx, u.ID, pu.PendingEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmGET(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An invalid token doesn't lock anything.
+	status, err := at.UserLockPOST("invalid")
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	// Lock the account.
+	at.ClearCredentials()
+	_, err = at.UserLockPOST(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old address is restored and the account is locked.
+	lu, err := at.DB.UserByID(at.Ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lu.Email != oldEmail || lu.LockedAt.IsZero() {
+		t.Fatalf("Expected the account to be locked with its old email restored, got %+v", lu)
+	}
+	// The token can only be used once.
+	_, err = at.UserLockPOST(match[1])
+	if err == nil {
+		t.Fatal("Expected the token to be invalid after use.")
+	}
+	// Existing sessions no longer work.
+	at.SetCookie(c)
+	_, status, err = at.UserGET()
+	if err == nil || status != http.StatusForbidden {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusForbidden, status, err)
+	}
+	at.ClearCredentials()
+	// Recovering the account unlocks it.
+	rm, err := at.DB.EmailLatest(at.Ctx, oldEmail, email.TemplateRecoverAccount)
+	if err != nil {
+		t.Fatal("Expected a recovery email, got", err)
+	}
+	rtk, err := test.EmailToken(rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPassword := hex.EncodeToString(fastrand.Bytes(16))
+	_, err = at.UserRecoverPOST(rtk, newPassword, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := at.LoginCredentialsPOST(oldEmail.String(), newPassword)
+	if err != nil {
+		t.Fatal(err, string(b))
+	}
+}
+
+// testEmailConfirmationPolicy ensures that the portal can restrict the actions
+// of users who haven't confirmed their email address.
+func testEmailConfirmationPolicy(t *testing.T, at *test.AccountsTester) {
+	u, c, err := test.CreateUserAndLogin(at, t.Name())
+	if err != nil {
+		t.Fatal("Failed to create a user and log in:", err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	defer at.ClearCredentials()
+	at.SetCookie(c)
+
+	// Without a policy unconfirmed users can create API keys.
+	_, _, err = at.UserAPIKeysPOST(api.APIKeyPOST{Name: "before"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Require a confirmed email for API keys and limits.
+	policy := database.ActionAPIKeys + "," + database.ActionLimits
+	_, err = at.DB.SettingSet(at.Ctx, database.ConfValEmailConfirmationRequired, policy, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_, err = at.DB.SettingSet(at.Ctx, database.ConfValEmailConfirmationRequired, "", t.Name())
+		if err != nil {
+			t.Error(errors.AddContext(err, "failed to reset the policy in defer"))
+		}
+	}()
+	_, status, err := at.UserAPIKeysPOST(api.APIKeyPOST{Name: "after"})
+	if status != http.StatusForbidden || err == nil || !strings.Contains(err.Error(), api.ErrCodeEmailNotConfirmed) {
+		t.Fatalf("Expected %d with code '%s', got %d and '%v'", http.StatusForbidden, api.ErrCodeEmailNotConfirmed, status, err)
+	}
+	// Unconfirmed users get the anonymous limits.
+	tl, _, err := at.UserLimits("byte", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl.TierName != database.UserLimits[database.TierAnonymous].TierName {
+		t.Fatalf("Expected anonymous limits, got tier '%s'", tl.TierName)
+	}
+	// Confirm the email and expect to be able to create API keys again.
+	tk, err := at.DB.UserCreateEmailConfirmation(at.Ctx, u.ID, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.UserConfirmGET(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = at.UserAPIKeysPOST(api.APIKeyPOST{Name: "confirmed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testEmailDomainPolicy ensures that the portal can restrict the email domains
+// with which users can register and to which they can switch.
+func testEmailDomainPolicy(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	defer at.ClearCredentials()
+	settings := map[string]interface{}{
+		database.ConfValEmailDomainsAllowed:    "siasky.net, example.com",
+		database.ConfValEmailDomainsDenied:     "blocked.siasky.net",
+		database.ConfValEmailDisposableBlocked: true,
+		database.ConfValEmailDisposableDomains: "throwaway.example.com",
+	}
+	for key, val := range settings {
+		if _, err := at.DB.SettingSet(at.Ctx, key, val, t.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defaults := map[string]interface{}{
+		database.ConfValEmailDomainsAllowed:    "",
+		database.ConfValEmailDomainsDenied:     "",
+		database.ConfValEmailDisposableBlocked: false,
+		database.ConfValEmailDisposableDomains: "",
+	}
+	defer func() {
+		for key, val := range defaults {
+			if _, err := at.DB.SettingSet(at.Ctx, key, val, t.Name()); err != nil {
+				t.Error(errors.AddContext(err, "failed to reset the policy in defer"))
+			}
+		}
+	}()
+
+	tests := []struct {
+		email  string
+		errMsg string
+	}{
+		{email: name + "@gmail.com", errMsg: database.ErrEmailDomainNotAllowed.Error()},
+		{email: name + "@blocked.siasky.net", errMsg: database.ErrEmailDomainDenied.Error()},
+		{email: name + "@throwaway.example.com", errMsg: database.ErrEmailDisposable.Error()},
+	}
+	for _, tt := range tests {
+		r, _, err := at.UserPOST(tt.email, name)
+		if r.StatusCode != http.StatusBadRequest || err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Fatalf("Expected %d with '%s' for %s, got %d and '%v'", http.StatusBadRequest, tt.errMsg, tt.email, r.StatusCode, err)
+		}
+	}
+	// Allowed addresses can register but can't switch to a blocked one.
+	u, c, err := test.CreateUserAndLogin(at, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	at.SetCookie(c)
+	_, status, err := at.UserPUT(name+"@blocked.siasky.net", "", "")
+	if status != http.StatusBadRequest || err == nil || !strings.Contains(err.Error(), database.ErrEmailDomainDenied.Error()) {
+		t.Fatalf("Expected %d with '%s', got %d and '%v'", http.StatusBadRequest, database.ErrEmailDomainDenied, status, err)
+	}
+}
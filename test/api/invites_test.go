@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/api"
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// testInviteOnlyRegistration ensures that new users need a valid invite code
+// while the portal is in invite-only mode and that users can invite others.
+func testInviteOnlyRegistration(t *testing.T, at *test.AccountsTester) {
+	name := test.DBNameForTest(t.Name())
+	defer at.ClearCredentials()
+
+	_, err := at.DB.SettingSet(at.Ctx, database.ConfValInvitesRequired, true, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_, err = at.DB.SettingSet(at.Ctx, database.ConfValInvitesRequired, false, t.Name())
+		if err != nil {
+			t.Error(errors.AddContext(err, "failed to reset the setting in defer"))
+		}
+	}()
+
+	// Registering without a code fails.
+	r, _, err := at.UserPOST(name+"_nocode@siasky.net", name)
+	if r.StatusCode != http.StatusForbidden || err == nil || !strings.Contains(err.Error(), api.ErrCodeInviteRequired) {
+		t.Fatalf("Expected %d with code '%s', got %d and '%v'", http.StatusForbidden, api.ErrCodeInviteRequired, r.StatusCode, err)
+	}
+	// So does registering with an invalid code.
+	r, _, err = at.UserPOSTWithInvite(name+"_badcode@siasky.net", name, "not a valid code")
+	if r.StatusCode != http.StatusForbidden || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusForbidden, r.StatusCode, err)
+	}
+
+	// An admin creates a single-use code which assigns a premium tier.
+	inv, _, err := at.InvitesPOST(api.InvitePOST{Tier: database.TierPremium5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailAddr := types.NewEmail(name + "@siasky.net")
+	r, b, err := at.UserPOSTWithInvite(emailAddr.String(), name, strings.ToLower(inv.Code))
+	if err != nil {
+		t.Fatal(err, string(b))
+	}
+	u, err := at.DB.UserByEmail(at.Ctx, emailAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = at.DB.UserDelete(at.Ctx, u); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	if u.InviteCode != inv.Code || u.Tier != database.TierPremium5 {
+		t.Fatalf("Expected the user to be registered with code %s and tier %d, got %s and %d", inv.Code, database.TierPremium5, u.InviteCode, u.Tier)
+	}
+	// The code is used up.
+	r, _, err = at.UserPOSTWithInvite(name+"_second@siasky.net", name, inv.Code)
+	if r.StatusCode != http.StatusForbidden || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusForbidden, r.StatusCode, err)
+	}
+
+	// The new user invites another one, up to their tier's quota.
+	r, _, err = at.LoginCredentialsPOST(emailAddr.String(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at.SetCookie(test.ExtractCookie(r))
+	var codes []string
+	for i := 0; i < database.InviteQuota[database.TierPremium5]; i++ {
+		uinv, _, err := at.UserInvitesPOST()
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, uinv.Code)
+	}
+	_, status, err := at.UserInvitesPOST()
+	if status != http.StatusBadRequest || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	invs, _, err := at.UserInvitesGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invs.Count != len(codes) {
+		t.Fatalf("Expected %d invite codes, got %d", len(codes), invs.Count)
+	}
+	at.ClearCredentials()
+	invitedEmail := types.NewEmail(name + "_invited@siasky.net")
+	_, b, err = at.UserPOSTWithInvite(invitedEmail.String(), name, codes[0])
+	if err != nil {
+		t.Fatal(err, string(b))
+	}
+	iu, err := at.DB.UserByEmail(at.Ctx, invitedEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = at.DB.UserDelete(at.Ctx, iu); err != nil {
+		t.Fatal(err)
+	}
+	if iu.Tier != database.TierFree {
+		t.Fatalf("Expected invited user to have tier %d, got %d", database.TierFree, iu.Tier)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/email"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// testLoginEmail ensures that users can log in by following a link we send to
+// their email address, and that the endpoint doesn't reveal which addresses
+// are registered.
+func testLoginEmail(t *testing.T, at *test.AccountsTester) {
+	defer at.ClearCredentials()
+
+	u, _, err := test.CreateUserAndLogin(at, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	at.ClearCredentials()
+
+	// An unknown address gets the same response as a registered one. Its
+	// owner gets notified about the attempt instead.
+	unknown := types.NewEmail(test.DBNameForTest(t.Name()) + "_unknown@siasky.net")
+	status, err := at.LoginEmailPOST(unknown.String())
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusNoContent, status, err)
+	}
+	_, err = at.DB.EmailLatest(at.Ctx, unknown, email.TemplateAccountAccessAttempted)
+	if err != nil {
+		t.Fatal("Expected an account access attempted email, got", err)
+	}
+	_, err = at.DB.EmailLatest(at.Ctx, unknown, email.TemplateLoginLink)
+	if err == nil {
+		t.Fatal("Expected no login link email.")
+	}
+
+	// Request a login link for the user.
+	status, err = at.LoginEmailPOST(u.Email.String())
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusNoContent, status, err)
+	}
+	m, err := at.DB.EmailLatest(at.Ctx, u.Email, email.TemplateLoginLink)
+	if err != nil {
+		t.Fatal("Expected a login link email, got", err)
+	}
+	tk, err := test.EmailToken(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An invalid token doesn't log anyone in.
+	status, err = at.LoginEmailGET("not a valid token")
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	r, err := at.LoginEmailConfirmPOST("not a valid token")
+	if err == nil || r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, r.StatusCode, err)
+	}
+	// Checking the token, e.g. by a link prefetcher, doesn't use it up and
+	// doesn't log anyone in.
+	for i := 0; i < 2; i++ {
+		status, err = at.LoginEmailGET(tk)
+		if err != nil || status != http.StatusNoContent {
+			t.Fatalf("Expected %d, got %d and '%v'", http.StatusNoContent, status, err)
+		}
+	}
+	// Confirming the login logs the user in.
+	r, err = at.LoginEmailConfirmPOST(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := test.ExtractCookie(r)
+	if c == nil {
+		t.Fatal("Expected a login cookie.")
+	}
+	at.SetCookie(c)
+	ug, _, err := at.UserGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ug.Sub != u.Sub {
+		t.Fatalf("Expected to be logged in as %s, got %s", u.Sub, ug.Sub)
+	}
+	at.ClearCredentials()
+	// The link can only be used once.
+	status, err = at.LoginEmailGET(tk)
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	r, err = at.LoginEmailConfirmPOST(tk)
+	if err == nil || r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, r.StatusCode, err)
+	}
+
+	// Requesting a new link invalidates the previous one.
+	_, err = at.LoginEmailPOST(u.Email.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = at.DB.EmailLatest(at.Ctx, u.Email, email.TemplateLoginLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk1, err := test.EmailToken(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.LoginEmailPOST(u.Email.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = at.LoginEmailConfirmPOST(tk1)
+	if err == nil || r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, r.StatusCode, err)
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/api"
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/webauthn"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// testPasskeys ensures that users can register passkeys, log in with them,
+// list them and delete them.
+func testPasskeys(t *testing.T, at *test.AccountsTester) {
+	defer at.ClearCredentials()
+
+	u, c, err := test.CreateUserAndLogin(at, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	auth, err := test.NewAuthenticator("siasky.net", database.TrustedOrigins[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	// Register a passkey.
+	at.SetCookie(c)
+	opts, _, err := at.UserPasskeysRegisterGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RP.ID != "siasky.net" || opts.Attestation != "none" || len(opts.PubKeyCredParams) == 0 {
+		t.Fatalf("Unexpected creation options %+v", opts)
+	}
+	if opts.User.ID != b64(u.ID[:]) {
+		t.Fatalf("Expected user id %s, got %s", b64(u.ID[:]), opts.User.ID)
+	}
+	challenge, err := webauthn.DecodeBase64URL(opts.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, att := auth.Create(challenge)
+	regCred := api.PublicKeyCredential{
+		ID:   b64(auth.CredentialID),
+		Type: "public-key",
+		Response: api.AuthenticatorResponseRaw{
+			ClientDataJSON:    b64(cd),
+			AttestationObject: b64(att),
+		},
+	}
+	pk, _, err := at.UserPasskeysRegisterPOST("laptop", regCred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk.Name != "laptop" {
+		t.Fatalf("Expected name 'laptop', got '%s'", pk.Name)
+	}
+	// The challenge can't be reused.
+	_, status, err := at.UserPasskeysRegisterPOST("laptop", regCred)
+	if status != http.StatusBadRequest || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	// The same credential can't be registered twice.
+	opts, _, err = at.UserPasskeysRegisterGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.ExcludeCredentials) != 1 || opts.ExcludeCredentials[0].ID != b64(auth.CredentialID) {
+		t.Fatalf("Expected the passkey to be excluded, got %+v", opts.ExcludeCredentials)
+	}
+	challenge, err = webauthn.DecodeBase64URL(opts.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, att = auth.Create(challenge)
+	regCred.Response.ClientDataJSON = b64(cd)
+	regCred.Response.AttestationObject = b64(att)
+	_, status, err = at.UserPasskeysRegisterPOST("laptop", regCred)
+	if status != http.StatusBadRequest || !errors.Contains(err, database.ErrPasskeyExists) {
+		t.Fatalf("Expected %d and '%v', got %d and '%v'", http.StatusBadRequest, database.ErrPasskeyExists, status, err)
+	}
+
+	// Log in with the passkey.
+	at.ClearCredentials()
+	login := func() api.PublicKeyCredential {
+		ro, _, err := at.LoginPasskeyGET()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ro.RPID != "siasky.net" {
+			t.Fatalf("Expected RP ID 'siasky.net', got '%s'", ro.RPID)
+		}
+		ch, err := webauthn.DecodeBase64URL(ro.Challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd, ad, sig := auth.Get(ch)
+		return api.PublicKeyCredential{
+			ID:   b64(auth.CredentialID),
+			Type: "public-key",
+			Response: api.AuthenticatorResponseRaw{
+				ClientDataJSON:    b64(cd),
+				AuthenticatorData: b64(ad),
+				Signature:         b64(sig),
+				UserHandle:        b64(u.ID[:]),
+			},
+		}
+	}
+	assertion := login()
+	r, err := at.LoginPasskeyPOST(assertion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := test.ExtractCookie(r)
+	if lc == nil {
+		t.Fatal("Expected a login cookie.")
+	}
+	// Replaying the assertion fails.
+	r, err = at.LoginPasskeyPOST(assertion)
+	if r.StatusCode != http.StatusUnauthorized || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+	// An assertion with another user's handle fails.
+	assertion = login()
+	assertion.Response.UserHandle = b64(make([]byte, 12))
+	r, err = at.LoginPasskeyPOST(assertion)
+	if r.StatusCode != http.StatusUnauthorized || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+
+	// List the user's passkeys.
+	at.SetCookie(lc)
+	pks, _, err := at.UserPasskeysGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pks.Items) != 1 || pks.Items[0].ID != pk.ID {
+		t.Fatalf("Expected the registered passkey, got %+v", pks.Items)
+	}
+	if pks.Items[0].LastUsedAt.IsZero() {
+		t.Fatal("Expected the passkey's last use to be recorded.")
+	}
+
+	// Delete the passkey.
+	status, err = at.UserPasskeysDELETE(pk.ID)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusNoContent, status, err)
+	}
+	status, err = at.UserPasskeysDELETE(pk.ID)
+	if status != http.StatusNotFound || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusNotFound, status, err)
+	}
+	// The deleted passkey can't be used for logging in.
+	at.ClearCredentials()
+	r, err = at.LoginPasskeyPOST(login())
+	if r.StatusCode != http.StatusUnauthorized || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusUnauthorized, r.StatusCode, err)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// testUserStatsHistory ensures that GET /user/stats/history reports the user's
+// usage in the correct buckets.
+func testUserStatsHistory(t *testing.T, at *test.AccountsTester) {
+	u, c, err := test.CreateUserAndLogin(at, t.Name())
+	if err != nil {
+		t.Fatal("Failed to create a user and log in:", err)
+	}
+	defer func() {
+		if err = u.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	at.SetCookie(c)
+	defer at.ClearCredentials()
+
+	sl := test.RandomSkylink()
+	_, err = at.TrackUpload(sl, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = at.TrackDownload(sl, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get the history for the last week. Expect 7 or 8 buckets, depending on
+	// the time of day, with all the activity in the last one.
+	now := time.Now().UTC()
+	history, _, err := at.UserStatsHistory(now.AddDate(0, 0, -7).Unix(), now.Add(time.Minute).Unix(), database.GranularityDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) < 7 || len(history) > 8 {
+		t.Fatalf("Expected 7 or 8 buckets, got %d", len(history))
+	}
+	last := history[len(history)-1]
+	if last.NumUploads != 1 || last.NumDownloads != 1 {
+		t.Fatalf("Expected 1 upload and 1 download, got %d and %d", last.NumUploads, last.NumDownloads)
+	}
+	if last.BandwidthDownloads != skynet.BandwidthDownloadCost(200) {
+		t.Fatalf("Expected download bandwidth %d, got %d", skynet.BandwidthDownloadCost(200), last.BandwidthDownloads)
+	}
+	for _, b := range history[:len(history)-1] {
+		if b.NumUploads != 0 || b.NumDownloads != 0 {
+			t.Fatalf("Expected no activity on %s, got %+v", b.Start, b)
+		}
+	}
+	// Get the monthly history with default period.
+	history, _, err = at.UserStatsHistory(0, 0, database.GranularityMonth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[len(history)-1].NumUploads != 1 {
+		t.Fatalf("Expected 1 upload in the current month, got %d", history[len(history)-1].NumUploads)
+	}
+	// Invalid granularity.
+	_, status, err := at.UserStatsHistory(0, 0, "week")
+	if err == nil || status != http.StatusBadRequest {
+		t.Fatalf("Expected %d, got %d %v", http.StatusBadRequest, status, err)
+	}
+	// Too long a period.
+	_, _, err = at.UserStatsHistory(now.AddDate(-2, 0, 0).Unix(), now.Unix(), database.GranularityDay)
+	if err == nil || !strings.Contains(err.Error(), badRequest) {
+		t.Fatalf("Expected '%s', got '%v'", badRequest, err)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// testUserMerge ensures that users can merge two of their accounts after
+// logging into both of them, and that admins can merge any two accounts.
+func testUserMerge(t *testing.T, at *test.AccountsTester) {
+	defer at.ClearCredentials()
+
+	target, ct, err := test.CreateUserAndLogin(at, t.Name()+"_target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = target.Delete(at.Ctx); err != nil {
+			t.Error(errors.AddContext(err, "failed to delete user in defer"))
+		}
+	}()
+	source, cs, err := test.CreateUserAndLogin(at, t.Name()+"_source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := database.PubKey(fastrand.Bytes(database.PubKeySize))
+	err = at.DB.UserPubKeyAdd(at.Ctx, *source.User, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = test.CreateTestUpload(at.Ctx, at.DB, *source.User, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get a merge token while logged into the source account.
+	at.SetCookie(cs)
+	mt, _, err := at.UserMergeTokenPOST()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token can't be used to merge the account into itself.
+	_, status, err := at.UserMergePOST(mt.Token)
+	if status != http.StatusBadRequest || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	mt, _, err = at.UserMergeTokenPOST()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use it while logged into the target account.
+	at.SetCookie(ct)
+	_, status, err = at.UserMergePOST("not a valid token")
+	if status != http.StatusBadRequest || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+	ug, _, err := at.UserMergePOST(mt.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ug.Sub != target.Sub {
+		t.Fatalf("Expected sub %s, got %s", target.Sub, ug.Sub)
+	}
+	// The source account is gone and its data belongs to the target account.
+	_, err = at.DB.UserByID(at.Ctx, source.ID)
+	if !errors.Contains(err, database.ErrUserNotFound) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrUserNotFound, err)
+	}
+	ups, _, err := at.UserUploadsGET()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ups.Count != 1 {
+		t.Fatalf("Expected the source's upload to be moved, got %+v", ups)
+	}
+	u, err := at.DB.UserByPubKey(at.Ctx, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != target.ID {
+		t.Fatalf("Expected the pubkey to belong to user %s, got %s", target.ID.Hex(), u.ID.Hex())
+	}
+	// The token can't be used twice.
+	_, status, err = at.UserMergePOST(mt.Token)
+	if status != http.StatusBadRequest || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusBadRequest, status, err)
+	}
+
+	// Admins can merge accounts directly.
+	at.ClearCredentials()
+	other, _, err := test.CreateUserAndLogin(at, t.Name()+"_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = at.DB.UserSetTier(at.Ctx, other.User, database.TierPremium20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ug, _, err = at.UsersMergePOST(target.Sub, other.Sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ug.Tier != database.TierPremium20 {
+		t.Fatalf("Expected the merged account to have tier %d, got %d", database.TierPremium20, ug.Tier)
+	}
+	_, status, err = at.UsersMergePOST(target.Sub, other.Sub)
+	if status != http.StatusNotFound || err == nil {
+		t.Fatalf("Expected %d, got %d and '%v'", http.StatusNotFound, status, err)
+	}
+}
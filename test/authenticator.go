@@ -0,0 +1,173 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"github.com/SkynetLabs/skynet-accounts/webauthn"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+type (
+	// Authenticator is a virtual WebAuthn authenticator which holds a single
+	// ES256 credential. We use it for testing passkeys.
+	Authenticator struct {
+		CredentialID []byte
+		Origin       string
+		RPID         string
+		SignCount    uint32
+		staticKey    *ecdsa.PrivateKey
+	}
+)
+
+// NewAuthenticator returns a new virtual authenticator for the given relying
+// party.
+func NewAuthenticator(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		CredentialID: fastrand.Bytes(32),
+		Origin:       origin,
+		RPID:         rpID,
+		staticKey:    key,
+	}, nil
+}
+
+// Create performs the authenticator's part of a registration ceremony. It
+// returns the client data JSON and the attestation object.
+func (a *Authenticator) Create(challenge []byte) ([]byte, []byte) {
+	cd := a.clientData("webauthn.create", challenge)
+	coseKey := cborEncode(map[int]interface{}{
+		1:  2,
+		3:  webauthn.AlgES256,
+		-1: 1,
+		-2: padTo32(a.staticKey.X.Bytes()),
+		-3: padTo32(a.staticKey.Y.Bytes()),
+	})
+	credData := make([]byte, 18, 18+len(a.CredentialID)+len(coseKey))
+	binary.BigEndian.PutUint16(credData[16:], uint16(len(a.CredentialID)))
+	credData = append(credData, a.CredentialID...)
+	credData = append(credData, coseKey...)
+	authData := append(a.authData(0x01|0x40), credData...)
+	att := cborEncode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	return cd, att
+}
+
+// Get performs the authenticator's part of an authentication ceremony. It
+// returns the client data JSON, the authenticator data and the signature.
+func (a *Authenticator) Get(challenge []byte) ([]byte, []byte, []byte) {
+	a.SignCount++
+	cd := a.clientData("webauthn.get", challenge)
+	authData := a.authData(0x01)
+	h := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), h[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.staticKey, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return cd, authData, sig
+}
+
+// authData returns the authenticator data with the given flags and without
+// attested credential data.
+func (a *Authenticator) authData(flags byte) []byte {
+	h := sha256.Sum256([]byte(a.RPID))
+	b := make([]byte, 37)
+	copy(b, h[:])
+	b[32] = flags
+	binary.BigEndian.PutUint32(b[33:], a.SignCount)
+	return b
+}
+
+// clientData returns the client data JSON of a ceremony of the given type.
+func (a *Authenticator) clientData(cdType string, challenge []byte) []byte {
+	b, err := json.Marshal(webauthn.ClientData{
+		Type:      cdType,
+		Challenge: webauthn.ChallengeString(challenge),
+		Origin:    a.Origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// cborEncode encodes the types we need for creating WebAuthn responses in
+// CBOR.
+func cborEncode(v interface{}) []byte {
+	switch val := v.(type) {
+	case int:
+		if val < 0 {
+			return cborHead(1, uint64(-1-val))
+		}
+		return cborHead(0, uint64(val))
+	case []byte:
+		return append(cborHead(2, uint64(len(val))), val...)
+	case string:
+		return append(cborHead(3, uint64(len(val))), val...)
+	case map[int]interface{}:
+		keys := make([]int, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		b := cborHead(5, uint64(len(val)))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(val[k])...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := cborHead(5, uint64(len(val)))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(val[k])...)
+		}
+		return b
+	}
+	panic("unsupported CBOR type")
+}
+
+// cborHead encodes the head of a CBOR item with the given major type and
+// argument.
+func cborHead(major byte, arg uint64) []byte {
+	if arg < 24 {
+		return []byte{major<<5 | byte(arg)}
+	}
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint64(b[1:], arg)
+	switch {
+	case arg <= 0xff:
+		b[7] = major<<5 | 24
+		return b[7:]
+	case arg <= 0xffff:
+		b[6] = major<<5 | 25
+		return b[6:]
+	case arg <= 0xffffffff:
+		b[4] = major<<5 | 26
+		return b[4:]
+	}
+	b[0] = major<<5 | 27
+	return b
+}
+
+// padTo32 left-pads the given big-endian integer to 32 bytes.
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/webauthn"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestAuthenticator ensures that the responses of the virtual authenticator
+// pass the verification of the webauthn package and that tampered responses
+// don't.
+func TestAuthenticator(t *testing.T) {
+	rp := webauthn.RelyingParty{ID: "siasky.net", Name: "Skynet", Origins: []string{"https://account.siasky.net"}}
+	a, err := NewAuthenticator(rp.ID, "https://account.siasky.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Registration.
+	challenge := fastrand.Bytes(32)
+	cd, att := a.Create(challenge)
+	_, err = rp.VerifyRegistration(fastrand.Bytes(32), cd, att)
+	if !errors.Contains(err, webauthn.ErrInvalidClientData) {
+		t.Fatalf("Expected error '%s', got '%v'", webauthn.ErrInvalidClientData, err)
+	}
+	other := webauthn.RelyingParty{ID: "example.com"}
+	_, err = other.VerifyRegistration(challenge, cd, att)
+	if !errors.Contains(err, webauthn.ErrInvalidClientData) {
+		t.Fatalf("Expected error '%s', got '%v'", webauthn.ErrInvalidClientData, err)
+	}
+	cred, err := rp.VerifyRegistration(challenge, cd, att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cred.ID) != string(a.CredentialID) || cred.SignCount != 0 {
+		t.Fatalf("Unexpected credential %+v", cred)
+	}
+
+	// Authentication.
+	challenge = fastrand.Bytes(32)
+	cd, ad, sig := a.Get(challenge)
+	_, err = rp.VerifyAssertion(challenge, cred, cd, ad, fastrand.Bytes(len(sig)))
+	if !errors.Contains(err, webauthn.ErrInvalidSignature) {
+		t.Fatalf("Expected error '%s', got '%v'", webauthn.ErrInvalidSignature, err)
+	}
+	n, err := rp.VerifyAssertion(challenge, cred, cd, ad, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != a.SignCount {
+		t.Fatalf("Expected sign count %d, got %d", a.SignCount, n)
+	}
+	cred.SignCount = n
+	// Replaying the same assertion fails because the counter didn't increase.
+	_, err = rp.VerifyAssertion(challenge, cred, cd, ad, sig)
+	if !errors.Contains(err, webauthn.ErrSignCountMismatch) {
+		t.Fatalf("Expected error '%s', got '%v'", webauthn.ErrSignCountMismatch, err)
+	}
+	// The registration response can't be used for authentication.
+	cd, _ = a.Create(challenge)
+	_, err = rp.VerifyAssertion(challenge, cred, cd, ad, sig)
+	if !errors.Contains(err, webauthn.ErrInvalidClientData) {
+		t.Fatalf("Expected error '%s', got '%v'", webauthn.ErrInvalidClientData, err)
+	}
+}
+
+// TestAllowsOrigin ensures that we only accept the relying party's origins and
+// not the other subdomains of the portal, which serve skapps.
+func TestAllowsOrigin(t *testing.T) {
+	rp := webauthn.RelyingParty{ID: "siasky.net", Origins: []string{"https://account.siasky.net"}}
+	tests := map[string]bool{
+		"https://account.siasky.net":      true,
+		"https://siasky.net":              false,
+		"https://account.siasky.net:8443": false,
+		"http://account.siasky.net":       false,
+		"https://evilsiasky.net":          false,
+		"https://siasky.net.evil.com":     false,
+		"https://account.siasky.net/foo":  false,
+		"account.siasky.net":              false,
+		// Skapps served by the portal.
+		"https://100bvbi8qhiv3s8tltrh7kj2f6dt5ib3sg4qrlrglmhrjk97b6i5cdg.siasky.net": false,
+		"https://foo.hns.siasky.net": false,
+	}
+	for origin, expected := range tests {
+		if rp.AllowsOrigin(origin) != expected {
+			t.Errorf("Expected AllowsOrigin('%s') to be %t", origin, expected)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestPortalStats ensures that the portal-wide statistics are accurate.
+func TestPortalStats(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// We only look at the data created during this test run.
+	from := time.Now().UTC().Add(-time.Second)
+
+	// Create a free and a premium user.
+	uFree, err := db.UserCreate(ctx, "free@example.com", "", string(fastrand.Bytes(test.UserSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		if err := db.UserDelete(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}(uFree)
+	uPaid, err := db.UserCreate(ctx, "paid@example.com", "", string(fastrand.Bytes(test.UserSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		if err := db.UserDelete(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}(uPaid)
+	// Convert the second user to a paid tier.
+	err = db.UserSetTier(ctx, uPaid, database.TierPremium5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.TierChangeCreate(ctx, uPaid.ID, database.TierFree, database.TierPremium5, database.TierChangeSourceStripe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload 100 bytes anonymously, 100 bytes by the free user and 200 bytes
+	// by the paid user. The free user also downloads the paid user's file.
+	_, _, err = test.CreateTestUpload(ctx, db, database.AnonUser, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = test.CreateTestUpload(ctx, db, *uFree, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl, _, err := test.CreateTestUpload(ctx, db, *uPaid, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.DownloadCreate(ctx, *uFree, *sl, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := time.Now().UTC().Add(time.Minute)
+
+	stats, err := db.PortalStats(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Registrations != 2 {
+		t.Fatalf("Expected 2 registrations, got %d", stats.Registrations)
+	}
+	if stats.ActiveUsers != 2 {
+		t.Fatalf("Expected 2 active users, got %d", stats.ActiveUsers)
+	}
+	if stats.Conversions != 1 {
+		t.Fatalf("Expected 1 conversion, got %d", stats.Conversions)
+	}
+	expectedUploads := map[int]database.PortalTraffic{
+		database.TierAnonymous: {Count: 1, Size: 100},
+		database.TierFree:      {Count: 1, Size: 100},
+		database.TierPremium5:  {Count: 1, Size: 200},
+	}
+	for tier, expected := range expectedUploads {
+		if stats.UploadsByTier[tier] != expected {
+			t.Fatalf("Expected tier %d uploads %+v, got %+v", tier, expected, stats.UploadsByTier[tier])
+		}
+	}
+	if stats.DownloadsByTier[database.TierFree] != (database.PortalTraffic{Count: 1, Size: 50}) {
+		t.Fatalf("Unexpected free tier downloads %+v", stats.DownloadsByTier[database.TierFree])
+	}
+	if stats.AnonymousUploadsShare != 0.25 {
+		t.Fatalf("Expected anonymous share of 0.25, got %f", stats.AnonymousUploadsShare)
+	}
+
+	// The daily history should hold the same numbers in its last bucket.
+	history, err := db.PortalStatsHistory(ctx, from, to, database.GranularityDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numUploads, numAnonUploads, registrations int64
+	for _, b := range history {
+		numUploads += b.NumUploads
+		numAnonUploads += b.NumAnonUploads
+		registrations += b.Registrations
+	}
+	if numUploads != 3 || numAnonUploads != 1 || registrations != 2 {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+
+	// The paid user should be the top uploader.
+	top, err := db.TopUploaders(ctx, from, to, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 uploaders, got %d", len(top))
+	}
+	if top[0].UserID != uPaid.ID || top[0].UploadsSize != 200 {
+		t.Fatalf("Expected the paid user to be the top uploader, got %+v", top[0])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/test"
 	"gitlab.com/NebulousLabs/errors"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -58,3 +59,40 @@ func TestConfiguration(t *testing.T) {
 		t.Fatalf("Expected value '%s', got '%s'", val, value)
 	}
 }
+
+// TestEmailConfirmationRequired ensures we correctly parse the policy which
+// lists the actions that require a confirmed email address.
+func TestEmailConfirmationRequired(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing requires a confirmed email when there is no policy.
+	required, err := db.EmailConfirmationRequired(ctx, database.ActionAPIKeys)
+	if err != nil || required {
+		t.Fatalf("Expected no confirmation requirement, got %t and '%v'", required, err)
+	}
+	_, err = db.SettingSet(ctx, database.ConfValEmailConfirmationRequired, database.ActionAPIKeys+", "+database.ActionCheckout, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		database.ActionAPIKeys:  true,
+		database.ActionCheckout: true,
+		database.ActionLimits:   false,
+	}
+	for action, expected := range tests {
+		required, err = db.EmailConfirmationRequired(ctx, action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if required != expected {
+			t.Errorf("Expected %t for action '%s', got %t", expected, action, required)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestEmailFailureAndRequeue ensures that we keep the last error of failed
+// email messages, that we can find them and requeue them.
+func TestEmailFailureAndRequeue(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := types.NewEmail(fmt.Sprintf("%x@example.com", fastrand.Bytes(8)))
+	err = db.EmailCreate(ctx, database.EmailMessage{
+		From:     "test@siasky.net",
+		To:       to.String(),
+		Subject:  "test",
+		Body:     "test",
+		BodyMime: "text/plain",
+		Template: "confirm_email",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := db.EmailLatest(ctx, to, "confirm_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != database.EmailStatusPending || m.CreatedAt.IsZero() {
+		t.Fatalf("Unexpected message %+v", m)
+	}
+	// Fail the message until we give up on it.
+	for i := 0; i < database.EmailMaxSendAttempts; i++ {
+		m.LastError = fmt.Sprintf("error %d", i)
+		err = db.MarkAsFailed(ctx, []*database.EmailMessage{&m})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, count, err := db.EmailList(ctx, database.EmailFilter{Status: database.EmailStatusFailed, To: to}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(msgs) != 1 {
+		t.Fatalf("Expected one failed message, got %d", count)
+	}
+	expectedErr := fmt.Sprintf("error %d", database.EmailMaxSendAttempts-1)
+	if msgs[0].LastError != expectedErr || msgs[0].LastAttemptAt.IsZero() {
+		t.Fatalf("Expected last error '%s', got %+v", expectedErr, msgs[0])
+	}
+	// Pending messages can't be requeued.
+	_, count, err = db.EmailList(ctx, database.EmailFilter{Status: database.EmailStatusPending, To: to}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("Expected no pending messages, got %d", count)
+	}
+	err = db.EmailRequeue(ctx, m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.EmailRequeue(ctx, m.ID)
+	if err != database.ErrEmailNotFound {
+		t.Fatalf("Expected '%v', got '%v'", database.ErrEmailNotFound, err)
+	}
+	m, err = db.EmailLatest(ctx, to, "confirm_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != database.EmailStatusPending || m.LastError != expectedErr {
+		t.Fatalf("Unexpected message after requeue %+v", m)
+	}
+	err = db.MarkAsSent(ctx, []primitive.ObjectID{m.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = db.EmailLatest(ctx, to, "confirm_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != database.EmailStatusSent {
+		t.Fatalf("Expected the message to be sent, got %+v", m)
+	}
+}
+
+// TestEmailRetrySchedule ensures that failed messages are not retried before
+// their next attempt is due and that permanently failed messages are not
+// retried at all.
+func TestEmailRetrySchedule(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.PurgeEmailCollection(ctx); err != nil {
+		t.Fatal(err)
+	}
+	to := types.NewEmail(fmt.Sprintf("%x@example.com", fastrand.Bytes(8)))
+	err = db.EmailCreate(ctx, database.EmailMessage{To: to.String(), Template: "confirm_email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := db.EmailLockAndFetch(ctx, t.Name(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("Expected to lock 1 message, got %d", len(msgs))
+	}
+	msgs[0].LastError = "451 try again later"
+	err = db.MarkAsFailed(ctx, []*database.EmailMessage{&msgs[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := db.EmailLatest(ctx, to, "confirm_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != database.EmailStatusPending || !m.NextAttemptAt.After(m.LastAttemptAt) {
+		t.Fatalf("Expected a scheduled retry, got %+v", m)
+	}
+	// The message is not due yet, so we shouldn't pick it up.
+	msgs, err = db.EmailLockAndFetch(ctx, t.Name(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("Expected no messages to be due, got %d", len(msgs))
+	}
+	// A permanent failure stops all retries.
+	m.LastError = "550 mailbox unavailable"
+	m.FailedPermanently = true
+	err = db.MarkAsFailed(ctx, []*database.EmailMessage{&m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = db.EmailLatest(ctx, to, "confirm_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != database.EmailStatusFailed {
+		t.Fatalf("Expected the message to have failed, got %+v", m)
+	}
+	// Requeueing makes the message due immediately.
+	err = db.EmailRequeue(ctx, m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = db.EmailLockAndFetch(ctx, t.Name(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].FailedPermanently {
+		t.Fatalf("Expected the requeued message to be due, got %+v", msgs)
+	}
+}
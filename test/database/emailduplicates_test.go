@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestEmailUniqueness ensures that all forms of an email address are
+// normalized to the same value and that the database doesn't allow two users
+// to have the same address.
+func TestEmailUniqueness(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A fresh database has no duplicates.
+	dups, err := db.EmailDuplicatesCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 0 {
+		t.Fatalf("Expected no duplicates, got %+v", dups)
+	}
+
+	email := types.NewEmail(t.Name() + "@bücher.example")
+	u1, err := db.UserCreate(ctx, email, "", string(fastrand.Bytes(test.UserSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another form of the same address is taken as well.
+	other := types.NewEmail(" " + t.Name() + "@XN--BCHER-KVA.example ")
+	_, err = db.UserCreate(ctx, other, "", string(fastrand.Bytes(test.UserSubLen)), database.TierFree)
+	if !errors.Contains(err, database.ErrUserAlreadyExists) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrUserAlreadyExists, err)
+	}
+	u, err := db.UserByEmail(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != u1.ID {
+		t.Fatalf("Expected user %s, got %s", u1.ID.Hex(), u.ID.Hex())
+	}
+	// Users can't switch to an address which is taken, even if they skip the
+	// checks we do before that.
+	u2, err := db.UserCreate(ctx, types.NewEmail(t.Name()+"_2@example.com"), "", string(fastrand.Bytes(test.UserSubLen)), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2.Email = other
+	err = db.UserSave(ctx, u2)
+	if !errors.Contains(err, database.ErrEmailInUse) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrEmailInUse, err)
+	}
+	dups, err = db.EmailDuplicates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 0 {
+		t.Fatalf("Expected no duplicates, got %+v", dups)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/email"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestEmailSuppression ensures that suppressed addresses flag their users and
+// don't get any more emails.
+func TestEmailSuppression(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := types.NewEmail(fmt.Sprintf("%x@example.com", fastrand.Bytes(8)))
+	sub := string(fastrand.Bytes(test.UserSubLen))
+	u, err := db.UserCreate(ctx, addr, "", sub, database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		err := db.UserDelete(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(u)
+
+	err = db.EmailSuppressionAdd(ctx, addr, "unknown", "")
+	if err != database.ErrInvalidEmailSuppressionReason {
+		t.Fatalf("Expected '%v', got '%v'", database.ErrInvalidEmailSuppressionReason, err)
+	}
+	err = db.EmailSuppressionAdd(ctx, addr, database.EmailSuppressionBounce, "550 user unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suppressed, err := db.EmailSuppressed(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suppressed {
+		t.Fatal("Expected the address to be suppressed.")
+	}
+	u, err = db.UserBySub(ctx, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.EmailDeliveryIssue != database.EmailSuppressionBounce {
+		t.Fatalf("Expected the user to be flagged, got '%s'", u.EmailDeliveryIssue)
+	}
+	// Emails to suppressed addresses are dropped.
+	err = email.NewMailer(db).SendAddressConfirmationEmail(ctx, addr, "", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.EmailLatest(ctx, addr, email.TemplateConfirmEmail)
+	if err != database.ErrEmailNotFound {
+		t.Fatalf("Expected '%v', got '%v'", database.ErrEmailNotFound, err)
+	}
+	// Removing the suppression clears the flag.
+	err = db.EmailSuppressionRemove(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.EmailSuppressionRemove(ctx, addr)
+	if err != database.ErrEmailSuppressionNotFound {
+		t.Fatalf("Expected '%v', got '%v'", database.ErrEmailSuppressionNotFound, err)
+	}
+	u, err = db.UserBySub(ctx, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.EmailDeliveryIssue != "" {
+		t.Fatalf("Expected the flag to be cleared, got '%s'", u.EmailDeliveryIssue)
+	}
+}
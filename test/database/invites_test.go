@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestInvites ensures that invite codes can be used as many times as they
+// allow, only before they expire, and that users can't create more codes than
+// their quota allows.
+func TestInvites(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid codes can't be created.
+	_, err = db.InviteCreate(ctx, primitive.ObjectID{}, 0, 0, 0)
+	if err == nil {
+		t.Fatal("Expected an error for a code without uses.")
+	}
+	_, err = db.InviteCreate(ctx, primitive.ObjectID{}, database.TierMaxReserved, 1, 0)
+	if err == nil {
+		t.Fatal("Expected an error for an invalid tier.")
+	}
+	// A multi-use code.
+	inv, err := db.InviteCreate(ctx, primitive.ObjectID{}, database.TierPremium20, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		rinv, err := db.InviteRedeem(ctx, inv.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rinv.Uses != i+1 || rinv.Tier != database.TierPremium20 {
+			t.Fatalf("Unexpected invite %+v", rinv)
+		}
+	}
+	_, err = db.InviteRedeem(ctx, inv.Code)
+	if !errors.Contains(err, database.ErrInvalidInviteCode) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidInviteCode, err)
+	}
+	// Releasing a use allows using the code again.
+	err = db.InviteRelease(ctx, inv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.InviteRedeem(ctx, inv.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired codes can't be used.
+	inv, err = db.InviteCreate(ctx, primitive.ObjectID{}, 0, 1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, err = db.InviteRedeem(ctx, inv.Code)
+	if !errors.Contains(err, database.ErrInvalidInviteCode) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidInviteCode, err)
+	}
+	// Deleted codes can't be used.
+	inv, err = db.InviteCreate(ctx, primitive.ObjectID{}, 0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InviteDelete(ctx, inv.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.InviteRedeem(ctx, inv.Code)
+	if !errors.Contains(err, database.ErrInvalidInviteCode) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidInviteCode, err)
+	}
+	// Users can create codes up to their quota.
+	u, err := db.UserCreate(ctx, "", "", t.Name(), database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < database.InviteQuota[database.TierFree]; i++ {
+		_, err = db.InviteCreateForUser(ctx, *u)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.InviteCreateForUser(ctx, *u)
+	if !errors.Contains(err, database.ErrInviteQuotaExceeded) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInviteQuotaExceeded, err)
+	}
+	invs, n, err := db.InvitesByUser(ctx, u.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != database.InviteQuota[database.TierFree] || len(invs) != n || invs[0].MaxUses != 1 {
+		t.Fatalf("Unexpected invites %+v", invs)
+	}
+
+	// Concurrent requests can't exceed the quota.
+	u2, err := db.UserCreate(ctx, "", "", t.Name()+"_concurrent", database.TierPremium20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota := database.InviteQuota[database.TierPremium20]
+	var wg sync.WaitGroup
+	var created int32
+	for i := 0; i < 2*quota; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.InviteCreateForUser(ctx, *u2); err == nil {
+				atomic.AddInt32(&created, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if int(created) != quota {
+		t.Fatalf("Expected %d invite codes, got %d", quota, created)
+	}
+}
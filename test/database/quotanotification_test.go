@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestQuotaNotificationRegister ensures that each quota notification is only
+// registered once per billing period or, for resources which aren't limited
+// per billing period, only once.
+func TestQuotaNotificationRegister(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := string(fastrand.Bytes(test.UserSubLen))
+	u, err := db.UserCreate(ctx, "email@example.com", "", sub, database.TierPremium5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		err := db.UserDelete(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(u)
+
+	isNew, err := db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceStorage, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected the first notification to be new.")
+	}
+	isNew, err = db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceStorage, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isNew {
+		t.Fatal("Expected the repeated notification not to be new.")
+	}
+	// Other thresholds and resources are tracked separately.
+	isNew, err = db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceStorage, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected a notification for another threshold to be new.")
+	}
+	isNew, err = db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceUploads, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected a notification for another resource to be new.")
+	}
+	// Unregistered notifications can be registered again.
+	err = db.QuotaNotificationUnregister(ctx, *u, database.QuotaResourceStorage, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isNew, err = db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceStorage, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected an unregistered notification to be new again.")
+	}
+	isNew, err = db.QuotaNotificationRegister(ctx, *u, database.QuotaResourceDownloadBandwidth, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected a notification for another resource to be new.")
+	}
+	// All of them are loaded at once. Only the download bandwidth ones belong
+	// to the current billing period.
+	qns, err := db.QuotaNotifications(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qns) != 4 {
+		t.Fatalf("Expected 4 notifications, got %d", len(qns))
+	}
+	for _, qn := range qns {
+		allTime := qn.Resource != database.QuotaResourceDownloadBandwidth
+		if qn.PeriodStart.IsZero() != allTime {
+			t.Fatalf("Unexpected period start of notification %+v", qn)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestSettings ensures that runtime settings are validated, audited and that
+// changes reach other servers once their cache expires.
+func TestSettings(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second connection to the same database acts as another server.
+	db2, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl := database.SettingsCacheTTL
+	database.SettingsCacheTTL = 100 * time.Millisecond
+	defer func() {
+		database.SettingsCacheTTL = ttl
+	}()
+
+	// Unset settings have their default values.
+	disabled, err := db.SettingBool(ctx, database.ConfValRegistrationsDisabled)
+	if err != nil || disabled {
+		t.Fatalf("Expected registrations to be enabled, got %t and '%v'", disabled, err)
+	}
+	disabled, err = db2.SettingBool(ctx, database.ConfValRegistrationsDisabled)
+	if err != nil || disabled {
+		t.Fatalf("Expected registrations to be enabled, got %t and '%v'", disabled, err)
+	}
+	// Unknown settings and invalid values are rejected.
+	_, err = db.SettingSet(ctx, "no such setting", true, t.Name())
+	if !errors.Contains(err, database.ErrUnknownSetting) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrUnknownSetting, err)
+	}
+	_, err = db.SettingSet(ctx, database.ConfValRegistrationsDisabled, "maybe", t.Name())
+	if !errors.Contains(err, database.ErrInvalidSettingValue) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidSettingValue, err)
+	}
+	_, err = db.SettingSet(ctx, database.ConfValEmailConfirmationRequired, []interface{}{"no such action"}, t.Name())
+	if !errors.Contains(err, database.ErrInvalidSettingValue) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidSettingValue, err)
+	}
+	// Change a setting. The change is visible right away on this server.
+	s, err := db.SettingSet(ctx, database.ConfValRegistrationsDisabled, true, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Value != true {
+		t.Fatalf("Expected value true, got %v", s.Value)
+	}
+	disabled, err = db.SettingBool(ctx, database.ConfValRegistrationsDisabled)
+	if err != nil || !disabled {
+		t.Fatalf("Expected registrations to be disabled, got %t and '%v'", disabled, err)
+	}
+	// The other server sees the change once its cache expires.
+	time.Sleep(2 * database.SettingsCacheTTL)
+	disabled, err = db2.SettingBool(ctx, database.ConfValRegistrationsDisabled)
+	if err != nil || !disabled {
+		t.Fatalf("Expected registrations to be disabled, got %t and '%v'", disabled, err)
+	}
+	// Setting the same value again is not recorded as a change.
+	_, err = db.SettingSet(ctx, database.ConfValRegistrationsDisabled, "true", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, total, err := db.SettingChanges(ctx, database.ConfValRegistrationsDisabled, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(changes) != 1 {
+		t.Fatalf("Expected a single change, got %d", total)
+	}
+	c := changes[0]
+	if c.OldValue != database.ConfValFalse || c.NewValue != database.ConfValTrue || c.ChangedBy != t.Name() {
+		t.Fatalf("Unexpected change %+v", c)
+	}
+	// All registered settings are listed.
+	settings, err := db.Settings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range settings {
+		if s.Key == database.ConfValRegistrationsDisabled {
+			found = s.Value == true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected to find the changed setting in %+v", settings)
+	}
+}
@@ -275,27 +275,45 @@ func TestUserConfirmEmail(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed to create a test user:", err)
 	}
+	if !u.EmailUnconfirmed {
+		t.Fatal("Expected the new user's email to be unconfirmed.")
+	}
+	tk, err := db.UserCreateEmailConfirmation(ctx, u.ID, u.Email)
+	if err != nil {
+		t.Fatal("Failed to create a confirmation token:", err)
+	}
 	// Confirm the email.
-	_, err = db.UserConfirmEmail(ctx, u.EmailConfirmationToken)
+	u1, err := db.UserConfirmEmail(ctx, tk)
 	if err != nil {
 		t.Fatal("Failed to confirm email:", err)
 	}
-	// Generate a new confirmation token.
-	u.EmailConfirmationToken, err = lib.GenerateUUID()
-	if err != nil {
-		t.Fatal("Failed to generate a token.")
+	if u1.EmailUnconfirmed {
+		t.Fatal("Expected the email to be confirmed.")
 	}
-	// Set the expiration of the token in the past.
-	u.EmailConfirmationTokenExpiration = time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
-	err = db.UserSave(ctx, u)
+	// The token can only be used once.
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'\n", database.ErrInvalidToken, err)
+	}
+	// Generate a token which has already expired.
+	tk, err = db.UserTokenCreate(ctx, u.ID, database.TokenPurposeEmailConfirmation, u.Email, -time.Minute)
 	if err != nil {
-		t.Fatal("Failed to save the user:", err)
+		t.Fatal("Failed to create a confirmation token:", err)
 	}
 	// Try to confirm the email, expecting to get an error because the token has
 	// expired.
-	_, err = db.UserConfirmEmail(ctx, u.EmailConfirmationToken)
+	_, err = db.UserConfirmEmail(ctx, tk)
 	if !errors.Contains(err, database.ErrInvalidToken) {
-		t.Fatalf("Expected error '%s', got '%s'\n", database.ErrInvalidToken.Error(), err.Error())
+		t.Fatalf("Expected error '%s', got '%v'\n", database.ErrInvalidToken, err)
+	}
+	// Tokens sent to an address the user no longer has are not valid.
+	tk, err = db.UserCreateEmailConfirmation(ctx, u.ID, types.NewEmail(t.Name()+"_old@siasky.net"))
+	if err != nil {
+		t.Fatal("Failed to create a confirmation token:", err)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'\n", database.ErrInvalidToken, err)
 	}
 }
 
@@ -375,7 +393,16 @@ func TestUserCreateEmailConfirmation(t *testing.T) {
 			t.Fatal(err)
 		}
 	}(u)
-	tk, err := db.UserCreateEmailConfirmation(ctx, u.ID)
+	tk, err := db.UserCreateEmailConfirmation(ctx, u.ID, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requesting a new confirmation marks the address as unconfirmed.
+	tk, err = db.UserCreateEmailConfirmation(ctx, u.ID, u.Email)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -383,8 +410,99 @@ func TestUserCreateEmailConfirmation(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if u1.EmailConfirmationToken != tk {
-		t.Fatal("Unexpected confirmation token.")
+	if !u1.EmailUnconfirmed {
+		t.Fatal("Expected the email to be unconfirmed.")
+	}
+	// Only the latest token is valid.
+	tk2, err := db.UserCreateEmailConfirmation(ctx, u.ID, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUserCreateEmailChange ensures that a user keeps their email address
+// until they confirm the new one and that unconfirmed changes expire.
+func TestUserCreateEmailChange(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal("Failed to connect to the DB:", err)
+	}
+	emailAddr := types.NewEmail(t.Name() + "@siasky.net")
+	newEmail := types.NewEmail(t.Name() + "_new@siasky.net")
+	u, err := db.UserCreate(ctx, emailAddr, "password", "", database.TierFree)
+	if err != nil {
+		t.Fatal("Failed to create a test user:", err)
+	}
+	tk, err := db.UserCreateEmailChange(ctx, u.ID, newEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The user keeps their current address until the change is confirmed.
+	u1, err := db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u1.Email != emailAddr || u1.PendingEmail != newEmail {
+		t.Fatalf("Unexpected email change state: %+v", u1)
+	}
+	// The change can't be confirmed if someone else took the address.
+	u2, err := db.UserCreate(ctx, newEmail, "password", "", database.TierFree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if !errors.Contains(err, database.ErrEmailInUse) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrEmailInUse, err)
+	}
+	if err = db.UserDelete(ctx, u2); err != nil {
+		t.Fatal(err)
+	}
+	// The failed attempt used up the token, so we need a new one.
+	tk, err = db.UserCreateEmailChange(ctx, u.ID, newEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Confirm the change.
+	u3, err := db.UserConfirmEmail(ctx, tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u3.Email != newEmail || u3.PendingEmail != "" || u3.EmailUnconfirmed {
+		t.Fatalf("Expected the user to switch to their new address, got %+v", u3)
+	}
+	// Expired changes are discarded.
+	tk, err = db.UserCreateEmailChange(ctx, u.ID, emailAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u3.PendingEmail = emailAddr
+	u3.PendingEmailExpiration = time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
+	if err = db.UserSave(ctx, u3); err != nil {
+		t.Fatal(err)
+	}
+	u4, err := db.UserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u4.PendingEmail != "" {
+		t.Fatalf("Expected the expired change to be discarded, got pending email %s", u4.PendingEmail)
+	}
+	_, err = db.UserConfirmEmail(ctx, tk)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
 	}
 }
 
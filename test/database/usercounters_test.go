@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/skynet"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestUserCounters ensures that the user's usage counters are kept in sync
+// with the raw uploads and downloads data.
+func TestUserCounters(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := string(fastrand.Bytes(test.UserSubLen))
+	u, err := db.UserCreate(ctx, "email@example.com", "", sub, database.TierPremium5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		err := db.UserDelete(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(u)
+
+	// The user has no counters yet, so they should be built on first read.
+	period, total, err := db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if period.NumUploads != 0 || total.NumUploads != 0 {
+		t.Fatalf("Expected no uploads, got %d and %d.", period.NumUploads, total.NumUploads)
+	}
+
+	// Upload a skylink twice, upload another one and download both.
+	testUploadSize := int64(1 + fastrand.Intn(1e9))
+	sl1, _, err := test.CreateTestUpload(ctx, db, *u, testUploadSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = test.RegisterTestUpload(ctx, db, *u, sl1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl2, _, err := test.CreateTestUpload(ctx, db, *u, testUploadSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.DownloadCreate(ctx, *u, *sl1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.DownloadCreate(ctx, *u, *sl2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// This download falls within the DownloadUpdateWindow, so it will
+	// increment the previous one.
+	_, err = db.DownloadCreate(ctx, *u, *sl2, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	period, total, err = db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.NumUploads != 3 || period.NumUploads != 3 {
+		t.Fatalf("Expected 3 uploads, got %d and %d.", period.NumUploads, total.NumUploads)
+	}
+	if total.UploadsSize != 2*testUploadSize {
+		t.Fatalf("Expected uploads size %d, got %d.", 2*testUploadSize, total.UploadsSize)
+	}
+	expectedBW := 3 * skynet.BandwidthUploadCost(testUploadSize)
+	if total.BandwidthUploads != expectedBW {
+		t.Fatalf("Expected upload bandwidth %d, got %d.", expectedBW, total.BandwidthUploads)
+	}
+	if total.NumDownloads != 2 {
+		t.Fatalf("Expected 2 downloads, got %d.", total.NumDownloads)
+	}
+	if total.DownloadsSize != testUploadSize+300 {
+		t.Fatalf("Expected downloads size %d, got %d.", testUploadSize+300, total.DownloadsSize)
+	}
+	expectedBW = skynet.BandwidthDownloadCost(testUploadSize) + skynet.BandwidthDownloadCost(300)
+	if total.BandwidthDownloads != expectedBW {
+		t.Fatalf("Expected download bandwidth %d, got %d.", expectedBW, total.BandwidthDownloads)
+	}
+	// The counters should match what we compute from the raw data.
+	mismatch, err := db.UserCountersRebuild(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch {
+		t.Fatal("Expected the counters to match the raw data.")
+	}
+
+	// Unpin the first skylink. Count and storage go down, bandwidth doesn't.
+	_, err = db.UnpinUploads(ctx, *sl1, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	period, total, err = db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.NumUploads != 1 || period.NumUploads != 1 {
+		t.Fatalf("Expected 1 upload, got %d and %d.", period.NumUploads, total.NumUploads)
+	}
+	if total.UploadsSize != testUploadSize || period.UploadsSize != testUploadSize {
+		t.Fatalf("Expected uploads size %d, got %d and %d.", testUploadSize, period.UploadsSize, total.UploadsSize)
+	}
+	mismatch, err = db.UserCountersRebuild(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch {
+		t.Fatal("Expected the counters to match the raw data after unpinning.")
+	}
+
+	// Changing the size of a skylink should invalidate the counters and they
+	// should be rebuilt on the next read.
+	err = db.SkylinkUpdate(ctx, sl2.ID, "", 2*testUploadSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, total, err = db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.UploadsSize != 2*testUploadSize {
+		t.Fatalf("Expected uploads size %d, got %d.", 2*testUploadSize, total.UploadsSize)
+	}
+}
+
+// TestUserCountersRebuildConcurrentUploads ensures that rebuilding the user's
+// counters doesn't lose uploads made while the rebuild is running.
+func TestUserCountersRebuildConcurrentUploads(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := string(fastrand.Bytes(test.UserSubLen))
+	u, err := db.UserCreate(ctx, "email@example.com", "", sub, database.TierPremium5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(user *database.User) {
+		err := db.UserDelete(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}(u)
+	// Build the counters, so the uploads below increment them.
+	_, _, err = db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep rebuilding the counters while we upload.
+	numUploads := 20
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, err := db.UserCountersRebuild(ctx, *u)
+			if err != nil && !errors.Contains(err, database.ErrUserCountersChanged) {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < numUploads; i++ {
+		_, _, err = test.CreateTestUpload(ctx, db, *u, int64(1+fastrand.Intn(1e6)))
+		if err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// The stored counters should reflect all uploads and match the raw data.
+	_, total, err := db.UserCounters(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.NumUploads != int64(numUploads) {
+		t.Fatalf("Expected %d uploads, got %d.", numUploads, total.NumUploads)
+	}
+	mismatch, err := db.UserCountersRebuild(ctx, *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch {
+		t.Fatal("Expected the counters to match the raw data.")
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/skynet-accounts/database"
+	"github.com/SkynetLabs/skynet-accounts/test"
+	"github.com/SkynetLabs/skynet-accounts/types"
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestUserTokens ensures that user tokens can only be used once, for their
+// purpose and before they expire, and that a new token invalidates the
+// previous ones.
+func TestUserTokens(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx := context.Background()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uID := primitive.NewObjectID()
+	emailAddr := types.NewEmail(t.Name() + "@siasky.net")
+
+	tk, err := db.UserTokenCreate(ctx, uID, database.TokenPurposeRecovery, emailAddr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token can't be used for another purpose.
+	_, err = db.UserTokenConsume(ctx, tk, database.TokenPurposeEmailConfirmation)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
+	}
+	ut, err := db.UserTokenConsume(ctx, tk, database.TokenPurposeRecovery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ut.UserID != uID || ut.Email != emailAddr || ut.ConsumedAt.IsZero() {
+		t.Fatalf("Unexpected token %+v", ut)
+	}
+	// We don't store the token itself.
+	if ut.TokenHash == tk {
+		t.Fatal("Expected the token to be hashed.")
+	}
+	// The token can only be used once.
+	_, err = db.UserTokenConsume(ctx, tk, database.TokenPurposeRecovery)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
+	}
+	// A new token invalidates the previous one.
+	tk1, err := db.UserTokenCreate(ctx, uID, database.TokenPurposeRecovery, emailAddr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk2, err := db.UserTokenCreate(ctx, uID, database.TokenPurposeRecovery, emailAddr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UserTokenConsume(ctx, tk1, database.TokenPurposeRecovery)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
+	}
+	_, err = db.UserTokenConsume(ctx, tk2, database.TokenPurposeRecovery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired tokens are invalid.
+	tk, err = db.UserTokenCreate(ctx, uID, database.TokenPurposeRecovery, emailAddr, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UserTokenConsume(ctx, tk, database.TokenPurposeRecovery)
+	if !errors.Contains(err, database.ErrInvalidToken) {
+		t.Fatalf("Expected error '%s', got '%v'", database.ErrInvalidToken, err)
+	}
+}
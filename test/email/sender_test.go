@@ -3,7 +3,14 @@ package email
 import (
 	"context"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,6 +20,7 @@ import (
 	"github.com/SkynetLabs/skynet-accounts/test"
 	"github.com/SkynetLabs/skynet-accounts/types"
 	"github.com/sirupsen/logrus"
+	"gitlab.com/SkynetLabs/skyd/skymodules"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.sia.tech/siad/build"
@@ -49,7 +57,7 @@ func TestSender(t *testing.T) {
 	// Send an email.
 	to := types.NewEmail(t.Name() + "@siasky.net")
 	token := t.Name()
-	err = mailer.SendAddressConfirmationEmail(ctx, to, token)
+	err = mailer.SendAddressConfirmationEmail(ctx, to, "", token)
 	if err != nil {
 		t.Fatal(err, "Failed to queue message for sending.")
 	}
@@ -87,6 +95,80 @@ func TestSender(t *testing.T) {
 	}
 }
 
+// TestSenderFileTransport ensures that the Sender can deliver emails via the
+// file transport and that we can inspect the delivered messages.
+func TestSenderFileTransport(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dbName := test.DBNameForTest(t.Name())
+	db, err := test.NewDatabase(ctx, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.PurgeEmailCollection(ctx); err != nil {
+		t.Fatal("Failed to purge email collection:", err)
+	}
+	defer func() {
+		if _, err = db.PurgeEmailCollection(ctx); err != nil {
+			t.Fatal("Failed to purge email collection:", err)
+		}
+	}()
+	dir := t.TempDir()
+	sender, err := email.NewSender(ctx, db, test.NewDiscardLogger(), &skymodules.SkynetDependencies{}, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := email.NewMailer(db)
+	to := types.NewEmail(t.Name() + "@siasky.net")
+	token := t.Name() + "-token"
+	err = mailer.SendAddressConfirmationEmail(ctx, to, "", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, failed := sender.ScanAndSend(t.Name())
+	if sent != 1 || failed != 0 {
+		t.Fatalf("Expected 1 sent and 0 failed emails, got %d and %d", sent, failed)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 email file, got %d", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("To") != to.String() {
+		t.Fatalf("Expected the email to go to %s, got %s", to, msg.Header.Get("To"))
+	}
+	// Decode the plain text part of the message.
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(text), "/user/confirm?token="+token) {
+		t.Fatal("Expected the email to contain the confirmation link.")
+	}
+}
+
 // TestContendingSenders ensures that each email generated by a cluster of
 // servers is sent exactly once. The test has several "servers" continuously
 // creating and "sending" emails.
@@ -120,7 +202,7 @@ func TestContendingSenders(t *testing.T) {
 		for i := 0; i < n; i++ {
 			// We'll use the target email address as token because it doesn't
 			// matter what we use.
-			err1 := m.SendAddressConfirmationEmail(ctx, targetAddr, targetAddr.String())
+			err1 := m.SendAddressConfirmationEmail(ctx, targetAddr, "", targetAddr.String())
 			if err1 != nil {
 				t.Error("Failed to send email.", err1)
 				return
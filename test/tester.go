@@ -355,6 +355,32 @@ func (at *AccountsTester) LoginCredentialsPOST(emailAddr, password string) (*htt
 	return at.post("/login", nil, params)
 }
 
+// LoginEmailGET performs `GET /login/email` with the given login link token.
+func (at *AccountsTester) LoginEmailGET(token string) (int, error) {
+	qp := url.Values{}
+	qp.Set("token", token)
+	r, err := at.Request(http.MethodGet, "/login/email", qp, nil, nil, nil)
+	return r.StatusCode, err
+}
+
+// LoginEmailConfirmPOST performs `POST /login/email/confirm` with the given
+// login link token.
+func (at *AccountsTester) LoginEmailConfirmPOST(token string) (*http.Response, error) {
+	b, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return &http.Response{}, err
+	}
+	return at.Request(http.MethodPost, "/login/email/confirm", nil, b, nil, nil)
+}
+
+// LoginEmailPOST performs `POST /login/email`
+func (at *AccountsTester) LoginEmailPOST(email string) (int, error) {
+	body := url.Values{}
+	body.Set("email", email)
+	r, _, err := at.post("/login/email", nil, body)
+	return r.StatusCode, err
+}
+
 // LoginCredentialsPOSTWithTTL logs the user in and returns a response.
 //
 // NOTE: The Body of the returned response is already read and closed.
@@ -399,6 +425,19 @@ func (at *AccountsTester) LoginPubKeyPOST(response, signature []byte, emailStr s
 	return at.post("/login", nil, bodyParams)
 }
 
+// LoginPubKeyPOSTWithOrigin performs `POST /login` with the given challenge
+// response, as if it was sent by a browser from the given origin.
+func (at *AccountsTester) LoginPubKeyPOSTWithOrigin(response, signature []byte, origin string) (*http.Response, error) {
+	b, err := json.Marshal(database.ChallengeResponseRequest{
+		Response:  hex.EncodeToString(response),
+		Signature: hex.EncodeToString(signature),
+	})
+	if err != nil {
+		return &http.Response{}, err
+	}
+	return at.Request(http.MethodPost, "/login", nil, b, map[string]string{"Origin": origin}, nil)
+}
+
 // LogoutPOST performs `POST /logout`
 func (at *AccountsTester) LogoutPOST() (*http.Response, []byte, error) {
 	return at.post("/logout", nil, nil)
@@ -493,6 +532,24 @@ func (at *AccountsTester) UserConfirmGET(confirmationToken string) (int, error)
 	return r.StatusCode, err
 }
 
+// UserConfirmPOST performs `POST /user/confirm`
+func (at *AccountsTester) UserConfirmPOST(confirmationToken string) (int, error) {
+	body := url.Values{}
+	body.Set("token", confirmationToken)
+	r, _, err := at.post("/user/confirm", nil, body)
+	return r.StatusCode, err
+}
+
+// UserLockPOST performs `POST /user/lock`
+func (at *AccountsTester) UserLockPOST(revertToken string) (int, error) {
+	b, err := json.Marshal(map[string]string{"token": revertToken})
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	r, err := at.Request(http.MethodPost, "/user/lock", nil, b, nil, nil)
+	return r.StatusCode, err
+}
+
 // UserPOST is a helper method that creates a new user.
 //
 // NOTE: The Body of the returned response is already read and closed.
@@ -503,6 +560,16 @@ func (at *AccountsTester) UserPOST(emailAddr, password string) (*http.Response,
 	return at.post("/user", nil, params)
 }
 
+// UserPOSTWithInvite is a helper method that creates a new user with the
+// given invite code.
+func (at *AccountsTester) UserPOSTWithInvite(emailAddr, password, inviteCode string) (*http.Response, []byte, error) {
+	params := url.Values{}
+	params.Set("email", emailAddr)
+	params.Set("password", password)
+	params.Set("inviteCode", inviteCode)
+	return at.post("/user", nil, params)
+}
+
 // UserPUT is a helper method which updates the entire user record.
 //
 // NOTE: The Body of the returned response is already read and closed.
@@ -532,6 +599,114 @@ func (at *AccountsTester) UserUploadsGET() (api.UploadsGET, int, error) {
 	return result, r.StatusCode, err
 }
 
+/*** Invite codes helpers ***/
+
+// InvitesPOST performs a `POST /invites` Request.
+func (at *AccountsTester) InvitesPOST(body api.InvitePOST) (database.Invite, int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return database.Invite{}, http.StatusBadRequest, err
+	}
+	var result database.Invite
+	r, err := at.Request(http.MethodPost, "/invites", nil, b, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UserInvitesGET performs a `GET /user/invites` Request.
+func (at *AccountsTester) UserInvitesGET() (api.InvitesGET, int, error) {
+	var result api.InvitesGET
+	r, err := at.Request(http.MethodGet, "/user/invites", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UserInvitesPOST performs a `POST /user/invites` Request.
+func (at *AccountsTester) UserInvitesPOST() (database.Invite, int, error) {
+	var result database.Invite
+	r, err := at.Request(http.MethodPost, "/user/invites", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+/*** Account merge helpers ***/
+
+// UserMergePOST performs a `POST /user/merge` Request.
+func (at *AccountsTester) UserMergePOST(token string) (api.UserGET, int, error) {
+	b, err := json.Marshal(api.UserMergePOST{Token: token})
+	if err != nil {
+		return api.UserGET{}, http.StatusBadRequest, err
+	}
+	var result api.UserGET
+	r, err := at.Request(http.MethodPost, "/user/merge", nil, b, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UserMergeTokenPOST performs a `POST /user/merge/token` Request.
+func (at *AccountsTester) UserMergeTokenPOST() (api.UserMergeTokenPOST, int, error) {
+	var result api.UserMergeTokenPOST
+	r, err := at.Request(http.MethodPost, "/user/merge/token", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UsersMergePOST performs a `POST /users/merge` Request.
+func (at *AccountsTester) UsersMergePOST(target, source string) (api.UserGET, int, error) {
+	b, err := json.Marshal(api.UsersMergePOST{Target: target, Source: source})
+	if err != nil {
+		return api.UserGET{}, http.StatusBadRequest, err
+	}
+	var result api.UserGET
+	r, err := at.Request(http.MethodPost, "/users/merge", nil, b, nil, &result)
+	return result, r.StatusCode, err
+}
+
+/*** User passkeys helpers ***/
+
+// LoginPasskeyGET performs a `GET /login/passkey` Request.
+func (at *AccountsTester) LoginPasskeyGET() (api.PasskeyRequestOptions, int, error) {
+	var result api.PasskeyRequestOptions
+	r, err := at.Request(http.MethodGet, "/login/passkey", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// LoginPasskeyPOST performs a `POST /login` Request with the given passkey
+// assertion.
+func (at *AccountsTester) LoginPasskeyPOST(cred api.PublicKeyCredential) (*http.Response, error) {
+	b, err := json.Marshal(map[string]interface{}{"passkey": cred})
+	if err != nil {
+		return &http.Response{}, err
+	}
+	return at.Request(http.MethodPost, "/login", nil, b, nil, nil)
+}
+
+// UserPasskeysDELETE performs a `DELETE /user/passkeys/:id` Request.
+func (at *AccountsTester) UserPasskeysDELETE(id primitive.ObjectID) (int, error) {
+	r, err := at.Request(http.MethodDelete, "/user/passkeys/"+id.Hex(), nil, nil, nil, nil)
+	return r.StatusCode, err
+}
+
+// UserPasskeysGET performs a `GET /user/passkeys` Request.
+func (at *AccountsTester) UserPasskeysGET() (api.PasskeysGET, int, error) {
+	var result api.PasskeysGET
+	r, err := at.Request(http.MethodGet, "/user/passkeys", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UserPasskeysRegisterGET performs a `GET /user/passkeys/register` Request.
+func (at *AccountsTester) UserPasskeysRegisterGET() (api.PasskeyCreationOptions, int, error) {
+	var result api.PasskeyCreationOptions
+	r, err := at.Request(http.MethodGet, "/user/passkeys/register", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
+// UserPasskeysRegisterPOST performs a `POST /user/passkeys/register` Request.
+func (at *AccountsTester) UserPasskeysRegisterPOST(name string, cred api.PublicKeyCredential) (database.Passkey, int, error) {
+	b, err := json.Marshal(api.PasskeyRegisterPOST{Name: name, Credential: cred})
+	if err != nil {
+		return database.Passkey{}, http.StatusBadRequest, err
+	}
+	var result database.Passkey
+	r, err := at.Request(http.MethodPost, "/user/passkeys/register", nil, b, nil, &result)
+	return result, r.StatusCode, err
+}
+
 /*** User API keys helpers ***/
 
 // UserAPIKeysDELETE performs a `DELETE /user/apikeys/:id` Request.
@@ -626,11 +801,35 @@ func (at *AccountsTester) UserPubkeyRegisterGET(pubKey string) (api.ChallengePub
 	return result, r.StatusCode, err
 }
 
+// UserPubkeyPUT performs `PUT /user/pubkey/:pubKey`
+func (at *AccountsTester) UserPubkeyPUT(pk database.PubKey, label string) (int, error) {
+	b, err := json.Marshal(api.PubKeyPUT{Label: label})
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	r, err := at.Request(http.MethodPut, "/user/pubkey/"+hex.EncodeToString(pk[:]), nil, b, nil, nil)
+	return r.StatusCode, err
+}
+
+// UserPubkeysGET performs a `GET /user/pubkeys` Request.
+func (at *AccountsTester) UserPubkeysGET() (api.PubKeysGET, int, error) {
+	var result api.PubKeysGET
+	r, err := at.Request(http.MethodGet, "/user/pubkeys", nil, nil, nil, &result)
+	return result, r.StatusCode, err
+}
+
 // UserPubkeyRegisterPOST performs a `POST /user/pubkey/register` Request.
 func (at *AccountsTester) UserPubkeyRegisterPOST(response, signature []byte) (api.UserGET, int, error) {
-	body := database.ChallengeResponseRequest{
-		Response:  hex.EncodeToString(response),
-		Signature: hex.EncodeToString(signature),
+	return at.UserPubkeyRegisterPOSTWithLabel(response, signature, "")
+}
+
+// UserPubkeyRegisterPOSTWithLabel performs a `POST /user/pubkey/register`
+// Request which sets the label of the new pubkey.
+func (at *AccountsTester) UserPubkeyRegisterPOSTWithLabel(response, signature []byte, label string) (api.UserGET, int, error) {
+	body := map[string]string{
+		"response":  hex.EncodeToString(response),
+		"signature": hex.EncodeToString(signature),
+		"label":     label,
 	}
 	b, err := json.Marshal(body)
 	if err != nil {
@@ -692,6 +891,21 @@ func (at *AccountsTester) UserStats(unit string, headers map[string]string) (dat
 	return resp, r.StatusCode, err
 }
 
+// UserStatsHistory performs a `GET /user/stats/history` request.
+func (at *AccountsTester) UserStatsHistory(from, to int64, granularity string) ([]database.UserStatsHistoryBucket, int, error) {
+	queryParams := url.Values{}
+	if from > 0 {
+		queryParams.Set("from", strconv.FormatInt(from, 10))
+	}
+	if to > 0 {
+		queryParams.Set("to", strconv.FormatInt(to, 10))
+	}
+	queryParams.Set("granularity", granularity)
+	var resp []database.UserStatsHistoryBucket
+	r, err := at.Request(http.MethodGet, "/user/stats/history", queryParams, nil, nil, &resp)
+	return resp, r.StatusCode, err
+}
+
 // UploadInfo performs a `GET /uploadinfo/:skylink` request.
 func (at *AccountsTester) UploadInfo(sl string) ([]api.UploadInfo, int, error) {
 	if !database.ValidSkylink(sl) {
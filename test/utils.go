@@ -5,11 +5,17 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"regexp"
 	"strings"
 
 	"github.com/SkynetLabs/skynet-accounts/database"
 	"github.com/SkynetLabs/skynet-accounts/types"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/NebulousLabs/fastrand"
 	"gitlab.com/SkynetLabs/skyd/skymodules"
@@ -137,6 +143,47 @@ func CreateTestUpload(ctx context.Context, db *database.DB, user database.User,
 	return RegisterTestUpload(ctx, db, user, skylink)
 }
 
+// EmailTextPart returns the decoded plain text part of the given email
+// message.
+func EmailTextPart(m database.EmailMessage) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(m.BodyMime)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return m.Body, nil
+	}
+	r := multipart.NewReader(strings.NewReader(m.Body), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return "", errors.New("no plain text part found")
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(p.Header.Get("Content-Type"), "text/plain") {
+			// NextPart decodes quoted-printable parts transparently.
+			b, err := io.ReadAll(p)
+			return string(b), err
+		}
+	}
+}
+
+// EmailToken returns the token from the first link with a token in the given
+// email message, e.g. the link in an email address confirmation email.
+func EmailToken(m database.EmailMessage) (string, error) {
+	text, err := EmailTextPart(m)
+	if err != nil {
+		return "", err
+	}
+	match := regexp.MustCompile(`\?token=(\S+)`).FindStringSubmatch(text)
+	if len(match) != 2 {
+		return "", errors.New("no token found")
+	}
+	return match[1], nil
+}
+
 // DBNameForTest sanitizes the input string, so it can be used as an email or
 // sub.
 func DBNameForTest(s string) string {
@@ -176,3 +223,11 @@ func RegisterTestUpload(ctx context.Context, db *database.DB, user database.User
 	}
 	return skylink, up.ID, nil
 }
+
+// SignEIP191 signs the given message in the same way Ethereum wallets do,
+// i.e. as an EIP-191 message, and returns the signature in the
+// [R || S || V] format.
+func SignEIP191(sk *secp256k1.PrivateKey, msg []byte) []byte {
+	compact := ecdsa.SignCompact(sk, database.EIP191Hash(msg), false)
+	return append(compact[1:], compact[0])
+}
@@ -12,18 +12,36 @@ package types
 import (
 	"encoding/json"
 	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
 )
 
 type (
-	// Email is a string type with some extra rules about its casing (it always
-	// gets converted to lowercase). All subsystems working with emails should
+	// Email is a string type with some extra rules about its format (it always
+	// gets normalized, see NewEmail). All subsystems working with emails should
 	// use this type in the signatures of their exported methods and functions.
 	Email string
 )
 
-// NewEmail creates a new Email.
+// NewEmail creates a new Email. It normalizes the given address, so that all
+// the ways of writing the same address result in the same Email: it trims the
+// surrounding whitespace, converts the address to lowercase and to Unicode
+// normalization form C, and converts internationalized domain names to their
+// ASCII (punycode) form.
 func NewEmail(s string) Email {
-	return Email(strings.ToLower(s))
+	s = norm.NFC.String(strings.ToLower(strings.TrimSpace(s)))
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return Email(s)
+	}
+	domain := strings.TrimSuffix(s[i+1:], ".")
+	// We leave domains which are not valid IDNs as they are. Validating the
+	// address is not our concern here.
+	if d, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = d
+	}
+	return Email(s[:i+1] + domain)
 }
 
 // MarshalJSON defines a custom marshaller for this type.
@@ -32,7 +50,7 @@ func (e Email) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON defines a custom unmarshaller for this type.
-// The only custom part is the fact that we cast the email to lower case.
+// The only custom part is the fact that we normalize the email.
 func (e *Email) UnmarshalJSON(data []byte) error {
 	var s string
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -43,6 +61,7 @@ func (e *Email) UnmarshalJSON(data []byte) error {
 }
 
 // String is a fmt.Stringer implementation for Email.
+// It returns the normalized form of the email.
 func (e Email) String() string {
-	return strings.ToLower(string(e))
+	return string(NewEmail(string(e)))
 }
@@ -49,3 +49,28 @@ func TestEmail_UnmarshalJSON(t *testing.T) {
 		t.Fatalf("Expected to get a lowercase version of '%s', i.e. '%s' but got '%s'", e, strings.ToLower(string(e)), e)
 	}
 }
+
+// TestNewEmail ensures that NewEmail normalizes all the ways of writing the
+// same address to the same Email.
+func TestNewEmail(t *testing.T) {
+	tests := []struct {
+		in  string
+		out Email
+	}{
+		{in: "user@example.com", out: "user@example.com"},
+		{in: "  User@Example.COM \n", out: "user@example.com"},
+		{in: "user@example.com.", out: "user@example.com"},
+		{in: "user@BÜCHER.example", out: "user@xn--bcher-kva.example"},
+		{in: "user@xn--bcher-kva.example", out: "user@xn--bcher-kva.example"},
+		// "e" followed by a combining acute accent and the precomposed "é".
+		{in: "jose\u0301@example.com", out: "jos\u00e9@example.com"},
+		{in: "jos\u00e9@example.com", out: "jos\u00e9@example.com"},
+		{in: "not an email", out: "not an email"},
+		{in: "", out: ""},
+	}
+	for _, tt := range tests {
+		if e := NewEmail(tt.in); e != tt.out {
+			t.Errorf("Expected '%s' to be normalized to '%s', got '%s'", tt.in, tt.out, e)
+		}
+	}
+}
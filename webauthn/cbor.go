@@ -0,0 +1,176 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+/**
+WebAuthn encodes attestation objects and public keys in CBOR (RFC 8949). We
+only need to decode the subset of CBOR used by WebAuthn: integers, byte and
+text strings, arrays, maps and simple values, all with definite lengths.
+*/
+
+const (
+	// cborMaxDepth limits the nesting of the CBOR data we decode.
+	cborMaxDepth = 16
+)
+
+// CBOR major types.
+const (
+	cborUint byte = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+var (
+	// errCBORUnexpectedEnd is returned when the CBOR data ends prematurely.
+	errCBORUnexpectedEnd = errors.New("unexpected end of CBOR data")
+)
+
+// cborDecode decodes the first CBOR item in the given data and returns it,
+// together with the remaining data. Integers are decoded as int64, byte
+// strings as []byte, text strings as string, arrays as []interface{} and maps
+// as map[interface{}]interface{}.
+func cborDecode(data []byte) (interface{}, []byte, error) {
+	return cborDecodeItem(data, 0)
+}
+
+// cborDecodeItem decodes a single CBOR item at the given nesting depth.
+func cborDecodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("CBOR data is nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORUnexpectedEnd
+	}
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+	// Simple values and floats use the additional info differently.
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+		}
+	}
+	arg, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case cborUint:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("CBOR integer overflow")
+		}
+		return int64(arg), data, nil
+	case cborNegInt:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("CBOR integer overflow")
+		}
+		return -1 - int64(arg), data, nil
+	case cborBytes, cborText:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORUnexpectedEnd
+		}
+		b := data[:arg]
+		if major == cborText {
+			return string(b), data[arg:], nil
+		}
+		return append([]byte{}, b...), data[arg:], nil
+	case cborArray:
+		// Each item takes at least one byte.
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORUnexpectedEnd
+		}
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, data, nil
+	case cborMap:
+		// Each entry takes at least two bytes.
+		if arg > uint64(len(data))/2 {
+			return nil, nil, errCBORUnexpectedEnd
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v interface{}
+			k, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("unsupported CBOR map key type")
+			}
+			v, data, err = cborDecodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, exists := m[k]; exists {
+				return nil, nil, errors.New("duplicate CBOR map key")
+			}
+			m[k] = v
+		}
+		return m, data, nil
+	case cborTag:
+		// We don't need the semantics of tags, only the tagged item.
+		return cborDecodeItem(data, depth+1)
+	}
+	return nil, nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
+
+// cborArgument decodes the argument of a CBOR item, based on its additional
+// info.
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, errors.New("indefinite-length CBOR items are not supported")
+	}
+	if len(data) < size {
+		return 0, nil, errCBORUnexpectedEnd
+	}
+	var arg uint64
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		arg = binary.BigEndian.Uint64(data)
+	}
+	return arg, data[size:], nil
+}
@@ -0,0 +1,73 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestCBORDecode ensures that we decode the CBOR items we need correctly. The
+// test vectors come from RFC 8949, Appendix A.
+func TestCBORDecode(t *testing.T) {
+	tests := []struct {
+		in  string
+		out interface{}
+	}{
+		{in: "00", out: int64(0)},
+		{in: "17", out: int64(23)},
+		{in: "1818", out: int64(24)},
+		{in: "1903e8", out: int64(1000)},
+		{in: "1a000f4240", out: int64(1000000)},
+		{in: "1b000000e8d4a51000", out: int64(1000000000000)},
+		{in: "20", out: int64(-1)},
+		{in: "3903e7", out: int64(-1000)},
+		{in: "f4", out: false},
+		{in: "f5", out: true},
+		{in: "f6", out: nil},
+		{in: "4401020304", out: []byte{1, 2, 3, 4}},
+		{in: "6449455446", out: "IETF"},
+		{in: "83010203", out: []interface{}{int64(1), int64(2), int64(3)}},
+		{in: "a201020304", out: map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{in: "a26161016162820203", out: map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	}
+	for _, tt := range tests {
+		b, err := hex.DecodeString(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, rest, err := cborDecode(b)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.in, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("%s: unexpected remaining data %x", tt.in, rest)
+		}
+		if !reflect.DeepEqual(v, tt.out) {
+			t.Errorf("%s: expected %#v, got %#v", tt.in, tt.out, v)
+		}
+	}
+
+	// Malformed and unsupported data.
+	bad := []string{
+		"",
+		"18",         // missing argument
+		"4401",       // byte string too short
+		"9f01ff",     // indefinite-length array
+		"a20102",     // truncated map
+		"a2010201",   // missing map value
+		"a201020103", // duplicate map key
+		"a1f401",     // unsupported map key type
+		"1bffffffffffffffff",
+	}
+	for _, in := range bad {
+		b, err := hex.DecodeString(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = cborDecode(b)
+		if err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+/**
+Package webauthn implements the server side of the WebAuthn registration and
+authentication ceremonies which we need in order to support passkeys.
+
+See https://www.w3.org/TR/webauthn-2/
+
+We only support the "none" attestation format, i.e. we don't verify the make
+and model of the user's authenticator, and the ES256, EdDSA and RS256
+signature algorithms.
+*/
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// The COSE algorithm identifiers we support.
+// See https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	// AlgES256 is ECDSA with the P-256 curve and SHA-256.
+	AlgES256 = -7
+	// AlgEdDSA is EdDSA with the Ed25519 curve.
+	AlgEdDSA = -8
+	// AlgRS256 is RSASSA-PKCS1-v1_5 with SHA-256.
+	AlgRS256 = -257
+)
+
+// The types of client data, as defined by the spec.
+const (
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+)
+
+// The flags of the authenticator data.
+const (
+	flagUserPresent            byte = 0x01
+	flagAttestedCredentialData byte = 0x40
+	flagExtensionData          byte = 0x80
+)
+
+const (
+	// minAuthDataSize is the size of the authenticator data without attested
+	// credential data and extensions: the RP ID hash, the flags and the
+	// signature counter.
+	minAuthDataSize = 32 + 1 + 4
+	// maxCredentialIDSize is the maximum size of a credential id, as defined
+	// by the spec.
+	maxCredentialIDSize = 1023
+)
+
+var (
+	// SupportedAlgorithms lists the COSE algorithms we support, in order of
+	// preference.
+	SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+	// ErrInvalidClientData is returned when the client data doesn't match the
+	// ceremony we expect.
+	ErrInvalidClientData = errors.New("invalid client data")
+	// ErrInvalidAuthenticatorData is returned when the authenticator data is
+	// malformed or doesn't match the relying party.
+	ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+	// ErrInvalidSignature is returned when the assertion's signature is not
+	// valid.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrSignCountMismatch is returned when the signature counter of an
+	// assertion didn't increase. That's a sign that the authenticator might
+	// have been cloned.
+	ErrSignCountMismatch = errors.New("signature counter did not increase, the authenticator might have been cloned")
+	// ErrUnsupportedAlgorithm is returned when the credential's public key
+	// uses an algorithm we don't support.
+	ErrUnsupportedAlgorithm = errors.New("unsupported public key algorithm")
+	// ErrUnsupportedAttestation is returned when the attestation object uses
+	// a format other than "none".
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format, only \"none\" is supported")
+)
+
+type (
+	// RelyingParty describes the service which the users authenticate with.
+	RelyingParty struct {
+		// ID is the domain for which the credentials are valid. Credentials
+		// are also valid for all subdomains of ID.
+		ID string
+		// Name is the human-readable name of the service.
+		Name string
+		// Origins are the exact origins from which we accept credentials,
+		// e.g. https://account.siasky.net. We don't accept all subdomains of
+		// ID because some of them might serve content we don't control.
+		Origins []string
+	}
+
+	// ClientData is the data the client passes to the authenticator.
+	ClientData struct {
+		Type string `json:"type"`
+		// Challenge is the base64url-encoded challenge.
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin,omitempty"`
+	}
+
+	// AuthenticatorData is the parsed data produced by the authenticator.
+	AuthenticatorData struct {
+		RPIDHash  []byte
+		Flags     byte
+		SignCount uint32
+		// CredentialID and PublicKey are only set during registration.
+		CredentialID []byte
+		PublicKey    []byte
+	}
+
+	// Credential is a public key credential which was registered by a user.
+	Credential struct {
+		ID []byte
+		// PublicKey is the COSE-encoded public key of the credential.
+		PublicKey []byte
+		SignCount uint32
+	}
+
+	// coseKey is a parsed COSE public key.
+	coseKey struct {
+		alg int
+		key crypto.PublicKey
+	}
+)
+
+// ChallengeString returns the form in which the given challenge appears in the
+// client data.
+func ChallengeString(challenge []byte) string {
+	return base64.RawURLEncoding.EncodeToString(challenge)
+}
+
+// DecodeBase64URL decodes the base64url-encoded binary values which clients
+// send us. It accepts values with and without padding.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ParseClientData parses the client data JSON sent by the client.
+func ParseClientData(clientDataJSON []byte) (ClientData, error) {
+	var cd ClientData
+	err := json.Unmarshal(clientDataJSON, &cd)
+	if err != nil {
+		return cd, errors.Compose(ErrInvalidClientData, err)
+	}
+	return cd, nil
+}
+
+// ParseAuthenticatorData parses the binary authenticator data.
+func ParseAuthenticatorData(b []byte) (AuthenticatorData, error) {
+	var ad AuthenticatorData
+	if len(b) < minAuthDataSize {
+		return ad, errors.AddContext(ErrInvalidAuthenticatorData, "too short")
+	}
+	ad.RPIDHash = b[:32]
+	ad.Flags = b[32]
+	ad.SignCount = binary.BigEndian.Uint32(b[33:37])
+	rest := b[minAuthDataSize:]
+	if ad.Flags&flagAttestedCredentialData != 0 {
+		// AAGUID (16 bytes) and the length of the credential id (2 bytes).
+		if len(rest) < 18 {
+			return ad, errors.AddContext(ErrInvalidAuthenticatorData, "missing attested credential data")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen > maxCredentialIDSize || len(rest) < idLen {
+			return ad, errors.AddContext(ErrInvalidAuthenticatorData, "invalid credential id")
+		}
+		ad.CredentialID = append([]byte{}, rest[:idLen]...)
+		rest = rest[idLen:]
+		_, afterKey, err := cborDecode(rest)
+		if err != nil {
+			return ad, errors.Compose(errors.AddContext(ErrInvalidAuthenticatorData, "invalid credential public key"), err)
+		}
+		ad.PublicKey = append([]byte{}, rest[:len(rest)-len(afterKey)]...)
+		rest = afterKey
+	}
+	if ad.Flags&flagExtensionData != 0 {
+		_, afterExt, err := cborDecode(rest)
+		if err != nil {
+			return ad, errors.Compose(errors.AddContext(ErrInvalidAuthenticatorData, "invalid extension data"), err)
+		}
+		rest = afterExt
+	}
+	if len(rest) > 0 {
+		return ad, errors.AddContext(ErrInvalidAuthenticatorData, "unexpected trailing data")
+	}
+	return ad, nil
+}
+
+// VerifyRegistration verifies the response of the authenticator to a
+// registration (credential creation) ceremony and returns the new credential.
+func (rp RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := rp.verifyClientData(clientDataJSON, clientDataTypeCreate, challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+	obj, rest, err := cborDecode(attestationObject)
+	if err != nil || len(rest) > 0 {
+		return Credential{}, errors.Compose(errors.New("invalid attestation object"), err)
+	}
+	att, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, errors.New("invalid attestation object")
+	}
+	if f, _ := att["fmt"].(string); f != "none" {
+		return Credential{}, ErrUnsupportedAttestation
+	}
+	authData, ok := att["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("attestation object is missing the authenticator data")
+	}
+	ad, err := rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if len(ad.CredentialID) == 0 || len(ad.PublicKey) == 0 {
+		return Credential{}, errors.AddContext(ErrInvalidAuthenticatorData, "missing attested credential data")
+	}
+	_, err = parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+	return Credential{
+		ID:        ad.CredentialID,
+		PublicKey: ad.PublicKey,
+		SignCount: ad.SignCount,
+	}, nil
+}
+
+// VerifyAssertion verifies the response of the authenticator to an
+// authentication ceremony, performed with the given credential. It returns
+// the new value of the credential's signature counter, which the caller needs
+// to store.
+func (rp RelyingParty) VerifyAssertion(challenge []byte, cred Credential, clientDataJSON, authData, signature []byte) (uint32, error) {
+	err := rp.verifyClientData(clientDataJSON, clientDataTypeGet, challenge)
+	if err != nil {
+		return 0, err
+	}
+	ad, err := rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), cdHash[:]...)
+	if !key.verify(signed, signature) {
+		return 0, ErrInvalidSignature
+	}
+	// Authenticators which don't support signature counters always return
+	// zero.
+	if (ad.SignCount != 0 || cred.SignCount != 0) && ad.SignCount <= cred.SignCount {
+		return 0, ErrSignCountMismatch
+	}
+	return ad.SignCount, nil
+}
+
+// AllowsOrigin returns true if the given origin is one of the relying party's
+// origins and it's served over HTTPS.
+func (rp RelyingParty) AllowsOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != "https" || u.Path != "" {
+		return false
+	}
+	for _, o := range rp.Origins {
+		if origin == o {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyClientData makes sure the client data belongs to the expected
+// ceremony, challenge and relying party.
+func (rp RelyingParty) verifyClientData(clientDataJSON []byte, cdType string, challenge []byte) error {
+	cd, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if cd.Type != cdType {
+		return errors.AddContext(ErrInvalidClientData, "unexpected type "+cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(ChallengeString(challenge))) != 1 {
+		return errors.AddContext(ErrInvalidClientData, "challenge mismatch")
+	}
+	if !rp.AllowsOrigin(cd.Origin) {
+		return errors.AddContext(ErrInvalidClientData, "unexpected origin "+cd.Origin)
+	}
+	return nil
+}
+
+// verifyAuthenticatorData parses the authenticator data and makes sure it was
+// produced for our relying party, with the user present.
+func (rp RelyingParty) verifyAuthenticatorData(authData []byte) (AuthenticatorData, error) {
+	ad, err := ParseAuthenticatorData(authData)
+	if err != nil {
+		return ad, err
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return ad, errors.AddContext(ErrInvalidAuthenticatorData, "RP ID mismatch")
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return ad, errors.AddContext(ErrInvalidAuthenticatorData, "user not present")
+	}
+	return ad, nil
+}
+
+// parseCOSEKey parses a COSE-encoded public key.
+// See https://www.rfc-editor.org/rfc/rfc8152#section-13
+func parseCOSEKey(b []byte) (coseKey, error) {
+	obj, rest, err := cborDecode(b)
+	if err != nil || len(rest) > 0 {
+		return coseKey{}, errors.Compose(errors.New("invalid public key"), err)
+	}
+	m, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return coseKey{}, errors.New("invalid public key")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errors.New("invalid ES256 public key")
+		}
+		pk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return coseKey{}, errors.New("invalid ES256 public key")
+		}
+		return coseKey{alg: AlgES256, key: pk}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return coseKey{}, errors.New("invalid EdDSA public key")
+		}
+		return coseKey{alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errors.New("invalid RS256 public key")
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		return coseKey{alg: AlgRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return coseKey{}, ErrUnsupportedAlgorithm
+}
+
+// verify checks the signature of the given data.
+func (k coseKey) verify(data, sig []byte) bool {
+	switch k.alg {
+	case AlgES256:
+		h := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), h[:], sig)
+	case AlgEdDSA:
+		return ed25519.Verify(k.key.(ed25519.PublicKey), data, sig)
+	case AlgRS256:
+		h := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, h[:], sig) == nil
+	}
+	return false
+}